@@ -0,0 +1,166 @@
+package capabilities
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/extract"
+	"github.com/replicate/pget/v2/pkg/integrity"
+	"github.com/replicate/pget/v2/pkg/version"
+)
+
+const CapabilitiesCMDName = "capabilities"
+
+const longDesc = `
+'capabilities' lists what this build of pget supports: URL schemes, output consumers, archive formats, download
+strategies, and the optional features compiled in for this platform, along with its commands and flags.
+
+With --json the listing is printed as a JSON document, so that orchestrators running different versions of pget
+across a fleet can detect features rather than compare version numbers.
+`
+
+const optJSON = "json"
+
+// Capabilities is the document printed by 'pget capabilities --json'. Fields are only ever added to it.
+type Capabilities struct {
+	Version             string          `json:"version"`
+	Platform            string          `json:"platform"`
+	Schemes             []string        `json:"schemes"`
+	Consumers           []string        `json:"consumers"`
+	Extractors          []string        `json:"extractors"`
+	ExtractDestinations []string        `json:"extract_destinations"`
+	CompressionFormats  []string        `json:"compression_formats"`
+	Strategies          []string        `json:"strategies"`
+	IntegrityAlgorithms []string        `json:"integrity_algorithms"`
+	CacheRewriters      []string        `json:"cache_rewriters"`
+	CacheKeyRules       []string        `json:"cache_key_rules"`
+	ProxySchemes        []string        `json:"proxy_schemes"`
+	Features            map[string]bool `json:"features"`
+	Commands            []string        `json:"commands"`
+	// Flags lists the flags of each command, by name. Those of the root command (pget) include its persistent flags,
+	// which every command accepts.
+	Flags map[string][]string `json:"flags"`
+}
+
+func GetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   CapabilitiesCMDName,
+		Short: "list the schemes, formats, strategies and features this build supports",
+		Long:  longDesc,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			asJSON, err := cmd.Flags().GetBool(optJSON)
+			if err != nil {
+				return err
+			}
+			return printCapabilities(os.Stdout, collect(cmd.Root()), asJSON)
+		},
+	}
+	cmd.Flags().Bool(optJSON, false, "Print the capabilities as a JSON document")
+	cmd.SetUsageTemplate(cli.UsageTemplate)
+	return cmd
+}
+
+// collect lists the capabilities of this build, taking the commands and flags from root.
+func collect(root *cobra.Command) Capabilities {
+	c := Capabilities{
+		Version:             version.GetVersion(),
+		Platform:            runtime.GOOS + "/" + runtime.GOARCH,
+		Schemes:             []string{"http", "https", "file", "ipfs", "hf", "replicate"},
+		Consumers:           []string{config.ConsumerFile, config.ConsumerTarExtractor, config.ConsumerZipExtractor, config.ConsumerNull},
+		Extractors:          []string{"tar", "zip"},
+		ExtractDestinations: []string{"file", "s3", "gs"},
+		CompressionFormats:  extract.CompressionFormats(),
+		Strategies:          []string{"buffer", "consistent-hashing", "striped", "ipfs", "hf", "replicate"},
+		IntegrityAlgorithms: integrity.Algorithms(),
+		CacheRewriters: []string{
+			download.CacheRewriteHost,
+			download.CacheRewriteHostPrefix,
+			download.CacheRewriteBase64URL,
+			download.CacheRewriteHeader,
+		},
+		CacheKeyRules: []string{download.CacheKeyStripQuery, download.CacheKeyLowercaseHost, download.CacheKeyCanonicalPath},
+		ProxySchemes:  []string{"http", "https", "socks5", "socks5h"},
+		Features: map[string]bool{
+			"preallocation": extract.Preallocation,
+			"xattrs":        extract.Xattrs,
+		},
+	}
+	c.Flags = map[string][]string{root.Name(): flagNames(root.LocalFlags())}
+	for _, sub := range root.Commands() {
+		if !sub.IsAvailableCommand() {
+			continue
+		}
+		c.Commands = append(c.Commands, sub.Name())
+		c.Flags[sub.Name()] = flagNames(sub.LocalNonPersistentFlags())
+	}
+	sort.Strings(c.Commands)
+	return c
+}
+
+// flagNames returns the names of the flags in fs, other than help and those which are hidden or deprecated.
+func flagNames(fs *pflag.FlagSet) []string {
+	names := []string{}
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Hidden || f.Deprecated != "" || f.Name == "help" {
+			return
+		}
+		names = append(names, f.Name)
+	})
+	return names
+}
+
+func printCapabilities(w io.Writer, c Capabilities, asJSON bool) error {
+	if asJSON {
+		data, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	features := make([]string, 0, len(c.Features))
+	for name, supported := range c.Features {
+		if supported {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	lines := []struct {
+		name   string
+		values []string
+	}{
+		{"schemes", c.Schemes},
+		{"consumers", c.Consumers},
+		{"extractors", c.Extractors},
+		{"extract destinations", c.ExtractDestinations},
+		{"compression formats", c.CompressionFormats},
+		{"strategies", c.Strategies},
+		{"integrity algorithms", c.IntegrityAlgorithms},
+		{"cache rewriters", c.CacheRewriters},
+		{"cache key rules", c.CacheKeyRules},
+		{"proxy schemes", c.ProxySchemes},
+		{"features", features},
+		{"commands", c.Commands},
+	}
+	if _, err := fmt.Fprintf(w, "pget %s (%s)\n", c.Version, c.Platform); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", line.name, strings.Join(line.values, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
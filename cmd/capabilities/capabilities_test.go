@@ -0,0 +1,37 @@
+package capabilities
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	root := &cobra.Command{Use: "pget", Run: func(*cobra.Command, []string) {}}
+	root.PersistentFlags().Int("concurrency", 4, "")
+	root.PersistentFlags().Int("max-chunks", 4, "")
+	require.NoError(t, root.PersistentFlags().MarkDeprecated("max-chunks", "use --concurrency instead"))
+	root.Flags().Bool("extract", false, "")
+	sub := &cobra.Command{Use: "multifile", Run: func(*cobra.Command, []string) {}}
+	sub.Flags().Bool("warmup", false, "")
+	root.AddCommand(sub, GetCommand())
+
+	c := collect(root)
+	assert.Equal(t, []string{"capabilities", "multifile"}, c.Commands)
+	assert.Equal(t, []string{"concurrency", "extract"}, c.Flags["pget"])
+	assert.Equal(t, []string{"warmup"}, c.Flags["multifile"])
+	assert.Contains(t, c.Schemes, "ipfs")
+	assert.Contains(t, c.CompressionFormats, "lz4")
+
+	var out bytes.Buffer
+	require.NoError(t, printCapabilities(&out, c, true))
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	for _, key := range []string{"version", "schemes", "consumers", "extractors", "extract_destinations", "compression_formats", "strategies", "features", "flags"} {
+		assert.Contains(t, decoded, key)
+	}
+}
@@ -3,14 +3,23 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 
-	"github.com/replicate/pget/cmd/multifile"
-	"github.com/replicate/pget/cmd/root"
-	"github.com/replicate/pget/cmd/version"
+	"github.com/replicate/pget/v2/cmd/capabilities"
+	"github.com/replicate/pget/v2/cmd/inspect"
+	"github.com/replicate/pget/v2/cmd/multifile"
+	"github.com/replicate/pget/v2/cmd/root"
+	"github.com/replicate/pget/v2/cmd/simulate"
+	"github.com/replicate/pget/v2/cmd/verify"
+	"github.com/replicate/pget/v2/cmd/version"
 )
 
 func GetRootCommand() *cobra.Command {
 	rootCMD := root.GetCommand()
 	rootCMD.AddCommand(multifile.GetCommand())
+	rootCMD.AddCommand(multifile.GetPrefetchCheckCommand())
+	rootCMD.AddCommand(verify.GetCommand())
+	rootCMD.AddCommand(capabilities.GetCommand())
+	rootCMD.AddCommand(simulate.GetCommand())
+	rootCMD.AddCommand(inspect.GetCommand())
 	rootCMD.AddCommand(version.VersionCMD)
 	return rootCMD
 }
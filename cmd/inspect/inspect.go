@@ -0,0 +1,187 @@
+package inspect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+const HashCMDName = "hash-inspect"
+
+const hashLongDesc = `
+'hash-inspect' prints which cache host each slice of a URL is served by, using the same consistent hashing as
+downloads do, along with the number of slices and bytes each cache host serves. It helps debug uneven load across
+cache hosts without running a download.
+
+The cache hosts are those of the SRV records of PGET_CACHE_NODES_SRV_NAME, as for downloads, or those given with
+--cache-hosts, in bucket order. Only URLs under PGET_CACHE_URI_PREFIXES are served by the cache hosts. The slice size
+and hash version are those downloads would use, including the recommendations of the cache's TXT record. Hosts
+failing the health check of PGET_CACHE_HEALTH_CHECK_PATH are reported as unavailable; downloads retry their slices on
+another host.
+
+Unless --size is given, the size of the object is found with the first request of its download, whose body isn't
+read. With --size no request is made.
+`
+
+const hashExamples = `
+  PGET_CACHE_NODES_SRV_NAME=cache.internal pget hash-inspect https://weights.replicate.delivery/model.tar
+
+  pget hash-inspect --cache-hosts cache-0,cache-1,cache-2 --size 20GiB https://weights.replicate.delivery/model.tar
+`
+
+const (
+	optCacheHosts = "cache-hosts"
+	optSize       = "size"
+)
+
+// hostLoad is the share of an object a cache host serves.
+type hostLoad struct {
+	slices int
+	bytes  int64
+}
+
+func GetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     HashCMDName + " [flags] <url>",
+		Short:   "print which cache host serves each slice of a URL",
+		Long:    hashLongDesc,
+		Args:    cobra.ExactArgs(1),
+		RunE:    runHashInspectCMD,
+		Example: hashExamples,
+	}
+	cmd.Flags().StringSlice(optCacheHosts, nil, "Cache hosts in bucket order, instead of those of the SRV records of PGET_CACHE_NODES_SRV_NAME (an empty entry is a bucket without a host)")
+	cmd.Flags().String(optSize, "", "Size of the object, e.g. 20GiB, instead of finding it with a request")
+	cmd.SetUsageTemplate(cli.UsageTemplate)
+	return cmd
+}
+
+func runHashInspectCMD(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	urlString := args[0]
+	cacheHosts, _ := cmd.Flags().GetStringSlice(optCacheHosts)
+	sizeString, _ := cmd.Flags().GetString(optSize)
+	size := int64(-1)
+	if sizeString != "" {
+		parsed, err := humanize.ParseBytes(sizeString)
+		if err != nil {
+			return fmt.Errorf("error parsing --%s: %w", optSize, err)
+		}
+		size = int64(parsed)
+	}
+	srvName := config.GetCacheSRV()
+	if len(cacheHosts) > 0 {
+		// the TXT records are only those of the cache hosts' SRV records
+		srvName = ""
+	} else if srvName == "" {
+		return fmt.Errorf("no cache hosts: set PGET_CACHE_NODES_SRV_NAME, or --%s", optCacheHosts)
+	}
+	cmd.SilenceUsage = true
+
+	chunkSize, _, err := config.ChunkSize()
+	if err != nil {
+		return err
+	}
+	clientOpts, err := cli.ClientOptions()
+	if err != nil {
+		return err
+	}
+	downloadOpts := download.Options{
+		ChunkSize:                chunkSize,
+		Client:                   clientOpts,
+		CacheableURIPrefixes:     config.CacheableURIPrefixes(),
+		CacheURIAliases:          config.GetURIAliases(),
+		CacheUsePathProxy:        viper.GetBool(config.OptCacheUsePathProxy),
+		CacheHealthCheckPath:     viper.GetString(config.OptCacheHealthCheckPath),
+		CacheHealthCheckInterval: viper.GetDuration(config.OptCacheHealthCheckInterval),
+		CacheHosts:               cacheHosts,
+	}
+	downloadOpts.CacheKeyRules, err = download.ParseCacheKeyRules(viper.GetStringSlice(config.OptCacheKeyRules))
+	if err != nil {
+		return err
+	}
+	if srvName != "" {
+		downloadOpts.CacheHosts, err = cli.LookupCacheHosts(ctx, downloadOpts.Client.TransportOpts.Resolver, srvName)
+		if err != nil {
+			return err
+		}
+	}
+	if err := cli.ApplyCacheTuning(ctx, downloadOpts.Client.TransportOpts.Resolver, srvName, &downloadOpts); err != nil {
+		return err
+	}
+	strategy, err := download.GetConsistentHashingMode(downloadOpts)
+	if err != nil {
+		return err
+	}
+	defer strategy.Close()
+
+	var slices []download.SlicePlan
+	if size >= 0 {
+		slices, err = strategy.PlaceSlices(urlString, size)
+	} else {
+		var plan *download.Plan
+		plan, err = download.PlanDownload(ctx, strategy, urlString)
+		if err == nil && plan.Strategy != download.PlanConsistentHashing {
+			err = fmt.Errorf("%s isn't downloaded from the cache hosts: %s", urlString, plan.Reason)
+		}
+		if err == nil {
+			size, slices = plan.Size, plan.Slices
+		}
+	}
+	if err != nil {
+		return cli.DeadlineError(ctx, err)
+	}
+	return printPlacement(os.Stdout, urlString, size, downloadOpts.SliceSize, downloadOpts.CacheHosts, slices)
+}
+
+// printPlacement writes the cache host of each slice of the object at url, then the load of each cache host.
+func printPlacement(w io.Writer, url string, size, sliceSize int64, cacheHosts []string, slices []download.SlicePlan) error {
+	if len(slices) == 0 {
+		return errors.New("the object is empty, it has no slices")
+	}
+	loads := make([]hostLoad, len(cacheHosts))
+	unavailable := make([]bool, len(cacheHosts))
+	for _, slice := range slices {
+		loads[slice.Bucket].slices++
+		loads[slice.Bucket].bytes += slice.End - slice.Start + 1
+		unavailable[slice.Bucket] = slice.CacheHost == ""
+	}
+
+	_, _ = fmt.Fprintf(w, "url:           %s\n", url)
+	_, _ = fmt.Fprintf(w, "size:          %s (%d bytes)\n", humanize.IBytes(uint64(size)), size)
+	_, _ = fmt.Fprintf(w, "slices:        %d of %s\n", len(slices), humanize.IBytes(uint64(sliceSize)))
+	_, _ = fmt.Fprintf(w, "hash version:  %d\n", slices[0].HashVersion)
+	_, _ = fmt.Fprintf(w, "cache hosts:   %d\n\n", len(cacheHosts))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SLICE\tBYTES\tBUCKET\tCACHE HOST")
+	for _, slice := range slices {
+		_, _ = fmt.Fprintf(tw, "%d\t%d-%d\t%d\t%s\n", slice.Slice, slice.Start, slice.End, slice.Bucket, hostName(cacheHosts, slice.Bucket, unavailable[slice.Bucket]))
+	}
+	_, _ = fmt.Fprintln(tw)
+	_, _ = fmt.Fprintln(tw, "BUCKET\tCACHE HOST\tSLICES\tBYTES")
+	for bucket, load := range loads {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", bucket, hostName(cacheHosts, bucket, unavailable[bucket]), load.slices, humanize.IBytes(uint64(load.bytes)))
+	}
+	return tw.Flush()
+}
+
+func hostName(cacheHosts []string, bucket int, unavailable bool) string {
+	switch {
+	case cacheHosts[bucket] == "":
+		return "(no host)"
+	case unavailable:
+		return cacheHosts[bucket] + " (unavailable)"
+	default:
+		return cacheHosts[bucket]
+	}
+}
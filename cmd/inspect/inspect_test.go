@@ -0,0 +1,47 @@
+package inspect
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/consistent"
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+func TestPrintPlacement(t *testing.T) {
+	cacheHosts := []string{"cache-0", "", "cache-2"}
+	slices := []download.SlicePlan{
+		{Slice: 0, Start: 0, End: 9, HashVersion: consistent.V2, Bucket: 2, CacheHost: "cache-2"},
+		{Slice: 1, Start: 10, End: 19, HashVersion: consistent.V2, Bucket: 1},
+		{Slice: 2, Start: 20, End: 24, HashVersion: consistent.V2, Bucket: 2, CacheHost: "cache-2"},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, printPlacement(&buf, "https://example.com/model.tar", 25, 10, cacheHosts, slices))
+	assert.Equal(t, "url:           https://example.com/model.tar\n"+
+		"size:          25 B (25 bytes)\n"+
+		"slices:        3 of 10 B\n"+
+		"hash version:  2\n"+
+		"cache hosts:   3\n"+
+		"\n"+
+		"SLICE  BYTES  BUCKET  CACHE HOST\n"+
+		"0      0-9    2       cache-2\n"+
+		"1      10-19  1       (no host)\n"+
+		"2      20-24  2       cache-2\n"+
+		"\n"+
+		"BUCKET  CACHE HOST  SLICES  BYTES\n"+
+		"0       cache-0     0       0 B\n"+
+		"1       (no host)   1       10 B\n"+
+		"2       cache-2     2       15 B\n", buf.String())
+
+	assert.Error(t, printPlacement(&buf, "https://example.com/empty", 0, 10, cacheHosts, nil))
+}
+
+func TestHostName(t *testing.T) {
+	cacheHosts := []string{"cache-0", ""}
+	assert.Equal(t, "cache-0", hostName(cacheHosts, 0, false))
+	assert.Equal(t, "cache-0 (unavailable)", hostName(cacheHosts, 0, true))
+	assert.Equal(t, "(no host)", hostName(cacheHosts, 1, true))
+}
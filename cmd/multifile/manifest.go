@@ -2,20 +2,25 @@ package multifile
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	netUrl "net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/viper"
 
-	pget "github.com/replicate/pget/pkg"
-	"github.com/replicate/pget/pkg/cli"
-	"github.com/replicate/pget/pkg/config"
-	"github.com/replicate/pget/pkg/logging"
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/integrity"
+	"github.com/replicate/pget/v2/pkg/logging"
 )
 
 // A manifest is a file consisting of pairs of URLs and paths:
@@ -26,11 +31,47 @@ import (
 // A manifest may contain blank lines.
 // The pairs are separated by arbitrary whitespace.
 //
+// Each pair may be followed by optional key=value attributes:
+//
+// http://example.com/model-00001.bin model/00001.bin group=model group-sha256=<hex>
+// http://example.com/model-00002.bin model/00002.bin group=model
+//
+// group=<name> declares the entry to be a shard of a larger artifact, and group-sha256 the hex-encoded SHA-256 of
+// the concatenation of the group's shards in manifest order. group-sha256 need only be given on one shard.
+// group-integrity may be given instead, in Subresource Integrity format (sha384-<base64>) or as a hex SHA-256.
+//
+// integrity=<sri> is the expected digest of the entry's own content, in the same formats as group-integrity.
+//
+// priority=<n> (a positive integer) makes the entry download before those without one, lowest value first.
+//
+// if-match=<etag> and version=<id> pin the entry to one version of the object, by its ETag and by its S3 versionId
+// or GCS generation, so that an object replaced while it is downloaded fails instead of producing a corrupt file.
+//
 // When we parse a manifest, we group by URL base (ie scheme://hostname) so that
 // all URLs that may share a connection are grouped.
 
 var errDupeURLDestCombo = errors.New("duplicate destination with different URLs")
 
+const (
+	attrGroup          = "group"
+	attrGroupSHA256    = "group-sha256"
+	attrGroupIntegrity = "group-integrity"
+	attrIntegrity      = "integrity"
+	attrPriority       = "priority"
+	attrIfMatch        = "if-match"
+	attrVersion        = "version"
+)
+
+var knownAttributes = map[string]bool{
+	attrGroup:          true,
+	attrGroupSHA256:    true,
+	attrGroupIntegrity: true,
+	attrIntegrity:      true,
+	attrPriority:       true,
+	attrIfMatch:        true,
+	attrVersion:        true,
+}
+
 func manifestFile(manifestPath string) (*os.File, error) {
 	if manifestPath == "-" {
 		return os.Stdin, nil
@@ -47,12 +88,98 @@ func manifestFile(manifestPath string) (*os.File, error) {
 
 func parseLine(line string) (url, dest string, err error) {
 	fields := strings.Fields(line)
-	if len(fields) != 2 {
+	if len(fields) < 2 {
 		return "", "", fmt.Errorf("error parsing manifest invalid line format `%s`", line)
 	}
 	return fields[0], fields[1], nil
 }
 
+// parseAttributes returns the key=value attributes following the url and destination of a line.
+func parseAttributes(line string) (map[string]string, error) {
+	fields := strings.Fields(line)
+	attrs := make(map[string]string)
+	for _, field := range fields[min(len(fields), 2):] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("error parsing manifest invalid attribute `%s` in line `%s`", field, line)
+		}
+		if _, ok := attrs[key]; ok {
+			return nil, fmt.Errorf("error parsing manifest duplicate attribute `%s` in line `%s`", key, line)
+		}
+		attrs[key] = value
+	}
+	return attrs, nil
+}
+
+// entryGroup returns the group declared by the attributes of a line, registering it in groups on first use.
+func entryGroup(groups map[string]*pget.ManifestGroup, attrs map[string]string) (*pget.ManifestGroup, error) {
+	name, ok := attrs[attrGroup]
+	checksum, hasChecksum := attrs[attrGroupSHA256]
+	sri, hasIntegrity := attrs[attrGroupIntegrity]
+	if !ok {
+		if hasChecksum {
+			return nil, fmt.Errorf("error parsing manifest: %s given without %s", attrGroupSHA256, attrGroup)
+		}
+		if hasIntegrity {
+			return nil, fmt.Errorf("error parsing manifest: %s given without %s", attrGroupIntegrity, attrGroup)
+		}
+		return nil, nil
+	}
+	group, ok := groups[name]
+	if !ok {
+		group = &pget.ManifestGroup{Name: name}
+		groups[name] = group
+	}
+	if hasChecksum {
+		checksum = strings.ToLower(checksum)
+		if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("error parsing manifest: invalid %s for group %s: %s", attrGroupSHA256, name, checksum)
+		}
+		if group.SHA256 != "" && group.SHA256 != checksum {
+			return nil, fmt.Errorf("error parsing manifest: conflicting %s for group %s", attrGroupSHA256, name)
+		}
+		group.SHA256 = checksum
+		sri = checksum
+	}
+	if hasChecksum || hasIntegrity {
+		expected, err := integrity.Parse(sri)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing manifest: invalid %s for group %s: %w", attrGroupIntegrity, name, err)
+		}
+		if group.Integrity != nil && !group.Integrity.Equal(expected) {
+			return nil, fmt.Errorf("error parsing manifest: conflicting checksums for group %s", name)
+		}
+		group.Integrity = expected
+	}
+	return group, nil
+}
+
+// entryIntegrity returns the expected digest of an entry declared by the attributes of a line, if any.
+func entryIntegrity(attrs map[string]string) (*integrity.Integrity, error) {
+	sri, ok := attrs[attrIntegrity]
+	if !ok {
+		return nil, nil
+	}
+	expected, err := integrity.Parse(sri)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %w", err)
+	}
+	return expected, nil
+}
+
+// entryPriority returns the priority of an entry declared by the attributes of a line, or 0 if it has none.
+func entryPriority(attrs map[string]string) (int, error) {
+	value, ok := attrs[attrPriority]
+	if !ok {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil || priority <= 0 {
+		return 0, fmt.Errorf("error parsing manifest: invalid %s `%s`, expected a positive integer", attrPriority, value)
+	}
+	return priority, nil
+}
+
 func checkSeenDestinations(destinations map[string]string, dest string, url string) error {
 	if seenURL, ok := destinations[dest]; ok {
 		if seenURL != url {
@@ -65,8 +192,15 @@ func checkSeenDestinations(destinations map[string]string, dest string, url stri
 }
 
 func parseManifest(file io.Reader) (pget.Manifest, error) {
+	return readManifest(file, true)
+}
+
+// readManifest parses a manifest. If ensureNotExist is set, it fails on destinations which already exist unless
+// they may be overwritten.
+func readManifest(file io.Reader, ensureNotExist bool) (pget.Manifest, error) {
 	logger := logging.GetLogger()
 	seenDestinations := make(map[string]string)
+	groups := make(map[string]*pget.ManifestGroup)
 	manifest := make(pget.Manifest, 0)
 
 	scanner := bufio.NewScanner(file)
@@ -85,12 +219,36 @@ func parseManifest(file io.Reader) (pget.Manifest, error) {
 			return nil, err
 
 		}
+		attrs, err := parseAttributes(line)
+		if err != nil {
+			return nil, err
+		}
+		for key := range attrs {
+			if !knownAttributes[key] {
+				return nil, fmt.Errorf("error parsing manifest unknown attribute `%s` in line `%s`", key, line)
+			}
+		}
+		group, err := entryGroup(groups, attrs)
+		if err != nil {
+			return nil, err
+		}
+		expected, err := entryIntegrity(attrs)
+		if err != nil {
+			return nil, err
+		}
+		priority, err := entryPriority(attrs)
+		if err != nil {
+			return nil, err
+		}
 
 		// THIS IS A BODGE - FIX ME MOVE THESE THINGS TO PGET
 		// and make the consumer responsible for knowing if this
 		// is allowed/not allowed/etc
 		consumer := viper.GetString(config.OptOutputConsumer)
 		if consumer != config.ConsumerNull {
+			if dest, err = cli.FileDestination(url, dest); err != nil {
+				return nil, err
+			}
 			err = checkSeenDestinations(seenDestinations, dest, url)
 			if err != nil {
 				if errors.Is(err, errDupeURLDestCombo) {
@@ -104,12 +262,19 @@ func parseManifest(file io.Reader) (pget.Manifest, error) {
 			}
 			seenDestinations[dest] = url
 
-			err = cli.EnsureDestinationNotExist(dest)
-			if err != nil {
-				return nil, err
+			// an existing destination is fine if it can be revalidated with --skip-unchanged
+			if ensureNotExist && (!viper.GetBool(config.OptSkipUnchanged) || !validatorsStored(dest)) {
+				err = cli.EnsureDestinationNotExist(dest)
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
-		manifest = manifest.AddEntry(url, dest)
+		pin := download.Pin{ETag: attrs[attrIfMatch], Version: attrs[attrVersion]}
+		if _, err := pin.URL(url); err != nil {
+			return nil, fmt.Errorf("error parsing manifest: %w", err)
+		}
+		manifest = append(manifest, pget.ManifestEntry{URL: url, Dest: dest, Group: group, Integrity: expected, Priority: priority, Pin: pin})
 	}
 
 	return manifest, nil
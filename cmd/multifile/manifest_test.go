@@ -1,12 +1,20 @@
 package multifile
 
 import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/download"
 )
 
 // validManifest is a valid manifest file with additional empty lines
@@ -87,3 +95,119 @@ func TestManifestFile(t *testing.T) {
 	_, err = manifestFile("/does/not/exist")
 	assert.Error(t, err)
 }
+
+func TestParseAttributes(t *testing.T) {
+	attrs, err := parseAttributes("https://example.com/file1.txt /tmp/file1.txt")
+	assert.NoError(t, err)
+	assert.Empty(t, attrs)
+
+	attrs, err = parseAttributes("https://example.com/file1.txt /tmp/file1.txt group=model group-sha256=abc")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"group": "model", "group-sha256": "abc"}, attrs)
+
+	_, err = parseAttributes("https://example.com/file1.txt /tmp/file1.txt group")
+	assert.Error(t, err)
+	_, err = parseAttributes("https://example.com/file1.txt /tmp/file1.txt group=a group=b")
+	assert.Error(t, err)
+}
+
+func TestParseManifestGroups(t *testing.T) {
+	checksum := strings.Repeat("ab", 32)
+	groupManifest := `
+https://example.com/shard1 /tmp/shard1 group=model group-sha256=` + checksum + `
+https://example.com/other /tmp/other
+https://example.com/shard2 /tmp/shard2 group=model`
+
+	parsedManifest, err := parseManifest(strings.NewReader(groupManifest))
+	require.NoError(t, err)
+	require.Len(t, parsedManifest, 3)
+	require.NotNil(t, parsedManifest[0].Group)
+	assert.Nil(t, parsedManifest[1].Group)
+	assert.Same(t, parsedManifest[0].Group, parsedManifest[2].Group)
+	assert.Equal(t, "model", parsedManifest[0].Group.Name)
+	assert.Equal(t, checksum, parsedManifest[0].Group.SHA256)
+	require.NotNil(t, parsedManifest[0].Group.Integrity)
+
+	sri := "sha384-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size384))
+	integrityManifest := `
+https://example.com/shard1 /tmp/shard1 group=model group-integrity=` + sri + `
+https://example.com/shard2 /tmp/shard2 group=model group-integrity=` + sri + `
+https://example.com/other /tmp/other integrity=` + checksum
+
+	parsedManifest, err = parseManifest(strings.NewReader(integrityManifest))
+	require.NoError(t, err)
+	require.Len(t, parsedManifest, 3)
+	assert.Equal(t, sri, parsedManifest[0].Group.Integrity.String())
+	assert.Empty(t, parsedManifest[0].Group.SHA256)
+	assert.Nil(t, parsedManifest[0].Integrity)
+	require.NotNil(t, parsedManifest[2].Integrity)
+	assert.Equal(t, "sha256-"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xab}, 32)), parsedManifest[2].Integrity.String())
+
+	invalidManifests := map[string]string{
+		"checksum without group": `https://example.com/shard1 /tmp/shard1 group-sha256=` + checksum,
+		"invalid checksum":       `https://example.com/shard1 /tmp/shard1 group=model group-sha256=abc`,
+		"conflicting checksums": `https://example.com/shard1 /tmp/shard1 group=model group-sha256=` + checksum + `
+https://example.com/shard2 /tmp/shard2 group=model group-sha256=` + strings.Repeat("cd", 32),
+		"unknown attribute":       `https://example.com/shard1 /tmp/shard1 colour=blue`,
+		"integrity without group": `https://example.com/shard1 /tmp/shard1 group-integrity=` + checksum,
+		"invalid integrity":       `https://example.com/shard1 /tmp/shard1 integrity=md5-abc`,
+		"checksum conflicting with integrity": `https://example.com/shard1 /tmp/shard1 group=model group-sha256=` + checksum + `
+https://example.com/shard2 /tmp/shard2 group=model group-integrity=sha384-` + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size384)),
+	}
+	for name, manifest := range invalidManifests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseManifest(strings.NewReader(manifest))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseManifestPriority(t *testing.T) {
+	parsedManifest, err := parseManifest(strings.NewReader(`
+https://example.com/shard1 /tmp/shard1 priority=1
+https://example.com/other /tmp/other
+https://example.com/shard2 /tmp/shard2 priority=2`))
+	require.NoError(t, err)
+	require.Len(t, parsedManifest, 3)
+	assert.Equal(t, 1, parsedManifest[0].Priority)
+	assert.Equal(t, 0, parsedManifest[1].Priority)
+	assert.Equal(t, 2, parsedManifest[2].Priority)
+
+	for _, value := range []string{"0", "-1", "high"} {
+		_, err := parseManifest(strings.NewReader("https://example.com/shard1 /tmp/shard1 priority=" + value))
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseManifestPin(t *testing.T) {
+	parsedManifest, err := parseManifest(strings.NewReader(`
+https://bucket.s3.amazonaws.com/a /tmp/a if-match="abc" version=v1
+https://bucket.s3.amazonaws.com/b /tmp/b`))
+	require.NoError(t, err)
+	require.Len(t, parsedManifest, 2)
+	assert.Equal(t, download.Pin{ETag: `"abc"`, Version: "v1"}, parsedManifest[0].Pin)
+	assert.True(t, parsedManifest[1].Pin.IsZero())
+
+	_, err = parseManifest(strings.NewReader("https://bucket.s3.amazonaws.com/a?versionId=v1 /tmp/a version=v2"))
+	assert.Error(t, err)
+}
+
+func TestParseManifestDirectoryDestination(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(config.OptOutputConsumer, config.ConsumerFile)
+	dir := t.TempDir()
+
+	parsedManifest, err := parseManifest(strings.NewReader(`
+https://example.com/a/weights.bin ` + dir + `
+https://example.com/b/config.json ` + dir + `/config.json`))
+	require.NoError(t, err)
+	require.Len(t, parsedManifest, 2)
+	assert.Equal(t, filepath.Join(dir, "weights.bin"), parsedManifest[0].Dest)
+	assert.Equal(t, dir+"/config.json", parsedManifest[1].Dest)
+
+	// two URLs with the same file name would be written to the same file
+	_, err = parseManifest(strings.NewReader(`
+https://example.com/a/weights.bin ` + dir + `
+https://example.com/b/weights.bin ` + dir))
+	assert.Error(t, err)
+}
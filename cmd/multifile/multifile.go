@@ -2,20 +2,24 @@ package multifile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	pget "github.com/replicate/pget/pkg"
-	"github.com/replicate/pget/pkg/cli"
-	"github.com/replicate/pget/pkg/client"
-	"github.com/replicate/pget/pkg/config"
-	"github.com/replicate/pget/pkg/download"
-	"github.com/replicate/pget/pkg/logging"
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/hf"
+	"github.com/replicate/pget/v2/pkg/ipfs"
+	"github.com/replicate/pget/v2/pkg/logging"
 )
 
 const longDesc = `
@@ -53,11 +57,25 @@ func GetCommand() *cobra.Command {
 		Example: multifileExamples,
 	}
 
+	cmd.Flags().Bool(config.OptContinueOnError, false, "Keep downloading the other entries when one fails, and report the failures at the end")
+	cmd.Flags().String(config.OptDedupeStrategy, string(pget.DedupeOff), "How to write entries with the same URL as an earlier one: off to download them all, or hardlink or copy to write them from it instead of downloading it again")
+	cmd.Flags().String(config.OptMaxTotalBytes, "", "Stop starting files once the completed files add up to this many bytes (e.g. 100G); files in flight are finished")
+	cmd.Flags().Duration(config.OptMaxTotalTime, 0, "Stop starting files once the run has taken this long (e.g. 30m); files in flight are finished")
+	cmd.Flags().Bool(config.OptSkipUnchanged, false, "Skip entries whose content is unchanged since the last download, using stored ETag/Last-Modified validators")
+	cmd.Flags().Bool(config.OptWarmup, false, "Discover the size of every entry before downloading any, and log the total")
+	cmd.Flags().String(config.OptFileOrder, string(pget.FileOrderManifest), "Order to download the entries in: manifest, smallest-first, largest-first (implies --warmup)")
+	cmd.Flags().Bool(config.OptTUI, false, "Draw a live table of the files being downloaded on the terminal, with their progress, throughput, retries and fallbacks")
+
 	err := viper.BindPFlags(cmd.PersistentFlags())
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	err = viper.BindPFlags(cmd.Flags())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	cmd.SetUsageTemplate(cli.UsageTemplate)
 	return cmd
 }
@@ -66,8 +84,11 @@ func multifilePreRunE(cmd *cobra.Command, args []string) error {
 	if viper.GetBool(config.OptExtract) {
 		return fmt.Errorf("cannot use --extract with multifile mode")
 	}
-	if viper.GetString(config.OptOutputConsumer) == config.ConsumerTarExtractor {
-		return fmt.Errorf("cannot use --output-consumer tar-extractor with multifile mode")
+	switch consumer := viper.GetString(config.OptOutputConsumer); {
+	case consumer == config.ConsumerTarExtractor, consumer == config.ConsumerZipExtractor:
+		return fmt.Errorf("cannot use --output-consumer %s with multifile mode", consumer)
+	case len(config.ConsumerNames()) > 1:
+		return fmt.Errorf("cannot combine consumers (--output-consumer %s) with multifile mode", consumer)
 	}
 	return nil
 }
@@ -80,12 +101,13 @@ func runMultifileCMD(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	defer file.Close()
-	manifest, err := parseManifest(file)
+	// a dry run reports existing destinations instead
+	manifest, err := readManifest(file, !viper.GetBool(config.OptDryRun))
 	if err != nil {
 		return fmt.Errorf("error processing manifest file %s: %w", manifestPath, err)
 	}
 
-	return multifileExecute(cmd.Context(), manifest)
+	return cli.DeadlineError(cmd.Context(), multifileExecute(cmd.Context(), manifest))
 }
 
 func maxConcurrentFiles() int {
@@ -97,40 +119,86 @@ func maxConcurrentFiles() int {
 }
 
 func multifileExecute(ctx context.Context, manifest pget.Manifest) error {
-	chunkSize, err := humanize.ParseBytes(viper.GetString(config.OptChunkSize))
+	chunkSize, autoChunkSize, err := config.ChunkSize()
 	if err != nil {
 		return err
 	}
-
-	// Get the resolution overrides
-	resolveOverrides, err := config.ResolveOverridesToMap(viper.GetStringSlice(config.OptResolve))
+	maxBuffered, err := config.MaxBuffered()
+	if err != nil {
+		return err
+	}
+	maxTotalBytes, err := config.MaxTotalBytes()
 	if err != nil {
-		return fmt.Errorf("error parsing resolve overrides: %w", err)
+		return err
 	}
 
-	clientOpts := client.Options{
-		MaxRetries: viper.GetInt(config.OptRetries),
-		TransportOpts: client.TransportOptions{
-			ForceHTTP2:       viper.GetBool(config.OptForceHTTP2),
-			ConnectTimeout:   viper.GetDuration(config.OptConnTimeout),
-			MaxConnPerHost:   viper.GetInt(config.OptMaxConnPerHost),
-			ResolveOverrides: resolveOverrides,
-		},
+	clientOpts, err := cli.ClientOptions()
+	if err != nil {
+		return err
+	}
+	fileOrder, err := pget.ParseFileOrder(viper.GetString(config.OptFileOrder))
+	if err != nil {
+		return err
+	}
+	dedupeStrategy, err := pget.ParseDedupeStrategy(viper.GetString(config.OptDedupeStrategy))
+	if err != nil {
+		return err
 	}
 	downloadOpts := download.Options{
-		MaxConcurrency: viper.GetInt(config.OptConcurrency),
-		ChunkSize:      int64(chunkSize),
-		Client:         clientOpts,
+		MaxConcurrency:      viper.GetInt(config.OptConcurrency),
+		ChunkSize:           chunkSize,
+		MaxBufferedBytes:    maxBuffered,
+		AutoChunkSize:       autoChunkSize,
+		Client:              clientOpts,
+		AdaptiveConcurrency: viper.GetBool(config.OptAdaptiveConcurrency),
+		ChunkDeadlineFactor: viper.GetFloat64(config.OptChunkDeadlineFactor),
+		LenientContentRange: viper.GetBool(config.OptLenientContentRange),
+		Compressed:          viper.GetBool(config.OptCompressed),
+		PipelineChunks:      viper.GetBool(config.OptPipelineChunks),
+		Strict:              viper.GetBool(config.OptStrict),
+		LowMemory:           viper.GetBool(config.OptLowMemory),
+		IPFSGateways:        viper.GetStringSlice(config.OptIPFSGateway),
+		IPFSSkipVerify:      viper.GetBool(config.OptIPFSSkipVerify),
 	}
 	pgetOpts := pget.Options{
 		MaxConcurrentFiles: maxConcurrentFiles(),
+		SoftTimeout:        viper.GetDuration(config.OptSoftTimeout),
+		HardTimeout:        viper.GetDuration(config.OptDownloadTimeout),
+		HeartbeatInterval:  viper.GetDuration(config.OptHeartbeatInterval),
+		LowMemory:          viper.GetBool(config.OptLowMemory),
+		Warmup:             viper.GetBool(config.OptWarmup),
+		FileOrder:          fileOrder,
+		ContinueOnError:    viper.GetBool(config.OptContinueOnError),
+		EmitSliceSums:      viper.GetBool(config.OptEmitSliceSums),
+		VerifySliceSums:    viper.GetBool(config.OptVerifySliceSums),
+		MaxTotalBytes:      maxTotalBytes,
+		MaxTotalTime:       viper.GetDuration(config.OptMaxTotalTime),
+		DedupeStrategy:     dedupeStrategy,
+	}
+	if viper.GetBool(config.OptTUI) {
+		if cli.IsTerminal(os.Stderr) {
+			pgetOpts.Progress = &pget.Progress{}
+		} else {
+			logger := logging.GetLogger()
+			logger.Info().Msg("Not A Terminal, Ignoring --tui")
+		}
 	}
+	emitter := cli.NewManifestEmitter(viper.GetString(config.OptEmitManifest))
+	printer := cli.NewResultPrinter(viper.GetBool(config.OptJSONOutput), os.Stdout)
+	pgetOpts.OnFileComplete = cli.OnFileComplete(emitter, printer)
 
 	consumer, err := config.GetConsumer()
 	if err != nil {
 		return fmt.Errorf("error getting consumer: %w", err)
 	}
 
+	if viper.GetBool(config.OptSkipUnchanged) {
+		manifest, consumer, err = skipUnchanged(ctx, client.NewHTTPClient(clientOpts), manifest, consumer, maxConcurrentFiles())
+		if err != nil {
+			return fmt.Errorf("error checking for unchanged files: %w", err)
+		}
+	}
+
 	getter := &pget.Getter{
 		Downloader: download.GetBufferMode(downloadOpts),
 		Consumer:   consumer,
@@ -139,26 +207,85 @@ func multifileExecute(ctx context.Context, manifest pget.Manifest) error {
 
 	// TODO DRY this
 	if srvName := config.GetCacheSRV(); srvName != "" {
-		downloadOpts.SliceSize = 500 * humanize.MiByte
 		downloadOpts.CacheableURIPrefixes = config.CacheableURIPrefixes()
+		downloadOpts.CacheURIAliases = config.GetURIAliases()
 		downloadOpts.CacheUsePathProxy = viper.GetBool(config.OptCacheUsePathProxy)
-		downloadOpts.CacheHosts, err = cli.LookupCacheHosts(srvName)
+		if rewrite := viper.GetString(config.OptCacheRewrite); rewrite != "" {
+			downloadOpts.CacheRewriter, err = download.ParseCacheRewriter(rewrite)
+			if err != nil {
+				return err
+			}
+		}
+		downloadOpts.CacheKeyRules, err = download.ParseCacheKeyRules(viper.GetStringSlice(config.OptCacheKeyRules))
 		if err != nil {
 			return err
 		}
-		getter.Downloader, err = download.GetConsistentHashingMode(downloadOpts)
+		downloadOpts.CacheFallbackThreshold = viper.GetFloat64(config.OptCacheFallbackThreshold)
+		downloadOpts.CacheHealthCheckPath = viper.GetString(config.OptCacheHealthCheckPath)
+		downloadOpts.CacheHealthCheckInterval = viper.GetDuration(config.OptCacheHealthCheckInterval)
+		downloadOpts.CachePrewarmConnections = viper.GetInt(config.OptPrewarmConnections)
+		downloadOpts.CacheHosts, err = cli.LookupCacheHosts(ctx, clientOpts.TransportOpts.Resolver, srvName)
+		if err != nil {
+			return err
+		}
+		if err := cli.ApplyCacheTuning(ctx, clientOpts.TransportOpts.Resolver, srvName, &downloadOpts); err != nil {
+			return err
+		}
+		mode, err := download.GetConsistentHashingMode(downloadOpts)
+		if err != nil {
+			return err
+		}
+		defer mode.Close()
+		getter.Downloader = mode
+	}
+	if slices.ContainsFunc(manifest, func(entry pget.ManifestEntry) bool { return ipfs.IsURL(entry.URL) }) {
+		getter.Downloader, err = download.GetIPFSMode(downloadOpts, getter.Downloader)
+		if err != nil {
+			return err
+		}
+	}
+	if slices.ContainsFunc(manifest, func(entry pget.ManifestEntry) bool { return hf.IsURL(entry.URL) }) {
+		downloadOpts.HFEndpoint = hf.Endpoint()
+		downloadOpts.HFToken, err = hf.Token()
+		if err != nil {
+			return err
+		}
+		getter.Downloader, err = download.GetHFMode(downloadOpts, getter.Downloader)
+		if err != nil {
+			return err
+		}
+	}
+	if slices.ContainsFunc(manifest, func(entry pget.ManifestEntry) bool { return download.IsReplicateURL(entry.URL) }) {
+		downloadOpts.ReplicateCredentialsEndpoint = viper.GetString(config.OptReplicateCredentials)
+		downloadOpts.ReplicateAPIToken = os.Getenv("REPLICATE_API_TOKEN")
+		getter.Downloader, err = download.GetReplicateMode(downloadOpts, getter.Downloader)
 		if err != nil {
 			return err
 		}
 	}
 
+	if viper.GetBool(config.OptDryRun) {
+		return cli.DryRun(ctx, os.Stdout, getter.Downloader, manifest)
+	}
+	stopStats := cli.StartStatsLogger(ctx, viper.GetDuration(config.OptStatsInterval), clientOpts.Transport)
+	defer stopStats()
+	stopDashboard := cli.StartDashboard(ctx, os.Stderr, getter.Options.Progress)
+	start := time.Now()
 	totalFileSize, elapsedTime, err := getter.DownloadFiles(ctx, manifest)
+	stopDashboard()
+	printErr := printer.Print(time.Since(start), err)
+	if emitErr := emitter.Write(); emitErr != nil || printErr != nil {
+		return errors.Join(err, emitErr, printErr)
+	}
+	logger := logging.GetLogger()
 	if err != nil {
+		if pgetOpts.ContinueOnError || errors.Is(err, pget.ErrBudgetExceeded) {
+			logSummary(manifest, err)
+		}
 		return err
 	}
 
 	throughput := float64(totalFileSize) / elapsedTime.Seconds()
-	logger := logging.GetLogger()
 	logger.Info().
 		Int("file_count", len(manifest)).
 		Str("total_bytes_downloaded", humanize.Bytes(uint64(totalFileSize))).
@@ -168,3 +295,44 @@ func multifileExecute(ctx context.Context, manifest pget.Manifest) error {
 
 	return nil
 }
+
+// logSummary logs how many entries of manifest were downloaded, which failed and why, and which were skipped, from
+// the error DownloadFiles returned with pget.Options.ContinueOnError or a run budget.
+func logSummary(manifest pget.Manifest, err error) {
+	var failures []string
+	var missingErr *pget.MissingEntriesError
+	if errors.As(err, &missingErr) {
+		for _, entry := range missingErr.Entries {
+			failures = append(failures, fmt.Sprintf("%s (%s): not found", entry.Dest, entry.URL))
+		}
+	}
+	var failedErr *pget.FailedEntriesError
+	if errors.As(err, &failedErr) {
+		for _, f := range failedErr.Failures {
+			failures = append(failures, fmt.Sprintf("%s (%s): %v", f.Entry.Dest, f.Entry.URL, f.Err))
+		}
+	}
+	var skipped []string
+	var budgetErr *pget.BudgetExceededError
+	if errors.As(err, &budgetErr) {
+		for _, entry := range budgetErr.Skipped {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", entry.Dest, entry.URL))
+		}
+	}
+	if len(failures) == 0 && len(skipped) == 0 {
+		return
+	}
+	slices.Sort(failures)
+	logger := logging.GetLogger()
+	event := logger.Warn().
+		Int("succeeded", len(manifest)-len(failures)-len(skipped)).
+		Int("failed", len(failures)).
+		Strs("failures", failures)
+	if budgetErr != nil {
+		event = event.
+			Int("skipped", len(skipped)).
+			Strs("skipped_entries", skipped).
+			Str("budget", budgetErr.Reason)
+	}
+	event.Msg("Summary")
+}
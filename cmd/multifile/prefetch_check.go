@@ -0,0 +1,209 @@
+package multifile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/validators"
+)
+
+const prefetchCheckLongDesc = `
+'prefetch-check' reads a manifest in the 'multifile' format and, without downloading anything, prints which
+entries a 'pget multifile' run would download and an estimate of the total number of bytes.
+
+Each entry is checked with a single-byte request, made conditional if validators were stored for the destination
+by a previous 'pget multifile --skip-unchanged' run. Entries are reported as one of:
+
+  missing    the destination does not exist
+  changed    the stored validators no longer match
+  unchanged  the stored validators still match
+  exists     the destination exists without validators (only downloaded with --force)
+
+Output is one tab-separated line per entry (status, size in bytes, URL, destination), followed by a summary.
+`
+
+const prefetchCheckExamples = `
+  pget prefetch-check manifest.txt
+
+  pget prefetch-check --force manifest.txt
+`
+
+const (
+	prefetchMissing   = "missing"
+	prefetchChanged   = "changed"
+	prefetchUnchanged = "unchanged"
+	prefetchExists    = "exists"
+)
+
+var prefetchContentRangeRegexp = regexp.MustCompile(`^bytes [0-9]+-[0-9]+/([0-9]+)$`)
+
+type prefetchResult struct {
+	status string
+	// size is -1 if the server did not report it
+	size int64
+}
+
+// needsDownload reports whether a multifile run would download the entry.
+func (r prefetchResult) needsDownload(force bool) bool {
+	switch r.status {
+	case prefetchMissing, prefetchChanged:
+		return true
+	case prefetchExists:
+		return force
+	}
+	return false
+}
+
+func GetPrefetchCheckCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "prefetch-check [flags] <manifest-file>",
+		Short:   "report which manifest entries need downloading, without downloading them",
+		Long:    prefetchCheckLongDesc,
+		Args:    cobra.ExactArgs(1),
+		RunE:    runPrefetchCheckCMD,
+		Example: prefetchCheckExamples,
+	}
+	cmd.SetUsageTemplate(cli.UsageTemplate)
+	return cmd
+}
+
+func runPrefetchCheckCMD(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	manifestPath := args[0]
+	file, err := manifestFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	manifest, err := readManifest(file, false)
+	if err != nil {
+		return fmt.Errorf("error processing manifest file %s: %w", manifestPath, err)
+	}
+	clientOpts, err := cli.ClientOptions()
+	if err != nil {
+		return err
+	}
+
+	results, err := prefetchCheck(cmd.Context(), client.NewHTTPClient(clientOpts), manifest, maxConcurrentFiles())
+	if err != nil {
+		return cli.DeadlineError(cmd.Context(), err)
+	}
+	policy, err := config.OverwritePolicy()
+	if err != nil {
+		return err
+	}
+	printPrefetchCheck(cmd.OutOrStdout(), manifest, results, policy.Allowed())
+	return nil
+}
+
+func printPrefetchCheck(w io.Writer, manifest pget.Manifest, results []prefetchResult, force bool) {
+	var count int
+	var total int64
+	unknownSizes := false
+	for i, entry := range manifest {
+		result := results[i]
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", result.status, result.size, entry.URL, entry.Dest)
+		if !result.needsDownload(force) {
+			continue
+		}
+		count++
+		if result.size < 0 {
+			unknownSizes = true
+			continue
+		}
+		total += result.size
+	}
+	estimate := humanize.Bytes(uint64(total))
+	if unknownSizes {
+		estimate = "at least " + estimate
+	}
+	fmt.Fprintf(w, "%d of %d entries need downloading, %s (%d bytes)\n", count, len(manifest), estimate, total)
+}
+
+func prefetchCheck(ctx context.Context, httpClient client.HTTPClient, manifest pget.Manifest, concurrency int) ([]prefetchResult, error) {
+	results := make([]prefetchResult, len(manifest))
+	errGroup, ctx := errgroup.WithContext(ctx)
+	errGroup.SetLimit(concurrency)
+	for i, entry := range manifest {
+		errGroup.Go(func() error {
+			var err error
+			results[i], err = checkEntry(ctx, httpClient, entry)
+			return err
+		})
+	}
+	if err := errGroup.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkEntry makes a single-byte request for entry, conditional on the validators stored for its destination if
+// there are any, and reports whether and how much it would need to download.
+func checkEntry(ctx context.Context, httpClient client.HTTPClient, entry pget.ManifestEntry) (prefetchResult, error) {
+	status := prefetchMissing
+	if _, err := os.Stat(entry.Dest); err == nil {
+		status = prefetchExists
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return prefetchResult{}, fmt.Errorf("error checking %s: %w", entry.Dest, err)
+	}
+	stored, err := validators.Load(entry.Dest)
+	if err != nil {
+		return prefetchResult{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.URL, nil)
+	if err != nil {
+		return prefetchResult{}, fmt.Errorf("failed to check %s: %w", entry.URL, err)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	// validators recorded for a different URL say nothing about this one
+	if stored != nil && stored.URL == entry.URL {
+		stored.Apply(req)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return prefetchResult{}, fmt.Errorf("error executing request for %s: %w", entry.URL, err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return prefetchResult{status: prefetchUnchanged, size: -1}, nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return prefetchResult{}, fmt.Errorf("unexpected http status %s: %s", entry.URL, resp.Status)
+	}
+	if stored != nil && stored.URL == entry.URL {
+		status = prefetchChanged
+	}
+	return prefetchResult{status: status, size: responseSize(resp)}, nil
+}
+
+// responseSize returns the size of the whole object from a response to a range request, or -1 if it is unknown.
+func responseSize(resp *http.Response) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		if groups := prefetchContentRangeRegexp.FindStringSubmatch(resp.Header.Get("Content-Range")); groups != nil {
+			if size, err := strconv.ParseInt(groups[1], 10, 64); err == nil {
+				return size
+			}
+		}
+		return -1
+	}
+	// the server ignored the Range header and sent the whole object
+	return resp.ContentLength
+}
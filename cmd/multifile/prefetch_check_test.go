@@ -0,0 +1,66 @@
+package multifile
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/validators"
+)
+
+func TestPrefetchCheck(t *testing.T) {
+	content := []byte("hello, world!")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+r.URL.Path+`-v2"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	unchangedDest := filepath.Join(dir, "unchanged")
+	changedDest := filepath.Join(dir, "changed")
+	existsDest := filepath.Join(dir, "exists")
+	missingDest := filepath.Join(dir, "missing")
+	for _, dest := range []string{unchangedDest, changedDest, existsDest} {
+		require.NoError(t, os.WriteFile(dest, []byte("old content"), 0644))
+	}
+	require.NoError(t, validators.Save(unchangedDest, validators.Validators{URL: server.URL + "/unchanged", ETag: `"/unchanged-v2"`}))
+	require.NoError(t, validators.Save(changedDest, validators.Validators{URL: server.URL + "/changed", ETag: `"/changed-v1"`}))
+
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(server.URL+"/unchanged", unchangedDest)
+	manifest = manifest.AddEntry(server.URL+"/changed", changedDest)
+	manifest = manifest.AddEntry(server.URL+"/exists", existsDest)
+	manifest = manifest.AddEntry(server.URL+"/missing", missingDest)
+
+	results, err := prefetchCheck(context.Background(), client.NewHTTPClient(client.Options{}), manifest, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []prefetchResult{
+		{status: prefetchUnchanged, size: -1},
+		{status: prefetchChanged, size: 13},
+		{status: prefetchExists, size: 13},
+		{status: prefetchMissing, size: 13},
+	}, results)
+
+	var out strings.Builder
+	printPrefetchCheck(&out, manifest, results, false)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, "changed\t13\t"+server.URL+"/changed\t"+changedDest, lines[1])
+	assert.Equal(t, "2 of 4 entries need downloading, 26 B (26 bytes)", lines[4])
+
+	out.Reset()
+	printPrefetchCheck(&out, manifest, results, true)
+	assert.Contains(t, out.String(), "3 of 4 entries need downloading, 39 B (39 bytes)")
+}
@@ -0,0 +1,173 @@
+package multifile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/sync/errgroup"
+
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/validators"
+)
+
+// validatorRecorder wraps a consumer and records the validators captured by the conditional check once the
+// destination has been written successfully.
+type validatorRecorder struct {
+	consumer.Consumer
+	// validators is keyed by destination and is read-only once downloads start
+	validators map[string]validators.Validators
+}
+
+var _ consumer.Consumer = &validatorRecorder{}
+
+func (r *validatorRecorder) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	if err := r.Consumer.Consume(reader, destPath, expectedBytes); err != nil {
+		return err
+	}
+	return r.save(destPath)
+}
+
+// save stores the validators captured for destPath, now that it has been written.
+func (r *validatorRecorder) save(destPath string) error {
+	if v, ok := r.validators[destPath]; ok && !v.Empty() {
+		return validators.Save(destPath, v)
+	}
+	return nil
+}
+
+// Unwrap returns the consumer the content is written with.
+func (r *validatorRecorder) Unwrap() consumer.Consumer {
+	return r.Consumer
+}
+
+// readerAtRecorder is a validatorRecorder of a consumer.ReaderAtConsumer, which keeps reading the download out of
+// order.
+type readerAtRecorder struct {
+	*validatorRecorder
+}
+
+var _ consumer.ReaderAtConsumer = readerAtRecorder{}
+
+func (r readerAtRecorder) ConsumeAt(reader io.ReaderAt, destPath string, size int64) error {
+	if err := r.Consumer.(consumer.ReaderAtConsumer).ConsumeAt(reader, destPath, size); err != nil {
+		return err
+	}
+	return r.save(destPath)
+}
+
+// validatorsStored returns true if dest exists and has validators stored alongside it.
+func validatorsStored(dest string) bool {
+	v, err := validators.Load(dest)
+	return err == nil && v != nil
+}
+
+// skipUnchanged issues a conditional request for every manifest entry and drops the entries the server reports as
+// unchanged (304 Not Modified), except for the shards of a group, which are marked Unchanged instead so that the
+// group is still accounted for in full. The returned consumer records fresh validators for the remaining entries as
+// they are downloaded.
+func skipUnchanged(ctx context.Context, httpClient client.HTTPClient, manifest pget.Manifest, c consumer.Consumer, concurrency int) (pget.Manifest, consumer.Consumer, error) {
+	logger := logging.GetLogger()
+	unchanged := make([]bool, len(manifest))
+	fresh := make([]validators.Validators, len(manifest))
+
+	errGroup, ctx := errgroup.WithContext(ctx)
+	errGroup.SetLimit(concurrency)
+	for i, entry := range manifest {
+		errGroup.Go(func() error {
+			var err error
+			unchanged[i], fresh[i], err = checkUnchanged(ctx, httpClient, entry)
+			return err
+		})
+	}
+	if err := errGroup.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	remaining := make(pget.Manifest, 0, len(manifest))
+	recorder := &validatorRecorder{Consumer: c, validators: make(map[string]validators.Validators)}
+	for i, entry := range manifest {
+		if unchanged[i] {
+			logger.Info().
+				Str("url", entry.URL).
+				Str("dest", entry.Dest).
+				Msg("Skip Unchanged")
+			if entry.Group != nil {
+				entry.Unchanged = true
+				remaining = append(remaining, entry)
+			}
+			continue
+		}
+		remaining = append(remaining, entry)
+		recorder.validators[entry.Dest] = fresh[i]
+	}
+	if _, ok := c.(consumer.ReaderAtConsumer); ok {
+		return remaining, readerAtRecorder{recorder}, nil
+	}
+	return remaining, recorder, nil
+}
+
+// checkable returns true if the conditional request of checkUnchanged can be sent to rawURL as it is. Replicate
+// weights are signed (and re-signed) by their strategy, so even their https:// URLs are left to it.
+func checkable(rawURL string) bool {
+	if download.IsReplicateURL(rawURL) {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// checkUnchanged makes a conditional single-byte request for entry using any validators stored for its
+// destination. It returns true if the server reported the content as unchanged, otherwise it returns the validators
+// from the server's response. Entries which are not plain HTTP(S) URLs, such as ipfs:// and replicate:// ones, or hf:// ones
+// which depend on the Hub endpoint, token and pin, are only resolved by their strategy, so they are not checked and always
+// downloaded.
+func checkUnchanged(ctx context.Context, httpClient client.HTTPClient, entry pget.ManifestEntry) (bool, validators.Validators, error) {
+	logger := logging.GetLogger()
+	if !checkable(entry.URL) {
+		logger.Debug().
+			Str("url", entry.URL).
+			Msg("Skip Unchanged: not checked")
+		return false, validators.Validators{}, nil
+	}
+	stored, err := validators.Load(entry.Dest)
+	if err != nil {
+		return false, validators.Validators{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.URL, nil)
+	if err != nil {
+		return false, validators.Validators{}, fmt.Errorf("failed to check %s: %w", entry.URL, err)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	// validators recorded for a different URL say nothing about this one
+	if stored != nil && stored.URL == entry.URL {
+		stored.Apply(req)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, validators.Validators{}, fmt.Errorf("error executing request for %s: %w", entry.URL, err)
+	}
+	// a server which ignores the range sends the whole object, of which only enough is read to reuse the connection
+	// for a short response
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4*1024))
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return true, validators.Validators{}, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// leave reporting the failure to the download itself
+		logger.Debug().
+			Str("url", entry.URL).
+			Str("status", resp.Status).
+			Msg("Skip Unchanged: conditional request failed")
+		return false, validators.Validators{}, nil
+	}
+	return false, validators.FromResponse(entry.URL, resp), nil
+}
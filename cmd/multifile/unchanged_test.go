@@ -0,0 +1,225 @@
+package multifile
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/overwrite"
+	"github.com/replicate/pget/v2/pkg/validators"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	content := []byte("hello, world!")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+r.URL.Path+`-v2"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	unchangedDest := filepath.Join(dir, "unchanged")
+	changedDest := filepath.Join(dir, "changed")
+	newDest := filepath.Join(dir, "new")
+	for _, dest := range []string{unchangedDest, changedDest} {
+		require.NoError(t, os.WriteFile(dest, []byte("old content"), 0644))
+	}
+	require.NoError(t, validators.Save(unchangedDest, validators.Validators{URL: server.URL + "/unchanged", ETag: `"/unchanged-v2"`}))
+	require.NoError(t, validators.Save(changedDest, validators.Validators{URL: server.URL + "/changed", ETag: `"/changed-v1"`}))
+
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(server.URL+"/unchanged", unchangedDest)
+	manifest = manifest.AddEntry(server.URL+"/changed", changedDest)
+	manifest = manifest.AddEntry(server.URL+"/new", newDest)
+
+	remaining, c, err := skipUnchanged(context.Background(), client.NewHTTPClient(client.Options{}), manifest, &consumer.FileWriter{Overwrite: overwrite.Always}, 2)
+	require.NoError(t, err)
+	require.Len(t, remaining, 2)
+	assert.Equal(t, changedDest, remaining[0].Dest)
+	assert.Equal(t, newDest, remaining[1].Dest)
+
+	// consuming records the new validators alongside the destination
+	for _, entry := range remaining {
+		require.NoError(t, c.Consume(bytes.NewReader(content), entry.Dest, int64(len(content))))
+	}
+	v, err := validators.Load(changedDest)
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	assert.Equal(t, `"/changed-v2"`, v.ETag)
+	v, err = validators.Load(newDest)
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	assert.Equal(t, `"/new-v2"`, v.ETag)
+
+	f, err := os.Open(changedDest)
+	require.NoError(t, err)
+	defer f.Close()
+	written, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, content, written)
+}
+
+func TestSkipUnchangedKeepsGroupShards(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("hello, world!")))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "shard-1")
+	require.NoError(t, os.WriteFile(dest, []byte("hello, world!"), 0644))
+	require.NoError(t, validators.Save(dest, validators.Validators{URL: server.URL + "/shard-1", ETag: `"v1"`}))
+
+	group := &pget.ManifestGroup{Name: "greeting"}
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddGroupEntry(server.URL+"/shard-1", dest, group)
+	manifest = manifest.AddGroupEntry(server.URL+"/shard-2", filepath.Join(dir, "shard-2"), group)
+
+	remaining, _, err := skipUnchanged(context.Background(), client.NewHTTPClient(client.Options{}), manifest, &consumer.FileWriter{}, 2)
+	require.NoError(t, err)
+	require.Len(t, remaining, 2)
+	assert.True(t, remaining[0].Unchanged)
+	assert.False(t, remaining[1].Unchanged)
+}
+
+func TestCheckUnchangedIgnoredRange(t *testing.T) {
+	const size = 64 * 1024 * 1024
+	written := make(chan int64, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the range is ignored and the whole object is sent
+		w.Header().Set("Content-Length", "67108864")
+		n, _ := io.Copy(w, io.LimitReader(zeroReader{}, size))
+		written <- n
+	}))
+	defer server.Close()
+
+	entry := pget.ManifestEntry{URL: server.URL + "/object", Dest: filepath.Join(t.TempDir(), "object")}
+	unchanged, _, err := checkUnchanged(context.Background(), client.NewHTTPClient(client.Options{}), entry)
+	require.NoError(t, err)
+	assert.False(t, unchanged)
+	assert.Less(t, <-written, int64(size))
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestSkipUnchangedUncheckableURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("hello, world!")))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	unchangedDest := filepath.Join(dir, "unchanged")
+	require.NoError(t, os.WriteFile(unchangedDest, []byte("hello, world!"), 0644))
+	require.NoError(t, validators.Save(unchangedDest, validators.Validators{URL: server.URL + "/unchanged", ETag: `"v1"`}))
+
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(server.URL+"/unchanged", unchangedDest)
+	manifest = manifest.AddEntry("ipfs://bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/weights.bin", filepath.Join(dir, "ipfs"))
+	manifest = manifest.AddEntry("hf://openai-community/gpt2/config.json", filepath.Join(dir, "hf"))
+	manifest = manifest.AddEntry("replicate://owner/model/weights.bin", filepath.Join(dir, "replicate"))
+
+	// the entries which can't be checked are downloaded rather than failing the whole run
+	remaining, _, err := skipUnchanged(context.Background(), client.NewHTTPClient(client.Options{}), manifest, &consumer.FileWriter{}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, manifest[1:], remaining)
+}
+
+func TestSkipUnchangedDedupe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("hello, world!")))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(server.URL+"/hello.txt", filepath.Join(dir, "a"))
+	manifest = manifest.AddEntry(server.URL+"/hello.txt", filepath.Join(dir, "b"))
+	httpClient := client.NewHTTPClient(client.Options{})
+
+	remaining, c, err := skipUnchanged(context.Background(), httpClient, manifest, &consumer.FileWriter{}, 2)
+	require.NoError(t, err)
+	getter := &pget.Getter{
+		Downloader: download.GetBufferMode(download.Options{}),
+		Consumer:   c,
+		Options:    pget.Options{DedupeStrategy: pget.DedupeHardlink},
+	}
+	_, _, err = getter.DownloadFiles(context.Background(), remaining)
+	require.NoError(t, err)
+
+	// the duplicate is written from the first entry along with its validators, so neither is downloaded again
+	remaining, _, err = skipUnchanged(context.Background(), httpClient, manifest, &consumer.FileWriter{}, 2)
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+}
+
+// readerAtSpy writes the content it is given to destPath, recording whether it was read out of order.
+type readerAtSpy struct {
+	consumedAt bool
+}
+
+func (s *readerAtSpy) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(destPath, data, 0644)
+}
+
+func (s *readerAtSpy) ConsumeAt(r io.ReaderAt, destPath string, size int64) error {
+	s.consumedAt = true
+	return s.Consume(io.NewSectionReader(r, 0, size), destPath, size)
+}
+
+func TestSkipUnchangedReaderAt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte("hello, world!")))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "dest")
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(server.URL+"/hello.txt", dest)
+	httpClient := client.NewHTTPClient(client.Options{})
+
+	// a consumer which reads the download out of order still can once wrapped
+	spy := &readerAtSpy{}
+	_, c, err := skipUnchanged(context.Background(), httpClient, manifest, spy, 1)
+	require.NoError(t, err)
+	readerAt, ok := c.(consumer.ReaderAtConsumer)
+	require.True(t, ok)
+	require.NoError(t, readerAt.ConsumeAt(bytes.NewReader([]byte("hello, world!")), dest, 13))
+	assert.True(t, spy.consumedAt)
+	v, err := validators.Load(dest)
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	assert.Equal(t, `"v1"`, v.ETag)
+
+	// while one which reads it in order is left to
+	_, c, err = skipUnchanged(context.Background(), httpClient, manifest, &consumer.FileWriter{}, 1)
+	require.NoError(t, err)
+	_, ok = c.(consumer.ReaderAtConsumer)
+	assert.False(t, ok)
+}
@@ -2,23 +2,33 @@ package root
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"time"
 
-	"github.com/dustin/go-humanize"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	"github.com/replicate/pget/cmd/version"
-	pget "github.com/replicate/pget/pkg"
-	"github.com/replicate/pget/pkg/cli"
-	"github.com/replicate/pget/pkg/client"
-	"github.com/replicate/pget/pkg/config"
-	"github.com/replicate/pget/pkg/download"
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/cmd/capabilities"
+	"github.com/replicate/pget/v2/cmd/inspect"
+	"github.com/replicate/pget/v2/cmd/simulate"
+	"github.com/replicate/pget/v2/cmd/verify"
+	"github.com/replicate/pget/v2/cmd/version"
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/extract"
+	"github.com/replicate/pget/v2/pkg/hf"
+	"github.com/replicate/pget/v2/pkg/integrity"
+	"github.com/replicate/pget/v2/pkg/ipfs"
+	"github.com/replicate/pget/v2/pkg/overwrite"
 )
 
 const rootLongDesc = `
@@ -41,11 +51,19 @@ performance, especially when dealing with large tar files. This makes PGet not j
 efficient file extractor, providing a streamlined solution for fetching and unpacking files.
 `
 
-var concurrency int
 var pidFile *cli.PIDFile
-var chunkSize string
 
-const chunkSizeDefault = "125M"
+// stopTotalDeadline releases the context of --total-deadline
+var stopTotalDeadline context.CancelFunc
+
+const (
+	chunkSizeDefault = "125M"
+	sliceSizeDefault = "500MiB"
+)
+
+// noDownloadCMDNames are the commands which don't download anything, so they don't take the PID file lock and can run
+// alongside a download.
+var noDownloadCMDNames = []string{version.VersionCMDName, capabilities.CapabilitiesCMDName, simulate.RebalanceCMDName, inspect.HashCMDName, verify.VerifyCMDName}
 
 func GetCommand() *cobra.Command {
 	cmd := &cobra.Command{
@@ -59,6 +77,11 @@ func GetCommand() *cobra.Command {
 		Example:            `  pget https://example.com/file.tar ./target-dir`,
 	}
 	cmd.Flags().BoolP(config.OptExtract, "x", false, "OptExtract archive after download")
+	cmd.Flags().String(config.OptIfMatch, "", "ETag the object must have; a download of another version fails instead of mixing chunks of both")
+	cmd.Flags().String(config.OptIntegrity, "", "Expected digest of the file, in Subresource Integrity format (e.g. sha384-<base64>) or as a hex SHA-256")
+	cmd.Flags().StringSlice(config.OptMirror, []string{}, "Another URL serving the same file; chunks are spread across the URL and its mirrors (may be repeated)")
+	cmd.Flags().Bool(config.OptMirrorLatencyWeighted, false, "Assign chunks to mirrors by their measured throughput instead of round-robin")
+	cmd.Flags().String(config.OptObjectVersion, "", "Version of the object to download: its S3 versionId, or its GCS generation")
 	cmd.SetUsageTemplate(cli.UsageTemplate)
 	config.ViperInit()
 	if err := persistentFlags(cmd); err != nil {
@@ -82,13 +105,15 @@ func GetCommand() *cobra.Command {
 // defaultPidFilePath returns the default path for the PID file. Notably modern OS X variants
 // have permissions difficulties in /var/run etc.
 func defaultPidFilePath() string {
-	// If we're on OS X, use the user's home directory
+	// If we're on OS X, use the user's home directory, on Windows the temp directory
 	// Otherwise, use /run
 	path := "/run/pget.pid"
 	if xdgPath, ok := os.LookupEnv("XDG_RUNTIME_DIR"); ok {
 		path = xdgPath + "/pget.pid"
 	} else if runtime.GOOS == "darwin" {
 		path = os.Getenv("HOME") + "/.pget.pid"
+	} else if runtime.GOOS == "windows" {
+		path = filepath.Join(os.TempDir(), "pget.pid")
 	}
 	return path
 }
@@ -107,56 +132,44 @@ func pidFlock(pidFilePath string) error {
 }
 
 func rootPersistentPreRunEFunc(cmd *cobra.Command, args []string) error {
-	logger := logging.GetLogger()
 	if err := config.PersistentStartupProcessFlags(); err != nil {
 		return err
 	}
-	if cmd.CalledAs() != version.VersionCMDName {
+	if !slices.Contains(noDownloadCMDNames, cmd.CalledAs()) {
 		if err := pidFlock(viper.GetString(config.OptPIDFile)); err != nil {
 			return err
 		}
 	}
 
-	// Handle chunk size flags (deprecation and overwriting where needed)
-	//
-	// Expected Behavior for chunk size flags:
-	// * If either cli option is set, use that value
-	// * If both are set, emit an error
-	// * If neither are set, use ENV values
-	// ** If PGET_CHUNK_SIZE is set, use that value
-	// ** If PGET_CHUNK_SIZE is not set, use PGET_MINIMUM_CHUNK_SIZE if set
-	//    NOTE: PGET_MINIMUM_CHUNK_SIZE value is just set over the key for PGET_CHUNK_SIZE
-	//    Warning message will be emitted
-	// ** If both PGET_CHUNK_SIZE and PGET_MINIMUM_CHUNK_SIZE are set, use PGET_CHUNK_SIZE
-	//    Warning message will be emitted if they differ
-	// * If neither are set, use the default value
-
-	changedMin := cmd.PersistentFlags().Changed(config.OptMinimumChunkSize)
-	changedChunk := cmd.PersistentFlags().Changed(config.OptChunkSize)
-	if changedMin && changedChunk {
-		return fmt.Errorf("--minimum-chunk-size and --chunk-size cannot be used at the same time, use --chunk-size instead")
-	}
-	minChunkSizeEnv := viper.GetString(config.OptMinimumChunkSize)
-	chunkSizeEnv := viper.GetString(config.OptChunkSize)
-	if minChunkSizeEnv != chunkSizeDefault && minChunkSizeEnv != chunkSizeEnv {
-		if chunkSizeEnv == chunkSizeDefault {
-			logger.Warn().Msg("Using PGET_MINIMUM_CHUNK_SIZE is deprecated, use PGET_CHUNK_SIZE instead")
-			viper.Set(config.OptChunkSize, minChunkSizeEnv)
-		} else {
-			logger.Warn().Msg("Both PGET_MINIMUM_CHUNK_SIZE and PGET_CHUNK_SIZE are set, using PGET_CHUNK_SIZE")
-		}
+	// --max-chunks, --minimum-chunk-size and --hard-timeout (and their environment variables) are deprecated aliases
+	if err := config.ResolveAliases(cmd); err != nil {
+		return err
 	}
 
+	// the deadline is measured from here for every subcommand; it is released by rootPersistentPostRunEFunc, or
+	// when the process exits after a failure
+	var ctx context.Context
+	ctx, stopTotalDeadline = cli.WithTotalDeadline(cmd.Context())
+	cmd.SetContext(ctx)
+
 	if viper.GetBool(config.OptExtract) {
 		// TODO: decide what to do when --output is set *and* --extract is set
 		log.Debug().Msg("Tar Extract Enabled")
-		viper.Set(config.OptOutputConsumer, config.ConsumerTarExtractor)
+		if viper.GetString(config.OptArchiveDest) != "" {
+			// keep a copy of the archive as well
+			viper.Set(config.OptOutputConsumer, config.ConsumerFile+"+"+config.ConsumerTarExtractor)
+		} else {
+			viper.Set(config.OptOutputConsumer, config.ConsumerTarExtractor)
+		}
 	}
 
 	return nil
 }
 
 func rootPersistentPostRunEFunc(cmd *cobra.Command, args []string) error {
+	if stopTotalDeadline != nil {
+		stopTotalDeadline()
+	}
 	if pidFile != nil {
 		return pidFile.Release()
 	}
@@ -165,20 +178,73 @@ func rootPersistentPostRunEFunc(cmd *cobra.Command, args []string) error {
 
 func persistentFlags(cmd *cobra.Command) error {
 	// Persistent Flags (applies to all commands/subcommands)
-	cmd.PersistentFlags().IntVarP(&concurrency, config.OptConcurrency, "c", runtime.GOMAXPROCS(0)*4, "Maximum number of concurrent downloads/maximum number of chunks for a given file")
-	cmd.PersistentFlags().IntVar(&concurrency, config.OptMaxChunks, runtime.GOMAXPROCS(0)*4, "Maximum number of chunks for a given file")
+	cmd.PersistentFlags().IntP(config.OptConcurrency, "c", runtime.GOMAXPROCS(0)*4, "Maximum number of concurrent downloads/maximum number of chunks for a given file")
+	cmd.PersistentFlags().Int(config.OptMaxChunks, runtime.GOMAXPROCS(0)*4, "Maximum number of chunks for a given file")
+	cmd.PersistentFlags().Bool(config.OptAdaptiveConcurrency, false, "Reduce a download to 1-4 connections if parallel connections turn out not to speed it up")
+	cmd.PersistentFlags().Float64(config.OptChunkDeadlineFactor, 0, "Reschedule a chunk which takes this many times as long as expected from the download's throughput so far (0 disables)")
+	cmd.PersistentFlags().Bool(config.OptCompressed, false, "Request zstd or gzip compressed responses from the origin (Accept-Encoding) and decompress them as they are read")
+	cmd.PersistentFlags().Bool(config.OptLenientContentRange, false, "Accept servers which omit the total size from Content-Range (bytes 0-99/*), discovering the size with HEAD or probe requests")
 	cmd.PersistentFlags().Duration(config.OptConnTimeout, 5*time.Second, "Timeout for establishing a connection, format is <number><unit>, e.g. 10s")
-	cmd.PersistentFlags().StringVarP(&chunkSize, config.OptChunkSize, "m", chunkSizeDefault, "Chunk size (in bytes) to use when downloading a file (e.g. 10M)")
-	cmd.PersistentFlags().StringVar(&chunkSize, config.OptMinimumChunkSize, chunkSizeDefault, "Minimum chunk size (in bytes) to use when downloading a file (e.g. 10M)")
+	cmd.PersistentFlags().StringP(config.OptChunkSize, "m", chunkSizeDefault, "Chunk size (in bytes) to use when downloading a file (e.g. 10M), or auto to size chunks from the measured throughput")
+	cmd.PersistentFlags().String(config.OptMinimumChunkSize, chunkSizeDefault, "Minimum chunk size (in bytes) to use when downloading a file (e.g. 10M)")
 	cmd.PersistentFlags().BoolP(config.OptForce, "f", false, "OptForce download, overwriting existing file")
+	cmd.PersistentFlags().String(config.OptOverwrite, string(overwrite.Never), "Policy for destinations which already exist: never, always, if-different (only write what differs), resume (keep the existing prefix)")
+	cmd.PersistentFlags().Bool(config.OptDirectIO, false, "Write downloaded files bypassing the page cache (O_DIRECT, Linux only), so that downloading more than fits in memory doesn't evict other processes' cached data")
+	cmd.PersistentFlags().Bool(config.OptExperimentalIOURing, false, "Write downloaded files through an io_uring, queueing the writes of the next buffers while the current one is filled (experimental, Linux only)")
+	cmd.PersistentFlags().Bool(config.OptNoAtomic, false, "Write downloaded files in place, rather than to <dest>.partial-<random> renamed to <dest> once complete, for filesystems without cheap renames")
 	cmd.PersistentFlags().StringSlice(config.OptResolve, []string{}, "OptResolve hostnames to specific IPs")
+	cmd.PersistentFlags().String(config.OptDNSServer, "", "DNS server (host or host:port) to resolve hostnames and cache hosts with instead of the system resolver")
+	cmd.PersistentFlags().Duration(config.OptDNSCacheTTL, 30*time.Second, "How long to cache DNS responses for, 0 disables caching")
+	cmd.PersistentFlags().String(config.OptProxy, "", "Proxy to send requests through (http://, https://, socks5:// or socks5h://), overriding HTTP_PROXY and HTTPS_PROXY")
+	cmd.PersistentFlags().StringSlice(config.OptNoProxy, []string{}, "Hosts, domains or CIDR ranges to connect to without the proxy, in addition to NO_PROXY (may be repeated)")
 	cmd.PersistentFlags().IntP(config.OptRetries, "r", 5, "Number of retries when attempting to retrieve a file")
+	cmd.PersistentFlags().Int(config.OptMaxRedirects, 10, "Maximum number of redirects to follow for a request")
+	cmd.PersistentFlags().StringSlice(config.OptRedirectAllowedHost, []string{}, "Only follow redirects to this host, or its subdomains with *.example.com (may be repeated; default any host)")
+	cmd.PersistentFlags().Bool(config.OptHTTPSOnly, false, "Refuse plaintext HTTP requests to origins, mirrors, redirects and cache hosts")
+	cmd.PersistentFlags().StringSlice(config.OptHTTPSOnlyAllowedHost, []string{}, "Allow plaintext HTTP to this host, or its subdomains with *.example.com, despite --https-only (may be repeated)")
+	cmd.PersistentFlags().String(config.OptRedirectAuth, string(client.RedirectAuthSameDomain), "Forward the Authorization header on redirects to: same-domain, same-host, never, always")
+	cmd.PersistentFlags().Float64(config.OptMaxRequestsPerSecond, 0, "Maximum number of requests to send per second, across all downloads and hosts (0 for no limit)")
+	cmd.PersistentFlags().Duration(config.OptMaxRetryAfter, 30*time.Second, "Maximum time to wait when a server responds 429 or 503 with a Retry-After header")
 	cmd.PersistentFlags().BoolP(config.OptVerbose, "v", false, "OptVerbose mode (equivalent to --log-level debug)")
 	cmd.PersistentFlags().String(config.OptLoggingLevel, "info", "Log level (debug, info, warn, error)")
+	cmd.PersistentFlags().String(config.OptLogFile, "", "Also write the log to this file, as JSON lines")
+	cmd.PersistentFlags().String(config.OptLogFileLevel, "", "Log level for --log-file (debug, info, warn, error; default --log-level)")
+	cmd.PersistentFlags().String(config.OptLogFileMaxSize, "100M", "Rotate --log-file once it reaches this size (e.g. 100M), 0 to never rotate")
+	cmd.PersistentFlags().Int(config.OptLogFileMaxBackups, 5, "Number of rotated log files to keep, 0 to keep all")
+	cmd.PersistentFlags().Duration(config.OptLogFileMaxAge, 0, "Remove rotated log files older than this, e.g. 168h (default keep them)")
 	cmd.PersistentFlags().Bool(config.OptForceHTTP2, false, "OptForce HTTP/2")
 	cmd.PersistentFlags().Int(config.OptMaxConnPerHost, 40, "Maximum number of (global) concurrent connections per host")
-	cmd.PersistentFlags().StringP(config.OptOutputConsumer, "o", "file", "Output Consumer (file, tar, null)")
+	cmd.PersistentFlags().StringP(config.OptOutputConsumer, "o", "file", "Output Consumer (file, tar-extractor, zip-extractor, null)")
+	cmd.PersistentFlags().String(config.OptOutputMode, "", "Permissions of written files in octal, e.g. 0644, applied regardless of the umask (default 0644 less the umask)")
+	cmd.PersistentFlags().String(config.OptOutputOwner, "", "Numeric uid:gid to give written files, e.g. 1000:1000 (usually requires running as root)")
+	cmd.PersistentFlags().String(config.OptArchiveDest, "", "Also write the downloaded archive to this path when extracting it (-x, or -o file+tar-extractor)")
+	cmd.PersistentFlags().String(config.OptExtractIndex, "", "Listing of the tar archive's entries (one name, or size<TAB>name, per line) used to create directories and allocate large files before extracting")
+	cmd.PersistentFlags().String(config.OptExtractLinks, string(extract.LinkPolicyDenyExternal), "Policy for archive links pointing outside the destination: deny-external, rewrite, allow")
+	cmd.PersistentFlags().String(config.OptExtractPreserve, "", "Comma-separated archive metadata to apply when extracting: owner (when running as root), times, xattrs")
+	cmd.PersistentFlags().Int(config.OptExtractConcurrency, runtime.NumCPU(), "Maximum number of files to write in parallel when extracting an archive")
+	cmd.PersistentFlags().Bool(config.OptPipelineChunks, false, "Request each connection's next chunk as soon as the current chunk's response headers arrive, to avoid idle round trips between chunks")
+	cmd.PersistentFlags().String(config.OptSliceSize, sliceSizeDefault, "Size (in bytes) of the slices the cache hosts store objects in, e.g. 500MiB (if not set, the size recommended by the cache's TXT record, if any)")
+	cmd.PersistentFlags().Int(config.OptCacheHashVersion, 0, "Version of the consistent hash mapping slices to cache hosts: 1 or 2 (if not set, the version recommended by the cache's TXT record, if any, or else 1)")
+	cmd.PersistentFlags().Float64(config.OptCacheHashRollout, 0, "Fraction of objects, e.g. 0.25, mapped to cache hosts with --cache-hash-version while a cache fleet migrates to it, the others using the version before it (default all objects)")
+	cmd.PersistentFlags().Int(config.OptPrewarmConnections, 0, "Number of connections to open to each cache host before downloading, so that the first chunks don't wait for handshakes")
 	cmd.PersistentFlags().String(config.OptPIDFile, defaultPidFilePath(), "PID file path")
+	cmd.PersistentFlags().Duration(config.OptSoftTimeout, 0, "Escalate a file download (bypass caches, add download workers) if it has not completed within this duration, e.g. 5m")
+	cmd.PersistentFlags().Bool(config.OptStrict, false, "Fail on inconsistent server responses (e.g. Content-Length disagreeing with Content-Range) instead of working around them")
+	cmd.PersistentFlags().Duration(config.OptStatsInterval, 0, "Log a summary of download statistics (active and queued chunks, throughput, errors by host) at this interval, e.g. 30s")
+	cmd.PersistentFlags().Bool(config.OptJSONOutput, false, "Print a JSON document describing the run (files, sizes, durations, throughput, retries, fallbacks, checksums) to stdout once it is over")
+	cmd.PersistentFlags().String(config.OptEmitManifest, "", "After the run, write a manifest of the downloaded files to this path (and a JSON version with sizes, ETags and checksums to <path>.json)")
+	cmd.PersistentFlags().Bool(config.OptEmitSliceSums, false, "Write the SHA-256 of each 64 MiB slice of each downloaded file next to it, to <dest>.pget.sum")
+	cmd.PersistentFlags().Bool(config.OptVerifySliceSums, false, "Check each slice of a download against the slice sums published at <url>.pget.sum, if there are any")
+	cmd.PersistentFlags().Duration(config.OptDownloadTimeout, 0, "Abort a file download if it has not completed within this duration, e.g. 30m")
+	cmd.PersistentFlags().Bool(config.OptDryRun, false, "Make only the first request of each download, and print how it would be downloaded (size, chunks, slices and their cache hosts) and what would be done with its destination")
+	cmd.PersistentFlags().Duration(config.OptHardTimeout, 0, "Abort a file download if it has not completed within this duration, e.g. 30m")
+	cmd.PersistentFlags().Duration(config.OptTotalDeadline, 0, "Abort the whole invocation, whatever it is downloading, if it has not completed within this duration, e.g. 2h")
+	cmd.PersistentFlags().Duration(config.OptHeartbeatInterval, 0, "Log the progress (percent complete, throughput) of each active file download at this interval, e.g. 1m")
+	cmd.PersistentFlags().StringSlice(config.OptIPFSGateway, []string{"https://ipfs.io", "https://dweb.link"}, "HTTP gateway to download ipfs:// URLs from; chunks are spread across the gateways which answer first (may be repeated)")
+	cmd.PersistentFlags().Bool(config.OptIPFSSkipVerify, false, "Don't check the content of ipfs:// URLs against their CID")
+	cmd.PersistentFlags().String(config.OptReplicateCredentials, "", "Endpoint which signs replicate:// URLs, and refreshes the signed URLs of Replicate weights when they expire mid-download (authenticated with REPLICATE_API_TOKEN)")
+	cmd.PersistentFlags().String(config.OptMaxBuffered, "", "Pause chunk scheduling while downloaded chunks not yet written (or extracted) take more than this much memory, e.g. 2G, for consumers slower than the network")
+	cmd.PersistentFlags().Bool(config.OptLowMemory, false, "Bound memory use for small devices: small chunks, at most 2 connections, no pipelining, buffers freed after each file")
 
 	if err := hideAndDeprecateFlags(cmd); err != nil {
 		return err
@@ -197,6 +263,8 @@ func hideAndDeprecateFlags(cmd *cobra.Command) error {
 	err := config.DeprecateFlags(cmd,
 		config.DeprecatedFlag{Flag: config.OptMaxChunks, Msg: fmt.Sprintf("use --%s instead", config.OptConcurrency)},
 		config.DeprecatedFlag{Flag: config.OptMinimumChunkSize, Msg: fmt.Sprintf("use --%s instead", config.OptChunkSize)},
+		config.DeprecatedFlag{Flag: config.OptForce, Msg: fmt.Sprintf("use --%s=%s instead", config.OptOverwrite, overwrite.Always)},
+		config.DeprecatedFlag{Flag: config.OptHardTimeout, Msg: fmt.Sprintf("use --%s instead", config.OptDownloadTimeout)},
 	)
 	if err != nil {
 		return err
@@ -224,12 +292,25 @@ func runRootCMD(cmd *cobra.Command, args []string) error {
 	// OMG BODGE FIX THIS
 	consumer := viper.GetString(config.OptOutputConsumer)
 	if consumer != config.ConsumerNull {
+		var err error
+		if dest, err = cli.FileDestination(url, dest); err != nil {
+			return err
+		}
+	}
+	// a dry run reports existing destinations instead
+	dryRun := viper.GetBool(config.OptDryRun)
+	if consumer != config.ConsumerNull && !dryRun {
 		if err := cli.EnsureDestinationNotExist(dest); err != nil {
 			return err
 		}
 	}
+	if archiveDest := viper.GetString(config.OptArchiveDest); archiveDest != "" && !dryRun {
+		if err := cli.EnsureDestinationNotExist(archiveDest); err != nil {
+			return err
+		}
+	}
 	if err := rootExecute(cmd.Context(), url, dest); err != nil {
-		return err
+		return cli.DeadlineError(cmd.Context(), err)
 	}
 
 	return nil
@@ -238,29 +319,49 @@ func runRootCMD(cmd *cobra.Command, args []string) error {
 // rootExecute is the main function of the program and encapsulates the general logic
 // returns any/all errors to the caller.
 func rootExecute(ctx context.Context, urlString, dest string) error {
-	chunkSize, err := humanize.ParseBytes(viper.GetString(config.OptChunkSize))
+	chunkSize, autoChunkSize, err := config.ChunkSize()
 	if err != nil {
-		return fmt.Errorf("error parsing chunk size: %w", err)
+		return err
+	}
+	maxBuffered, err := config.MaxBuffered()
+	if err != nil {
+		return err
 	}
 
-	resolveOverrides, err := config.ResolveOverridesToMap(viper.GetStringSlice(config.OptResolve))
+	clientOpts, err := cli.ClientOptions()
 	if err != nil {
-		return fmt.Errorf("error parsing resolve overrides: %w", err)
+		return err
 	}
-	clientOpts := client.Options{
-		MaxRetries: viper.GetInt(config.OptRetries),
-		TransportOpts: client.TransportOptions{
-			ForceHTTP2:       viper.GetBool(config.OptForceHTTP2),
-			ConnectTimeout:   viper.GetDuration(config.OptConnTimeout),
-			MaxConnPerHost:   viper.GetInt(config.OptMaxConnPerHost),
-			ResolveOverrides: resolveOverrides,
-		},
+	var expected *integrity.Integrity
+	if sri := viper.GetString(config.OptIntegrity); sri != "" {
+		expected, err = integrity.Parse(sri)
+		if err != nil {
+			return fmt.Errorf("error parsing --%s: %w", config.OptIntegrity, err)
+		}
 	}
-
+	pin := download.Pin{ETag: viper.GetString(config.OptIfMatch), Version: viper.GetString(config.OptObjectVersion)}
+	urlString, err = pin.URL(urlString)
+	if err != nil {
+		return fmt.Errorf("error pinning --%s: %w", config.OptObjectVersion, err)
+	}
+	ctx = download.WithPin(ctx, pin)
 	downloadOpts := download.Options{
-		MaxConcurrency: viper.GetInt(config.OptConcurrency),
-		ChunkSize:      int64(chunkSize),
-		Client:         clientOpts,
+		MaxConcurrency:        viper.GetInt(config.OptConcurrency),
+		ChunkSize:             chunkSize,
+		MaxBufferedBytes:      maxBuffered,
+		AutoChunkSize:         autoChunkSize,
+		Client:                clientOpts,
+		AdaptiveConcurrency:   viper.GetBool(config.OptAdaptiveConcurrency),
+		ChunkDeadlineFactor:   viper.GetFloat64(config.OptChunkDeadlineFactor),
+		LenientContentRange:   viper.GetBool(config.OptLenientContentRange),
+		Compressed:            viper.GetBool(config.OptCompressed),
+		PipelineChunks:        viper.GetBool(config.OptPipelineChunks),
+		Strict:                viper.GetBool(config.OptStrict),
+		Mirrors:               viper.GetStringSlice(config.OptMirror),
+		MirrorLatencyWeighted: viper.GetBool(config.OptMirrorLatencyWeighted),
+		LowMemory:             viper.GetBool(config.OptLowMemory),
+		IPFSGateways:          viper.GetStringSlice(config.OptIPFSGateway),
+		IPFSSkipVerify:        viper.GetBool(config.OptIPFSSkipVerify),
 	}
 
 	consumer, err := config.GetConsumer()
@@ -268,28 +369,96 @@ func rootExecute(ctx context.Context, urlString, dest string) error {
 		return err
 	}
 
+	pgetOpts := pget.Options{
+		SoftTimeout:       viper.GetDuration(config.OptSoftTimeout),
+		HardTimeout:       viper.GetDuration(config.OptDownloadTimeout),
+		HeartbeatInterval: viper.GetDuration(config.OptHeartbeatInterval),
+		LowMemory:         viper.GetBool(config.OptLowMemory),
+		EmitSliceSums:     viper.GetBool(config.OptEmitSliceSums),
+		VerifySliceSums:   viper.GetBool(config.OptVerifySliceSums),
+	}
+	emitter := cli.NewManifestEmitter(viper.GetString(config.OptEmitManifest))
+	printer := cli.NewResultPrinter(viper.GetBool(config.OptJSONOutput), os.Stdout)
+	pgetOpts.OnFileComplete = cli.OnFileComplete(emitter, printer)
+
 	getter := pget.Getter{
 		Downloader: download.GetBufferMode(downloadOpts),
 		Consumer:   consumer,
+		Options:    pgetOpts,
 	}
 
 	// TODO DRY this
-	if srvName := config.GetCacheSRV(); srvName != "" {
-		downloadOpts.SliceSize = 500 * humanize.MiByte
-		// FIXME: make this a config option
+	if len(downloadOpts.Mirrors) > 0 {
+		getter.Downloader = download.GetStripedMode(downloadOpts)
+	} else if srvName := config.GetCacheSRV(); srvName != "" {
 		downloadOpts.CacheableURIPrefixes = config.CacheableURIPrefixes()
+		downloadOpts.CacheURIAliases = config.GetURIAliases()
 		downloadOpts.CacheUsePathProxy = viper.GetBool(config.OptCacheUsePathProxy)
-		downloadOpts.CacheHosts, err = cli.LookupCacheHosts(srvName)
+		if rewrite := viper.GetString(config.OptCacheRewrite); rewrite != "" {
+			downloadOpts.CacheRewriter, err = download.ParseCacheRewriter(rewrite)
+			if err != nil {
+				return err
+			}
+		}
+		downloadOpts.CacheKeyRules, err = download.ParseCacheKeyRules(viper.GetStringSlice(config.OptCacheKeyRules))
+		if err != nil {
+			return err
+		}
+		downloadOpts.CacheFallbackThreshold = viper.GetFloat64(config.OptCacheFallbackThreshold)
+		downloadOpts.CacheHealthCheckPath = viper.GetString(config.OptCacheHealthCheckPath)
+		downloadOpts.CacheHealthCheckInterval = viper.GetDuration(config.OptCacheHealthCheckInterval)
+		downloadOpts.CachePrewarmConnections = viper.GetInt(config.OptPrewarmConnections)
+		downloadOpts.CacheHosts, err = cli.LookupCacheHosts(ctx, clientOpts.TransportOpts.Resolver, srvName)
+		if err != nil {
+			return err
+		}
+		if err := cli.ApplyCacheTuning(ctx, clientOpts.TransportOpts.Resolver, srvName, &downloadOpts); err != nil {
+			return err
+		}
+		mode, err := download.GetConsistentHashingMode(downloadOpts)
+		if err != nil {
+			return err
+		}
+		defer mode.Close()
+		getter.Downloader = mode
+	}
+	if ipfs.IsURL(urlString) {
+		getter.Downloader, err = download.GetIPFSMode(downloadOpts, getter.Downloader)
+		if err != nil {
+			return err
+		}
+	}
+	if hf.IsURL(urlString) {
+		downloadOpts.HFEndpoint = hf.Endpoint()
+		downloadOpts.HFToken, err = hf.Token()
 		if err != nil {
 			return err
 		}
-		getter.Downloader, err = download.GetConsistentHashingMode(downloadOpts)
+		getter.Downloader, err = download.GetHFMode(downloadOpts, getter.Downloader)
+		if err != nil {
+			return err
+		}
+	}
+	if download.IsReplicateURL(urlString) {
+		downloadOpts.ReplicateCredentialsEndpoint = viper.GetString(config.OptReplicateCredentials)
+		downloadOpts.ReplicateAPIToken = os.Getenv("REPLICATE_API_TOKEN")
+		getter.Downloader, err = download.GetReplicateMode(downloadOpts, getter.Downloader)
 		if err != nil {
 			return err
 		}
 	}
 
-	_, _, err = getter.DownloadFile(ctx, urlString, dest)
+	if viper.GetBool(config.OptDryRun) {
+		return cli.DryRun(ctx, os.Stdout, getter.Downloader, pget.Manifest{}.AddEntry(urlString, dest))
+	}
+	stopStats := cli.StartStatsLogger(ctx, viper.GetDuration(config.OptStatsInterval), clientOpts.Transport)
+	defer stopStats()
+	start := time.Now()
+	_, _, err = getter.DownloadVerifiedFile(ctx, urlString, dest, expected)
+	printErr := printer.Print(time.Since(start), err)
+	if emitErr := emitter.Write(); emitErr != nil || printErr != nil {
+		return errors.Join(err, emitErr, printErr)
+	}
 	return err
 }
 
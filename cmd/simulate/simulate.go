@@ -0,0 +1,195 @@
+package simulate
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/consistent"
+)
+
+const RebalanceCMDName = "simulate-rebalance"
+
+const rebalanceLongDesc = `
+'simulate-rebalance' reports which fraction of slices would be served by a different cache host after a change to
+the cache topology, using the same consistent hashing as downloads do. It helps plan scaling events: every slice which
+moves has to be fetched from the origin again by its new cache host.
+
+Cache hosts are indexed by the number at the end of their hostname (cache-0, cache-1, ...). Scaling in with --remove
+removes the highest-indexed hosts, as scaling in a Kubernetes StatefulSet does; scaling out with --add appends hosts.
+Hosts listed with --unavailable stay in the topology but fail their health check, so their slices are retried on
+another host, as downloads do.
+`
+
+const rebalanceExamples = `
+  pget simulate-rebalance --hosts 20 --remove 2 --slices 10000
+
+  pget simulate-rebalance --hosts 20 --unavailable 3,7
+`
+
+const (
+	optHosts       = "hosts"
+	optAdd         = "add"
+	optRemove      = "remove"
+	optUnavailable = "unavailable"
+	optSlices      = "slices"
+	optURL         = "url"
+)
+
+// topology is a cache topology to simulate.
+type topology struct {
+	hosts       int
+	unavailable []int
+}
+
+// rebalanceResult is the outcome of changing from one topology to another.
+type rebalanceResult struct {
+	slices int
+	moved  int
+	// before and after are the number of slices served by each host, -1 for the origin (no host was available)
+	before map[int]int
+	after  map[int]int
+}
+
+func GetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     RebalanceCMDName + " [flags]",
+		Short:   "simulate how many slices move between cache hosts when the cache topology changes",
+		Long:    rebalanceLongDesc,
+		Args:    cobra.NoArgs,
+		RunE:    runRebalanceCMD,
+		Example: rebalanceExamples,
+	}
+	cmd.Flags().Int(optHosts, 0, "Number of cache hosts before the change")
+	cmd.Flags().Int(optAdd, 0, "Number of cache hosts to add")
+	cmd.Flags().Int(optRemove, 0, "Number of cache hosts to remove (the highest-indexed ones)")
+	cmd.Flags().IntSlice(optUnavailable, nil, "Indexes of cache hosts which are unavailable after the change")
+	cmd.Flags().Int(optSlices, 10000, "Number of slices to simulate")
+	cmd.Flags().String(optURL, "https://weights.replicate.delivery/simulate-rebalance", "URL whose slices are simulated")
+	_ = cmd.MarkFlagRequired(optHosts)
+	cmd.SetUsageTemplate(cli.UsageTemplate)
+	return cmd
+}
+
+func runRebalanceCMD(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	hosts, _ := flags.GetInt(optHosts)
+	add, _ := flags.GetInt(optAdd)
+	remove, _ := flags.GetInt(optRemove)
+	unavailable, _ := flags.GetIntSlice(optUnavailable)
+	numSlices, _ := flags.GetInt(optSlices)
+	url, _ := flags.GetString(optURL)
+
+	before := topology{hosts: hosts}
+	after := topology{hosts: hosts + add - remove, unavailable: unavailable}
+	switch {
+	case hosts <= 0:
+		return fmt.Errorf("--%s must be positive", optHosts)
+	case numSlices <= 0:
+		return fmt.Errorf("--%s must be positive", optSlices)
+	case after.hosts <= 0:
+		return fmt.Errorf("cannot remove %d of %d cache hosts", remove, hosts)
+	}
+	for _, index := range unavailable {
+		if index < 0 || index >= after.hosts {
+			return fmt.Errorf("--%s index %d is out of range, there are %d cache hosts after the change", optUnavailable, index, after.hosts)
+		}
+	}
+	cmd.SilenceUsage = true
+
+	result, err := simulateRebalance(url, numSlices, before, after)
+	if err != nil {
+		return err
+	}
+	return printRebalance(os.Stdout, before, after, result)
+}
+
+// simulateRebalance assigns slices 0 to numSlices-1 of url to a cache host in each topology, and counts those whose
+// host changes.
+func simulateRebalance(url string, numSlices int, before, after topology) (rebalanceResult, error) {
+	result := rebalanceResult{slices: numSlices, before: make(map[int]int), after: make(map[int]int)}
+	for slice := int64(0); slice < int64(numSlices); slice++ {
+		hostBefore, err := before.host(url, slice)
+		if err != nil {
+			return rebalanceResult{}, err
+		}
+		hostAfter, err := after.host(url, slice)
+		if err != nil {
+			return rebalanceResult{}, err
+		}
+		result.before[hostBefore]++
+		result.after[hostAfter]++
+		if hostBefore != hostAfter {
+			result.moved++
+		}
+	}
+	return result, nil
+}
+
+// host returns the cache host serving slice of url, or -1 if every host is unavailable. Like
+// ConsistentHashingMode, an unavailable host's slices are retried on the next host SliceBucket picks.
+func (t topology) host(url string, slice int64) (int, error) {
+	var previous []int
+	for len(previous) < t.hosts {
+		bucket, err := consistent.SliceBucket(url, slice, t.hosts, previous...)
+		if err != nil {
+			return -1, err
+		}
+		if !slices.Contains(t.unavailable, bucket) {
+			return bucket, nil
+		}
+		previous = append(previous, bucket)
+	}
+	return -1, nil
+}
+
+// available returns the number of hosts which can serve slices.
+func (t topology) available() int {
+	unavailable := 0
+	for i := 0; i < t.hosts; i++ {
+		if slices.Contains(t.unavailable, i) {
+			unavailable++
+		}
+	}
+	return t.hosts - unavailable
+}
+
+// minimalMoves returns the fraction of slices which must move for the change, if the hashing were perfectly
+// balanced: the share of the hosts which appear or disappear.
+func minimalMoves(before, after topology) float64 {
+	b, a := before.available(), after.available()
+	if a == 0 {
+		return 1
+	}
+	return math.Abs(float64(a-b)) / float64(max(a, b))
+}
+
+func printRebalance(w io.Writer, before, after topology, result rebalanceResult) error {
+	lines := []string{
+		fmt.Sprintf("cache hosts: %d -> %d (%d unavailable)", before.hosts, after.hosts, after.hosts-after.available()),
+		fmt.Sprintf("slices moved: %d of %d (%.2f%%, minimum %.2f%%)", result.moved, result.slices,
+			100*float64(result.moved)/float64(result.slices), 100*minimalMoves(before, after)),
+		"host\tbefore\tafter",
+	}
+	for host := -1; host < max(before.hosts, after.hosts); host++ {
+		if host == -1 && result.after[-1] == 0 {
+			continue
+		}
+		name := fmt.Sprintf("%d", host)
+		if host == -1 {
+			name = "origin"
+		}
+		lines = append(lines, fmt.Sprintf("%s\t%d\t%d", name, result.before[host], result.after[host]))
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package simulate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testURL = "https://example.com/model.tar"
+
+func TestSimulateRebalanceScaling(t *testing.T) {
+	// jump hashing only moves the slices of the removed hosts
+	result, err := simulateRebalance(testURL, 10000, topology{hosts: 20}, topology{hosts: 18})
+	require.NoError(t, err)
+	assert.Equal(t, result.before[18]+result.before[19], result.moved)
+	assert.Zero(t, result.after[18]+result.after[19])
+	assert.InDelta(t, 0.1, float64(result.moved)/float64(result.slices), 0.02)
+
+	// and adding hosts only moves slices to them
+	result, err = simulateRebalance(testURL, 10000, topology{hosts: 20}, topology{hosts: 25})
+	require.NoError(t, err)
+	added := 0
+	for host := 20; host < 25; host++ {
+		added += result.after[host]
+	}
+	assert.Equal(t, added, result.moved)
+	assert.InDelta(t, 0.2, float64(result.moved)/float64(result.slices), 0.02)
+
+	result, err = simulateRebalance(testURL, 10000, topology{hosts: 20}, topology{hosts: 20})
+	require.NoError(t, err)
+	assert.Zero(t, result.moved)
+}
+
+func TestSimulateRebalanceUnavailable(t *testing.T) {
+	result, err := simulateRebalance(testURL, 10000, topology{hosts: 20}, topology{hosts: 20, unavailable: []int{3, 7}})
+	require.NoError(t, err)
+	assert.Equal(t, result.before[3]+result.before[7], result.moved)
+	assert.Zero(t, result.after[3]+result.after[7])
+	assert.Zero(t, result.after[-1])
+
+	result, err = simulateRebalance(testURL, 100, topology{hosts: 2}, topology{hosts: 2, unavailable: []int{0, 1}})
+	require.NoError(t, err)
+	assert.Equal(t, 100, result.after[-1])
+	assert.Equal(t, 100, result.moved)
+}
+
+func TestPrintRebalance(t *testing.T) {
+	before, after := topology{hosts: 3}, topology{hosts: 2}
+	result := rebalanceResult{
+		slices: 9,
+		moved:  3,
+		before: map[int]int{0: 3, 1: 3, 2: 3},
+		after:  map[int]int{0: 5, 1: 4},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, printRebalance(&buf, before, after, result))
+	assert.Equal(t, "cache hosts: 3 -> 2 (0 unavailable)\n"+
+		"slices moved: 3 of 9 (33.33%, minimum 33.33%)\n"+
+		"host\tbefore\tafter\n"+
+		"0\t3\t5\n"+
+		"1\t3\t4\n"+
+		"2\t3\t0\n", buf.String())
+}
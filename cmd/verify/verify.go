@@ -0,0 +1,89 @@
+package verify
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/verify"
+)
+
+const VerifyCMDName = "verify"
+
+const longDesc = `
+'verify' compares a local file against a remote URL without re-downloading it.
+
+The sizes of the local and remote files are always compared. If the server publishes slice sums for the file
+at <url>.pget.sum (see --emit-slice-sums) each slice of the local file is hashed and compared against them, and
+the slices which differ are reported. Failing that, if the server exposes a whole-object digest (Repr-Digest,
+x-goog-hash or x-amz-checksum-sha256) the local file is hashed and compared against it, otherwise a number of
+byte ranges spread across the file are requested and compared against the local copy.
+
+'verify' exits with a non-zero status if the files differ.
+`
+
+const verifyExamples = `
+  pget verify https://example.com/weights.safetensors ./weights.safetensors
+
+  pget verify --samples 64 --sample-size 4M https://example.com/model.tar ./model.tar
+`
+
+func GetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     VerifyCMDName + " [flags] <url> <file>",
+		Short:   "verify a local file against a remote URL",
+		Long:    longDesc,
+		Args:    cobra.ExactArgs(2),
+		RunE:    runVerifyCMD,
+		Example: verifyExamples,
+	}
+	cmd.Flags().Int(config.OptVerifySamples, 16, "Number of byte ranges to compare when the server does not expose a digest")
+	cmd.Flags().String(config.OptVerifySampleSize, "1M", "Size of each compared byte range (e.g. 1M)")
+
+	err := viper.BindPFlags(cmd.Flags())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	cmd.SetUsageTemplate(cli.UsageTemplate)
+	return cmd
+}
+
+func runVerifyCMD(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	url, path := args[0], args[1]
+
+	sampleSize, err := humanize.ParseBytes(viper.GetString(config.OptVerifySampleSize))
+	if err != nil {
+		return fmt.Errorf("error parsing sample size: %w", err)
+	}
+	clientOpts, err := cli.ClientOptions()
+	if err != nil {
+		return err
+	}
+	verifyOpts := verify.Options{
+		Samples:    viper.GetInt(config.OptVerifySamples),
+		SampleSize: int64(sampleSize),
+	}
+
+	verifier := verify.NewVerifier(verifyOpts, clientOpts)
+	result, err := verifier.Verify(cmd.Context(), url, path)
+	if err != nil {
+		return cli.DeadlineError(cmd.Context(), err)
+	}
+
+	logger := logging.GetLogger()
+	logger.Info().
+		Str("url", url).
+		Str("file", path).
+		Str("size", humanize.Bytes(uint64(result.Size))).
+		Str("method", result.Method).
+		Msg("Verified")
+	return nil
+}
@@ -5,7 +5,7 @@ import (
 
 	"github.com/spf13/cobra"
 
-	"github.com/replicate/pget/pkg/version"
+	"github.com/replicate/pget/v2/pkg/version"
 )
 
 const VersionCMDName = "version"
@@ -3,8 +3,9 @@ package main
 import (
 	"os"
 
-	"github.com/replicate/pget/cmd"
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/cmd"
+	"github.com/replicate/pget/v2/pkg/cli"
+	"github.com/replicate/pget/v2/pkg/logging"
 )
 
 func main() {
@@ -12,6 +13,6 @@ func main() {
 	rootCMD := cmd.GetRootCommand()
 
 	if err := rootCMD.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
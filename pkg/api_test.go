@@ -0,0 +1,175 @@
+package pget_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateAPI = flag.Bool("update-api", false, "record the current stable API in testdata/api.txt")
+
+// stableAPI lists the types covered by the compatibility promise of the module (see doc.go), by the directory of
+// their package relative to this one.
+var stableAPI = map[string][]string{
+	".":        {"Getter", "Options", "Manifest", "ManifestEntry"},
+	"client":   {"Options"},
+	"consumer": {"Consumer"},
+	"download": {"Options", "Strategy"},
+}
+
+// TestStableAPI fails if a type, field or method recorded in testdata/api.txt has been removed or changed. Additions
+// are logged; record them with go test ./pkg -run TestStableAPI -update-api.
+func TestStableAPI(t *testing.T) {
+	current := apiSurface(t)
+	path := filepath.Join("testdata", "api.txt")
+	if *updateAPI {
+		require.NoError(t, os.WriteFile(path, []byte(strings.Join(current, "\n")+"\n"), 0644))
+		return
+	}
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	recorded := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, line := range recorded {
+		assert.Contains(t, current, line, "breaking change to the stable API, which needs a new major version")
+	}
+	for _, line := range current {
+		if !slices.Contains(recorded, line) {
+			t.Logf("not recorded in %s: %s", path, line)
+		}
+	}
+}
+
+// apiSurface returns a line for each type of stableAPI, and each of its exported fields and methods, sorted.
+func apiSurface(t *testing.T) []string {
+	var lines []string
+	for dir, names := range stableAPI {
+		fset := token.NewFileSet()
+		pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+			return !strings.HasSuffix(info.Name(), "_test.go")
+		}, parser.SkipObjectResolution)
+		require.NoError(t, err)
+		for pkgName, pkg := range pkgs {
+			for _, file := range pkg.Files {
+				lines = append(lines, fileAPI(fset, pkgName, file, names)...)
+			}
+		}
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+func fileAPI(fset *token.FileSet, pkgName string, file *ast.File, names []string) []string {
+	var lines []string
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				spec, ok := spec.(*ast.TypeSpec)
+				if !ok || !slices.Contains(names, spec.Name.Name) {
+					continue
+				}
+				lines = append(lines, typeAPI(fset, pkgName+"."+spec.Name.Name, spec.Type)...)
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil || !decl.Name.IsExported() {
+				continue
+			}
+			recv := decl.Recv.List[0].Type
+			star := ""
+			if ptr, ok := recv.(*ast.StarExpr); ok {
+				recv, star = ptr.X, "*"
+			}
+			if ident, ok := recv.(*ast.Ident); ok && slices.Contains(names, ident.Name) {
+				lines = append(lines, fmt.Sprintf("method (%s%s.%s) %s%s", star, pkgName, ident.Name, decl.Name.Name,
+					signature(fset, decl.Type)))
+			}
+		}
+	}
+	return lines
+}
+
+func typeAPI(fset *token.FileSet, name string, expr ast.Expr) []string {
+	switch expr := expr.(type) {
+	case *ast.StructType:
+		lines := []string{"type " + name + " struct"}
+		for _, field := range expr.Fields.List {
+			for _, fieldName := range fieldNames(field) {
+				if ast.IsExported(fieldName) {
+					lines = append(lines, fmt.Sprintf("field %s.%s %s", name, fieldName, node(fset, field.Type)))
+				}
+			}
+		}
+		return lines
+	case *ast.InterfaceType:
+		lines := []string{"type " + name + " interface"}
+		for _, method := range expr.Methods.List {
+			fn, ok := method.Type.(*ast.FuncType)
+			if !ok {
+				lines = append(lines, fmt.Sprintf("embedded %s %s", name, node(fset, method.Type)))
+				continue
+			}
+			for _, methodName := range method.Names {
+				lines = append(lines, fmt.Sprintf("method %s.%s%s", name, methodName.Name, signature(fset, fn)))
+			}
+		}
+		return lines
+	}
+	return []string{fmt.Sprintf("type %s %s", name, node(fset, expr))}
+}
+
+// fieldNames returns the names of field, that of its type if it is embedded.
+func fieldNames(field *ast.Field) []string {
+	if len(field.Names) > 0 {
+		names := make([]string, len(field.Names))
+		for i, name := range field.Names {
+			names[i] = name.Name
+		}
+		return names
+	}
+	expr := field.Type
+	if ptr, ok := expr.(*ast.StarExpr); ok {
+		expr = ptr.X
+	}
+	if sel, ok := expr.(*ast.SelectorExpr); ok {
+		return []string{sel.Sel.Name}
+	}
+	return []string{node(nil, expr)}
+}
+
+// signature prints fn without its func keyword, and without parameter names, which are not part of the API.
+func signature(fset *token.FileSet, fn *ast.FuncType) string {
+	strip := func(fields *ast.FieldList) *ast.FieldList {
+		if fields == nil {
+			return nil
+		}
+		stripped := &ast.FieldList{}
+		for _, field := range fields.List {
+			for range max(len(field.Names), 1) {
+				stripped.List = append(stripped.List, &ast.Field{Type: field.Type})
+			}
+		}
+		return stripped
+	}
+	return strings.TrimPrefix(node(fset, &ast.FuncType{Params: strip(fn.Params), Results: strip(fn.Results)}), "func")
+}
+
+func node(fset *token.FileSet, n ast.Node) string {
+	if fset == nil {
+		fset = token.NewFileSet()
+	}
+	var buf bytes.Buffer
+	_ = printer.Fprint(&buf, fset, n)
+	return buf.String()
+}
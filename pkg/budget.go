@@ -0,0 +1,102 @@
+package pget
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// ErrBudgetExceeded is wrapped by the *BudgetExceededError DownloadFiles returns when Options.MaxTotalBytes or
+// Options.MaxTotalTime stopped the run early.
+var ErrBudgetExceeded = errors.New("run budget exceeded")
+
+// BudgetExceededError is returned by DownloadFiles when the run used up Options.MaxTotalBytes or
+// Options.MaxTotalTime before every entry of the manifest was started. The entries already being downloaded were
+// finished; Skipped lists the others, which were not started.
+type BudgetExceededError struct {
+	// Reason describes the budget which was used up
+	Reason  string
+	Skipped []ManifestEntry
+}
+
+func (e *BudgetExceededError) Error() string {
+	dests := make([]string, len(e.Skipped))
+	for i, entry := range e.Skipped {
+		dests[i] = fmt.Sprintf("%s (%s)", entry.Dest, entry.URL)
+	}
+	return fmt.Sprintf("%s, %d of the manifest entries were not downloaded: %s", e.Reason, len(e.Skipped), strings.Join(dests, ", "))
+}
+
+func (e *BudgetExceededError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// budgetTracker decides whether DownloadFiles may start another entry, and records the entries it skips. Only the
+// bytes of completed files count against the byte budget, so the run may go over it by the files in flight when it
+// is used up.
+type budgetTracker struct {
+	maxBytes   int64
+	downloaded *atomic.Int64
+	// deadline is zero if the run has no time budget
+	deadline time.Time
+	maxTime  time.Duration
+
+	mu      sync.Mutex
+	reason  string
+	skipped []ManifestEntry
+}
+
+func newBudgetTracker(opts Options, downloaded *atomic.Int64, start time.Time) *budgetTracker {
+	b := &budgetTracker{maxBytes: opts.MaxTotalBytes, downloaded: downloaded, maxTime: opts.MaxTotalTime}
+	if opts.MaxTotalTime > 0 {
+		b.deadline = start.Add(opts.MaxTotalTime)
+	}
+	return b
+}
+
+// allow reports whether entry may be downloaded, recording it as skipped if not. Once the budget is used up no
+// further entry is allowed.
+func (b *budgetTracker) allow(entry ManifestEntry, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.reason == "" {
+		downloaded := b.downloaded.Load()
+		switch {
+		case b.maxBytes > 0 && downloaded >= b.maxBytes:
+			b.reason = fmt.Sprintf("downloaded %s of a %s budget", humanize.IBytes(uint64(downloaded)), humanize.IBytes(uint64(b.maxBytes)))
+		case !b.deadline.IsZero() && !now.Before(b.deadline):
+			b.reason = fmt.Sprintf("ran for the %s budget", b.maxTime)
+		default:
+			return true
+		}
+		logger := logging.GetLogger()
+		logger.Warn().
+			Str("reason", b.reason).
+			Msg("Budget Exceeded: finishing the files in flight, skipping the rest")
+	}
+	b.skipped = append(b.skipped, entry)
+	return false
+}
+
+// err returns a *BudgetExceededError for the entries which were skipped, or nil if there are none.
+func (b *budgetTracker) err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.skipped) == 0 {
+		return nil
+	}
+	skipped := slices.Clone(b.skipped)
+	slices.SortFunc(skipped, func(a, b ManifestEntry) int {
+		return cmp.Compare(a.Dest, b.Dest)
+	})
+	return &BudgetExceededError{Reason: b.reason, Skipped: skipped}
+}
@@ -1,19 +1,31 @@
 package cli
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
+	"math"
 	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/dustin/go-humanize"
 	"github.com/spf13/viper"
 
-	"github.com/replicate/pget/pkg/config"
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/consistent"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/overwrite"
+	"github.com/replicate/pget/v2/pkg/vfs"
 )
 
 const UsageTemplate = `
@@ -43,21 +55,194 @@ Use "{{.CommandPath}} [command] --help" for more information about a command.{{e
 `
 
 func EnsureDestinationNotExist(dest string) error {
-	_, err := os.Stat(dest)
-	if !viper.GetBool(config.OptForce) && !errors.Is(err, fs.ErrNotExist) {
-		return fmt.Errorf("destination %s already exists", dest)
+	policy, err := config.OverwritePolicy()
+	if err != nil {
+		return err
+	}
+	if vfs.IsRemote(dest) {
+		return ensureObjectNotExist(dest, policy)
+	}
+	_, err = os.Stat(dest)
+	if !policy.Allowed() && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("destination %s already exists (see --%s)", dest, config.OptOverwrite)
 	}
 	return nil
 }
 
-func LookupCacheHosts(srvName string) ([]string, error) {
-	_, srvs, err := net.LookupSRV("http", "tcp", srvName)
+// ensureObjectNotExist is EnsureDestinationNotExist for dest, an s3:// or gs:// URL. A prefix, which archives are
+// extracted into, is not checked.
+func ensureObjectNotExist(dest string, policy overwrite.Policy) error {
+	if strings.HasSuffix(dest, "/") || viper.GetString(config.OptOutputConsumer) != config.ConsumerFile {
+		return nil
+	}
+	switch policy {
+	case overwrite.Always:
+		return nil
+	case overwrite.Never:
+	default:
+		return fmt.Errorf("--%s=%s is not supported for object destination %s", config.OptOverwrite, policy, dest)
+	}
+	exists, err := vfs.ObjectExists(context.Background(), dest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("destination %s already exists (see --%s)", dest, config.OptOverwrite)
+	}
+	return nil
+}
+
+// FileDestination returns the path the file consumer writes the download of rawURL to: dest, or if dest is an
+// existing directory, or an object store prefix ending in a slash (e.g. s3://bucket/models/), the file in it named
+// after the last element of the URL's path.
+func FileDestination(rawURL, dest string) (string, error) {
+	if viper.GetString(config.OptOutputConsumer) != config.ConsumerFile {
+		// extractors take dest as the directory to extract to
+		return dest, nil
+	}
+	remote := vfs.IsRemote(dest)
+	if remote && !strings.HasSuffix(dest, "/") {
+		return dest, nil
+	}
+	if !remote {
+		info, err := os.Stat(dest)
+		if err != nil || !info.IsDir() {
+			return dest, nil
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s: %w", rawURL, err)
+	}
+	name := path.Base(u.Path)
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") || name == "/" || name == "." || name == ".." {
+		return "", fmt.Errorf("destination %s is a directory and %s has no file name to write in it", dest, rawURL)
+	}
+	logger := logging.GetLogger()
+	logger.Debug().Str("url", rawURL).Str("dest", dest).Str("file", name).Msg("Destination Is A Directory")
+	if remote {
+		return dest + url.PathEscape(name), nil
+	}
+	return filepath.Join(dest, name), nil
+}
+
+// LookupCacheHosts discovers the cache hosts from the SRV records of srvName, ordered by the index in their hostnames.
+func LookupCacheHosts(ctx context.Context, resolver client.Resolver, srvName string) ([]string, error) {
+	_, srvs, err := resolver.LookupSRV(ctx, "http", "tcp", srvName)
 	if err != nil {
 		return nil, err
 	}
 	return orderCacheHosts(srvs)
 }
 
+// CacheTuning holds the options the cache service recommends downloading from it with, which are zero where it
+// recommends none.
+type CacheTuning struct {
+	SliceSize   int64
+	ChunkSize   int64
+	HashVersion consistent.Version
+	HashRollout float64
+}
+
+// LookupCacheTuning reads the options recommended by the cache service from the TXT records next to the SRV records
+// of srvName, made of space-separated pairs named after the options they set, e.g. "slice-size=500MiB
+// chunk-size=125MiB cache-hash-version=2 cache-hash-rollout=0.25". Other pairs and records are ignored, as is the
+// absence of TXT records.
+func LookupCacheTuning(ctx context.Context, resolver client.Resolver, srvName string) (CacheTuning, error) {
+	var tuning CacheTuning
+	txts, err := resolver.LookupTXT(ctx, "_http._tcp."+srvName)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return tuning, nil
+	}
+	if err != nil {
+		return tuning, err
+	}
+	for _, txt := range txts {
+		for _, field := range strings.Fields(txt) {
+			key, value, _ := strings.Cut(field, "=")
+			var err error
+			switch key {
+			case config.OptSliceSize:
+				tuning.SliceSize, err = parseTunedSize(value)
+			case config.OptChunkSize:
+				tuning.ChunkSize, err = parseTunedSize(value)
+			case config.OptCacheHashVersion:
+				var version int
+				if version, err = strconv.Atoi(value); err == nil {
+					tuning.HashVersion, err = consistent.ParseVersion(version)
+				}
+			case config.OptCacheHashRollout:
+				tuning.HashRollout, err = strconv.ParseFloat(value, 64)
+				if err == nil && (tuning.HashRollout <= 0 || tuning.HashRollout > 1) {
+					err = errors.New("not a fraction between 0 and 1")
+				}
+			}
+			if err != nil {
+				return CacheTuning{}, fmt.Errorf("invalid %s %q in TXT record of %s: %w", key, value, srvName, err)
+			}
+		}
+	}
+	return tuning, nil
+}
+
+func parseTunedSize(value string) (int64, error) {
+	parsed, err := humanize.ParseBytes(value)
+	if err == nil && (parsed == 0 || parsed > math.MaxInt) {
+		err = errors.New("not a positive size")
+	}
+	return int64(parsed), err
+}
+
+// tunedOptions are the options the cache service can recommend.
+var tunedOptions = []string{config.OptSliceSize, config.OptChunkSize, config.OptCacheHashVersion, config.OptCacheHashRollout}
+
+// ApplyCacheTuning sets the slice and chunk sizes and the consistent hashing version of opts for the cache service
+// of srvName: those given with --slice-size, --chunk-size, --cache-hash-version and --cache-hash-rollout (or their
+// environment variables), or else those the service recommends (see LookupCacheTuning), or else the options'
+// defaults. Recommendations are advisory: failing to look them up is logged, and the defaults used. With no srvName,
+// e.g. for cache hosts given explicitly, none are looked up.
+func ApplyCacheTuning(ctx context.Context, resolver client.Resolver, srvName string, opts *download.Options) error {
+	sliceSize, err := config.SliceSize()
+	if err != nil {
+		return err
+	}
+	opts.SliceSize = sliceSize
+	opts.CacheHashVersion = consistent.Version(viper.GetInt(config.OptCacheHashVersion))
+	opts.CacheHashRollout = viper.GetFloat64(config.OptCacheHashRollout)
+	if srvName == "" || !slices.ContainsFunc(tunedOptions, func(opt string) bool { return !viper.IsSet(opt) }) {
+		return nil
+	}
+	logger := logging.GetLogger()
+	tuning, err := LookupCacheTuning(ctx, resolver, srvName)
+	if err != nil {
+		logger.Warn().Err(err).Str("srv_name", srvName).Msg("Ignoring Cache Tuning")
+		return nil
+	}
+	if tuning.SliceSize > 0 && !viper.IsSet(config.OptSliceSize) {
+		opts.SliceSize = tuning.SliceSize
+	}
+	if tuning.ChunkSize > 0 && !viper.IsSet(config.OptChunkSize) {
+		opts.ChunkSize = tuning.ChunkSize
+	}
+	if tuning.HashVersion != 0 && !viper.IsSet(config.OptCacheHashVersion) {
+		opts.CacheHashVersion = tuning.HashVersion
+	}
+	if tuning.HashRollout > 0 && !viper.IsSet(config.OptCacheHashRollout) {
+		opts.CacheHashRollout = tuning.HashRollout
+	}
+	if tuning != (CacheTuning{}) {
+		logger.Info().
+			Str("srv_name", srvName).
+			Int64("slice_size", opts.SliceSize).
+			Int64("chunk_size", opts.ChunkSize).
+			Int("cache_hash_version", int(opts.CacheHashVersion)).
+			Float64("cache_hash_rollout", opts.CacheHashRollout).
+			Msg("Using Cache Tuning")
+	}
+	return nil
+}
+
 var hostnameIndexRegexp = regexp.MustCompile(`^[a-z0-9-]*-([0-9]+)[.]`)
 
 func orderCacheHosts(srvs []*net.SRV) ([]string, error) {
@@ -99,3 +284,82 @@ func cacheIndexFor(hostname string) (int, error) {
 	}
 	return strconv.Atoi(matches[1])
 }
+
+// RedirectOptions returns the limits on redirects selected with --max-redirects, --redirect-allowed-host and
+// --redirect-auth.
+func RedirectOptions() (client.RedirectOptions, error) {
+	auth, err := client.ParseRedirectAuth(viper.GetString(config.OptRedirectAuth))
+	if err != nil {
+		return client.RedirectOptions{}, err
+	}
+	return client.RedirectOptions{
+		Max:          viper.GetInt(config.OptMaxRedirects),
+		AllowedHosts: viper.GetStringSlice(config.OptRedirectAllowedHost),
+		Auth:         auth,
+	}, nil
+}
+
+// HTTPSOnlyOptions returns the restriction to HTTPS selected with --https-only and --https-only-allowed-host.
+func HTTPSOnlyOptions() client.HTTPSOnlyOptions {
+	return client.HTTPSOnlyOptions{
+		Enabled:      viper.GetBool(config.OptHTTPSOnly),
+		AllowedHosts: viper.GetStringSlice(config.OptHTTPSOnlyAllowedHost),
+	}
+}
+
+// Resolver returns the resolver selected with --dns-server, caching its responses for --dns-cache-ttl.
+func Resolver() client.Resolver {
+	resolver := client.NewResolver(viper.GetString(config.OptDNSServer))
+	ttl := viper.GetDuration(config.OptDNSCacheTTL)
+	if ttl <= 0 {
+		return resolver
+	}
+	return &client.CachingResolver{Resolver: resolver, TTL: ttl}
+}
+
+// ProxyOptions returns the proxy selected with --proxy and --no-proxy.
+func ProxyOptions() (client.ProxyOptions, error) {
+	opts := client.ProxyOptions{NoProxy: viper.GetStringSlice(config.OptNoProxy)}
+	if proxy := viper.GetString(config.OptProxy); proxy != "" {
+		proxyURL, err := client.ParseProxyURL(proxy)
+		if err != nil {
+			return client.ProxyOptions{}, err
+		}
+		opts.URL = proxyURL
+	}
+	return opts, nil
+}
+
+// ClientOptions returns the options of the HTTP client selected with the flags shared by every command, with a
+// Transport shared by every client made from them, so that --max-conn-per-host holds for the whole run.
+func ClientOptions() (client.Options, error) {
+	resolveOverrides, err := config.ResolveOverridesToMap(viper.GetStringSlice(config.OptResolve))
+	if err != nil {
+		return client.Options{}, fmt.Errorf("error parsing resolve overrides: %w", err)
+	}
+	redirects, err := RedirectOptions()
+	if err != nil {
+		return client.Options{}, err
+	}
+	proxy, err := ProxyOptions()
+	if err != nil {
+		return client.Options{}, err
+	}
+	transportOpts := client.TransportOptions{
+		ForceHTTP2:           viper.GetBool(config.OptForceHTTP2),
+		ConnectTimeout:       viper.GetDuration(config.OptConnTimeout),
+		MaxConnPerHost:       viper.GetInt(config.OptMaxConnPerHost),
+		ResolveOverrides:     resolveOverrides,
+		Resolver:             Resolver(),
+		Proxy:                proxy,
+		HTTPSOnly:            HTTPSOnlyOptions(),
+		MaxRequestsPerSecond: viper.GetFloat64(config.OptMaxRequestsPerSecond),
+	}
+	return client.Options{
+		MaxRetries:    viper.GetInt(config.OptRetries),
+		MaxRetryAfter: viper.GetDuration(config.OptMaxRetryAfter),
+		Transport:     client.NewTransport(transportOpts),
+		TransportOpts: transportOpts,
+		Redirects:     redirects,
+	}, nil
+}
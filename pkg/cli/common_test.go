@@ -1,14 +1,21 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"net"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
-	"github.com/replicate/pget/pkg/config"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/consistent"
+	"github.com/replicate/pget/v2/pkg/download"
 )
 
 func TestEnsureDestinationNotExist(t *testing.T) {
@@ -40,6 +47,48 @@ func TestEnsureDestinationNotExist(t *testing.T) {
 	}
 }
 
+func TestFileDestination(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(config.OptOutputConsumer, config.ConsumerFile)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "existing")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+
+	for _, tc := range []struct {
+		name     string
+		url      string
+		dest     string
+		expected string
+		err      bool
+	}{
+		{"file", "https://example.com/weights.bin", filepath.Join(dir, "new"), filepath.Join(dir, "new"), false},
+		{"existing file", "https://example.com/weights.bin", file, file, false},
+		{"directory", "https://example.com/models/weights.bin?sig=abc", dir, filepath.Join(dir, "weights.bin"), false},
+		{"escaped name", "https://example.com/my%20weights.bin", dir, filepath.Join(dir, "my weights.bin"), false},
+		{"no file name", "https://example.com/models/", dir, "", true},
+		{"no path", "https://example.com", dir, "", true},
+		{"object", "https://example.com/weights.bin", "s3://bucket/models/llama.bin", "s3://bucket/models/llama.bin", false},
+		{"object prefix", "https://example.com/my%20weights.bin", "s3://bucket/models/", "s3://bucket/models/my%20weights.bin", false},
+		{"object prefix no file name", "https://example.com/models/", "gs://bucket/", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest, err := FileDestination(tc.url, tc.dest)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, dest)
+		})
+	}
+
+	// extractors extract into the directory
+	viper.Set(config.OptOutputConsumer, config.ConsumerTarExtractor)
+	dest, err := FileDestination("https://example.com/model.tar", dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, dest)
+}
+
 type tc struct {
 	srvs           []*net.SRV
 	expectedOutput []string
@@ -94,3 +143,120 @@ func TestOrderCacheHosts(t *testing.T) {
 		assert.Equal(t, testCase.expectedOutput, cacheHosts)
 	}
 }
+
+type srvResolver map[string][]*net.SRV
+
+func (r srvResolver) LookupHost(context.Context, string) ([]string, error) {
+	return nil, errors.New("unexpected host lookup")
+}
+
+func (r srvResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "http" || proto != "tcp" {
+		return "", nil, errors.New("unexpected service")
+	}
+	srvs, ok := r[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, srvs, nil
+}
+
+func (r srvResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// txtResolver serves TXT records, and SRV records from srvResolver.
+type txtResolver struct {
+	srvResolver
+	txts map[string][]string
+}
+
+func (r txtResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	txts, ok := r.txts[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return txts, nil
+}
+
+func TestLookupCacheHosts(t *testing.T) {
+	resolver := srvResolver{"cache.test": testCases[0].srvs}
+
+	cacheHosts, err := LookupCacheHosts(context.Background(), resolver, "cache.test")
+	require.NoError(t, err)
+	assert.Equal(t, testCases[0].expectedOutput, cacheHosts)
+
+	_, err = LookupCacheHosts(context.Background(), resolver, "unknown.test")
+	assert.Error(t, err)
+}
+
+func TestLookupCacheTuning(t *testing.T) {
+	resolver := txtResolver{txts: map[string][]string{
+		"_http._tcp.cache.test":   {"v=spf1 -all", "slice-size=256MiB chunk-size=64M unknown=1", "cache-hash-version=2 cache-hash-rollout=0.25"},
+		"_http._tcp.partial.test": {"chunk-size=32MiB"},
+		"_http._tcp.invalid.test": {"slice-size=lots"},
+		"_http._tcp.":             {"slice-size=1GiB"},
+		"_http._tcp.version.test": {"cache-hash-version=9"},
+		"_http._tcp.rollout.test": {"cache-hash-rollout=2"},
+	}}
+
+	tuning, err := LookupCacheTuning(context.Background(), resolver, "cache.test")
+	require.NoError(t, err)
+	assert.Equal(t, CacheTuning{SliceSize: 256 * 1024 * 1024, ChunkSize: 64_000_000, HashVersion: consistent.V2, HashRollout: 0.25}, tuning)
+
+	tuning, err = LookupCacheTuning(context.Background(), resolver, "partial.test")
+	require.NoError(t, err)
+	assert.Equal(t, CacheTuning{ChunkSize: 32 * 1024 * 1024}, tuning)
+
+	// no TXT records, no recommendations
+	tuning, err = LookupCacheTuning(context.Background(), resolver, "unknown.test")
+	require.NoError(t, err)
+	assert.Equal(t, CacheTuning{}, tuning)
+
+	for _, srvName := range []string{"invalid.test", "version.test", "rollout.test"} {
+		_, err = LookupCacheTuning(context.Background(), resolver, srvName)
+		assert.Error(t, err, srvName)
+	}
+}
+
+func TestApplyCacheTuning(t *testing.T) {
+	resolver := txtResolver{txts: map[string][]string{
+		"_http._tcp.cache.test":   {"slice-size=256MiB chunk-size=64MiB cache-hash-version=2 cache-hash-rollout=0.5"},
+		"_http._tcp.invalid.test": {"slice-size=lots"},
+		"_http._tcp.":             {"slice-size=1GiB"},
+	}}
+	testCases := []struct {
+		name                string
+		srvName             string
+		flags               []string
+		expectedSliceSize   int64
+		expectedChunkSize   int64
+		expectedHashVersion consistent.Version
+		expectedHashRollout float64
+	}{
+		{"defaults", "unknown.test", nil, 500 * 1024 * 1024, 125_000_000, 0, 0},
+		{"recommended", "cache.test", nil, 256 * 1024 * 1024, 64 * 1024 * 1024, consistent.V2, 0.5},
+		{"flags override recommendations", "cache.test", []string{"--slice-size", "1GiB", "--cache-hash-version", "1"}, 1024 * 1024 * 1024, 64 * 1024 * 1024, consistent.V1, 0.5},
+		{"invalid recommendations are ignored", "invalid.test", nil, 500 * 1024 * 1024, 125_000_000, 0, 0},
+		{"no SRV name", "", []string{"--cache-hash-version", "2"}, 500 * 1024 * 1024, 125_000_000, consistent.V2, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer viper.Reset()
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags.String(config.OptSliceSize, "500MiB", "")
+			flags.String(config.OptChunkSize, "125M", "")
+			flags.Int(config.OptCacheHashVersion, 0, "")
+			flags.Float64(config.OptCacheHashRollout, 0, "")
+			require.NoError(t, flags.Parse(tc.flags))
+			require.NoError(t, viper.BindPFlags(flags))
+
+			opts := download.Options{ChunkSize: 125_000_000}
+			require.NoError(t, ApplyCacheTuning(context.Background(), resolver, tc.srvName, &opts))
+			assert.Equal(t, tc.expectedSliceSize, opts.SliceSize)
+			assert.Equal(t, tc.expectedChunkSize, opts.ChunkSize)
+			assert.Equal(t, tc.expectedHashVersion, opts.CacheHashVersion)
+			assert.Equal(t, tc.expectedHashRollout, opts.CacheHashRollout)
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dustin/go-humanize"
+
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+const (
+	dashboardInterval = 500 * time.Millisecond
+	// dashboardRows is the number of files listed: the active downloads, then the failed and the most recently
+	// completed ones
+	dashboardRows = 20
+	// defaultTerminalWidth is assumed where the width of the terminal can't be found
+	defaultTerminalWidth = 120
+	progressBarWidth     = 20
+	maxNameWidth         = 48
+)
+
+// IsTerminal reports whether f is a terminal, which a dashboard can be drawn on.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// dashboard draws a table of the file downloads tracked by a pget.Progress at the bottom of a terminal, redrawing it
+// in place as they progress. Console log lines are printed above it.
+type dashboard struct {
+	out      *os.File
+	progress *pget.Progress
+
+	mu sync.Mutex
+	// table is the table last drawn, which is erased before anything else is written
+	table []string
+	// previous is the number of bytes downloaded of each file at the previous refresh, by its position in the
+	// progress, to work out the throughput of the active downloads
+	previous     []int64
+	previousTime time.Time
+}
+
+// StartDashboard draws a live table of the files tracked by progress on out, a terminal (see IsTerminal), with
+// their progress, throughput, retries and fallbacks, along with the totals of download.Stats, until the returned
+// function is called, which draws it a last time and leaves it on the terminal. Console log lines are printed above
+// the table meanwhile. It does nothing if progress is nil.
+func StartDashboard(ctx context.Context, out *os.File, progress *pget.Progress) (stop func()) {
+	if progress == nil {
+		return func() {}
+	}
+	d := &dashboard{out: out, progress: progress, previousTime: time.Now()}
+	restoreConsole := logging.RedirectConsole(d)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(dashboardInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				d.refresh(now)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+		d.refresh(time.Now())
+		restoreConsole()
+	}
+}
+
+// Write prints a console log line above the table.
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.erase()
+	n, err := d.out.Write(p)
+	d.draw()
+	return n, err
+}
+
+func (d *dashboard) refresh(now time.Time) {
+	files := d.progress.Files()
+	width := terminalWidth(d.out)
+	if width <= 0 {
+		width = defaultTerminalWidth
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	table := renderDashboard(files, d.previous, now.Sub(d.previousTime), download.Stats())
+	for i, line := range table {
+		// a line wrapping would take the erasing of the table off by one
+		table[i] = truncate(line, width-1)
+	}
+	d.erase()
+	d.table = table
+	d.draw()
+	d.previous = d.previous[:0]
+	for _, f := range files {
+		d.previous = append(d.previous, f.Downloaded)
+	}
+	d.previousTime = now
+}
+
+// erase moves the cursor up to the first line of the table and clears the screen from there.
+func (d *dashboard) erase() {
+	if len(d.table) > 0 {
+		_, _ = fmt.Fprintf(d.out, "\x1b[%dF\x1b[J", len(d.table))
+	}
+}
+
+func (d *dashboard) draw() {
+	if len(d.table) > 0 {
+		_, _ = d.out.WriteString(strings.Join(d.table, "\n") + "\n")
+	}
+}
+
+// renderDashboard returns the lines of the table of files, previous being the number of bytes each had downloaded
+// elapsed ago.
+func renderDashboard(files []pget.FileProgress, previous []int64, elapsed time.Duration, stats download.StatsSnapshot) []string {
+	var active, done, failed []int
+	for i, f := range files {
+		switch f.Status {
+		case pget.FileActive:
+			active = append(active, i)
+		case pget.FileFailed:
+			failed = append(failed, i)
+		default:
+			done = append(done, i)
+		}
+	}
+	// the most recently completed first
+	for i, j := 0, len(done)-1; i < j; i, j = i+1, j-1 {
+		done[i], done[j] = done[j], done[i]
+	}
+	rows := make([]int, 0, len(files))
+	rows = append(append(append(rows, active...), failed...), done...)
+	hidden := 0
+	if len(rows) > dashboardRows {
+		hidden = len(rows) - dashboardRows
+		rows = rows[:dashboardRows]
+	}
+
+	summary := fmt.Sprintf("%d active, %d done, %d failed | %s downloaded | %s/s | %d active chunks, %d queued",
+		len(active), len(done), len(failed),
+		humanize.Bytes(uint64(stats.BytesDownloaded)), humanize.Bytes(uint64(stats.BytesPerSecond)),
+		stats.ActiveChunks, stats.QueuedChunks)
+	if stats.CacheHits+stats.CacheMisses > 0 {
+		summary += fmt.Sprintf(" | cache hit ratio %.2f", float64(stats.CacheHits)/float64(stats.CacheHits+stats.CacheMisses))
+	}
+
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "FILE\tSIZE\tPROGRESS\tTHROUGHPUT\tRETRIES\tFALLBACKS\tSTATUS")
+	for _, i := range rows {
+		f := files[i]
+		name := f.Dest
+		if name == "" {
+			name = f.URL
+		}
+		size, bar := "-", "-"
+		if f.Size >= 0 {
+			size = humanize.Bytes(uint64(f.Size))
+			bar = progressBar(f.Downloaded, f.Size)
+		}
+		var bytesPerSecond float64
+		switch {
+		case f.Status != pget.FileActive && f.Elapsed > 0:
+			// the average of the download
+			bytesPerSecond = float64(f.Downloaded) / f.Elapsed.Seconds()
+		case i < len(previous) && elapsed > 0:
+			bytesPerSecond = float64(f.Downloaded-previous[i]) / elapsed.Seconds()
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s/s\t%d\t%d\t%s %s\n", shortenName(name), size, bar,
+			humanize.Bytes(uint64(bytesPerSecond)), f.Retries, f.Fallbacks, f.Status, f.Elapsed.Round(time.Second))
+	}
+	_ = w.Flush()
+
+	lines := append([]string{summary}, strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")...)
+	if hidden > 0 {
+		lines = append(lines, fmt.Sprintf("... and %d more", hidden))
+	}
+	return lines
+}
+
+func progressBar(downloaded, size int64) string {
+	fraction := 1.0
+	if size > 0 {
+		fraction = min(float64(downloaded)/float64(size), 1)
+	}
+	filled := int(fraction * progressBarWidth)
+	return fmt.Sprintf("[%s%s] %5.1f%%", strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled), 100*fraction)
+}
+
+// shortenName cuts the start of a name too long for its column, which leaves the file name.
+func shortenName(name string) string {
+	if utf8.RuneCountInString(name) <= maxNameWidth {
+		return name
+	}
+	runes := []rune(name)
+	return "..." + string(runes[len(runes)-maxNameWidth+3:])
+}
+
+// truncate cuts line to width runes.
+func truncate(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+func TestRenderDashboard(t *testing.T) {
+	files := []pget.FileProgress{
+		{URL: "https://example.com/a", Dest: "a", Size: 1000, Downloaded: 1000, Status: pget.FileDone, Elapsed: 2 * time.Second},
+		{URL: "https://example.com/b", Dest: "b", Size: 4000, Downloaded: 1000, Retries: 2, Fallbacks: 1, Status: pget.FileActive, Elapsed: time.Second},
+		{URL: "https://example.com/c", Dest: "c", Size: -1, Status: pget.FileFailed},
+		{URL: "https://example.com/d", Dest: "d", Size: 10, Downloaded: 10, Status: pget.FileDone, Elapsed: time.Second},
+	}
+	lines := renderDashboard(files, []int64{1000, 500}, time.Second, download.StatsSnapshot{BytesDownloaded: 2010, CacheHits: 3, CacheMisses: 1})
+	require.Len(t, lines, 6)
+	assert.Equal(t, "1 active, 2 done, 1 failed | 2.0 kB downloaded | 0 B/s | 0 active chunks, 0 queued | cache hit ratio 0.75", lines[0])
+	assert.Equal(t, []string{"FILE", "SIZE", "PROGRESS", "THROUGHPUT", "RETRIES", "FALLBACKS", "STATUS"}, strings.Fields(lines[1]))
+	// active, then failed, then the most recently completed
+	assert.Equal(t, []string{"b", "4.0", "kB", "[#####...............]", "25.0%", "500", "B/s", "2", "1", "active", "1s"}, strings.Fields(lines[2]))
+	assert.Equal(t, []string{"c", "-", "-", "0", "B/s", "0", "0", "failed", "0s"}, strings.Fields(lines[3]))
+	assert.Equal(t, "d", strings.Fields(lines[4])[0])
+	assert.Equal(t, []string{"a", "1.0", "kB", "[####################]", "100.0%", "500", "B/s", "0", "0", "done", "2s"}, strings.Fields(lines[5]))
+}
+
+func TestShortenName(t *testing.T) {
+	assert.Equal(t, "models/weights.bin", shortenName("models/weights.bin"))
+	long := strings.Repeat("d/", 40) + "weights.bin"
+	shortened := shortenName(long)
+	assert.Len(t, shortened, maxNameWidth)
+	assert.True(t, strings.HasPrefix(shortened, "..."))
+	assert.True(t, strings.HasSuffix(shortened, "/weights.bin"))
+	assert.Equal(t, "abc", truncate("abcdef", 3))
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"github.com/replicate/pget/v2/pkg/config"
+)
+
+// ErrTotalDeadline is the cause of the end of an invocation which ran past --total-deadline.
+var ErrTotalDeadline = errors.New("total deadline exceeded")
+
+// WithTotalDeadline returns ctx ending --total-deadline from now, if it is set, with ErrTotalDeadline as its cause.
+// The deadline reaches every download through the context, including the requests to cache hosts, which are told
+// how long they have left.
+func WithTotalDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline := viper.GetDuration(config.OptTotalDeadline)
+	if deadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, deadline, ErrTotalDeadline)
+}
+
+// DeadlineError makes err match ErrTotalDeadline if ctx ended because of it, so that the failure is reported as the
+// deadline rather than as whichever download it interrupted.
+func DeadlineError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrTotalDeadline) && !errors.Is(err, ErrTotalDeadline) {
+		return fmt.Errorf("%w (%s): %w", ErrTotalDeadline, viper.GetDuration(config.OptTotalDeadline), err)
+	}
+	return err
+}
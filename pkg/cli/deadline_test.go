@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/replicate/pget/v2/pkg/config"
+)
+
+func TestWithTotalDeadline(t *testing.T) {
+	defer viper.Reset()
+
+	ctx, cancel := WithTotalDeadline(context.Background())
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+	cancel()
+	assert.NoError(t, DeadlineError(ctx, nil))
+	// cancelled, rather than past the deadline
+	assert.NotErrorIs(t, DeadlineError(ctx, context.Canceled), ErrTotalDeadline)
+
+	viper.Set(config.OptTotalDeadline, time.Millisecond)
+	ctx, cancel = WithTotalDeadline(context.Background())
+	defer cancel()
+	<-ctx.Done()
+	err := DeadlineError(ctx, errors.New("error reading chunk"))
+	assert.ErrorIs(t, err, ErrTotalDeadline)
+	assert.Equal(t, ExitTimeout, ExitCode(err))
+}
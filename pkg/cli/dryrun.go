@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/viper"
+
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/vfs"
+)
+
+// DryRun writes to w how strategy would download each entry of manifest, and what would be done with its
+// destination, for --dry-run. Only the first request of each download is made (see download.PlanDownload), without
+// reading its body. Entries which can't be planned are reported as such, and make it return an error once all of
+// them have been.
+func DryRun(ctx context.Context, w io.Writer, strategy download.Strategy, manifest pget.Manifest) error {
+	var errs []error
+	for i, entry := range manifest {
+		if i > 0 {
+			_, _ = fmt.Fprintln(w)
+		}
+		_, _ = fmt.Fprintln(w, entry.URL)
+		if entry.Unchanged {
+			_, _ = fmt.Fprintf(w, "  dest:      %s is unchanged, not downloading\n", entry.Dest)
+			continue
+		}
+		plan, err := download.PlanDownload(ctx, strategy, entry.URL)
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "  error:     %v\n", err)
+			errs = append(errs, fmt.Errorf("%s: %w", entry.URL, err))
+		} else {
+			writePlan(w, entry.URL, plan)
+		}
+		for _, action := range destinationActions(entry.Dest) {
+			_, _ = fmt.Fprintf(w, "  dest:      %s\n", action)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("dry run: %d of %d downloads can't be made: %w", len(errs), len(manifest), errors.Join(errs...))
+	}
+	return nil
+}
+
+func writePlan(w io.Writer, rawURL string, plan *download.Plan) {
+	if plan.URL != rawURL {
+		_, _ = fmt.Fprintf(w, "  from:      %s\n", plan.URL)
+	}
+	if plan.Size >= 0 {
+		_, _ = fmt.Fprintf(w, "  size:      %s (%d bytes)\n", humanize.IBytes(uint64(plan.Size)), plan.Size)
+	} else {
+		_, _ = fmt.Fprintln(w, "  size:      unknown")
+	}
+	strategy := plan.Strategy
+	if plan.Reason != "" {
+		strategy += fmt.Sprintf(" (%s)", plan.Reason)
+	}
+	_, _ = fmt.Fprintf(w, "  strategy:  %s\n", strategy)
+	switch {
+	case plan.Strategy == download.PlanOther:
+	case plan.SingleConnection:
+		_, _ = fmt.Fprintln(w, "  chunks:    1, over a single connection (no range support)")
+	case plan.AutoChunkSize:
+		_, _ = fmt.Fprintf(w, "  chunks:    first %s, then at least %s each, sized from the first (--chunk-size auto)\n",
+			humanize.IBytes(uint64(plan.FirstChunkSize)), humanize.IBytes(uint64(plan.ChunkSize)))
+	default:
+		_, _ = fmt.Fprintf(w, "  chunks:    %d of up to %s\n", plan.Chunks, humanize.IBytes(uint64(plan.ChunkSize)))
+	}
+	if len(plan.Slices) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "  slices:    %d of %s, hash version %d\n", len(plan.Slices), humanize.IBytes(uint64(plan.SliceSize)), plan.Slices[0].HashVersion)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "    SLICE\tBYTES\tCHUNKS\tBUCKET\tCACHE HOST")
+	for _, slice := range plan.Slices {
+		host := slice.CacheHost
+		if host == "" {
+			host = "(unavailable, next bucket or origin)"
+		}
+		_, _ = fmt.Fprintf(tw, "    %d\t%d-%d\t%d\t%d\t%s\n", slice.Slice, slice.Start, slice.End, slice.Chunks, slice.Bucket, host)
+	}
+	_ = tw.Flush()
+}
+
+// destinationActions describes what each consumer would do with dest.
+func destinationActions(dest string) []string {
+	names := config.ConsumerNames()
+	var actions []string
+	for _, name := range names {
+		switch name {
+		case config.ConsumerFile:
+			target := dest
+			if len(names) > 1 {
+				target = viper.GetString(config.OptArchiveDest)
+			}
+			actions = append(actions, fileAction(target))
+		case config.ConsumerTarExtractor, config.ConsumerZipExtractor:
+			kind := strings.TrimSuffix(name, "-extractor")
+			if vfs.IsRemote(dest) {
+				actions = append(actions, fmt.Sprintf("extract %s archive into object store prefix %s", kind, dest))
+			} else {
+				actions = append(actions, fmt.Sprintf("extract %s archive into directory %s", kind, dest))
+			}
+		case config.ConsumerNull:
+			actions = append(actions, "discard the content")
+		default:
+			actions = append(actions, fmt.Sprintf("unknown consumer %s", name))
+		}
+	}
+	return actions
+}
+
+// fileAction describes what the file consumer would do with dest.
+func fileAction(dest string) string {
+	if vfs.IsRemote(dest) {
+		return fmt.Sprintf("upload to object %s, replacing it if it exists", dest)
+	}
+	if _, err := os.Lstat(dest); err != nil {
+		return fmt.Sprintf("write %s", dest)
+	}
+	policy, err := config.OverwritePolicy()
+	if err != nil {
+		return fmt.Sprintf("%s exists: %v", dest, err)
+	}
+	if !policy.Allowed() {
+		return fmt.Sprintf("fail: %s already exists (see --%s)", dest, config.OptOverwrite)
+	}
+	return fmt.Sprintf("overwrite existing %s (--%s %s)", dest, config.OptOverwrite, policy)
+}
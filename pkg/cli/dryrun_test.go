@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/config"
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+func TestDryRun(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(config.OptOutputConsumer, config.ConsumerFile)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.bin")
+	require.NoError(t, os.WriteFile(source, []byte("0123456789"), 0644))
+	existing := filepath.Join(dir, "existing")
+	require.NoError(t, os.WriteFile(existing, nil, 0644))
+	sourceURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(source)}).String()
+	missingURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(dir, "missing"))}).String()
+
+	manifest := pget.Manifest{}.
+		AddEntry(sourceURL, filepath.Join(dir, "new")).
+		AddEntry(sourceURL, existing).
+		AddEntry(missingURL, filepath.Join(dir, "other"))
+	var out strings.Builder
+	err := DryRun(context.Background(), &out, download.GetBufferMode(download.Options{ChunkSize: 4}), manifest)
+	assert.ErrorContains(t, err, "1 of 3 downloads can't be made")
+
+	assert.Contains(t, out.String(), "  size:      10 B (10 bytes)\n  strategy:  buffer\n  chunks:    3 of up to 4 B\n")
+	assert.Contains(t, out.String(), "  dest:      write "+filepath.Join(dir, "new")+"\n")
+	assert.Contains(t, out.String(), "  dest:      fail: "+existing+" already exists (see --overwrite)\n")
+	assert.Contains(t, out.String(), missingURL+"\n  error:     ")
+	// nothing was written
+	_, err = os.Stat(filepath.Join(dir, "new"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDestinationActions(t *testing.T) {
+	defer viper.Reset()
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	require.NoError(t, os.WriteFile(existing, nil, 0644))
+
+	for _, tc := range []struct {
+		name     string
+		consumer string
+		dest     string
+		opts     map[string]any
+		expected []string
+	}{
+		{"new file", config.ConsumerFile, filepath.Join(dir, "new"), nil, []string{"write " + filepath.Join(dir, "new")}},
+		{"existing file", config.ConsumerFile, existing, nil, []string{"fail: " + existing + " already exists (see --overwrite)"}},
+		{"overwrite", config.ConsumerFile, existing, map[string]any{config.OptForce: true}, []string{"overwrite existing " + existing + " (--overwrite always)"}},
+		{"object", config.ConsumerFile, "s3://bucket/key", nil, []string{"upload to object s3://bucket/key, replacing it if it exists"}},
+		{"tar", config.ConsumerTarExtractor, dir, nil, []string{"extract tar archive into directory " + dir}},
+		{"zip to object store", config.ConsumerZipExtractor, "gs://bucket/prefix/", nil, []string{"extract zip archive into object store prefix gs://bucket/prefix/"}},
+		{"null", config.ConsumerNull, "", nil, []string{"discard the content"}},
+		{
+			"archive and extract", config.ConsumerFile + "+" + config.ConsumerTarExtractor, dir,
+			map[string]any{config.OptArchiveDest: filepath.Join(dir, "archive.tar")},
+			[]string{"write " + filepath.Join(dir, "archive.tar"), "extract tar archive into directory " + dir},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			viper.Reset()
+			viper.Set(config.OptOutputConsumer, tc.consumer)
+			for key, value := range tc.opts {
+				viper.Set(key, value)
+			}
+			assert.Equal(t, tc.expected, destinationActions(tc.dest))
+		})
+	}
+}
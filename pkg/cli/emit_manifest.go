@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"sync"
+
+	pget "github.com/replicate/pget/v2/pkg"
+)
+
+// ManifestEmitter collects a pget.DownloadRecord for every downloaded file and writes them out with
+// pget.WriteDownloadRecords once the run is over.
+type ManifestEmitter struct {
+	path string
+
+	mu      sync.Mutex
+	records []pget.DownloadRecord
+}
+
+// NewManifestEmitter returns nil if path is empty. Write is safe to call on a nil *ManifestEmitter.
+func NewManifestEmitter(path string) *ManifestEmitter {
+	if path == "" {
+		return nil
+	}
+	return &ManifestEmitter{path: path}
+}
+
+// Record is intended to be used as pget.Options.OnFileComplete.
+func (e *ManifestEmitter) Record(record pget.DownloadRecord) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.records = append(e.records, record)
+}
+
+func (e *ManifestEmitter) Write() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return pget.WriteDownloadRecords(e.path, e.records)
+}
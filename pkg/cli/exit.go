@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"errors"
+
+	pget "github.com/replicate/pget/v2/pkg"
+)
+
+const (
+	// ExitFailure is the exit status of a failed run.
+	ExitFailure = 1
+	// ExitPartial is the exit status of a run which a budget (--max-total-bytes or --max-total-time) stopped
+	// early: every file it started was downloaded, but some were not started.
+	ExitPartial = 3
+	// ExitTimeout is the exit status of a run which --total-deadline ended, as with timeout(1).
+	ExitTimeout = 124
+)
+
+// ExitCode returns the exit status for a run which returned err.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if errors.Is(err, ErrTotalDeadline) {
+		return ExitTimeout
+	}
+	var missingErr *pget.MissingEntriesError
+	var failedErr *pget.FailedEntriesError
+	if errors.Is(err, pget.ErrBudgetExceeded) && !errors.As(err, &missingErr) && !errors.As(err, &failedErr) {
+		return ExitPartial
+	}
+	return ExitFailure
+}
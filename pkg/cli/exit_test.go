@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	pget "github.com/replicate/pget/v2/pkg"
+)
+
+func TestExitCode(t *testing.T) {
+	budgetErr := &pget.BudgetExceededError{Reason: "ran for the 1m0s budget"}
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, ExitFailure, ExitCode(errors.New("boom")))
+	assert.Equal(t, ExitPartial, ExitCode(budgetErr))
+	assert.Equal(t, ExitPartial, ExitCode(fmt.Errorf("wrapped: %w", budgetErr)))
+	// a run which also had failures is not a partial success
+	assert.Equal(t, ExitFailure, ExitCode(errors.Join(&pget.MissingEntriesError{}, budgetErr)))
+	assert.Equal(t, ExitFailure, ExitCode(errors.Join(&pget.FailedEntriesError{}, budgetErr)))
+	assert.Equal(t, ExitTimeout, ExitCode(fmt.Errorf("%w: %w", ErrTotalDeadline, budgetErr)))
+}
@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	pget "github.com/replicate/pget/v2/pkg"
+)
+
+// ResultPrinter collects a pget.DownloadRecord for every downloaded file and prints a JSON document describing the
+// run once it is over, so that scripts need not parse the log.
+type ResultPrinter struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	records []pget.DownloadRecord
+}
+
+// NewResultPrinter returns nil if enabled is false. Print is safe to call on a nil *ResultPrinter.
+func NewResultPrinter(enabled bool, w io.Writer) *ResultPrinter {
+	if !enabled {
+		return nil
+	}
+	return &ResultPrinter{w: w}
+}
+
+// Record is intended to be used as pget.Options.OnFileComplete.
+func (p *ResultPrinter) Record(record pget.DownloadRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.records = append(p.records, record)
+}
+
+type runResult struct {
+	Files           []fileResult `json:"files"`
+	Bytes           int64        `json:"bytes"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	// Throughput is in bytes per second
+	Throughput float64 `json:"throughput"`
+	Retries    int64   `json:"retries"`
+	Fallbacks  int64   `json:"fallbacks"`
+	// CacheHits and CacheMisses count the responses of cache hosts by their reported cache status, and
+	// CacheHitRatio is the fraction of them which were hits, if there were any
+	CacheHits     int64    `json:"cache_hits"`
+	CacheMisses   int64    `json:"cache_misses"`
+	CacheHitRatio *float64 `json:"cache_hit_ratio,omitempty"`
+	// Error is set if the run failed; Files lists the files downloaded before it did
+	Error string `json:"error,omitempty"`
+	// Skipped lists the entries a run budget stopped from being downloaded
+	Skipped []skippedResult `json:"skipped,omitempty"`
+}
+
+type skippedResult struct {
+	URL  string `json:"url"`
+	Dest string `json:"dest"`
+}
+
+type fileResult struct {
+	URL             string   `json:"url"`
+	Dest            string   `json:"dest"`
+	Bytes           int64    `json:"bytes"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	Throughput      float64  `json:"throughput"`
+	Retries         int64    `json:"retries"`
+	Fallbacks       int64    `json:"fallbacks"`
+	CacheHits       int64    `json:"cache_hits"`
+	CacheMisses     int64    `json:"cache_misses"`
+	CacheHitRatio   *float64 `json:"cache_hit_ratio,omitempty"`
+	ETag            string   `json:"etag,omitempty"`
+	SHA256          string   `json:"sha256"`
+}
+
+// Print writes the document for a run which took elapsed and failed with runErr, if not nil. Files are listed by
+// destination.
+func (p *ResultPrinter) Print(elapsed time.Duration, runErr error) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := runResult{
+		Files:           make([]fileResult, 0, len(p.records)),
+		DurationSeconds: elapsed.Seconds(),
+	}
+	for _, r := range p.records {
+		result.Files = append(result.Files, fileResult{
+			URL:             r.URL,
+			Dest:            r.Dest,
+			Bytes:           r.Size,
+			DurationSeconds: r.Duration.Seconds(),
+			Throughput:      throughput(r.Size, r.Duration),
+			Retries:         r.Retries,
+			Fallbacks:       r.Fallbacks,
+			CacheHits:       r.CacheHits,
+			CacheMisses:     r.CacheMisses,
+			CacheHitRatio:   cacheHitRatio(r.CacheHits, r.CacheMisses),
+			ETag:            r.ETag,
+			SHA256:          r.SHA256,
+		})
+		result.Bytes += r.Size
+		result.Retries += r.Retries
+		result.Fallbacks += r.Fallbacks
+		result.CacheHits += r.CacheHits
+		result.CacheMisses += r.CacheMisses
+	}
+	result.CacheHitRatio = cacheHitRatio(result.CacheHits, result.CacheMisses)
+	sort.Slice(result.Files, func(i, j int) bool { return result.Files[i].Dest < result.Files[j].Dest })
+	result.Throughput = throughput(result.Bytes, elapsed)
+	if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	var budgetErr *pget.BudgetExceededError
+	if errors.As(runErr, &budgetErr) {
+		for _, entry := range budgetErr.Skipped {
+			result.Skipped = append(result.Skipped, skippedResult{URL: entry.URL, Dest: entry.Dest})
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(p.w, string(data)); err != nil {
+		return fmt.Errorf("error writing JSON output: %w", err)
+	}
+	return nil
+}
+
+// cacheHitRatio returns the fraction of hits among the responses of cache hosts, or nil if there were none.
+func cacheHitRatio(hits, misses int64) *float64 {
+	if hits+misses == 0 {
+		return nil
+	}
+	ratio := float64(hits) / float64(hits+misses)
+	return &ratio
+}
+
+func throughput(size int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(size) / elapsed.Seconds()
+}
+
+// OnFileComplete returns the pget.Options.OnFileComplete which records to emitter and printer, either of which may
+// be nil, or nil if both are.
+func OnFileComplete(emitter *ManifestEmitter, printer *ResultPrinter) func(pget.DownloadRecord) {
+	switch {
+	case emitter == nil && printer == nil:
+		return nil
+	case printer == nil:
+		return emitter.Record
+	case emitter == nil:
+		return printer.Record
+	}
+	return func(record pget.DownloadRecord) {
+		emitter.Record(record)
+		printer.Record(record)
+	}
+}
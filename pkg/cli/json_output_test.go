@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pget "github.com/replicate/pget/v2/pkg"
+)
+
+func TestResultPrinter(t *testing.T) {
+	var out bytes.Buffer
+	printer := NewResultPrinter(true, &out)
+	onFileComplete := OnFileComplete(nil, printer)
+	onFileComplete(pget.DownloadRecord{URL: "https://example.com/b", Dest: "b", Size: 300, SHA256: "bb", Duration: time.Second, Retries: 2, CacheHits: 3, CacheMisses: 1})
+	onFileComplete(pget.DownloadRecord{URL: "https://example.com/a", Dest: "a", Size: 100, SHA256: "aa", Duration: time.Second, Fallbacks: 1})
+	require.NoError(t, printer.Print(2*time.Second, errors.New("boom")))
+
+	var result map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, float64(400), result["bytes"])
+	assert.Equal(t, float64(200), result["throughput"])
+	assert.Equal(t, float64(2), result["retries"])
+	assert.Equal(t, float64(1), result["fallbacks"])
+	assert.Equal(t, float64(3), result["cache_hits"])
+	assert.Equal(t, float64(1), result["cache_misses"])
+	assert.Equal(t, 0.75, result["cache_hit_ratio"])
+	assert.Equal(t, "boom", result["error"])
+	files := result["files"].([]any)
+	require.Len(t, files, 2)
+	first := files[0].(map[string]any)
+	assert.Equal(t, "a", first["dest"])
+	assert.Equal(t, "https://example.com/a", first["url"])
+	assert.Equal(t, "aa", first["sha256"])
+	assert.Equal(t, float64(100), first["throughput"])
+	// the ratio is left out of files which no cache host served
+	assert.NotContains(t, first, "cache_hit_ratio")
+	assert.Equal(t, 0.75, files[1].(map[string]any)["cache_hit_ratio"])
+
+	// entries skipped by a budget are listed
+	out.Reset()
+	budgetErr := &pget.BudgetExceededError{Reason: "ran for the 1m0s budget", Skipped: []pget.ManifestEntry{{URL: "https://example.com/c", Dest: "c"}}}
+	require.NoError(t, printer.Print(2*time.Second, budgetErr))
+	require.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, []any{map[string]any{"url": "https://example.com/c", "dest": "c"}}, result["skipped"])
+
+	// disabled
+	assert.Nil(t, NewResultPrinter(false, &out))
+	assert.Nil(t, OnFileComplete(nil, nil))
+	var disabled *ResultPrinter
+	assert.NoError(t, disabled.Print(time.Second, nil))
+}
@@ -1,18 +1,14 @@
-//go:build !windows
-
 package cli
 
 import (
 	"fmt"
 	"os"
-	"syscall"
 
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/logging"
 )
 
 type PIDFile struct {
 	file *os.File
-	fd   int
 }
 
 func NewPIDFile(path string) (*PIDFile, error) {
@@ -20,7 +16,7 @@ func NewPIDFile(path string) (*PIDFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &PIDFile{file: file, fd: int(file.Fd())}, nil
+	return &PIDFile{file: file}, nil
 }
 
 func (p *PIDFile) Acquire() error {
@@ -28,14 +24,14 @@ func (p *PIDFile) Acquire() error {
 	funcs := []func() error{
 		func() error {
 			logger.Debug().Str("blocking_lock_acquire", "false").Msg("Waiting on Lock")
-			err := syscall.Flock(p.fd, syscall.LOCK_EX|syscall.LOCK_NB)
+			err := lockFile(p.file, false)
 			if err != nil {
 				logger.Warn().
 					Err(err).
 					Str("warn_message", "Another pget process may be running, use 'pget multifile' to download multiple files in parallel").
 					Msg("Waiting on Lock")
 				logger.Debug().Str("blocking_lock_acquire", "true").Msg("Waiting on Lock")
-				err = syscall.Flock(p.fd, syscall.LOCK_EX)
+				err = lockFile(p.file, true)
 			}
 			return err
 		},
@@ -47,7 +43,7 @@ func (p *PIDFile) Acquire() error {
 
 func (p *PIDFile) Release() error {
 	funcs := []func() error{
-		func() error { return syscall.Flock(p.fd, syscall.LOCK_UN) },
+		func() error { return unlockFile(p.file) },
 		p.file.Close,
 	}
 	return p.executeFuncs(funcs)
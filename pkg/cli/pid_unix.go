@@ -0,0 +1,22 @@
+//go:build !windows
+
+package cli
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive flock on f. If blocking is false, it fails rather than wait for another process to
+// release its lock.
+func lockFile(f *os.File, blocking bool) error {
+	how := syscall.LOCK_EX
+	if !blocking {
+		how |= syscall.LOCK_NB
+	}
+	return syscall.Flock(int(f.Fd()), how)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// StartStatsLogger logs a summary of download.Stats at INFO level every interval, until the returned function is
+// called, along with the connection pool stats of transport if it is a *client.Transport. It does nothing if
+// interval is zero.
+func StartStatsLogger(ctx context.Context, interval time.Duration, transport http.RoundTripper) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				logStats(download.Stats(), transport)
+			}
+		}
+	}()
+	return cancel
+}
+
+func logStats(s download.StatsSnapshot, transport http.RoundTripper) {
+	logger := logging.GetLogger()
+	event := logger.Info().
+		Int64("active_chunks", s.ActiveChunks).
+		Int64("queued_chunks", s.QueuedChunks).
+		Str("buffered", humanize.Bytes(uint64(s.BufferedBytes))).
+		Int64("paused_chunks", s.PausedChunks).
+		Str("downloaded", humanize.Bytes(uint64(s.BytesDownloaded))).
+		Str("throughput", humanize.Bytes(uint64(s.BytesPerSecond))+"/s")
+	if len(s.HostErrors) > 0 {
+		errors := make(map[string]any, len(s.HostErrors))
+		for host, count := range s.HostErrors {
+			errors[host] = count
+		}
+		event = event.Fields(map[string]any{"host_errors": errors})
+	}
+	if s.CacheHits+s.CacheMisses > 0 {
+		event = event.
+			Int64("cache_hits", s.CacheHits).
+			Int64("cache_misses", s.CacheMisses).
+			Float64("cache_hit_ratio", float64(s.CacheHits)/float64(s.CacheHits+s.CacheMisses))
+	}
+	if t, ok := transport.(*client.Transport); ok {
+		pools := t.Stats()
+		connections := make(map[string]any, len(pools))
+		for host, pool := range pools {
+			connections[host] = map[string]any{
+				"open":     pool.Open,
+				"dialed":   pool.Dialed,
+				"requests": pool.Requests,
+				"reused":   pool.Reused,
+			}
+		}
+		event = event.Fields(map[string]any{"connections": connections})
+	}
+	event.Msg("Stats")
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cli
+
+import "os"
+
+// terminalWidth returns the number of columns of the terminal f is, or 0 if it can't be found.
+func terminalWidth(*os.File) int {
+	return 0
+}
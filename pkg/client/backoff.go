@@ -0,0 +1,66 @@
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// linearJitterRetryAfterBackoff returns a retryablehttp.Backoff which wraps retryablehttp.LinearJitterBackoff but
+// also adheres to Retry-After headers on 429 and 503 responses, waiting at most maxRetryAfter.
+func linearJitterRetryAfterBackoff(maxRetryAfter time.Duration) retryablehttp.Backoff {
+	return func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		var retryAfter time.Duration
+
+		if shouldApplyRetryAfter(resp) {
+			retryAfter = evaluateRetryAfter(resp, time.Now())
+		}
+		if retryAfter > maxRetryAfter {
+			retryAfter = maxRetryAfter
+		}
+
+		if retryAfter > 0 {
+			// If the Retry-After header is set, treat this as attempt 0 to get just the jitter
+			jitter := max - min
+			return retryablehttp.LinearJitterBackoff(retryAfter, retryAfter+jitter, 0, resp)
+		}
+
+		return retryablehttp.LinearJitterBackoff(min, max, attemptNum, resp)
+	}
+}
+
+// evaluateRetryAfter parses the Retry-After header, which is either a number of seconds or an HTTP-date (RFC 9110
+// section 10.2.3). It returns 0 if the header is missing, invalid, or in the past.
+func evaluateRetryAfter(resp *http.Response, now time.Time) time.Duration {
+	retryAfter := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if retryAfter == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		// guard against overflowing time.Duration, the caller caps the wait anyway
+		if seconds > int64(time.Duration(1<<63-1)/time.Second) {
+			return time.Duration(1<<63 - 1)
+		}
+		return time.Second * time.Duration(seconds)
+	}
+
+	date, err := http.ParseTime(retryAfter)
+	if err != nil {
+		return 0
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func shouldApplyRetryAfter(resp *http.Response) bool {
+	return resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable)
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		retryAfter string
+		expected   time.Duration
+	}{
+		{"missing", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"zero seconds", "0", 0},
+		{"negative seconds", "-5", 0},
+		{"http-date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"http-date in the past", now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"garbage", "soon", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tc.retryAfter != "" {
+				resp.Header.Set("Retry-After", tc.retryAfter)
+			}
+			assert.Equal(t, tc.expected, evaluateRetryAfter(resp, now))
+		})
+	}
+}
+
+func TestLinearJitterRetryAfterBackoff(t *testing.T) {
+	backoff := linearJitterRetryAfterBackoff(10 * time.Second)
+	min, max := 100*time.Millisecond, 200*time.Millisecond
+
+	testCases := []struct {
+		name       string
+		status     int
+		retryAfter string
+		lower      time.Duration
+		upper      time.Duration
+	}{
+		{"429 honours retry-after", http.StatusTooManyRequests, "3", 3 * time.Second, 3*time.Second + 100*time.Millisecond},
+		{"503 honours retry-after", http.StatusServiceUnavailable, "3", 3 * time.Second, 3*time.Second + 100*time.Millisecond},
+		{"retry-after is capped", http.StatusTooManyRequests, "3600", 10 * time.Second, 10*time.Second + 100*time.Millisecond},
+		{"500 ignores retry-after", http.StatusInternalServerError, "3", min, max},
+		{"429 without retry-after", http.StatusTooManyRequests, "", min, max},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tc.status, Header: http.Header{}}
+			if tc.retryAfter != "" {
+				resp.Header.Set("Retry-After", tc.retryAfter)
+			}
+			wait := backoff(min, max, 0, resp)
+			assert.GreaterOrEqual(t, wait, tc.lower)
+			assert.LessOrEqual(t, wait, tc.upper)
+		})
+	}
+}
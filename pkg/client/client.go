@@ -6,14 +6,12 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 
-	"github.com/replicate/pget/pkg/config"
-	"github.com/replicate/pget/pkg/logging"
-	"github.com/replicate/pget/pkg/version"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/version"
 )
 
 const (
@@ -21,9 +19,17 @@ const (
 	// see retryablehttp.LinearJitterBackoff for more details
 	retryMinWait = 850 * time.Millisecond
 	retryMaxWait = 1250 * time.Millisecond
+
+	defaultMaxRetryAfter = 30 * time.Second
 )
 
-var ErrStrategyFallback = errors.New("fallback to next strategy")
+var (
+	ErrStrategyFallback = errors.New("fallback to next strategy")
+
+	// ErrCacheUnreachable is returned, along with ErrStrategyFallback, when a request to a cache host fails with a
+	// connection-level error (see fallbackError) or no cache host is available.
+	ErrCacheUnreachable = errors.New("cache host unreachable")
+)
 
 type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
@@ -37,13 +43,22 @@ type PGetHTTPClient struct {
 
 func (c *PGetHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	req.Header.Set("User-Agent", fmt.Sprintf("pget/%s", version.GetVersion()))
+	if s, ok := req.Context().Value(signedURLKey{}).(*signedURL); ok && req.Body == nil && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+		return s.do(c.Client, req)
+	}
 	return c.Client.Do(req)
 }
 
 type Options struct {
-	MaxRetries    int
+	MaxRetries int
+	// MaxRetryAfter caps how long a Retry-After header can make us wait before retrying. If set to zero, 30s will
+	// be used.
+	MaxRetryAfter time.Duration
+	// Transport, if set, is used instead of a new Transport built from TransportOpts. Clients which should share a
+	// connection pool are given the same one.
 	Transport     http.RoundTripper
 	TransportOpts TransportOptions
+	Redirects     RedirectOptions
 }
 
 type TransportOptions struct {
@@ -51,6 +66,16 @@ type TransportOptions struct {
 	ResolveOverrides map[string]string
 	MaxConnPerHost   int
 	ConnectTimeout   time.Duration
+	// Resolver looks up the addresses of the hosts connected to. If nil, the dialer resolves them with the system
+	// resolver.
+	Resolver Resolver
+	// Proxy selects the proxy requests are sent through.
+	Proxy ProxyOptions
+	// HTTPSOnly refuses requests over plaintext HTTP.
+	HTTPSOnly HTTPSOnlyOptions
+	// MaxRequestsPerSecond, if set, limits the rate of requests sent through the Transport, retries and requests to
+	// cache hosts included, for origins which throttle by request rate rather than bandwidth.
+	MaxRequestsPerSecond float64
 }
 
 // NewHTTPClient factory function returns a new http.Client with the appropriate settings and can limit number of clients
@@ -58,50 +83,36 @@ type TransportOptions struct {
 func NewHTTPClient(opts Options) HTTPClient {
 
 	transport := opts.Transport
-
 	if transport == nil {
-		topts := opts.TransportOpts
-		dialer := &transportDialer{
-			DNSOverrideMap: topts.ResolveOverrides,
-			Dialer: &net.Dialer{
-				Timeout:   topts.ConnectTimeout,
-				KeepAlive: 30 * time.Second,
-			},
-		}
-
-		disableKeepAlives := topts.ForceHTTP2
-		transport = &http.Transport{
-			Proxy:                 http.ProxyFromEnvironment,
-			DialContext:           dialer.DialContext,
-			ForceAttemptHTTP2:     topts.ForceHTTP2,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   5 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			DisableKeepAlives:     disableKeepAlives,
-			MaxConnsPerHost:       topts.MaxConnPerHost,
-			MaxIdleConnsPerHost:   topts.MaxConnPerHost,
-		}
+		transport = NewTransport(opts.TransportOpts)
 	}
 
 	retryClient := &retryablehttp.Client{
 		HTTPClient: &http.Client{
-			Transport:     transport,
-			CheckRedirect: checkRedirectFunc,
+			Transport:     authorizingTransport{preconditionTransport{transport}},
+			CheckRedirect: opts.Redirects.checkRedirect,
 		},
 		Logger:       nil,
 		RetryWaitMin: retryMinWait,
 		RetryWaitMax: retryMaxWait,
 		RetryMax:     opts.MaxRetries,
 		CheckRetry:   RetryPolicy,
-		Backoff:      linearJitterRetryAfterBackoff,
+		Backoff:      linearJitterRetryAfterBackoff(opts.maxRetryAfter()),
 	}
 
 	client := retryClient.StandardClient()
 	return &PGetHTTPClient{Client: client}
 }
 
-// RetryPolicy wraps retryablehttp.DefaultRetryPolicy and included additional logic:
+func (o Options) maxRetryAfter() time.Duration {
+	if o.MaxRetryAfter <= 0 {
+		return defaultMaxRetryAfter
+	}
+	return o.MaxRetryAfter
+}
+
+// RetryPolicy wraps retryablehttp.DefaultRetryPolicy and included additional logic for requests executed with
+// Request.StrategyFallback set:
 // - checks for specific errors that indicate a fall-back to the next download strategy
 // - checks for http.StatusBadGateway and http.StatusServiceUnavailable which also indicate a fall-back
 func RetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, error) {
@@ -112,12 +123,15 @@ func RetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, err
 		return false, ctx.Err()
 	}
 
-	// While type assertions are not ideal, alternatives are limited to adding custom data in the request
-	// or in the context. The context clearly isolates this data.
-	consistentHashing, ok := ctx.Value(config.ConsistentHashingStrategyKey).(bool)
-	if ok && consistentHashing {
+	// a redirect refused by RedirectOptions, or a request refused by HTTPSOnlyOptions, would be refused again
+	if errors.Is(err, ErrTooManyRedirects) || errors.Is(err, ErrRedirectNotAllowed) || errors.Is(err, ErrPlaintextNotAllowed) {
+		return false, err
+	}
+
+	// The per-request policy is set by Do; retryablehttp only hands us the context.
+	if strategyFallbackEnabled(ctx) {
 		if fallbackError(err) {
-			return false, ErrStrategyFallback
+			return false, fmt.Errorf("%w: %w", ErrStrategyFallback, ErrCacheUnreachable)
 		}
 		if err == nil && (resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable) {
 			return false, ErrStrategyFallback
@@ -125,7 +139,11 @@ func RetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, err
 	}
 
 	// Wrap the standard retry policy
-	return retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	retry, err := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	if retry {
+		countRetry(ctx)
+	}
+	return retry, err
 }
 
 // fallbackError returns true if the error is an error we should fall back to the next strategy.
@@ -160,55 +178,9 @@ func fallbackError(err error) bool {
 	return false
 }
 
-// linearJitterRetryAfterBackoff wraps retryablehttp.LinearJitterBackoff but also will adhere to Retry-After responses
-func linearJitterRetryAfterBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
-	var retryAfter time.Duration
-
-	if shouldApplyRetryAfter(resp) {
-		retryAfter = evaluateRetryAfter(resp)
-	}
-
-	if retryAfter > 0 {
-		// If the Retry-After header is set, treat this as attempt 0 to get just the jitter
-		jitter := max - min
-		return retryablehttp.LinearJitterBackoff(retryAfter, retryAfter+jitter, 0, resp)
-	}
-
-	return retryAfter + retryablehttp.LinearJitterBackoff(min, max, attemptNum, resp)
-}
-
-func evaluateRetryAfter(resp *http.Response) time.Duration {
-	retryAfter := resp.Header.Get("Retry-After")
-	if retryAfter != "" {
-		return 0
-	}
-
-	duration, err := strconv.ParseInt(retryAfter, 10, 64)
-	if err != nil {
-		return 0
-	}
-
-	return time.Second * time.Duration(duration)
-}
-
-func shouldApplyRetryAfter(resp *http.Response) bool {
-	return resp != nil && resp.StatusCode == http.StatusTooManyRequests
-}
-
-// checkRedirectFunc is a wrapper around http.Client.CheckRedirect that allows for printing out redirects
-func checkRedirectFunc(req *http.Request, via []*http.Request) error {
-	logger := logging.GetLogger()
-
-	logger.Trace().
-		Str("redirect_url", req.URL.String()).
-		Str("url", via[0].URL.String()).
-		Int("status", req.Response.StatusCode).
-		Msg("Redirect")
-	return nil
-}
-
 type transportDialer struct {
 	DNSOverrideMap map[string]string
+	Resolver       Resolver
 	Dialer         *net.Dialer
 }
 
@@ -218,5 +190,33 @@ func (d *transportDialer) DialContext(ctx context.Context, network, addr string)
 		logger.Debug().Str("addr", addr).Str("override", addrOverride).Msg("DNS Override")
 		addr = addrOverride
 	}
-	return d.Dialer.DialContext(ctx, network, addr)
+	if d.Resolver == nil {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+	ips, err := d.Resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	// try the addresses in turn, as net.Dialer does with those it resolves itself
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = d.Dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return nil, err
 }
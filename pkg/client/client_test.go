@@ -2,16 +2,22 @@ package client_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
-	"github.com/replicate/pget/pkg/client"
-	"github.com/replicate/pget/pkg/config"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/config"
 )
 
 func TestRetryPolicy(t *testing.T) {
@@ -153,10 +159,215 @@ func TestRetryPolicy(t *testing.T) {
 			actualResult, actualError := client.RetryPolicy(tc.ctx, tc.resp, tc.err)
 			assert.Equal(t, tc.expectedResult, actualResult)
 			if tc.expectedError != nil {
-				assert.Equal(t, tc.expectedError, actualError)
+				assert.ErrorIs(t, actualError, tc.expectedError)
+				if tc.err != nil && errors.Is(tc.expectedError, client.ErrStrategyFallback) {
+					assert.ErrorIs(t, actualError, client.ErrCacheUnreachable)
+				}
 			} else {
 				assert.NoError(t, actualError)
 			}
 		})
 	}
 }
+
+func TestDoStrategyFallback(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	httpClient := client.NewHTTPClient(client.Options{MaxRetries: 0})
+
+	tc := []struct {
+		name             string
+		strategyFallback bool
+	}{
+		{"fallback", true},
+		{"no fallback", false},
+	}
+
+	for _, tc := range tc {
+		t.Run(tc.name, func(t *testing.T) {
+			requests.Store(0)
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+			_, err = client.Do(httpClient, &client.Request{Request: req, StrategyFallback: tc.strategyFallback})
+			require.Error(t, err)
+			if tc.strategyFallback {
+				assert.ErrorIs(t, err, client.ErrStrategyFallback)
+			} else {
+				assert.NotErrorIs(t, err, client.ErrStrategyFallback)
+			}
+			assert.Equal(t, int32(1), requests.Load())
+		})
+	}
+}
+
+type fakeResolver struct {
+	hosts   map[string][]string
+	srvs    map[string][]*net.SRV
+	txts    map[string][]string
+	lookups atomic.Int32
+}
+
+func (r *fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	r.lookups.Add(1)
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.lookups.Add(1)
+	if srvs, ok := r.srvs[name]; ok {
+		return name, srvs, nil
+	}
+	return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	r.lookups.Add(1)
+	if txts, ok := r.txts[name]; ok {
+		return txts, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestTransportResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// nothing listens on the first address, so the dialer must move on to the second
+	resolver := &fakeResolver{hosts: map[string][]string{"example.test": {"127.0.0.2", "127.0.0.1"}}}
+	httpClient := client.NewHTTPClient(client.Options{
+		TransportOpts: client.TransportOptions{Resolver: resolver},
+	})
+
+	resp, err := httpClient.Do(mustRequest(t, fmt.Sprintf("http://example.test:%s/", serverURL.Port())))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "example.test:"+serverURL.Port(), string(body))
+
+	_, err = httpClient.Do(mustRequest(t, "http://unknown.test/"))
+	var dnsErr *net.DNSError
+	assert.ErrorAs(t, err, &dnsErr)
+}
+
+func mustRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	return req
+}
+
+func TestCachingResolver(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeResolver{
+		hosts: map[string][]string{"example.test": {"127.0.0.1"}},
+		srvs:  map[string][]*net.SRV{"cache.test": {{Target: "cache-0.cache.test.", Port: 80}}},
+		txts:  map[string][]string{"_http._tcp.cache.test": {"slice-size=500MiB"}},
+	}
+
+	resolver := &client.CachingResolver{Resolver: fake, TTL: time.Hour}
+	for i := 0; i < 3; i++ {
+		addrs, err := resolver.LookupHost(ctx, "example.test")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"127.0.0.1"}, addrs)
+		_, srvs, err := resolver.LookupSRV(ctx, "http", "tcp", "cache.test")
+		require.NoError(t, err)
+		assert.Len(t, srvs, 1)
+		txts, err := resolver.LookupTXT(ctx, "_http._tcp.cache.test")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"slice-size=500MiB"}, txts)
+	}
+	assert.Equal(t, int32(3), fake.lookups.Load())
+
+	// failures are not cached
+	fake.lookups.Store(0)
+	for i := 0; i < 2; i++ {
+		_, err := resolver.LookupHost(ctx, "unknown.test")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(2), fake.lookups.Load())
+
+	// expired responses are looked up again
+	resolver = &client.CachingResolver{Resolver: fake, TTL: 0}
+	fake.lookups.Store(0)
+	for i := 0; i < 2; i++ {
+		_, err := resolver.LookupHost(ctx, "example.test")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), fake.lookups.Load())
+}
+
+func TestWithRetryCounter(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var retries atomic.Int64
+	ctx := client.WithRetryCounter(context.Background(), &retries)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.NewHTTPClient(client.Options{MaxRetries: 2}).Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(1), retries.Load())
+}
+
+func TestWithIfMatch(t *testing.T) {
+	var requests atomic.Int32
+	var ifMatch []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, server.URL+"/object", http.StatusFound)
+			return
+		}
+		if r.Header.Get("If-Match") != `"v1"` {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	c := client.NewHTTPClient(client.Options{MaxRetries: 2})
+
+	ctx := client.WithIfMatch(context.Background(), `"v1"`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/redirect", nil)
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{`"v1"`, `"v1"`}, ifMatch)
+
+	// a failed precondition is not retried
+	requests.Store(0)
+	ctx = client.WithIfMatch(context.Background(), `"v2"`)
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/object", nil)
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, int32(1), requests.Load())
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fileTransport serves file:// URLs from the local file system as a file server would, answering range requests
+// with 206 Partial Content, so that local files (e.g. on an NFS mount) are read in parallel chunks like any other
+// download. A missing file is 404 Not Found, and a directory or an unreadable file 403 Forbidden.
+type fileTransport struct{}
+
+var _ http.RoundTripper = fileTransport{}
+
+func (fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if req.URL.Host != "" && req.URL.Host != "localhost" {
+		return nil, fmt.Errorf("file URL %s names host %s, only local files are supported", req.URL.Redacted(), req.URL.Host)
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return fileResponse(req, http.StatusMethodNotAllowed), nil
+	}
+	f, err := os.Open(filepath.FromSlash(req.URL.Path))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fileResponse(req, http.StatusNotFound), nil
+	case errors.Is(err, fs.ErrPermission):
+		return fileResponse(req, http.StatusForbidden), nil
+	case err != nil:
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return fileResponse(req, http.StatusForbidden), nil
+	}
+
+	size := info.Size()
+	resp := fileResponse(req, http.StatusOK)
+	resp.Header.Set("Accept-Ranges", "bytes")
+	resp.Header.Set("Content-Type", "application/octet-stream")
+	resp.Header.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	start, end := int64(0), size-1
+	if ranged, ok := parseRange(req.Header.Get("Range"), size); ok {
+		if ranged[0] >= size {
+			f.Close()
+			resp = fileResponse(req, http.StatusRequestedRangeNotSatisfiable)
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return resp, nil
+		}
+		start, end = ranged[0], ranged[1]
+		resp.StatusCode, resp.Status = http.StatusPartialContent, statusLine(http.StatusPartialContent)
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+	resp.ContentLength = end - start + 1
+	resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	if req.Method == http.MethodHead {
+		f.Close()
+		return resp, nil
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, start, resp.ContentLength), f}
+	return resp, nil
+}
+
+func fileResponse(req *http.Request, status int) *http.Response {
+	return &http.Response{
+		Status:     statusLine(status),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+func statusLine(status int) string {
+	return fmt.Sprintf("%d %s", status, http.StatusText(status))
+}
+
+// parseRange returns the first and last byte of the single range requested by header for content of size bytes,
+// with the last byte capped at the end of the content, and false if no range, or several, are requested, in which
+// case the whole content is served.
+func parseRange(header string, size int64) ([2]int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return [2]int64{}, false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return [2]int64{}, false
+	}
+	if first == "" {
+		// the last bytes of the content
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return [2]int64{}, false
+		}
+		return [2]int64{max(size-suffix, 0), size - 1}, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return [2]int64{}, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return [2]int64{}, false
+		}
+		end = min(end, size-1)
+	}
+	return [2]int64{start, end}, true
+}
@@ -0,0 +1,87 @@
+package client_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func fileURL(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func TestFileURLs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.bin")
+	require.NoError(t, os.WriteFile(path, []byte("0123456789"), 0644))
+	c := client.NewHTTPClient(client.Options{
+		MaxRetries:    0,
+		TransportOpts: client.TransportOptions{HTTPSOnly: client.HTTPSOnlyOptions{Enabled: true}},
+	})
+
+	testCases := []struct {
+		name         string
+		url          string
+		method       string
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"whole file", fileURL(path), http.MethodGet, "", http.StatusOK, "0123456789", ""},
+		{"range", fileURL(path), http.MethodGet, "bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"open range", fileURL(path), http.MethodGet, "bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"suffix range", fileURL(path), http.MethodGet, "bytes=-2", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"range past the end", fileURL(path), http.MethodGet, "bytes=8-100", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"unsatisfiable range", fileURL(path), http.MethodGet, "bytes=10-20", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"head", fileURL(path), http.MethodHead, "bytes=0-3", http.StatusPartialContent, "", "bytes 0-3/10"},
+		{"missing file", fileURL(filepath.Join(dir, "missing")), http.MethodGet, "", http.StatusNotFound, "", ""},
+		{"directory", fileURL(dir), http.MethodGet, "", http.StatusForbidden, "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.url, nil)
+			require.NoError(t, err)
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			resp, err := c.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tc.status, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.body, string(body))
+			assert.Equal(t, tc.contentRange, resp.Header.Get("Content-Range"))
+		})
+	}
+
+	// remote files can't be read
+	req, err := http.NewRequest(http.MethodGet, "file://example.com/etc/hosts", nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	assert.Error(t, err)
+}
+
+func TestRedirectToFileURLRefused(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(path, []byte("secret"), 0644))
+	origin := httptest.NewServer(http.RedirectHandler(fileURL(path), http.StatusFound))
+	t.Cleanup(origin.Close)
+
+	c := client.NewHTTPClient(client.Options{})
+	req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	assert.ErrorIs(t, err, client.ErrRedirectNotAllowed)
+}
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrPlaintextNotAllowed is returned for a request over plaintext HTTP when HTTPSOnlyOptions.Enabled is set and its
+// host is not in HTTPSOnlyOptions.AllowedHosts.
+var ErrPlaintextNotAllowed = errors.New("plaintext HTTP not allowed")
+
+// HTTPSOnlyOptions refuses requests over plaintext HTTP. It is enforced by Transport, so it applies to origins,
+// redirects, mirrors and cache hosts alike.
+type HTTPSOnlyOptions struct {
+	Enabled bool
+	// AllowedHosts are the hosts which may still be contacted over plaintext HTTP, such as cache hosts on a trusted
+	// network. An entry starting with "*." matches the subdomains of the rest, as in RedirectOptions.AllowedHosts.
+	AllowedHosts []string
+}
+
+// AllowsPlaintext reports whether host, which may include a port, may be contacted over plaintext HTTP.
+func (o HTTPSOnlyOptions) AllowsPlaintext(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return !o.Enabled || matchHost(o.AllowedHosts, host)
+}
+
+// check returns an error wrapping ErrPlaintextNotAllowed if req may not be sent.
+func (o HTTPSOnlyOptions) check(req *http.Request) error {
+	if req.URL.Scheme == "https" || o.AllowsPlaintext(req.URL.Host) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s (--https-only)", ErrPlaintextNotAllowed, req.URL.Redacted())
+}
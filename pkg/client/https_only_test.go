@@ -0,0 +1,55 @@
+package client_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func TestHTTPSOnly(t *testing.T) {
+	// the origin, 127.0.0.1, redirects to localhost
+	origin, _ := redirectServers(t)
+	testCases := []struct {
+		name      string
+		httpsOnly client.HTTPSOnlyOptions
+		err       error
+	}{
+		{"disabled", client.HTTPSOnlyOptions{}, nil},
+		{"plaintext refused", client.HTTPSOnlyOptions{Enabled: true}, client.ErrPlaintextNotAllowed},
+		{"redirect refused", client.HTTPSOnlyOptions{Enabled: true, AllowedHosts: []string{"127.0.0.1"}}, client.ErrPlaintextNotAllowed},
+		{"allowed hosts", client.HTTPSOnlyOptions{Enabled: true, AllowedHosts: []string{"127.0.0.1", "localhost"}}, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := client.NewHTTPClient(client.Options{
+				MaxRetries:    2,
+				TransportOpts: client.TransportOptions{HTTPSOnly: tc.httpsOnly},
+			})
+			req, err := http.NewRequest("GET", origin.URL, nil)
+			require.NoError(t, err)
+			resp, err := c.Do(req)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			_, err = io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestHTTPSOnlyAllowsPlaintext(t *testing.T) {
+	opts := client.HTTPSOnlyOptions{Enabled: true, AllowedHosts: []string{"*.cache.internal"}}
+	assert.True(t, opts.AllowsPlaintext("cache-0.cache.internal:8080"))
+	assert.True(t, opts.AllowsPlaintext("cache-1.cache.internal"))
+	assert.False(t, opts.AllowsPlaintext("cache.internal"))
+	assert.False(t, opts.AllowsPlaintext("example.com:80"))
+	assert.True(t, client.HTTPSOnlyOptions{}.AllowsPlaintext("example.com"))
+}
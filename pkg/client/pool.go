@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Transport is the http.RoundTripper NewHTTPClient builds from TransportOptions. Clients sharing a Transport (see
+// Options.Transport) share its connection pool, so TransportOptions.MaxConnPerHost limits their combined connections
+// to each host, and connections opened for one client are reused by the others.
+type Transport struct {
+	transport *http.Transport
+	httpsOnly HTTPSOnlyOptions
+	// limiter, if set, limits the rate of requests across every client sharing the Transport
+	limiter *rate.Limiter
+
+	mu    sync.Mutex
+	pools map[string]*poolCounters
+}
+
+var _ http.RoundTripper = &Transport{}
+
+// PoolStats describes the connections of a Transport to one host.
+type PoolStats struct {
+	// Open is the number of connections currently open, whether in use or idle
+	Open int64
+	// Dialed is the number of connections opened so far
+	Dialed int64
+	// Requests is the number of requests sent, and Reused the number of those sent over a connection which had
+	// already been used
+	Requests int64
+	Reused   int64
+}
+
+type poolCounters struct {
+	open, dialed, requests, reused atomic.Int64
+}
+
+// NewTransport returns a Transport for opts.
+func NewTransport(opts TransportOptions) *Transport {
+	t := &Transport{pools: make(map[string]*poolCounters), httpsOnly: opts.HTTPSOnly}
+	if opts.MaxRequestsPerSecond > 0 {
+		// a burst of a second's worth of requests, so that a wave of chunks starts together
+		t.limiter = rate.NewLimiter(rate.Limit(opts.MaxRequestsPerSecond), max(int(math.Ceil(opts.MaxRequestsPerSecond)), 1))
+	}
+	dialer := &transportDialer{
+		DNSOverrideMap: opts.ResolveOverrides,
+		Resolver:       opts.Resolver,
+		Dialer: &net.Dialer{
+			Timeout:   opts.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		},
+	}
+
+	disableKeepAlives := opts.ForceHTTP2
+	t.transport = &http.Transport{
+		Proxy:                 opts.Proxy.Proxy,
+		DialContext:           t.dialContext(dialer),
+		ForceAttemptHTTP2:     opts.ForceHTTP2,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     disableKeepAlives,
+		MaxConnsPerHost:       opts.MaxConnPerHost,
+		MaxIdleConnsPerHost:   opts.MaxConnPerHost,
+	}
+	return t
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "file" {
+		// local files are read without the network, so none of its limits apply
+		return fileTransport{}.RoundTrip(req)
+	}
+	if err := t.httpsOnly.check(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	if t.limiter != nil {
+		if err := t.limiter.Wait(req.Context()); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	pool := t.pool(hostPort(req.URL))
+	pool.requests.Add(1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				pool.reused.Add(1)
+			}
+		},
+	}
+	return t.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// CloseIdleConnections closes the connections which are not in use.
+func (t *Transport) CloseIdleConnections() {
+	t.transport.CloseIdleConnections()
+}
+
+// Stats returns the PoolStats of each host connected to, by host:port. Connections through a proxy are counted
+// against the proxy, and the requests sent through them against their destination.
+func (t *Transport) Stats() map[string]PoolStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make(map[string]PoolStats, len(t.pools))
+	for host, pool := range t.pools {
+		stats[host] = PoolStats{
+			Open:     pool.open.Load(),
+			Dialed:   pool.dialed.Load(),
+			Requests: pool.requests.Load(),
+			Reused:   pool.reused.Load(),
+		}
+	}
+	return stats
+}
+
+func (t *Transport) pool(host string) *poolCounters {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pool, ok := t.pools[host]
+	if !ok {
+		pool = &poolCounters{}
+		t.pools[host] = pool
+	}
+	return pool
+}
+
+// dialContext counts the connections dialer opens and closes.
+func (t *Transport) dialContext(dialer *transportDialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		pool := t.pool(addr)
+		pool.dialed.Add(1)
+		pool.open.Add(1)
+		return &countedConn{Conn: conn, pool: pool}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	pool *poolCounters
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.pool.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+// hostPort returns the host:port requests to u are sent to, as it is passed to the dialer.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,85 @@
+package client_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func TestSharedTransport(t *testing.T) {
+	var conns atomic.Int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	// clients built from the same options, as each download strategy does, share the transport's limit and pool
+	transport := client.NewTransport(client.TransportOptions{MaxConnPerHost: 1})
+	opts := client.Options{Transport: transport}
+	clients := []client.HTTPClient{client.NewHTTPClient(opts), client.NewHTTPClient(opts)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := clients[i%2].Do(mustRequest(t, ts.URL))
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), conns.Load())
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	stats := transport.Stats()
+	require.Contains(t, stats, u.Host)
+	assert.Equal(t, client.PoolStats{Open: 1, Dialed: 1, Requests: 6, Reused: 5}, stats[u.Host])
+
+	transport.CloseIdleConnections()
+	assert.Equal(t, int64(0), transport.Stats()[u.Host].Open)
+}
+
+func TestTransportMaxRequestsPerSecond(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	c := client.NewHTTPClient(client.Options{TransportOpts: client.TransportOptions{MaxRequestsPerSecond: 50}})
+	start := time.Now()
+	var wg sync.WaitGroup
+	// a burst of 50 requests, then 10 more at 50 per second
+	for range 60 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Do(mustRequest(t, ts.URL))
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
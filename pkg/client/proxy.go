@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProxyOptions selects the proxy requests are sent through.
+type ProxyOptions struct {
+	// URL is the proxy, with the scheme http, https, socks5 or socks5h. Hostnames are resolved by a SOCKS5 proxy
+	// under either scheme. If nil, the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables.
+	URL *url.URL
+	// NoProxy are the hosts requests to which bypass the proxy, in addition to those in NO_PROXY when the proxy is
+	// taken from the environment. An entry is "*" (every host), an IP address or CIDR range, or a domain, which
+	// matches its subdomains too (a leading "." or "*." is ignored). A domain or IP address may be followed by a
+	// port, in which case only that port bypasses the proxy.
+	NoProxy []string
+}
+
+// ParseProxyURL parses the value of --proxy.
+func ParseProxyURL(value string) (*url.URL, error) {
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", value, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q, expected the scheme http, https, socks5 or socks5h", value)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q, no host", value)
+	}
+	return proxyURL, nil
+}
+
+// Proxy returns the proxy for req, or nil if it is to be sent directly. It is used as http.Transport.Proxy.
+func (o ProxyOptions) Proxy(req *http.Request) (*url.URL, error) {
+	if o.bypass(req.URL) {
+		return nil, nil
+	}
+	if o.URL != nil {
+		return o.URL, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+func (o ProxyOptions) bypass(reqURL *url.URL) bool {
+	host := strings.ToLower(reqURL.Hostname())
+	port := reqURL.Port()
+	if port == "" {
+		port = "80"
+		if reqURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+	for _, entry := range o.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost = strings.TrimPrefix(strings.TrimPrefix(entryHost, "*"), ".")
+		if entryHost != "" && (host == entryHost || strings.HasSuffix(host, "."+entryHost)) {
+			return true
+		}
+	}
+	return false
+}
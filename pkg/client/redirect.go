@@ -0,0 +1,124 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+const defaultMaxRedirects = 10
+
+var (
+	// ErrTooManyRedirects is returned when a request is redirected more than RedirectOptions.Max times.
+	ErrTooManyRedirects = errors.New("too many redirects")
+	// ErrRedirectNotAllowed is returned when a request is redirected to a host which is not in
+	// RedirectOptions.AllowedHosts.
+	ErrRedirectNotAllowed = errors.New("redirect not allowed")
+)
+
+// RedirectAuth decides whether the Authorization header of a request is sent on when it is redirected.
+type RedirectAuth string
+
+const (
+	// RedirectAuthSameDomain forwards it to the same host or its subdomains, as net/http does. This is the default.
+	RedirectAuthSameDomain RedirectAuth = "same-domain"
+	// RedirectAuthSameHost only forwards it to the same host.
+	RedirectAuthSameHost RedirectAuth = "same-host"
+	// RedirectAuthNever never forwards it, even to the same host.
+	RedirectAuthNever RedirectAuth = "never"
+	// RedirectAuthAlways forwards it to any host, such as a CDN the origin redirects to with a pre-authorized URL
+	// which still checks the header.
+	RedirectAuthAlways RedirectAuth = "always"
+)
+
+// ParseRedirectAuth parses the value of --redirect-auth. An empty value selects RedirectAuthSameDomain.
+func ParseRedirectAuth(value string) (RedirectAuth, error) {
+	switch policy := RedirectAuth(value); policy {
+	case "":
+		return RedirectAuthSameDomain, nil
+	case RedirectAuthSameDomain, RedirectAuthSameHost, RedirectAuthNever, RedirectAuthAlways:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown --redirect-auth value %q, expected same-domain, same-host, never or always", value)
+}
+
+// RedirectOptions limits the redirects a request follows.
+type RedirectOptions struct {
+	// Max is the number of redirects followed before failing with ErrTooManyRedirects. If set to zero, 10 will be
+	// used.
+	Max int
+	// AllowedHosts, if set, are the only hosts a request may be redirected to, other than its own. An entry
+	// starting with "*." matches the subdomains of the rest, e.g. *.example.com matches cdn.example.com.
+	AllowedHosts []string
+	// Auth decides whether the Authorization header is forwarded. If empty, RedirectAuthSameDomain is used.
+	Auth RedirectAuth
+}
+
+func (o RedirectOptions) max() int {
+	if o.Max <= 0 {
+		return defaultMaxRedirects
+	}
+	return o.Max
+}
+
+func (o RedirectOptions) allowed(host string) bool {
+	return len(o.AllowedHosts) == 0 || matchHost(o.AllowedHosts, host)
+}
+
+// matchHost reports whether host is one of patterns, an entry starting with "*." matching the subdomains of the rest.
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRedirect is the http.Client.CheckRedirect function applying the options. It logs each redirect.
+func (o RedirectOptions) checkRedirect(req *http.Request, via []*http.Request) error {
+	logger := logging.GetLogger()
+	original := via[0]
+
+	logger.Trace().
+		Str("redirect_url", req.URL.String()).
+		Str("url", original.URL.String()).
+		Int("status", req.Response.StatusCode).
+		Msg("Redirect")
+
+	if len(via) > o.max() {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrTooManyRedirects, o.max())
+	}
+	if req.URL.Scheme == "file" {
+		// a server must not get local files read in its name
+		return fmt.Errorf("%w: %s redirected to local file %s", ErrRedirectNotAllowed, original.URL.String(), req.URL.String())
+	}
+	host := req.URL.Hostname()
+	if host != original.URL.Hostname() && !o.allowed(host) {
+		return fmt.Errorf("%w: %s redirected to host %s", ErrRedirectNotAllowed, original.URL.String(), host)
+	}
+
+	// net/http has already dropped the header if the host is not the same domain
+	auth := original.Header.Get("Authorization")
+	if auth == "" {
+		return nil
+	}
+	switch o.Auth {
+	case RedirectAuthSameHost:
+		if req.URL.Host != original.URL.Host {
+			req.Header.Del("Authorization")
+		}
+	case RedirectAuthNever:
+		req.Header.Del("Authorization")
+	case RedirectAuthAlways:
+		req.Header.Set("Authorization", auth)
+	}
+	return nil
+}
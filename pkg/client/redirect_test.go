@@ -0,0 +1,89 @@
+package client_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func TestMaxRedirects(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	}))
+	defer server.Close()
+
+	c := client.NewHTTPClient(client.Options{MaxRetries: 2, Redirects: client.RedirectOptions{Max: 3}})
+	req, err := http.NewRequest("GET", server.URL, nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	assert.ErrorIs(t, err, client.ErrTooManyRedirects)
+	// the request and 3 redirects, not retried
+	assert.Equal(t, int64(4), requests.Load())
+}
+
+// redirectServers returns an origin which redirects to target, which responds with the Authorization header it
+// received. The two have different hostnames.
+func redirectServers(t *testing.T) (origin, target *httptest.Server) {
+	target = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	t.Cleanup(target.Close)
+	targetURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, targetURL+r.URL.Path, http.StatusFound)
+	}))
+	t.Cleanup(origin.Close)
+	return origin, target
+}
+
+func TestRedirectPolicies(t *testing.T) {
+	origin, _ := redirectServers(t)
+	testCases := []struct {
+		name      string
+		redirects client.RedirectOptions
+		auth      string
+		err       error
+	}{
+		{"default strips cross-host auth", client.RedirectOptions{}, "", nil},
+		{"always forwards auth", client.RedirectOptions{Auth: client.RedirectAuthAlways}, "Bearer token", nil},
+		{"allowed host", client.RedirectOptions{AllowedHosts: []string{"localhost"}}, "", nil},
+		{"allowed subdomain", client.RedirectOptions{AllowedHosts: []string{"*.localhost"}}, "", client.ErrRedirectNotAllowed},
+		{"host not allowed", client.RedirectOptions{AllowedHosts: []string{"example.com"}}, "", client.ErrRedirectNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := client.NewHTTPClient(client.Options{MaxRetries: 2, Redirects: tc.redirects})
+			req, err := http.NewRequest("GET", origin.URL, nil)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer token")
+			resp, err := c.Do(req)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.auth, string(body))
+		})
+	}
+}
+
+func TestParseRedirectAuth(t *testing.T) {
+	policy, err := client.ParseRedirectAuth("")
+	require.NoError(t, err)
+	assert.Equal(t, client.RedirectAuthSameDomain, policy)
+	_, err = client.ParseRedirectAuth("sometimes")
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/replicate/pget/v2/pkg/config"
+)
+
+// Request wraps an http.Request with the pget-specific policy to apply when executing it.
+type Request struct {
+	*http.Request
+
+	// StrategyFallback makes errors that indicate a fundamental problem with the server or the network to it
+	// (connection errors, timeouts, 502 and 503 responses) fail fast with ErrStrategyFallback instead of being
+	// retried, so that the caller can fall back to the next download strategy.
+	StrategyFallback bool
+}
+
+type requestPolicyKey struct{}
+
+type requestPolicy struct {
+	strategyFallback bool
+}
+
+// Do executes req with c, applying the request's policy. Any HTTPClient may be used; the policy is only
+// honored by clients built with NewHTTPClient.
+func Do(c HTTPClient, req *Request) (*http.Response, error) {
+	ctx := context.WithValue(req.Context(), requestPolicyKey{}, requestPolicy{strategyFallback: req.StrategyFallback})
+	return c.Do(req.Request.WithContext(ctx))
+}
+
+func strategyFallbackEnabled(ctx context.Context) bool {
+	if policy, ok := ctx.Value(requestPolicyKey{}).(requestPolicy); ok {
+		return policy.strategyFallback
+	}
+	// Callers predating Request set the policy with a context key; keep honoring it.
+	consistentHashing, ok := ctx.Value(config.ConsistentHashingStrategyKey).(bool)
+	return ok && consistentHashing
+}
+
+type retryCounterKey struct{}
+
+// WithRetryCounter returns a context which has the requests executed with it, by clients built with NewHTTPClient,
+// add the number of times they are retried to counter.
+func WithRetryCounter(ctx context.Context, counter *atomic.Int64) context.Context {
+	return context.WithValue(ctx, retryCounterKey{}, counter)
+}
+
+func countRetry(ctx context.Context) {
+	if counter, ok := ctx.Value(retryCounterKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+}
+
+type authorizationKey struct{}
+
+type authorization struct {
+	host  string
+	value string
+}
+
+// WithAuthorization returns a context which has the requests executed with it, by clients built with
+// NewHTTPClient, send value as their Authorization header if they are to host (host[:port]). The header is added to
+// each request as it is sent, rather than to the request executed, so redirects to other hosts never carry it,
+// whatever RedirectOptions.Auth.
+func WithAuthorization(ctx context.Context, host, value string) context.Context {
+	return context.WithValue(ctx, authorizationKey{}, authorization{host: host, value: value})
+}
+
+// authorizingTransport adds the Authorization header of WithAuthorization to the requests it sends.
+type authorizingTransport struct {
+	http.RoundTripper
+}
+
+func (t authorizingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if auth, ok := req.Context().Value(authorizationKey{}).(authorization); ok && req.URL.Host == auth.host && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", auth.value)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+type ifMatchKey struct{}
+
+// WithIfMatch returns a context which has the GET and HEAD requests executed with it, by clients built with
+// NewHTTPClient, send etag (a quoted entity tag) as their If-Match header, at every hop. A server holding another
+// version of the object then answers 412 Precondition Failed, which is not retried, instead of serving a range of it.
+func WithIfMatch(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, etag)
+}
+
+// preconditionTransport adds the If-Match header of WithIfMatch to the requests it sends.
+type preconditionTransport struct {
+	http.RoundTripper
+}
+
+func (t preconditionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if etag, ok := req.Context().Value(ifMatchKey{}).(string); ok && (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Header.Get("If-Match") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-Match", etag)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
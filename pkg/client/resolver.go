@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Resolver looks up the addresses of hosts, and the SRV and TXT records of cache host discovery. *net.Resolver
+// implements it; tests inject fakes.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+var _ Resolver = &net.Resolver{}
+
+// NewResolver returns a resolver which sends its queries to server (host or host:port, port 53 if omitted), or the
+// system resolver if server is empty.
+func NewResolver(server string) Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// CachingResolver caches the successful responses of Resolver for TTL, so that the cache hosts and origins of a run
+// are looked up once rather than for every connection. Go's resolver doesn't expose the TTLs of the records it
+// returns, so TTL should not exceed those of the records served; failed lookups are not cached.
+type CachingResolver struct {
+	Resolver Resolver
+	TTL      time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]cachedHosts
+	srvs  map[string]cachedSRV
+	txts  map[string]cachedTXT
+}
+
+type cachedHosts struct {
+	addrs   []string
+	expires time.Time
+}
+
+type cachedTXT struct {
+	txts    []string
+	expires time.Time
+}
+
+type cachedSRV struct {
+	cname   string
+	srvs    []*net.SRV
+	expires time.Time
+}
+
+var _ Resolver = &CachingResolver{}
+
+func (r *CachingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	cached, ok := r.hosts[host]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.addrs, nil
+	}
+	addrs, err := r.Resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hosts == nil {
+		r.hosts = make(map[string]cachedHosts)
+	}
+	r.hosts[host] = cachedHosts{addrs: addrs, expires: time.Now().Add(r.TTL)}
+	return addrs, nil
+}
+
+func (r *CachingResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	key := fmt.Sprintf("_%s._%s.%s", service, proto, name)
+	r.mu.Lock()
+	cached, ok := r.srvs[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.cname, cached.srvs, nil
+	}
+	cname, srvs, err := r.Resolver.LookupSRV(ctx, service, proto, name)
+	if err != nil {
+		return "", nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.srvs == nil {
+		r.srvs = make(map[string]cachedSRV)
+	}
+	r.srvs[key] = cachedSRV{cname: cname, srvs: srvs, expires: time.Now().Add(r.TTL)}
+	return cname, srvs, nil
+}
+
+func (r *CachingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.mu.Lock()
+	cached, ok := r.txts[name]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.txts, nil
+	}
+	txts, err := r.Resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.txts == nil {
+		r.txts = make(map[string]cachedTXT)
+	}
+	r.txts[name] = cachedTXT{txts: txts, expires: time.Now().Add(r.TTL)}
+	return txts, nil
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// SignedURLRefresher returns a freshly signed URL for a download whose signed URL has expired.
+type SignedURLRefresher func(ctx context.Context) (string, error)
+
+type signedURLKey struct{}
+
+// signedURL tracks the signed URL of a download as it is refreshed.
+type signedURL struct {
+	refresh SignedURLRefresher
+
+	mu sync.Mutex
+	// current is the latest signed URL, nil until the first refresh
+	current *url.URL
+	// generation counts the refreshes, so that concurrent requests refused with the same signature refresh it once
+	generation int
+	// expired are the URLs refused as expired: earlier signed URLs, and the URLs they redirected to
+	expired map[string]bool
+}
+
+// WithSignedURLRefresh returns a context which has the GET and HEAD requests executed with it, by clients built with
+// NewHTTPClient, refresh the signed URL of a download with refresh when they are refused as if their signature had
+// expired (400, 401 or 403), and then retried once with the fresh URL. Later requests for a URL found to have expired,
+// such as the URL the signed URL redirected to, are sent to the fresh URL instead.
+func WithSignedURLRefresh(ctx context.Context, refresh SignedURLRefresher) context.Context {
+	return context.WithValue(ctx, signedURLKey{}, &signedURL{refresh: refresh, expired: make(map[string]bool)})
+}
+
+func signatureExpired(status int) bool {
+	return status == http.StatusBadRequest || status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// rewrite returns req, sent to the current signed URL if its own has expired, and the generation of the URL sent.
+func (s *signedURL) rewrite(req *http.Request) (*http.Request, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil && s.expired[req.URL.String()] {
+		req = withURL(req, s.current)
+	}
+	return req, s.generation
+}
+
+// refreshed returns the signed URL to retry a request which was refused with the URL of generation, refreshing it
+// unless another request already has.
+func (s *signedURL) refreshed(ctx context.Context, refused *http.Request, generation int) (*url.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired[refused.URL.String()] = true
+	if generation != s.generation {
+		return s.current, nil
+	}
+	fresh, err := s.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(fresh)
+	if err != nil {
+		return nil, err
+	}
+	if s.current != nil {
+		s.expired[s.current.String()] = true
+	}
+	s.current = u
+	s.generation++
+	return u, nil
+}
+
+func (s *signedURL) do(c *http.Client, req *http.Request) (*http.Response, error) {
+	req, generation := s.rewrite(req)
+	resp, err := c.Do(req)
+	if err != nil || !signatureExpired(resp.StatusCode) {
+		return resp, err
+	}
+	logger := logging.GetLogger()
+	fresh, refreshErr := s.refreshed(req.Context(), req, generation)
+	if refreshErr != nil {
+		logger.Warn().
+			Str("url", req.URL.String()).
+			Int("status", resp.StatusCode).
+			Err(refreshErr).
+			Msg("Error refreshing signed URL")
+		return resp, nil
+	}
+	logger.Info().
+		Str("url", req.URL.String()).
+		Int("status", resp.StatusCode).
+		Msg("Signed URL refreshed")
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	return c.Do(withURL(req, fresh))
+}
+
+func withURL(req *http.Request, u *url.URL) *http.Request {
+	req = req.Clone(req.Context())
+	req.URL = u
+	req.Host = ""
+	return req
+}
@@ -2,16 +2,21 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"net"
 	"net/url"
+	"os"
 	"strings"
 
+	"github.com/dustin/go-humanize"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	"github.com/replicate/pget/pkg/consumer"
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/extract"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/overwrite"
 )
 
 const viperEnvPrefix = "PGET"
@@ -19,6 +24,7 @@ const viperEnvPrefix = "PGET"
 const (
 	ConsumerFile         = "file"
 	ConsumerTarExtractor = "tar-extractor"
+	ConsumerZipExtractor = "zip-extractor"
 	ConsumerNull         = "null"
 )
 
@@ -28,6 +34,9 @@ var (
 
 type ConsistentHashingStrategy struct{}
 
+// ConsistentHashingStrategyKey is a context key that enables strategy fallback in client.RetryPolicy.
+//
+// Deprecated: execute requests with client.Do and set client.Request.StrategyFallback instead.
 var ConsistentHashingStrategyKey ConsistentHashingStrategy
 
 type DeprecatedFlag struct {
@@ -35,11 +44,89 @@ type DeprecatedFlag struct {
 	Msg  string
 }
 
+// An AliasedOption is an option which can also be set with a deprecated alias, on the command line or in the
+// environment. The alias must be registered as a flag of its own, not bound to the same variable as the option's
+// flag, so that which of them was given can be told apart.
+type AliasedOption struct {
+	Name  string
+	Alias string
+}
+
+// AliasedOptions are the options with deprecated aliases, resolved by ResolveAliases.
+var AliasedOptions = []AliasedOption{
+	{Name: OptConcurrency, Alias: OptMaxChunks},
+	{Name: OptChunkSize, Alias: OptMinimumChunkSize},
+	{Name: OptDownloadTimeout, Alias: OptHardTimeout},
+}
+
+// ResolveAliases sets each of AliasedOptions from the first of these which is given: its flag, its alias's flag, its
+// environment variable, its alias's environment variable. Giving both flags is an error; a warning is logged when
+// the alias's environment variable is used or ignored.
+func ResolveAliases(cmd *cobra.Command) error {
+	logger := logging.GetLogger()
+	flags := cmd.Flags()
+	for _, opt := range AliasedOptions {
+		changed, aliasChanged := flags.Changed(opt.Name), flags.Changed(opt.Alias)
+		switch {
+		case changed && aliasChanged:
+			return fmt.Errorf("--%s and --%s cannot be used at the same time, use --%s instead", opt.Alias, opt.Name, opt.Name)
+		case changed:
+			continue
+		case aliasChanged:
+			viper.Set(opt.Name, viper.Get(opt.Alias))
+			continue
+		}
+		value, set := os.LookupEnv(envVar(opt.Name))
+		aliasValue, aliasSet := os.LookupEnv(envVar(opt.Alias))
+		switch {
+		case aliasSet && !set:
+			logger.Warn().Msgf("Using %s is deprecated, use %s instead", envVar(opt.Alias), envVar(opt.Name))
+			viper.Set(opt.Name, aliasValue)
+		case aliasSet && aliasValue != value:
+			logger.Warn().Msgf("Both %s and %s are set, using %s", envVar(opt.Alias), envVar(opt.Name), envVar(opt.Name))
+		}
+	}
+	return nil
+}
+
+// envVar returns the environment variable viper reads opt from.
+func envVar(opt string) string {
+	return viperEnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(opt, "-", "_"))
+}
+
 func PersistentStartupProcessFlags() error {
 	if viper.GetBool(OptVerbose) {
 		viper.Set(OptLoggingLevel, "debug")
 	}
 	setLogLevel(viper.GetString(OptLoggingLevel))
+	return setupLogFile()
+}
+
+// setupLogFile adds the --log-file sink to the logger, at --log-file-level (default --log-level).
+func setupLogFile() error {
+	path := viper.GetString(OptLogFile)
+	if path == "" {
+		return nil
+	}
+	var maxSize uint64
+	if value := viper.GetString(OptLogFileMaxSize); value != "" {
+		var err error
+		maxSize, err = humanize.ParseBytes(value)
+		if err != nil {
+			return fmt.Errorf("error parsing --%s: %w", OptLogFileMaxSize, err)
+		}
+	}
+	fileLevel := viper.GetString(OptLogFileLevel)
+	if fileLevel == "" {
+		fileLevel = viper.GetString(OptLoggingLevel)
+	}
+	file := &logging.RotatingFile{
+		Path:       path,
+		MaxSize:    int64(maxSize),
+		MaxAge:     viper.GetDuration(OptLogFileMaxAge),
+		MaxBackups: viper.GetInt(OptLogFileMaxBackups),
+	}
+	logging.AddFileSink(file, parseLogLevel(viper.GetString(OptLoggingLevel)), parseLogLevel(fileLevel))
 	return nil
 }
 
@@ -85,18 +172,19 @@ func ViperInit() {
 }
 
 func setLogLevel(logLevel string) {
-	// Set log-level
+	zerolog.SetGlobalLevel(parseLogLevel(logLevel))
+}
+
+func parseLogLevel(logLevel string) zerolog.Level {
 	switch logLevel {
 	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case "info":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		return zerolog.DebugLevel
 	case "warn":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+		return zerolog.WarnLevel
 	case "error":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+		return zerolog.ErrorLevel
 	default:
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		return zerolog.InfoLevel
 	}
 }
 
@@ -143,14 +231,192 @@ func ResolveOverridesToMap(resolveOverrides []string) (map[string]string, error)
 // GetConsumer returns the consumer specified by the user on the command line
 // or an error if the consumer is invalid. Note that this function explicitly
 // calls viper.GetString(OptExtract) internally.
+//
+// Several consumers joined with "+" (e.g. "file+tar-extractor") are combined
+// into a consumer.CompositeConsumer. The file consumer of such a combination
+// writes to --archive-dest, as the destination is taken by the other consumer.
 func GetConsumer() (consumer.Consumer, error) {
-	consumerName := viper.GetString(OptOutputConsumer)
-	enableOverwrite := viper.GetBool(OptForce)
+	consumerNames := ConsumerNames()
+	if len(consumerNames) == 1 {
+		return getConsumer(consumerNames[0])
+	}
+
+	composite := &consumer.CompositeConsumer{}
+	seen := make(map[string]bool)
+	for _, name := range consumerNames {
+		if seen[name] {
+			return nil, fmt.Errorf("consumer %s specified more than once", name)
+		}
+		seen[name] = true
+		c, err := getConsumer(name)
+		if err != nil {
+			return nil, err
+		}
+		target := consumer.Target{Consumer: c}
+		if name == ConsumerFile {
+			target.DestPath = viper.GetString(OptArchiveDest)
+			if target.DestPath == "" {
+				return nil, fmt.Errorf("--%s is required when combining the file consumer with others", OptArchiveDest)
+			}
+		}
+		composite.Targets = append(composite.Targets, target)
+	}
+	return composite, nil
+}
+
+// ConsumerNames returns the names of the consumers specified by the user on
+// the command line.
+func ConsumerNames() []string {
+	return strings.Split(viper.GetString(OptOutputConsumer), "+")
+}
+
+// ChunkSizeAuto is the value of --chunk-size which selects download.Options.AutoChunkSize.
+const ChunkSizeAuto = "auto"
+
+// ChunkSize parses --chunk-size, which is either a size in bytes (e.g. 10M) or ChunkSizeAuto, in which case size is
+// zero and auto is true.
+func ChunkSize() (size int64, auto bool, err error) {
+	value := viper.GetString(OptChunkSize)
+	if value == ChunkSizeAuto {
+		return 0, true, nil
+	}
+	parsed, err := humanize.ParseBytes(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("error parsing chunk size: %w", err)
+	}
+	// each chunk is read into a single buffer
+	if parsed > math.MaxInt {
+		return 0, false, fmt.Errorf("chunk size %s is larger than the largest buffer on this platform (%s)", value, humanize.IBytes(math.MaxInt))
+	}
+	return int64(parsed), false, nil
+}
+
+// MaxBuffered parses --max-buffered, a size in bytes (e.g. 2G), which is zero if not set.
+func MaxBuffered() (int64, error) {
+	return sizeOption(OptMaxBuffered)
+}
+
+// SliceSize parses --slice-size, the size in bytes (e.g. 500MiB) of the slices the cache hosts store objects in.
+func SliceSize() (int64, error) {
+	size, err := sizeOption(OptSliceSize)
+	if err == nil && size == 0 {
+		err = fmt.Errorf("--%s must be larger than zero", OptSliceSize)
+	}
+	return size, err
+}
+
+// MaxTotalBytes parses --max-total-bytes, a size in bytes (e.g. 100G), which is zero if not set.
+func MaxTotalBytes() (int64, error) {
+	return sizeOption(OptMaxTotalBytes)
+}
+
+// sizeOption parses the option name, a size in bytes (e.g. 2G), which is zero if not set.
+func sizeOption(name string) (int64, error) {
+	value := viper.GetString(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := humanize.ParseBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing --%s: %w", name, err)
+	}
+	if parsed > math.MaxInt64 {
+		return 0, fmt.Errorf("--%s %s is too large", name, value)
+	}
+	return int64(parsed), nil
+}
+
+// OverwritePolicy returns the policy for existing destinations selected with --overwrite. The deprecated --force
+// selects overwrite.Always unless --overwrite is also given.
+func OverwritePolicy() (overwrite.Policy, error) {
+	policy, err := overwrite.Parse(viper.GetString(OptOverwrite))
+	if err != nil {
+		return "", err
+	}
+	if policy == overwrite.Never && viper.GetBool(OptForce) {
+		return overwrite.Always, nil
+	}
+	return policy, nil
+}
+
+// OutputAttributes returns the mode and owner of written files selected with --output-mode and --output-owner.
+func OutputAttributes() (consumer.FileAttributes, error) {
+	mode, err := consumer.ParseFileMode(viper.GetString(OptOutputMode))
+	if err != nil {
+		return consumer.FileAttributes{}, err
+	}
+	owner, err := consumer.ParseFileOwner(viper.GetString(OptOutputOwner))
+	if err != nil {
+		return consumer.FileAttributes{}, err
+	}
+	return consumer.FileAttributes{Mode: mode, Owner: owner}, nil
+}
+
+// readExtractIndex reads the listing of the tar archive given with --extract-index, if any.
+func readExtractIndex() ([]extract.IndexEntry, error) {
+	path := viper.GetString(OptExtractIndex)
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening --%s: %w", OptExtractIndex, err)
+	}
+	defer f.Close()
+	return extract.ReadIndex(f)
+}
+
+func getConsumer(consumerName string) (consumer.Consumer, error) {
+	policy, err := OverwritePolicy()
+	if err != nil {
+		return nil, err
+	}
+	// with --skip-unchanged, destinations that have changed upstream are replaced
+	if policy == overwrite.Never && viper.GetBool(OptSkipUnchanged) {
+		policy = overwrite.Always
+	}
+	attributes, err := OutputAttributes()
+	if err != nil {
+		return nil, err
+	}
 	switch consumerName {
 	case ConsumerFile:
-		return &consumer.FileWriter{Overwrite: enableOverwrite}, nil
-	case ConsumerTarExtractor:
-		return &consumer.TarExtractor{Overwrite: enableOverwrite}, nil
+		return &consumer.FileWriter{
+			Overwrite:  policy,
+			Attributes: attributes,
+			NoAtomic:   viper.GetBool(OptNoAtomic),
+			DirectIO:   viper.GetBool(OptDirectIO),
+			IOURing:    viper.GetBool(OptExperimentalIOURing),
+		}, nil
+	case ConsumerTarExtractor, ConsumerZipExtractor:
+		preserve, err := extract.ParsePreserve(viper.GetString(OptExtractPreserve))
+		if err != nil {
+			return nil, err
+		}
+		links, err := extract.ParseLinkPolicy(viper.GetString(OptExtractLinks))
+		if err != nil {
+			return nil, err
+		}
+		if consumerName == ConsumerZipExtractor {
+			return &consumer.ZipExtractor{
+				Overwrite:       policy,
+				Concurrency:     viper.GetInt(OptExtractConcurrency),
+				Preserve:        preserve,
+				Links:           links,
+				SpoolAttributes: attributes,
+			}, nil
+		}
+		index, err := readExtractIndex()
+		if err != nil {
+			return nil, err
+		}
+		return &consumer.TarExtractor{
+			Overwrite:   policy,
+			Concurrency: viper.GetInt(OptExtractConcurrency),
+			Preserve:    preserve,
+			Links:       links,
+			Index:       index,
+		}, nil
 	case ConsumerNull:
 		return &consumer.NullWriter{}, nil
 	default:
@@ -207,3 +473,27 @@ func CacheableURIPrefixes() map[string][]*url.URL {
 	}
 	return result
 }
+
+// GetURIAliases returns a map of target URI prefixes to the alias prefixes which serve the same content, if set.
+// ENV is `PGET_CACHE_URI_ALIASES`, and the format is
+// `https://alias.example.com/prefix=https://example.com/prefix https://other.example.com/=https://example.com/prefix [...]`
+// where several aliases may share a target.
+func GetURIAliases() map[string][]*url.URL {
+	logger := logging.GetLogger()
+	result := make(map[string][]*url.URL)
+
+	for _, pair := range viper.GetStringSlice(OptCacheURIAliases) {
+		alias, target, found := strings.Cut(pair, "=")
+		parsedAlias, err := url.Parse(alias)
+		if !found || err != nil || parsedAlias.Host == "" || parsedAlias.Scheme == "" {
+			logger.Error().
+				Err(err).
+				Str("alias", pair).
+				Str("requirements", "requires <alias>=<target>, the alias with at minimum scheme and host").
+				Msg("Cache URI Aliases")
+			continue
+		}
+		result[target] = append(result[target], parsedAlias)
+	}
+	return result
+}
@@ -4,8 +4,10 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -185,3 +187,80 @@ func TestGetCacheSRV(t *testing.T) {
 		})
 	}
 }
+
+func TestGetURIAliases(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(OptCacheURIAliases, strings.Join([]string{
+		"https://mirror.example.com=https://weights.replicate.delivery",
+		"https://other.example.org/weights=https://weights.replicate.delivery",
+		"https://example.net/models=https://models.example.com/prefix",
+		"invalid",
+		"no-scheme=https://weights.replicate.delivery",
+	}, " "))
+
+	expected := map[string][]*url.URL{
+		"https://weights.replicate.delivery": helperUrlParse(t, "https://mirror.example.com", "https://other.example.org/weights"),
+		"https://models.example.com/prefix":  helperUrlParse(t, "https://example.net/models"),
+	}
+	assert.Equal(t, expected, GetURIAliases())
+}
+
+func TestResolveAliases(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		concurrency int
+		chunkSize   string
+		err         bool
+	}{
+		{"defaults", nil, nil, 8, "125M", false},
+		{"flags", []string{"--concurrency", "3", "--chunk-size", "10M"}, nil, 3, "10M", false},
+		{"alias flags", []string{"--max-chunks", "3", "--minimum-chunk-size", "10M"}, nil, 3, "10M", false},
+		{"both flags", []string{"--concurrency", "3", "--max-chunks", "4"}, nil, 0, "", true},
+		{"env", nil, map[string]string{"PGET_CONCURRENCY": "5", "PGET_CHUNK_SIZE": "20M"}, 5, "20M", false},
+		{"alias env", nil, map[string]string{"PGET_MAX_CHUNKS": "5", "PGET_MINIMUM_CHUNK_SIZE": "20M"}, 5, "20M", false},
+		{"both env", nil, map[string]string{"PGET_CONCURRENCY": "5", "PGET_MAX_CHUNKS": "6"}, 5, "125M", false},
+		{"alias flag over env", []string{"--max-chunks", "3"}, map[string]string{"PGET_CONCURRENCY": "5"}, 3, "125M", false},
+		{"flag over alias env", []string{"--chunk-size", "10M"}, map[string]string{"PGET_MINIMUM_CHUNK_SIZE": "20M"}, 8, "10M", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer viper.Reset()
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			ViperInit()
+			cmd := &cobra.Command{}
+			cmd.Flags().Int(OptConcurrency, 8, "")
+			cmd.Flags().Int(OptMaxChunks, 8, "")
+			cmd.Flags().String(OptChunkSize, "125M", "")
+			cmd.Flags().String(OptMinimumChunkSize, "125M", "")
+			require.NoError(t, viper.BindPFlags(cmd.Flags()))
+			require.NoError(t, cmd.ParseFlags(tc.args))
+
+			err := ResolveAliases(cmd)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.concurrency, viper.GetInt(OptConcurrency))
+			assert.Equal(t, tc.chunkSize, viper.GetString(OptChunkSize))
+		})
+	}
+}
+
+func TestResolveAliasesHardTimeout(t *testing.T) {
+	defer viper.Reset()
+	ViperInit()
+	cmd := &cobra.Command{}
+	cmd.Flags().Duration(OptDownloadTimeout, 0, "")
+	cmd.Flags().Duration(OptHardTimeout, 0, "")
+	require.NoError(t, viper.BindPFlags(cmd.Flags()))
+	require.NoError(t, cmd.ParseFlags([]string{"--hard-timeout", "30m"}))
+
+	require.NoError(t, ResolveAliases(cmd))
+	assert.Equal(t, 30*time.Minute, viper.GetDuration(OptDownloadTimeout))
+}
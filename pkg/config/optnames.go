@@ -6,24 +6,100 @@ const (
 	OptCacheNodesSRVNameByHostCIDR = "cache-nodes-srv-name-by-host-cidr"
 	OptCacheNodesSRVName           = "cache-nodes-srv-name"
 	OptCacheURIPrefixes            = "cache-uri-prefixes"
+	OptCacheURIAliases             = "cache-uri-aliases"
 	OptCacheUsePathProxy           = "cache-use-path-proxy"
+	OptCacheRewrite                = "cache-rewrite"
+	OptCacheKeyRules               = "cache-key-rules" // e.g. "strip-query=X-Amz-Signature,X-Amz-Date lowercase-host"
+	OptCacheFallbackThreshold      = "cache-fallback-threshold"
+	OptCacheHealthCheckPath        = "cache-health-check-path"
+	OptCacheHealthCheckInterval    = "cache-health-check-interval"
 	OptHostIP                      = "host-ip"
 
 	// Normal options with CLI arguments
-	OptConcurrency        = "concurrency"
-	OptConnTimeout        = "connect-timeout"
-	OptChunkSize          = "chunk-size"
-	OptExtract            = "extract"
-	OptForce              = "force"
-	OptForceHTTP2         = "force-http2"
-	OptLoggingLevel       = "log-level"
-	OptMaxChunks          = "max-chunks"
-	OptMaxConnPerHost     = "max-conn-per-host"
-	OptMaxConcurrentFiles = "max-concurrent-files"
-	OptMinimumChunkSize   = "minimum-chunk-size"
-	OptOutputConsumer     = "output"
-	OptPIDFile            = "pid-file"
-	OptResolve            = "resolve"
-	OptRetries            = "retries"
-	OptVerbose            = "verbose"
+	OptAdaptiveConcurrency   = "adaptive-concurrency"
+	OptArchiveDest           = "archive-dest"
+	OptCacheHashRollout      = "cache-hash-rollout"
+	OptCacheHashVersion      = "cache-hash-version"
+	OptCompressed            = "compressed"
+	OptConcurrency           = "concurrency"
+	OptContinueOnError       = "continue-on-error"
+	OptConnTimeout           = "connect-timeout"
+	OptChunkDeadlineFactor   = "chunk-deadline-factor"
+	OptChunkSize             = "chunk-size"
+	OptDedupeStrategy        = "dedupe-strategy"
+	OptDirectIO              = "direct-io"
+	OptDNSCacheTTL           = "dns-cache-ttl"
+	OptDNSServer             = "dns-server"
+	OptDownloadTimeout       = "download-timeout"
+	OptDryRun                = "dry-run"
+	OptEmitManifest          = "emit-manifest"
+	OptEmitSliceSums         = "emit-slice-sums"
+	OptExperimentalIOURing   = "experimental-io-uring"
+	OptExtract               = "extract"
+	OptExtractConcurrency    = "extract-concurrency"
+	OptExtractIndex          = "extract-index"
+	OptExtractLinks          = "extract-links"
+	OptExtractPreserve       = "extract-preserve"
+	OptFileOrder             = "file-order"
+	OptForce                 = "force"
+	OptForceHTTP2            = "force-http2"
+	OptHardTimeout           = "hard-timeout"
+	OptHeartbeatInterval     = "heartbeat-interval"
+	OptHTTPSOnly             = "https-only"
+	OptHTTPSOnlyAllowedHost  = "https-only-allowed-host"
+	OptIfMatch               = "if-match"
+	OptIntegrity             = "integrity"
+	OptIPFSGateway           = "ipfs-gateway"
+	OptIPFSSkipVerify        = "ipfs-skip-verify"
+	OptJSONOutput            = "json-output"
+	OptLenientContentRange   = "lenient-content-range"
+	OptLowMemory             = "low-memory"
+	OptLoggingLevel          = "log-level"
+	OptLogFile               = "log-file"
+	OptLogFileLevel          = "log-file-level"
+	OptLogFileMaxAge         = "log-file-max-age"
+	OptLogFileMaxBackups     = "log-file-max-backups"
+	OptLogFileMaxSize        = "log-file-max-size"
+	OptMaxBuffered           = "max-buffered"
+	OptMaxChunks             = "max-chunks"
+	OptMaxConnPerHost        = "max-conn-per-host"
+	OptMaxRedirects          = "max-redirects"
+	OptMaxRequestsPerSecond  = "max-requests-per-second"
+	OptMaxRetryAfter         = "max-retry-after"
+	OptMaxConcurrentFiles    = "max-concurrent-files"
+	OptMaxTotalBytes         = "max-total-bytes"
+	OptMaxTotalTime          = "max-total-time"
+	OptMinimumChunkSize      = "minimum-chunk-size"
+	OptMirror                = "mirror"
+	OptMirrorLatencyWeighted = "mirror-latency-weighted"
+	OptNoAtomic              = "no-atomic"
+	OptNoProxy               = "no-proxy"
+	OptObjectVersion         = "object-version"
+	OptOutputConsumer        = "output"
+	OptOutputMode            = "output-mode"
+	OptOutputOwner           = "output-owner"
+	OptOverwrite             = "overwrite"
+	OptPipelineChunks        = "pipeline-chunks"
+	OptPrewarmConnections    = "prewarm-connections"
+	OptPIDFile               = "pid-file"
+	OptProxy                 = "proxy"
+	OptRedirectAllowedHost   = "redirect-allowed-host"
+	OptRedirectAuth          = "redirect-auth"
+	OptReplicateCredentials  = "replicate-credentials-endpoint"
+	OptResolve               = "resolve"
+	OptRetries               = "retries"
+	OptSkipUnchanged         = "skip-unchanged"
+	OptSliceSize             = "slice-size"
+	OptSoftTimeout           = "soft-timeout"
+	OptStatsInterval         = "stats-interval"
+	OptStrict                = "strict"
+	OptTotalDeadline         = "total-deadline"
+	OptTUI                   = "tui"
+	OptVerbose               = "verbose"
+	OptVerifySliceSums       = "verify-slice-sums"
+	OptWarmup                = "warmup"
+
+	// Verify options
+	OptVerifySamples    = "samples"
+	OptVerifySampleSize = "sample-size"
 )
@@ -0,0 +1,181 @@
+package consistent
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"slices"
+
+	"github.com/dgryski/go-jump"
+)
+
+// A Version identifies a mapping from (URL, slice) to cache host, as computed by Version.SliceHash and
+// Version.SliceBucket.
+//
+// The mappings are a stable API: cache servers, possibly written in other languages, rely on computing the same
+// bucket as pget does for a slice, and changing it sends every slice to a different cache host. For a given
+// version the output never changes; any change to the algorithm must come with a new version (and new seeds).
+// testdata/slice_bucket_vectors*.json hold test vectors for implementations in other languages.
+type Version int
+
+const (
+	// V1 is the original mapping, described by SliceHash.
+	V1 Version = 1
+	// V2 hashes with SHA-256, described by Version.SliceHash.
+	V2 Version = 2
+)
+
+// HashVersion is the version of the mapping computed by SliceHash and SliceBucket, used unless another is selected.
+const HashVersion = V1
+
+// ParseVersion parses a version number, e.g. 2.
+func ParseVersion(value int) (Version, error) {
+	switch v := Version(value); v {
+	case V1, V2:
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown consistent hashing version %d, expected 1 or 2", value)
+}
+
+// The seeds of HashVersion 1. The algorithm was originally the hashstructure (FormatV2) hash of Go structs with
+// these names, which is why the seeds are struct names.
+const (
+	sliceSeedV1   = "CacheKey"
+	attemptSeedV1 = "cacheKey"
+)
+
+// The seeds of V2, and of RolloutPosition.
+const (
+	sliceSeedV2   = "pget-slice-v2"
+	rolloutSeedV2 = "pget-rollout"
+)
+
+// SliceHash returns the V1 64-bit hash of slice number slice of the object at url, for the given attempt (the number
+// of cache hosts already tried for it). With FNV1(b) the 64-bit FNV-1 hash (not FNV-1a) of the bytes b, LE(x) the
+// 8 little-endian bytes of the uint64 x, and
+//
+//	field(h, name, v) = FNV1(LE(h XOR FNV1(LE(FNV1(name)) || LE(v))))
+//
+// the hash is computed as
+//
+//	k = FNV1("CacheKey")
+//	k = field(k, "URL", FNV1(url))
+//	if slice != 0: k = field(k, "Slice", FNV1(LE(slice)))
+//	h = FNV1("cacheKey")
+//	h = field(h, "Key", k)
+//	if attempt != 0: h = field(h, "Attempt", FNV1(LE(attempt)))
+//
+// where strings are hashed as their UTF-8 bytes and url is the URL exactly as requested from the cache host,
+// before it is rewritten to address the host.
+func SliceHash(url string, slice int64, attempt int) uint64 {
+	return V1.SliceHash(url, slice, attempt)
+}
+
+// SliceHash returns the 64-bit hash of slice number slice of the object at url for the given attempt under version
+// v, which must be V1 or V2. V1 is described by the function SliceHash. With V2, with LE(x) the 8 little-endian
+// bytes of x, the hash is the first 8 bytes, read as a little-endian integer, of
+//
+//	SHA-256("pget-slice-v2" || 0x00 || url || 0x00 || LE(slice) || LE(attempt))
+func (v Version) SliceHash(url string, slice int64, attempt int) uint64 {
+	switch v {
+	case V1:
+		return sliceHashV1(url, slice, attempt)
+	case V2:
+		return sliceHashV2(url, slice, attempt)
+	}
+	panic(fmt.Sprintf("unknown consistent hashing version %d", v))
+}
+
+func sliceHashV1(url string, slice int64, attempt int) uint64 {
+	k := fnv1(sliceSeedV1)
+	k = hashField(k, "URL", fnv1(url))
+	if slice != 0 {
+		k = hashField(k, "Slice", fnv1LE(uint64(slice)))
+	}
+	h := fnv1(attemptSeedV1)
+	h = hashField(h, "Key", k)
+	if attempt != 0 {
+		h = hashField(h, "Attempt", fnv1LE(uint64(attempt)))
+	}
+	return h
+}
+
+func sliceHashV2(url string, slice int64, attempt int) uint64 {
+	h := sha256.New()
+	h.Write([]byte(sliceSeedV2 + "\x00" + url + "\x00"))
+	h.Write(binary.LittleEndian.AppendUint64(nil, uint64(slice)))
+	h.Write(binary.LittleEndian.AppendUint64(nil, uint64(attempt)))
+	return binary.LittleEndian.Uint64(h.Sum(nil))
+}
+
+// SliceBucket returns the bucket (the index of the cache host) in [0,buckets) for slice number slice of the object
+// at url. As with HashBucket, previousBuckets are the buckets already tried, which are avoided, and the slice is
+// sorted.
+//
+// The bucket is Jump Consistent Hash (http://arxiv.org/abs/1406.2294) of SliceHash(url, slice,
+// len(previousBuckets)) over buckets-len(previousBuckets) buckets, incremented once for each previous bucket (in
+// ascending order) it is greater than or equal to.
+func SliceBucket(url string, slice int64, buckets int, previousBuckets ...int) (int, error) {
+	return V1.SliceBucket(url, slice, buckets, previousBuckets...)
+}
+
+// SliceBucket returns the bucket in [0,buckets) for slice number slice of the object at url under version v, as the
+// function SliceBucket does with v's SliceHash.
+func (v Version) SliceBucket(url string, slice int64, buckets int, previousBuckets ...int) (int, error) {
+	if v != V1 && v != V2 {
+		return -1, fmt.Errorf("unknown consistent hashing version %d", v)
+	}
+	if len(previousBuckets) >= buckets {
+		return -1, fmt.Errorf("No more buckets left: %d buckets available but %d already attempted", buckets, previousBuckets)
+	}
+	hash := v.SliceHash(url, slice, len(previousBuckets))
+	bucket := int(jump.Hash(hash, buckets-len(previousBuckets)))
+	slices.Sort(previousBuckets)
+	for _, prev := range previousBuckets {
+		if bucket >= prev {
+			bucket++
+		}
+	}
+	return bucket, nil
+}
+
+// RolloutPosition returns the position in [0,1) of the object at url in the rollout of a new version: the first 8
+// bytes, read as a little-endian integer, of SHA-256("pget-rollout" || 0x00 || url), shifted right by 11 bits and
+// divided by 2^53.
+func RolloutPosition(url string) float64 {
+	sum := sha256.Sum256([]byte(rolloutSeedV2 + "\x00" + url))
+	return float64(binary.LittleEndian.Uint64(sum[:])>>11) / (1 << 53)
+}
+
+// ForObject returns the version whose mapping the slices of the object at url are cached with while a fleet
+// migrates to version v, of which rollout is the fraction of objects using it: objects whose RolloutPosition is
+// below rollout use v, and the others the version before v. A rollout of 0 or at least 1 means every object uses v.
+// Raising rollout only moves more objects, each of whose slices move once, so that the fleet's cache misses are
+// spread over the migration instead of all happening at once.
+func (v Version) ForObject(url string, rollout float64) Version {
+	if v <= V1 || rollout <= 0 || rollout >= 1 || RolloutPosition(url) < rollout {
+		return v
+	}
+	return v - 1
+}
+
+func fnv1(s string) uint64 {
+	h := fnv.New64()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func fnv1LE(values ...uint64) uint64 {
+	h := fnv.New64()
+	var buf [8]byte
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		_, _ = h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+func hashField(h uint64, name string, value uint64) uint64 {
+	return fnv1LE(h ^ fnv1LE(fnv1(name), value))
+}
@@ -0,0 +1,115 @@
+package consistent_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/consistent"
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+type sliceBucketVector struct {
+	URL      string `json:"url"`
+	Slice    int64  `json:"slice"`
+	Buckets  int    `json:"buckets"`
+	Previous []int  `json:"previous_buckets"`
+	Hash     string `json:"hash"`
+	Bucket   int    `json:"bucket"`
+}
+
+func loadSliceBucketVectors(t *testing.T) []sliceBucketVector {
+	return loadVersionVectors(t, "testdata/slice_bucket_vectors.json")
+}
+
+func loadVersionVectors(t *testing.T, path string) []sliceBucketVector {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var vectors []sliceBucketVector
+	require.NoError(t, json.Unmarshal(data, &vectors))
+	require.NotEmpty(t, vectors)
+	return vectors
+}
+
+func TestSliceBucketVectors(t *testing.T) {
+	require.Equal(t, consistent.V1, consistent.HashVersion, "test vectors are for hash version 1")
+	for _, v := range loadSliceBucketVectors(t) {
+		t.Run(fmt.Sprintf("%s/%d/%d/%v", v.URL, v.Slice, v.Buckets, v.Previous), func(t *testing.T) {
+			assert.Equal(t, v.Hash, fmt.Sprintf("%016x", consistent.SliceHash(v.URL, v.Slice, len(v.Previous))))
+			bucket, err := consistent.SliceBucket(v.URL, v.Slice, v.Buckets, slices.Clone(v.Previous)...)
+			require.NoError(t, err)
+			assert.Equal(t, v.Bucket, bucket)
+		})
+	}
+}
+
+func TestSliceBucketVectorsV2(t *testing.T) {
+	for _, v := range loadVersionVectors(t, "testdata/slice_bucket_vectors_v2.json") {
+		t.Run(fmt.Sprintf("%s/%d/%d/%v", v.URL, v.Slice, v.Buckets, v.Previous), func(t *testing.T) {
+			assert.Equal(t, v.Hash, fmt.Sprintf("%016x", consistent.V2.SliceHash(v.URL, v.Slice, len(v.Previous))))
+			bucket, err := consistent.V2.SliceBucket(v.URL, v.Slice, v.Buckets, slices.Clone(v.Previous)...)
+			require.NoError(t, err)
+			assert.Equal(t, v.Bucket, bucket)
+		})
+	}
+}
+
+func TestSliceBucketUnknownVersion(t *testing.T) {
+	_, err := consistent.Version(3).SliceBucket("http://example.com/file", 0, 2)
+	assert.Error(t, err)
+	_, err = consistent.ParseVersion(0)
+	assert.Error(t, err)
+	version, err := consistent.ParseVersion(2)
+	require.NoError(t, err)
+	assert.Equal(t, consistent.V2, version)
+}
+
+func TestRolloutPosition(t *testing.T) {
+	assert.Equal(t, 0.012151637984940011, consistent.RolloutPosition("https://example.com/a"))
+	assert.Equal(t, 0.7662503484815425, consistent.RolloutPosition("https://example.com/b"))
+}
+
+func TestForObject(t *testing.T) {
+	a, b := "https://example.com/a", "https://example.com/b"
+	for _, tc := range []struct {
+		name      string
+		version   consistent.Version
+		rollout   float64
+		expectedA consistent.Version
+		expectedB consistent.Version
+	}{
+		{"no rollout", consistent.V2, 0, consistent.V2, consistent.V2},
+		{"complete rollout", consistent.V2, 1, consistent.V2, consistent.V2},
+		{"partial rollout", consistent.V2, 0.5, consistent.V2, consistent.V1},
+		{"wider rollout", consistent.V2, 0.8, consistent.V2, consistent.V2},
+		{"first version", consistent.V1, 0.5, consistent.V1, consistent.V1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedA, tc.version.ForObject(a, tc.rollout))
+			assert.Equal(t, tc.expectedB, tc.version.ForObject(b, tc.rollout))
+		})
+	}
+}
+
+// The mapping predates SliceBucket, when it was the HashBucket of a download.CacheKey; caches populated then must
+// stay valid.
+func TestSliceBucketMatchesHashBucket(t *testing.T) {
+	for _, v := range loadSliceBucketVectors(t) {
+		parsed, err := url.Parse(v.URL)
+		require.NoError(t, err)
+		bucket, err := consistent.HashBucket(download.CacheKey{URL: parsed, Slice: v.Slice}, v.Buckets, slices.Clone(v.Previous)...)
+		require.NoError(t, err)
+		assert.Equal(t, v.Bucket, bucket, "%+v", v)
+	}
+}
+
+func TestSliceBucketNoBucketsLeft(t *testing.T) {
+	_, err := consistent.SliceBucket("http://example.com/file", 0, 2, 0, 1)
+	assert.Error(t, err)
+}
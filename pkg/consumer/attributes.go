@@ -0,0 +1,69 @@
+package consumer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultFileMode is the mode files are created with, less the umask, unless FileAttributes.Mode is set.
+const defaultFileMode os.FileMode = 0644
+
+// FileAttributes are the permissions and owner given to the files a consumer writes, so that they need no chmod or
+// chown afterwards, e.g. when pget runs as root in an init container.
+type FileAttributes struct {
+	// Mode, if not zero, is the permission bits of the files, applied regardless of the umask.
+	Mode os.FileMode
+	// Owner, if set, is the owner of the files. Changing it usually requires running as root.
+	Owner *FileOwner
+}
+
+// A FileOwner is a numeric user and group ID.
+type FileOwner struct {
+	UID int
+	GID int
+}
+
+// ParseFileMode parses an octal permission mode such as 0644, as used by --output-mode. An empty value is zero.
+func ParseFileMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > uint64(os.ModePerm) {
+		return 0, fmt.Errorf("invalid --output-mode %q, expected octal permissions such as 0644", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ParseFileOwner parses a numeric uid:gid, as used by --output-owner. An empty value is nil.
+func ParseFileOwner(value string) (*FileOwner, error) {
+	if value == "" {
+		return nil, nil
+	}
+	uid, gid, ok := strings.Cut(value, ":")
+	owner := &FileOwner{}
+	var uidErr, gidErr error
+	owner.UID, uidErr = strconv.Atoi(uid)
+	owner.GID, gidErr = strconv.Atoi(gid)
+	if !ok || uidErr != nil || gidErr != nil || owner.UID < 0 || owner.GID < 0 {
+		return nil, fmt.Errorf("invalid --output-owner %q, expected numeric uid:gid such as 1000:1000", value)
+	}
+	return owner, nil
+}
+
+// apply sets the mode and owner of the file at path.
+func (a FileAttributes) apply(path string) error {
+	if a.Mode != 0 {
+		if err := os.Chmod(path, a.Mode); err != nil {
+			return fmt.Errorf("error setting mode of %s: %w", path, err)
+		}
+	}
+	if a.Owner != nil {
+		if err := os.Chown(path, a.Owner.UID, a.Owner.GID); err != nil {
+			return fmt.Errorf("error setting owner of %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package consumer_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/consumer"
+)
+
+func TestParseFileMode(t *testing.T) {
+	mode, err := consumer.ParseFileMode("")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0), mode)
+
+	mode, err = consumer.ParseFileMode("0640")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), mode)
+
+	mode, err = consumer.ParseFileMode("755")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), mode)
+
+	for _, invalid := range []string{"0844", "rw-r--r--", "01777"} {
+		_, err = consumer.ParseFileMode(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseFileOwner(t *testing.T) {
+	owner, err := consumer.ParseFileOwner("")
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+
+	owner, err = consumer.ParseFileOwner("1000:2000")
+	require.NoError(t, err)
+	assert.Equal(t, &consumer.FileOwner{UID: 1000, GID: 2000}, owner)
+
+	for _, invalid := range []string{"1000", "root:root", "1000:", "-1:0"} {
+		_, err = consumer.ParseFileOwner(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,64 @@
+package consumer
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// A Target is one of the consumers of a CompositeConsumer. If DestPath is empty, the consumer is given the
+// destination path passed to CompositeConsumer.Consume.
+type Target struct {
+	Consumer Consumer
+	DestPath string
+}
+
+// CompositeConsumer tees the downloaded stream to several consumers at once, e.g. to keep a copy of an archive while
+// extracting it. Consumers read at their own pace, but the stream only advances as fast as the slowest of them.
+type CompositeConsumer struct {
+	Targets []Target
+}
+
+var _ Consumer = &CompositeConsumer{}
+
+func (c *CompositeConsumer) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	errGroup := new(errgroup.Group)
+	writers := make([]io.Writer, len(c.Targets))
+	pipeWriters := make([]*io.PipeWriter, len(c.Targets))
+	for i, target := range c.Targets {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+		pipeWriters[i] = pw
+		dest := target.DestPath
+		if dest == "" {
+			dest = destPath
+		}
+		errGroup.Go(func() error {
+			err := target.Consumer.Consume(pr, dest, expectedBytes)
+			if err != nil {
+				// unblocks the tee, which then fails
+				pr.CloseWithError(err)
+				return err
+			}
+			// a consumer which is done before the end of the stream must not hold up the others
+			_, _ = io.Copy(io.Discard, pr)
+			return nil
+		})
+	}
+
+	_, copyErr := io.Copy(io.MultiWriter(writers...), reader)
+	for _, pw := range pipeWriters {
+		// with a nil copyErr the consumers see io.EOF
+		pw.CloseWithError(copyErr)
+	}
+	err := errGroup.Wait()
+	if err != nil {
+		// the consumer's error explains why the copy failed
+		return err
+	}
+	if copyErr != nil {
+		return fmt.Errorf("error reading download stream: %w", copyErr)
+	}
+	return nil
+}
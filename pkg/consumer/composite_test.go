@@ -0,0 +1,77 @@
+package consumer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/consumer"
+)
+
+type failingConsumer struct {
+	err error
+}
+
+func (f failingConsumer) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	// read a little, then give up
+	_, _ = reader.Read(make([]byte, 1))
+	return f.err
+}
+
+type earlyConsumer struct{}
+
+func (earlyConsumer) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	return nil
+}
+
+func TestCompositeConsumer_Consume(t *testing.T) {
+	tarFileBytes, err := createTarFileBytesBuffer()
+	require.NoError(t, err)
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "archive.tar")
+	extractDir := filepath.Join(tmpDir, "extract")
+
+	composite := &consumer.CompositeConsumer{Targets: []consumer.Target{
+		{Consumer: &consumer.FileWriter{}, DestPath: archivePath},
+		{Consumer: &consumer.TarExtractor{}},
+	}}
+	require.NoError(t, composite.Consume(bytes.NewReader(tarFileBytes), extractDir, int64(len(tarFileBytes))))
+
+	written, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, tarFileBytes, written)
+	checkTarExtraction(t, extractDir)
+}
+
+func TestCompositeConsumer_ConsumeError(t *testing.T) {
+	content := generateTestContent(100 * kB)
+	consumerErr := errors.New("consumer failed")
+
+	composite := &consumer.CompositeConsumer{Targets: []consumer.Target{
+		{Consumer: &consumer.NullWriter{}},
+		{Consumer: failingConsumer{err: consumerErr}},
+	}}
+	err := composite.Consume(bytes.NewReader(content), "", int64(len(content)))
+	assert.ErrorIs(t, err, consumerErr)
+}
+
+func TestCompositeConsumer_ConsumeEarlyReturn(t *testing.T) {
+	content := generateTestContent(100 * kB)
+	destPath := filepath.Join(t.TempDir(), "file")
+
+	composite := &consumer.CompositeConsumer{Targets: []consumer.Target{
+		{Consumer: earlyConsumer{}},
+		{Consumer: &consumer.FileWriter{}},
+	}}
+	require.NoError(t, composite.Consume(bytes.NewReader(content), destPath, int64(len(content))))
+
+	written, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, written)
+}
@@ -5,3 +5,15 @@ import "io"
 type Consumer interface {
 	Consume(reader io.Reader, destPath string, expectedBytes int64) error
 }
+
+// ReaderAtConsumer is a Consumer which can also read the download out of order, given its size up front. A
+// pget.Getter calls ConsumeAt instead of Consume when its download strategy supports random access (see
+// download.RandomAccessStrategy) and nothing else needs to see the content in order, e.g. to verify a checksum.
+//
+// Consumers which write the content in order anyway (FileWriter, WriterAtConsumer, TarExtractor) only implement
+// Consume: the stream is downloaded in parallel chunks already, ahead of the reads.
+type ReaderAtConsumer interface {
+	Consumer
+	// ConsumeAt consumes the size bytes readable from r, which is safe for concurrent use.
+	ConsumeAt(r io.ReaderAt, destPath string, size int64) error
+}
@@ -6,7 +6,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
-	"github.com/replicate/pget/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/consumer"
 )
 
 func TestNullWriter_Consume(t *testing.T) {
@@ -0,0 +1,42 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/replicate/pget/v2/pkg/overwrite"
+	"github.com/replicate/pget/v2/pkg/vfs"
+)
+
+// ObjectWriter uploads the download to an object store, destPath being the URL of the object (s3://bucket/key or
+// gs://bucket/key, see vfs.UploadObject), as it is downloaded and without writing it to local disk. Objects larger
+// than a part are uploaded in parts, several at a time, each sent as soon as the chunks it is made of complete.
+// FileWriter uploads s3:// and gs:// destinations with it.
+type ObjectWriter struct {
+	// Overwrite is the policy for an object which already exists: overwrite.Always replaces it, overwrite.Never (or
+	// empty) fails the upload (see vfs.UploadNewObject). The policies which reuse the existing content are not
+	// supported.
+	Overwrite overwrite.Policy
+}
+
+var _ Consumer = &ObjectWriter{}
+
+func (w ObjectWriter) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	upload := vfs.UploadNewObject
+	switch w.Overwrite {
+	case "", overwrite.Never:
+	case overwrite.Always:
+		upload = vfs.UploadObject
+	default:
+		return fmt.Errorf("overwrite policy %s is not supported for object destination %s", w.Overwrite, destPath)
+	}
+	btReader := &byteTrackingReader{r: reader}
+	if err := upload(context.Background(), destPath, btReader, expectedBytes); err != nil {
+		return err
+	}
+	if btReader.bytesRead != expectedBytes {
+		return fmt.Errorf("expected %d bytes, read %d", expectedBytes, btReader.bytesRead)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package consumer_test
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/overwrite"
+)
+
+func TestFileWriterUploadsObjects(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, exists := objects[r.URL.Path]
+		if r.Method == http.MethodHead {
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut || err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if exists && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		objects[r.URL.Path] = body
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+
+	buf := generateTestContent(kB)
+	writer := consumer.FileWriter{NoAtomic: true}
+	require.NoError(t, writer.Consume(bytes.NewReader(buf), "s3://bucket/models/weights.bin", kB))
+	assert.Equal(t, buf, objects["/bucket/models/weights.bin"])
+
+	// the download is cut short
+	err := writer.Consume(bytes.NewReader(buf[:kB/2]), "s3://bucket/models/short.bin", kB)
+	assert.Error(t, err)
+	assert.NotContains(t, objects, "/bucket/models/short.bin")
+
+	err = writer.Consume(bytes.NewReader(buf), "s3://bucket/models/", kB)
+	assert.ErrorContains(t, err, "has no object name")
+
+	// an existing object is only replaced with overwrite.Always
+	replacement := generateTestContent(kB)
+	err = writer.Consume(bytes.NewReader(replacement), "s3://bucket/models/weights.bin", kB)
+	assert.ErrorIs(t, err, fs.ErrExist)
+	assert.Equal(t, buf, objects["/bucket/models/weights.bin"])
+	err = (&consumer.FileWriter{Overwrite: overwrite.IfDifferent}).Consume(bytes.NewReader(replacement), "s3://bucket/models/weights.bin", kB)
+	assert.ErrorContains(t, err, "not supported")
+	require.NoError(t, (&consumer.FileWriter{Overwrite: overwrite.Always}).Consume(bytes.NewReader(replacement), "s3://bucket/models/weights.bin", kB))
+	assert.Equal(t, replacement, objects["/bucket/models/weights.bin"])
+}
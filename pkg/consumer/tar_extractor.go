@@ -5,11 +5,24 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/replicate/pget/pkg/extract"
+	"github.com/replicate/pget/v2/pkg/extract"
+	"github.com/replicate/pget/v2/pkg/overwrite"
+	"github.com/replicate/pget/v2/pkg/vfs"
 )
 
+// TarExtractor extracts a tar archive into the destination directory, or uploads its files to an object store if the
+// destination is an s3:// or gs:// URL (see vfs.Open).
 type TarExtractor struct {
-	Overwrite bool
+	// Overwrite is the policy for extracted files which already exist. If empty, overwrite.Never is used.
+	Overwrite overwrite.Policy
+	// Concurrency is the number of goroutines writing extracted files. If less than 2, files are written one at a
+	// time.
+	Concurrency int
+	Preserve    extract.Preserve
+	Links       extract.LinkPolicy
+	// Index, if set, lists the entries of the archive so that the directory tree can be prepared before it is
+	// read. See extract.Options.Index.
+	Index []extract.IndexEntry
 }
 
 var _ Consumer = &TarExtractor{}
@@ -28,8 +41,22 @@ func (b *byteTrackingReader) Read(p []byte) (n int, err error) {
 }
 
 func (f *TarExtractor) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	opts := extract.Options{
+		Overwrite:   f.Overwrite,
+		Concurrency: f.Concurrency,
+		Preserve:    f.Preserve,
+		Links:       f.Links,
+		Index:       f.Index,
+	}
+	if vfs.IsRemote(destPath) {
+		fsys, err := vfs.Open(destPath)
+		if err != nil {
+			return err
+		}
+		opts.FS = fsys
+	}
 	btReader := &byteTrackingReader{r: reader}
-	err := extract.TarFile(bufio.NewReader(btReader), destPath, f.Overwrite)
+	err := extract.TarFile(bufio.NewReader(btReader), destPath, opts)
 	if err != nil {
 		return fmt.Errorf("error extracting file: %w", err)
 	}
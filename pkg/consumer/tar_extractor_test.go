@@ -3,6 +3,7 @@ package consumer_test
 import (
 	"archive/tar"
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"path"
@@ -11,7 +12,8 @@ import (
 
 	"github.com/stretchr/testify/require"
 
-	"github.com/replicate/pget/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/overwrite"
 )
 
 const (
@@ -165,3 +167,47 @@ func checkTarExtraction(t *testing.T, targetDir string) {
 		t.Errorf("hard link does not match file2.txt")
 	}
 }
+
+func TestTarExtractor_ConsumeParallel(t *testing.T) {
+	r := require.New(t)
+
+	tarFileBytes, err := createTarFileBytesBuffer()
+	r.NoError(err)
+	targetDir := path.Join(t.TempDir(), "extract")
+	tarConsumer := consumer.TarExtractor{Concurrency: 4}
+	r.NoError(tarConsumer.Consume(bytes.NewReader(tarFileBytes), targetDir, int64(len(tarFileBytes))))
+	checkTarExtraction(t, targetDir)
+
+	// many small files, plus names which appear twice with differently sized content: the last entry must win
+	large := bytes.Repeat([]byte("L"), 2*1024*1024)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeEntry := func(name string, content []byte) {
+		r.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content))}))
+		_, err := tw.Write(content)
+		r.NoError(err)
+	}
+	for i := 0; i < 100; i++ {
+		writeEntry(fmt.Sprintf("dir%d/file%d.txt", i%7, i), []byte(fmt.Sprintf("content %d", i)))
+	}
+	writeEntry("small-then-large", []byte("small"))
+	writeEntry("large-then-small", large)
+	writeEntry("small-then-large", large)
+	writeEntry("large-then-small", []byte("small"))
+	r.NoError(tw.Close())
+
+	targetDir = path.Join(t.TempDir(), "extract-many")
+	tarConsumer = consumer.TarExtractor{Concurrency: 4, Overwrite: overwrite.Always}
+	r.NoError(tarConsumer.Consume(bytes.NewReader(buf.Bytes()), targetDir, int64(buf.Len())))
+	for i := 0; i < 100; i++ {
+		content, err := os.ReadFile(path.Join(targetDir, fmt.Sprintf("dir%d/file%d.txt", i%7, i)))
+		r.NoError(err)
+		r.Equal(fmt.Sprintf("content %d", i), string(content))
+	}
+	content, err := os.ReadFile(path.Join(targetDir, "small-then-large"))
+	r.NoError(err)
+	r.Equal(large, content)
+	content, err = os.ReadFile(path.Join(targetDir, "large-then-small"))
+	r.NoError(err)
+	r.Equal("small", string(content))
+}
@@ -0,0 +1,124 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrWatermarkUnknown is returned by Watermarks.Wait if the destination has not started downloading.
+var ErrWatermarkUnknown = errors.New("no download to destination")
+
+// Watermarks tracks, for each destination written by a FileWriter, the watermark: the offset below which every byte
+// has been written to the file. The file is extended to its full size before the first write, so a reader may mmap
+// the whole file as soon as the download starts and touch pages below the watermark while it continues.
+//
+// Bytes below the watermark have been written to the file (and so are visible to any mapping of it), but are not
+// necessarily synced to stable storage.
+type Watermarks struct {
+	mu     sync.Mutex
+	states map[string]*watermarkState
+}
+
+type watermarkState struct {
+	offset int64
+	size   int64
+	done   bool
+	err    error
+	// changed is closed, and replaced, whenever the state changes
+	changed chan struct{}
+}
+
+func NewWatermarks() *Watermarks {
+	return &Watermarks{states: make(map[string]*watermarkState)}
+}
+
+// Offset returns the watermark of destPath and whether a download to it has started.
+func (w *Watermarks) Offset(destPath string) (int64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.states[destPath]
+	if !ok {
+		return 0, false
+	}
+	return s.offset, true
+}
+
+// Wait blocks until the watermark of destPath reaches offset, which is clamped to the size of the file. It returns
+// the download's error if the download fails first, or ErrWatermarkUnknown if there is no download to destPath,
+// so it must only be called once the download has started (e.g. after Offset reports it).
+func (w *Watermarks) Wait(ctx context.Context, destPath string, offset int64) error {
+	for {
+		w.mu.Lock()
+		s, ok := w.states[destPath]
+		if !ok {
+			w.mu.Unlock()
+			return ErrWatermarkUnknown
+		}
+		switch {
+		case s.offset >= min(offset, s.size):
+			w.mu.Unlock()
+			return nil
+		case s.err != nil:
+			w.mu.Unlock()
+			return s.err
+		case s.done:
+			w.mu.Unlock()
+			return io.ErrUnexpectedEOF
+		}
+		changed := s.changed
+		w.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// start resets the watermark of destPath for a new download of size bytes.
+func (w *Watermarks) start(destPath string, size int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if s, ok := w.states[destPath]; ok {
+		close(s.changed)
+	}
+	w.states[destPath] = &watermarkState{size: size, changed: make(chan struct{})}
+}
+
+func (w *Watermarks) advance(destPath string, n int64) {
+	w.update(destPath, func(s *watermarkState) { s.offset += n })
+}
+
+func (w *Watermarks) finish(destPath string, err error) {
+	w.update(destPath, func(s *watermarkState) {
+		s.done = true
+		s.err = err
+	})
+}
+
+func (w *Watermarks) update(destPath string, f func(s *watermarkState)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.states[destPath]
+	f(s)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// watermarkWriter advances the watermark of a destination as the (sequential) writes to it complete.
+type watermarkWriter struct {
+	w          io.Writer
+	watermarks *Watermarks
+	destPath   string
+}
+
+func (ww *watermarkWriter) Write(p []byte) (int, error) {
+	n, err := ww.w.Write(p)
+	if n > 0 {
+		ww.watermarks.advance(ww.destPath, int64(n))
+	}
+	return n, err
+}
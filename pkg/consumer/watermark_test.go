@@ -0,0 +1,75 @@
+package consumer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/consumer"
+)
+
+func TestFileWriter_Watermarks(t *testing.T) {
+	content := generateTestContent(10 * kB)
+	destPath := filepath.Join(t.TempDir(), "file")
+	watermarks := consumer.NewWatermarks()
+	writer := &consumer.FileWriter{Watermarks: watermarks}
+
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- writer.Consume(pr, destPath, int64(len(content)))
+	}()
+
+	_, err := pw.Write(content[:4*kB])
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, ok := watermarks.Offset(destPath)
+		return ok
+	}, time.Second, time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, watermarks.Wait(ctx, destPath, 4*kB))
+
+	// the file has its full size while it is being written, and is complete below the watermark
+	info, err := os.Stat(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+	written, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, content[:4*kB], written[:4*kB])
+
+	// waiting past the watermark blocks until the download catches up
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer shortCancel()
+	assert.ErrorIs(t, watermarks.Wait(shortCtx, destPath, 5*kB), context.DeadlineExceeded)
+
+	_, err = pw.Write(content[4*kB:])
+	require.NoError(t, err)
+	require.NoError(t, pw.Close())
+	require.NoError(t, <-errCh)
+	// offsets past the end of the file are clamped
+	require.NoError(t, watermarks.Wait(ctx, destPath, 20*kB))
+	offset, _ := watermarks.Offset(destPath)
+	assert.Equal(t, int64(len(content)), offset)
+}
+
+func TestFileWriter_WatermarksFailure(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "file")
+	watermarks := consumer.NewWatermarks()
+	writer := &consumer.FileWriter{Watermarks: watermarks}
+
+	pr, pw := io.Pipe()
+	readErr := errors.New("download failed")
+	pw.CloseWithError(readErr)
+	require.Error(t, writer.Consume(pr, destPath, kB))
+
+	assert.ErrorIs(t, watermarks.Wait(context.Background(), destPath, kB), readErr)
+	assert.ErrorIs(t, watermarks.Wait(context.Background(), "other", kB), consumer.ErrWatermarkUnknown)
+}
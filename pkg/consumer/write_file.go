@@ -1,34 +1,131 @@
 package consumer
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"strconv"
+
+	"github.com/replicate/pget/v2/pkg/fileio"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/overwrite"
+	"github.com/replicate/pget/v2/pkg/vfs"
 )
 
+// FileWriter writes the download to the file destPath, or uploads it with an ObjectWriter if destPath is an s3:// or
+// gs:// URL, to which none of its options but Overwrite apply.
 type FileWriter struct {
-	Overwrite bool
+	// Overwrite is the policy for a destination which already exists. If empty, overwrite.Never is used.
+	Overwrite overwrite.Policy
+	// Watermarks, if set, tracks how far each destination has been written. See Watermarks.
+	Watermarks *Watermarks
+	// Attributes are applied to each destination as soon as it is opened.
+	Attributes FileAttributes
+	// NoAtomic writes each destination in place. Otherwise it is written to a temporary file next to it,
+	// <dest>.partial-<random>, which is renamed to the destination once complete, so that readers never see a half
+	// written file and an interrupted download leaves none behind at the destination. Destinations are always
+	// written in place with Watermarks, which are read as they are written, and with the overwrite.IfDifferent and
+	// overwrite.Resume policies, which reuse the content of the existing file.
+	NoAtomic bool
+	// DirectIO writes destinations bypassing the page cache where it is supported (see fileio.NewDirectWriter), so
+	// that downloading more than fits in memory doesn't evict what other processes have cached. It only applies with
+	// the overwrite.Never and overwrite.Always policies, and not with Watermarks, whose readers map the destination
+	// as it is written.
+	DirectIO bool
+	// IOURing writes destinations through an io_uring where it is supported (see fileio.NewURingWriter), taking
+	// precedence over DirectIO. It is experimental, and applies like DirectIO.
+	IOURing bool
 }
 
 var _ Consumer = &FileWriter{}
 
 func (f *FileWriter) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
-	openFlags := os.O_WRONLY | os.O_CREATE
+	if vfs.IsRemote(destPath) {
+		return ObjectWriter{Overwrite: f.Overwrite}.Consume(reader, destPath, expectedBytes)
+	}
 	targetDir := filepath.Dir(destPath)
 	if err := os.MkdirAll(targetDir, 0755); err != nil {
 		return fmt.Errorf("error creating directory: %w", err)
 	}
-	if f.Overwrite {
-		openFlags |= os.O_TRUNC
+	if !f.atomic() {
+		out, err := overwrite.Create(destPath, expectedBytes, defaultFileMode, f.Overwrite)
+		if err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+		return f.write(reader, out, destPath, expectedBytes)
+	}
+
+	if !f.Overwrite.Allowed() {
+		// fail before anything is downloaded, as when writing in place
+		if _, err := os.Lstat(destPath); err == nil {
+			return fmt.Errorf("error writing file: %w", &fs.PathError{Op: "open", Path: destPath, Err: fs.ErrExist})
+		}
 	}
-	out, err := os.OpenFile(destPath, openFlags, 0644)
+	out, tempPath, err := createTemp(destPath, expectedBytes)
 	if err != nil {
 		return fmt.Errorf("error writing file: %w", err)
 	}
-	defer out.Close()
+	if err := f.write(reader, out, tempPath, expectedBytes); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := overwrite.Rename(tempPath, destPath, f.Overwrite); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error writing file: %w", err)
+	}
+	return nil
+}
 
-	written, err := io.Copy(out, reader)
+func (f *FileWriter) atomic() bool {
+	return !f.NoAtomic && f.Watermarks == nil &&
+		(f.Overwrite == "" || f.Overwrite == overwrite.Never || f.Overwrite == overwrite.Always)
+}
+
+// createTemp creates the temporary file destPath is written to, with the mode of a new destination.
+func createTemp(destPath string, expectedBytes int64) (*overwrite.File, string, error) {
+	for {
+		tempPath := destPath + ".partial-" + strconv.FormatUint(rand.Uint64(), 36)
+		out, err := overwrite.Create(tempPath, expectedBytes, defaultFileMode, overwrite.Never)
+		if !errors.Is(err, fs.ErrExist) {
+			return out, tempPath, err
+		}
+	}
+}
+
+// write writes the content read from reader to out, the file at path, and closes it.
+func (f *FileWriter) write(reader io.Reader, out *overwrite.File, path string, expectedBytes int64) (err error) {
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error writing file: %w", closeErr)
+		}
+	}()
+	if err := f.Attributes.apply(path); err != nil {
+		return err
+	}
+
+	var writer io.Writer = out
+	switch {
+	case f.Watermarks != nil:
+		// written through the page cache, which readers map
+	case f.IOURing && replaceWriter(out, path, "io_uring", fileio.NewURingWriter):
+	case f.DirectIO:
+		replaceWriter(out, path, "Direct I/O", fileio.NewDirectWriter)
+	}
+	if f.Watermarks != nil {
+		// extend the file to its full size first, so that it can be mapped while it is written
+		if err := out.Preallocate(); err != nil {
+			return fmt.Errorf("error writing file: %w", err)
+		}
+		f.Watermarks.start(path, expectedBytes)
+		defer func() { f.Watermarks.finish(path, err) }()
+		writer = &watermarkWriter{w: out, watermarks: f.Watermarks, destPath: path}
+	}
+
+	written, err := io.Copy(writer, reader)
 	if err != nil {
 		return fmt.Errorf("error writing file: %w", err)
 	}
@@ -38,3 +135,13 @@ func (f *FileWriter) Consume(reader io.Reader, destPath string, expectedBytes in
 	}
 	return nil
 }
+
+// replaceWriter has out, the file at path, written by the writer newWriter returns, and reports whether it will be.
+func replaceWriter(out *overwrite.File, path, name string, newWriter func(*os.File) (io.WriteCloser, error)) bool {
+	replaced, err := out.ReplaceWriter(newWriter)
+	if err != nil {
+		logger := logging.GetLogger()
+		logger.Debug().Err(err).Str("path", path).Msg(name + " not supported")
+	}
+	return replaced
+}
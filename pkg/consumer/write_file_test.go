@@ -2,12 +2,19 @@ package consumer_test
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"testing"
+	"testing/iotest"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
-	"github.com/replicate/pget/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/overwrite"
 )
 
 func TestFileWriter_Consume(t *testing.T) {
@@ -24,6 +31,11 @@ func TestFileWriter_Consume(t *testing.T) {
 		os.Remove(tmpFile.Name())
 	})
 
+	// the default policy refuses to overwrite the file
+	r.ErrorIs(writeFileConsumer.Consume(reader, tmpFile.Name(), kB), fs.ErrExist)
+	r.NoError(os.Remove(tmpFile.Name()))
+
+	_, _ = reader.Seek(0, 0)
 	r.NoError(writeFileConsumer.Consume(reader, tmpFile.Name(), kB))
 
 	// Check the file content is correct
@@ -42,10 +54,66 @@ func TestFileWriter_Consume(t *testing.T) {
 
 	// consume the reader
 	_, _ = reader.Seek(0, 0)
-	writeFileConsumer.Overwrite = true
+	writeFileConsumer.Overwrite = overwrite.Always
 	r.NoError(writeFileConsumer.Consume(reader, tmpFile.Name(), kB))
 
 	// check the file content is correct
 	fileContent, _ = os.ReadFile(tmpFile.Name())
 	r.Equal(buf, fileContent)
 }
+
+func TestFileWriter_ConsumeAttributes(t *testing.T) {
+	r := require.New(t)
+	dest := filepath.Join(t.TempDir(), "file")
+	buf := generateTestContent(kB)
+
+	// the mode is applied regardless of the umask, and the owner may be the current one without root
+	writer := consumer.FileWriter{Attributes: consumer.FileAttributes{
+		Mode:  0666,
+		Owner: &consumer.FileOwner{UID: os.Getuid(), GID: os.Getgid()},
+	}}
+	r.NoError(writer.Consume(bytes.NewReader(buf), dest, kB))
+	info, err := os.Stat(dest)
+	r.NoError(err)
+	r.Equal(os.FileMode(0666), info.Mode().Perm())
+
+	// an existing destination is given the mode too
+	writer = consumer.FileWriter{Overwrite: overwrite.Always, Attributes: consumer.FileAttributes{Mode: 0600}}
+	r.NoError(writer.Consume(bytes.NewReader(buf), dest, kB))
+	info, err = os.Stat(dest)
+	r.NoError(err)
+	r.Equal(os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestFileWriter_ConsumeAtomic(t *testing.T) {
+	buf := generateTestContent(kB)
+	failing := func() io.Reader {
+		return io.MultiReader(bytes.NewReader(buf[:kB/2]), iotest.ErrReader(errors.New("connection reset")))
+	}
+	for _, noAtomic := range []bool{false, true} {
+		dir := t.TempDir()
+		dest := filepath.Join(dir, "file")
+		writer := consumer.FileWriter{Overwrite: overwrite.Always, NoAtomic: noAtomic}
+
+		// an interrupted download leaves a truncated file behind only when written in place
+		require.Error(t, writer.Consume(failing(), dest, kB))
+		_, err := os.Stat(dest)
+		assert.Equal(t, noAtomic, err == nil)
+
+		require.NoError(t, writer.Consume(bytes.NewReader(buf), dest, kB))
+		content, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, buf, content)
+
+		// a failed replacement leaves the previous content in place
+		require.Error(t, writer.Consume(failing(), dest, kB))
+		content, err = os.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, !noAtomic, bytes.Equal(buf, content))
+
+		// no temporary file is left behind
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	}
+}
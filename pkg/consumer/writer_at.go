@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WriterAtConsumer writes the download into a caller-supplied io.WriterAt, such as a file opened by an embedding
+// application, starting at Offset. The destination path is ignored.
+type WriterAtConsumer struct {
+	W      io.WriterAt
+	Offset int64
+}
+
+var _ Consumer = &WriterAtConsumer{}
+
+func (c *WriterAtConsumer) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	written, err := io.Copy(io.NewOffsetWriter(c.W, c.Offset), reader)
+	if err != nil {
+		return fmt.Errorf("error writing: %w", err)
+	}
+	if written != expectedBytes {
+		return fmt.Errorf("expected %d bytes, wrote %d", expectedBytes, written)
+	}
+	return nil
+}
+
+// MemoryWriter reads the download directly into a caller-supplied region of memory, such as an mmapped or pinned
+// buffer a model loader consumes weights from, without a copy through the filesystem. The region must be at least
+// as large as the download; the bytes after it are left untouched. The destination path is ignored. Given random
+// access to the download (see ReaderAtConsumer), its blocks are downloaded straight into the region, in parallel and
+// in any order.
+type MemoryWriter struct {
+	Region []byte
+}
+
+var _ ReaderAtConsumer = &MemoryWriter{}
+
+func (m *MemoryWriter) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	if expectedBytes > int64(len(m.Region)) {
+		return fmt.Errorf("download of %d bytes does not fit in a region of %d bytes", expectedBytes, len(m.Region))
+	}
+	read, err := io.ReadFull(reader, m.Region[:expectedBytes])
+	if err != nil {
+		return fmt.Errorf("expected %d bytes, read %d: %w", expectedBytes, read, err)
+	}
+	// the reader must be at its end, so that any error it returns there is not missed
+	var extra [1]byte
+	switch _, err := io.ReadFull(reader, extra[:]); {
+	case err == nil:
+		return fmt.Errorf("expected %d bytes, read more", expectedBytes)
+	case !errors.Is(err, io.EOF):
+		return fmt.Errorf("error reading: %w", err)
+	}
+	return nil
+}
+
+func (m *MemoryWriter) ConsumeAt(r io.ReaderAt, destPath string, size int64) error {
+	if size > int64(len(m.Region)) {
+		return fmt.Errorf("download of %d bytes does not fit in a region of %d bytes", size, len(m.Region))
+	}
+	read, err := r.ReadAt(m.Region[:size], 0)
+	if err != nil && !(errors.Is(err, io.EOF) && int64(read) == size) {
+		return fmt.Errorf("expected %d bytes, read %d: %w", size, read, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package consumer_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/consumer"
+)
+
+func TestWriterAtConsumer_Consume(t *testing.T) {
+	r := require.New(t)
+	buf := generateTestContent(kB)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	r.NoError(err)
+	defer f.Close()
+	writerAt := &consumer.WriterAtConsumer{W: f, Offset: 100}
+	r.NoError(writerAt.Consume(bytes.NewReader(buf), "ignored", kB))
+
+	content, err := os.ReadFile(f.Name())
+	r.NoError(err)
+	r.Equal(append(make([]byte, 100), buf...), content)
+
+	r.Error(writerAt.Consume(bytes.NewReader(buf), "ignored", kB-100))
+}
+
+func TestMemoryWriter_Consume(t *testing.T) {
+	r := require.New(t)
+	buf := generateTestContent(kB)
+
+	region := make([]byte, kB+10)
+	memory := &consumer.MemoryWriter{Region: region}
+	r.NoError(memory.Consume(bytes.NewReader(buf), "", kB))
+	r.Equal(buf, region[:kB])
+	r.Equal(make([]byte, 10), region[kB:])
+
+	// too small a region, too short or too long a download, or an error at the end of the download
+	r.Error((&consumer.MemoryWriter{Region: make([]byte, kB-1)}).Consume(bytes.NewReader(buf), "", kB))
+	r.Error(memory.Consume(bytes.NewReader(buf[:kB-100]), "", kB))
+	r.Error(memory.Consume(bytes.NewReader(buf), "", kB-100))
+	r.ErrorIs(memory.Consume(io.MultiReader(bytes.NewReader(buf), iotest.ErrReader(io.ErrUnexpectedEOF)), "", kB), io.ErrUnexpectedEOF)
+}
+
+func TestMemoryWriter_ConsumeAt(t *testing.T) {
+	r := require.New(t)
+	buf := generateTestContent(kB)
+
+	region := make([]byte, kB+10)
+	memory := &consumer.MemoryWriter{Region: region}
+	r.NoError(memory.ConsumeAt(bytes.NewReader(buf), "", kB))
+	r.Equal(buf, region[:kB])
+	r.Equal(make([]byte, 10), region[kB:])
+
+	r.Error((&consumer.MemoryWriter{Region: make([]byte, kB-1)}).ConsumeAt(bytes.NewReader(buf), "", kB))
+	r.Error(memory.ConsumeAt(bytes.NewReader(buf[:kB-100]), "", kB))
+}
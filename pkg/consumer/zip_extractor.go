@@ -0,0 +1,96 @@
+package consumer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/replicate/pget/v2/pkg/extract"
+	"github.com/replicate/pget/v2/pkg/overwrite"
+	"github.com/replicate/pget/v2/pkg/vfs"
+)
+
+// ZipExtractor extracts a zip archive into the destination directory. Unlike a tar archive, a zip archive can only
+// be read once its central directory (at the end) has arrived. Given random access to the download (see
+// ReaderAtConsumer), it reads the central directory and then the entries straight from it; otherwise the download is
+// spooled to a temporary file next to the destination first (or in the temporary directory, for a remote
+// destination), which is removed afterwards.
+type ZipExtractor struct {
+	// Overwrite is the policy for extracted files which already exist. If empty, overwrite.Never is used.
+	Overwrite overwrite.Policy
+	// Concurrency is the number of goroutines writing extracted files. If less than 2, files are written one at a
+	// time.
+	Concurrency int
+	Preserve    extract.Preserve
+	Links       extract.LinkPolicy
+	// SpoolAttributes are applied to the temporary file the archive is spooled to.
+	SpoolAttributes FileAttributes
+}
+
+var _ ReaderAtConsumer = &ZipExtractor{}
+
+func (f *ZipExtractor) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	opts, err := f.options(destPath)
+	if err != nil {
+		return err
+	}
+	spoolDir := filepath.Dir(filepath.Clean(destPath))
+	if opts.FS != nil {
+		spoolDir = os.TempDir()
+	}
+	if err := os.MkdirAll(spoolDir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	spool, err := os.CreateTemp(spoolDir, ".pget-*.zip")
+	if err != nil {
+		return fmt.Errorf("error creating spool file: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	if err := f.SpoolAttributes.apply(spool.Name()); err != nil {
+		return err
+	}
+
+	written, err := io.Copy(spool, reader)
+	if err != nil {
+		return fmt.Errorf("error writing spool file: %w", err)
+	}
+	if written != expectedBytes {
+		return fmt.Errorf("expected %d bytes, read %d from archive", expectedBytes, written)
+	}
+	return f.extract(spool, written, destPath, opts)
+}
+
+func (f *ZipExtractor) ConsumeAt(r io.ReaderAt, destPath string, size int64) error {
+	opts, err := f.options(destPath)
+	if err != nil {
+		return err
+	}
+	return f.extract(r, size, destPath, opts)
+}
+
+// options returns the extract.Options for destPath, opening it if it is a remote destination.
+func (f *ZipExtractor) options(destPath string) (extract.Options, error) {
+	opts := extract.Options{
+		Overwrite:   f.Overwrite,
+		Concurrency: f.Concurrency,
+		Preserve:    f.Preserve,
+		Links:       f.Links,
+	}
+	if vfs.IsRemote(destPath) {
+		fsys, err := vfs.Open(destPath)
+		if err != nil {
+			return opts, err
+		}
+		opts.FS = fsys
+	}
+	return opts, nil
+}
+
+func (f *ZipExtractor) extract(r io.ReaderAt, size int64, destPath string, opts extract.Options) error {
+	if err := extract.ZipFile(r, size, destPath, opts); err != nil {
+		return fmt.Errorf("error extracting file: %w", err)
+	}
+	return nil
+}
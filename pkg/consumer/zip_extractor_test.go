@@ -0,0 +1,69 @@
+package consumer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/consumer"
+)
+
+func createZipFileBytes(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(file1Path)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(file1Content))
+	require.NoError(t, err)
+	header := &zip.FileHeader{Name: fileSymLinkPath}
+	header.SetMode(os.ModeSymlink | 0777)
+	w, err = zw.CreateHeader(header)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(file1Path))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestZipExtractor_Consume(t *testing.T) {
+	archive := createZipFileBytes(t)
+	destDir := filepath.Join(t.TempDir(), "extracted")
+
+	extractor := &consumer.ZipExtractor{}
+	require.NoError(t, extractor.Consume(bytes.NewReader(archive), destDir, int64(len(archive))))
+
+	content, err := os.ReadFile(filepath.Join(destDir, fileSymLinkPath))
+	require.NoError(t, err)
+	assert.Equal(t, file1Content, string(content))
+
+	// the spooled archive is removed
+	entries, err := os.ReadDir(filepath.Dir(destDir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestZipExtractor_ConsumeAt(t *testing.T) {
+	archive := createZipFileBytes(t)
+	destDir := filepath.Join(t.TempDir(), "extracted")
+
+	extractor := &consumer.ZipExtractor{}
+	require.NoError(t, extractor.ConsumeAt(bytes.NewReader(archive), destDir, int64(len(archive))))
+
+	content, err := os.ReadFile(filepath.Join(destDir, fileSymLinkPath))
+	require.NoError(t, err)
+	assert.Equal(t, file1Content, string(content))
+}
+
+func TestZipExtractor_ConsumeSizeMismatch(t *testing.T) {
+	archive := createZipFileBytes(t)
+	destDir := filepath.Join(t.TempDir(), "extracted")
+
+	extractor := &consumer.ZipExtractor{}
+	err := extractor.Consume(bytes.NewReader(archive), destDir, int64(len(archive))+1)
+	assert.Error(t, err)
+}
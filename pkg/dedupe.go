@@ -0,0 +1,175 @@
+package pget
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/validators"
+	"github.com/replicate/pget/v2/pkg/vfs"
+)
+
+// DedupeStrategy is how DownloadFiles handles the entries of a manifest which have the same URL and different
+// destinations.
+type DedupeStrategy string
+
+const (
+	// DedupeHardlink downloads the URL once, for the first of the entries, and hard links the other destinations to
+	// it, copying it instead where a link can't be made (e.g. across file systems). The linked destinations share
+	// their content: writing to one changes them all.
+	DedupeHardlink DedupeStrategy = "hardlink"
+	// DedupeCopy downloads the URL once, for the first of the entries, and copies it to the other destinations.
+	DedupeCopy DedupeStrategy = "copy"
+	// DedupeOff downloads every entry. This is the default.
+	DedupeOff DedupeStrategy = "off"
+)
+
+// ParseDedupeStrategy parses the value of --dedupe-strategy. An empty value selects DedupeOff.
+func ParseDedupeStrategy(value string) (DedupeStrategy, error) {
+	switch strategy := DedupeStrategy(value); strategy {
+	case "":
+		return DedupeOff, nil
+	case DedupeHardlink, DedupeCopy, DedupeOff:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown --dedupe-strategy value %q, expected hardlink, copy or off", value)
+}
+
+// dedupeKey identifies the content of an entry: entries pinned to different versions of an object, or expecting
+// different digests, are downloaded separately.
+type dedupeKey struct {
+	url       string
+	pin       download.Pin
+	integrity string
+}
+
+func dedupeKeyOf(entry ManifestEntry) dedupeKey {
+	key := dedupeKey{url: entry.URL, pin: entry.Pin}
+	if entry.Integrity != nil {
+		key.integrity = entry.Integrity.String()
+	}
+	return key
+}
+
+// dedupe returns the entries to download, along with the duplicates of each, by its destination, which are written
+// from the downloaded file (see writeDuplicate) rather than downloaded again. Entries are only deduplicated with a
+// consumer.FileWriter, which leaves a file to write them from, and not when uploaded to an object store.
+func (g *Getter) dedupe(entries []ManifestEntry) ([]ManifestEntry, map[string][]ManifestEntry) {
+	if _, ok := g.fileWriter(); !ok || g.Options.DedupeStrategy == "" || g.Options.DedupeStrategy == DedupeOff {
+		return entries, nil
+	}
+	primaries := make(map[dedupeKey]string)
+	duplicates := make(map[string][]ManifestEntry)
+	unique := make([]ManifestEntry, 0, len(entries))
+	for _, entry := range entries {
+		if vfs.IsRemote(entry.Dest) {
+			unique = append(unique, entry)
+			continue
+		}
+		key := dedupeKeyOf(entry)
+		primary, ok := primaries[key]
+		if ok && primary != entry.Dest {
+			duplicates[primary] = append(duplicates[primary], entry)
+			continue
+		}
+		if !ok {
+			primaries[key] = entry.Dest
+		}
+		unique = append(unique, entry)
+	}
+	if deduplicated := len(entries) - len(unique); deduplicated > 0 {
+		logger := logging.GetLogger()
+		logger.Info().
+			Int("entries", deduplicated).
+			Str("dedupe_strategy", string(g.Options.DedupeStrategy)).
+			Msg("Deduplicating Manifest")
+	}
+	return unique, duplicates
+}
+
+// writeDuplicate writes dup, an entry with the same content as the file downloaded as record, from that file, and
+// completes it as if it had been downloaded. Any validators stored for the file are stored for dup too, as they
+// describe the same content.
+func (g *Getter) writeDuplicate(record DownloadRecord, dup ManifestEntry, groups *groupTracker) error {
+	start := time.Now()
+	writer, ok := g.fileWriter()
+	if !ok {
+		return fmt.Errorf("error writing %s from %s: consumer doesn't write files", dup.Dest, record.Dest)
+	}
+	if err := linkOrCopy(writer, g.Options.DedupeStrategy, record.Dest, dup.Dest, record.Size); err != nil {
+		return fmt.Errorf("error writing %s from %s: %w", dup.Dest, record.Dest, err)
+	}
+	if err := copyValidators(record.Dest, dup.Dest); err != nil {
+		return fmt.Errorf("error writing %s from %s: %w", dup.Dest, record.Dest, err)
+	}
+	logger := logging.GetLogger()
+	logger.Info().
+		Str("dest", dup.Dest).
+		Str("source", record.Dest).
+		Str("dedupe_strategy", string(g.Options.DedupeStrategy)).
+		Msg("Deduplicated")
+	if g.Options.OnFileComplete != nil {
+		record.Dest = dup.Dest
+		record.Duration = time.Since(start)
+		record.Retries, record.Fallbacks, record.CacheHits, record.CacheMisses = 0, 0, 0, 0
+		g.Options.OnFileComplete(record)
+	}
+	if dup.Group != nil {
+		return groups.shardComplete(dup.Group, record.Size, g.verifiesGroup(dup.Dest))
+	}
+	return nil
+}
+
+// linkOrCopy makes dest a hard link to src with DedupeHardlink, falling back to copying it with writer, under its
+// overwrite policy.
+func linkOrCopy(writer *consumer.FileWriter, strategy DedupeStrategy, src, dest string, size int64) error {
+	if strategy == DedupeHardlink {
+		err := hardlink(src, dest, writer.Overwrite.Allowed())
+		if err == nil || errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		logger := logging.GetLogger()
+		logger.Debug().Err(err).Str("dest", dest).Msg("Hard Link Failed, Copying")
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writer.Consume(f, dest, size)
+}
+
+// copyValidators stores the validators stored for src, if any, for dest.
+func copyValidators(src, dest string) error {
+	v, err := validators.Load(src)
+	if err != nil || v == nil {
+		return err
+	}
+	return validators.Save(dest, *v)
+}
+
+// hardlink links dest to src, replacing an existing dest if replace is set.
+func hardlink(src, dest string, replace bool) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	err := os.Link(src, dest)
+	if !errors.Is(err, fs.ErrExist) || !replace {
+		return err
+	}
+	if srcInfo, statErr := os.Stat(src); statErr == nil {
+		if destInfo, statErr := os.Stat(dest); statErr == nil && os.SameFile(srcInfo, destInfo) {
+			return nil
+		}
+	}
+	if err := os.Remove(dest); err != nil {
+		return err
+	}
+	return os.Link(src, dest)
+}
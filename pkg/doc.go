@@ -0,0 +1,9 @@
+// Package pget downloads files in parallel chunks, as the pget command does. It is imported as
+// github.com/replicate/pget/v2/pkg.
+//
+// The module follows semantic versioning from v2 on. Getter, Options, Manifest and ManifestEntry, download.Options and
+// download.Strategy, consumer.Consumer and client.Options are its stable API: within v2, their exported fields and
+// methods are only ever added to, and an identifier which is superseded is kept, marked Deprecated, until the next
+// major version. testdata/api.txt records this surface and TestStableAPI checks it. The rest of the exported
+// identifiers, and the packages under cmd, may change in minor releases.
+package pget
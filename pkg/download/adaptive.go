@@ -0,0 +1,149 @@
+package download
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+const (
+	// adaptiveProbeSize is the number of bytes of the first chunk which are downloaded on their own, to measure
+	// the throughput of a single connection.
+	adaptiveProbeSize = 4 * 1024 * 1024
+	// minParallelSpeedup is the multiple of the single-connection throughput which the first wave of parallel chunks
+	// must achieve for parallelism to be considered worthwhile.
+	minParallelSpeedup = 1.5
+	// maxCollapsedConnections is the most connections a download is collapsed to.
+	maxCollapsedConnections = 4
+)
+
+// adaptiveConcurrency detects downloads for which parallel connections are counterproductive, such as origins
+// which serialize range requests or limit connections per client, and collapses them to a few connections.
+//
+// The first chunk doubles as a single-connection probe: the other chunks are held back until adaptiveProbeSize
+// bytes of it have arrived. The throughput of the first wave of parallel chunks is then compared with the probe's,
+// and if it is less than minParallelSpeedup times as much, the download continues with as many connections as the
+// parallel throughput is worth (between 1 and maxCollapsedConnections).
+type adaptiveConcurrency struct {
+	url         string
+	connections int
+	limiter     *connLimiter
+	probeCh     chan float64
+
+	mu        sync.Mutex
+	probe     float64
+	wave      int
+	start     time.Time
+	waveDone  int
+	waveBytes int64
+}
+
+func newAdaptiveConcurrency(url string, connections int) *adaptiveConcurrency {
+	return &adaptiveConcurrency{
+		url:         url,
+		connections: connections,
+		limiter:     newConnLimiter(connections),
+		probeCh:     make(chan float64, 1),
+	}
+}
+
+// probed records the single-connection probe. It must be called exactly once; a failed probe (zero bytes per
+// second) disables the detection.
+func (a *adaptiveConcurrency) probed(n int64, elapsed time.Duration, err error) {
+	if err != nil || elapsed <= 0 {
+		a.probeCh <- 0
+		return
+	}
+	a.probeCh <- float64(n) / elapsed.Seconds()
+}
+
+// waitProbe blocks until the probe is done and starts timing the first wave of remaining chunks.
+func (a *adaptiveConcurrency) waitProbe(remainingChunks int) {
+	probe := <-a.probeCh
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.probe = probe
+	a.wave = min(remainingChunks, a.connections)
+	a.start = time.Now()
+}
+
+// chunkDone records the download of n bytes of a remaining chunk, and once the first wave is done, decides whether
+// to collapse the download.
+func (a *adaptiveConcurrency) chunkDone(n int64) {
+	a.mu.Lock()
+	if a.probe <= 0 || a.waveDone >= a.wave {
+		a.mu.Unlock()
+		return
+	}
+	a.waveDone++
+	a.waveBytes += n
+	if a.waveDone < a.wave {
+		a.mu.Unlock()
+		return
+	}
+	elapsed := time.Since(a.start)
+	parallel := float64(a.waveBytes) / elapsed.Seconds()
+	probe := a.probe
+	a.mu.Unlock()
+
+	logger := logging.GetLogger()
+	if parallel >= probe*minParallelSpeedup {
+		logger.Debug().
+			Str("url", a.url).
+			Float64("single_bytes_per_second", probe).
+			Float64("parallel_bytes_per_second", parallel).
+			Msg("Parallel download is effective")
+		return
+	}
+	connections := int(math.Ceil(parallel / probe))
+	connections = max(1, min(connections, maxCollapsedConnections))
+	logger.Info().
+		Str("url", a.url).
+		Float64("single_bytes_per_second", probe).
+		Float64("parallel_bytes_per_second", parallel).
+		Int("connections", connections).
+		Msg("Parallel download is counterproductive, collapsing connections")
+	a.limiter.setLimit(connections)
+}
+
+// connLimiter limits the number of concurrent connections of a single download, with a limit that can be lowered
+// while the download is in progress.
+type connLimiter struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int
+	inUse int
+}
+
+func newConnLimiter(limit int) *connLimiter {
+	l := &connLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *connLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inUse >= l.limit {
+		l.cond.Wait()
+	}
+	l.inUse++
+}
+
+func (l *connLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inUse--
+	l.cond.Signal()
+}
+
+// setLimit changes the limit. Connections above a lowered limit are not interrupted, but no new ones are made
+// until enough of them are released.
+func (l *connLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
@@ -0,0 +1,106 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func TestAdaptiveConcurrencyDecision(t *testing.T) {
+	tc := []struct {
+		name                string
+		parallelBytesPerSec int64
+		expectedLimit       int
+	}{
+		{name: "parallel slower than single", parallelBytesPerSec: 500, expectedLimit: 1},
+		{name: "parallel as fast as single", parallelBytesPerSec: 1000, expectedLimit: 1},
+		{name: "parallel slightly faster", parallelBytesPerSec: 1200, expectedLimit: 2},
+		{name: "parallel effective", parallelBytesPerSec: 4000, expectedLimit: 8},
+	}
+	for _, tc := range tc {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newAdaptiveConcurrency("http://example.com/file", 8)
+			a.probed(1000, time.Second, nil)
+			a.waitProbe(20)
+			// pretend the wave took 8s
+			a.start = time.Now().Add(-8 * time.Second)
+			for i := 0; i < 8; i++ {
+				a.chunkDone(tc.parallelBytesPerSec)
+			}
+			assert.Equal(t, tc.expectedLimit, a.limiter.limit)
+
+			// later chunks do not change the decision
+			a.chunkDone(1)
+			assert.Equal(t, tc.expectedLimit, a.limiter.limit)
+		})
+	}
+}
+
+func TestAdaptiveConcurrencyFailedProbe(t *testing.T) {
+	a := newAdaptiveConcurrency("http://example.com/file", 8)
+	a.probed(10, time.Second, io.ErrUnexpectedEOF)
+	a.waitProbe(20)
+	a.start = time.Now().Add(-time.Hour)
+	for i := 0; i < 8; i++ {
+		a.chunkDone(1)
+	}
+	assert.Equal(t, 8, a.limiter.limit)
+}
+
+func TestConnLimiterLowered(t *testing.T) {
+	l := newConnLimiter(2)
+	l.acquire()
+	l.acquire()
+	l.setLimit(1)
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire()
+		close(acquired)
+	}()
+	l.release()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a connection above the lowered limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+	l.release()
+	<-acquired
+}
+
+func TestBufferModeAdaptiveConcurrencySerializedOrigin(t *testing.T) {
+	content := generateTestContent(64 * 1024)
+	// an origin which serves one range request at a time
+	var mu sync.Mutex
+	fileServer := newTestServer(t, content)
+	defer fileServer.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		fileServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	bufferMode := GetBufferMode(Options{
+		Client:              client.Options{},
+		MaxConcurrency:      8,
+		ChunkSize:           4 * 1024,
+		AdaptiveConcurrency: true,
+	})
+	reader, size, err := bufferMode.Fetch(context.Background(), server.URL+"/"+testFilePath)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, content, data)
+}
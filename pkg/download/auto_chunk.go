@@ -0,0 +1,81 @@
+package download
+
+import (
+	"io"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// autoInitialChunkSize is the size of the first chunk of a download with automatic chunk sizing. It is kept
+	// small so that small files don't need large buffers, and doubles as the measurement of the connection.
+	autoInitialChunkSize = 4 * humanize.MiByte
+	// autoMinChunkSize is the smallest chunk size chosen automatically.
+	autoMinChunkSize = 1 * humanize.MiByte
+	// autoTargetRoundTrips is how many round trips a chunk should take to download, so that the latency of each
+	// chunk's request is a small part of its download time.
+	autoTargetRoundTrips = 20
+	// autoMinChunkDuration is the shortest time a chunk should take to download, for links whose round trip is
+	// too short to measure.
+	autoMinChunkDuration = 250 * time.Millisecond
+)
+
+// chunkMeasurement is how the first chunk of a download with automatic chunk sizing arrived.
+type chunkMeasurement struct {
+	// rtt is the time until the response headers arrived, which approximates the round trip of a request
+	rtt time.Duration
+	// bytes were read in readTime after the headers arrived
+	bytes    int64
+	readTime time.Duration
+	err      error
+}
+
+func (c chunkMeasurement) bytesPerSecond() float64 {
+	if c.readTime <= 0 {
+		return 0
+	}
+	return float64(c.bytes) / c.readTime.Seconds()
+}
+
+// autoChunkSize returns the chunk size for the remainingBytes of a download after the measured first chunk: large
+// enough for each chunk to take autoTargetRoundTrips round trips (at least autoMinChunkDuration) at the measured
+// per-connection throughput, but no larger than it takes to give each of the connections a chunk. The result is
+// between autoMinChunkSize and maxChunkSize.
+func autoChunkSize(m chunkMeasurement, remainingBytes int64, connections int, maxChunkSize int64) int64 {
+	size := maxChunkSize
+	if throughput := m.bytesPerSecond(); throughput > 0 {
+		target := max(autoTargetRoundTrips*m.rtt, autoMinChunkDuration)
+		size = int64(min(throughput*target.Seconds(), float64(maxChunkSize)))
+	}
+	// integer divide rounding up
+	perConnection := (remainingBytes-1)/int64(max(connections, 1)) + 1
+	size = min(size, perConnection)
+	return max(min(size, maxChunkSize), min(autoMinChunkSize, maxChunkSize))
+}
+
+// deferredReader is an io.Reader which only becomes available later, such as the chunks of a download after the
+// first one, which are laid out once the first one has been measured. Read blocks until resolve is called.
+type deferredReader struct {
+	ready chan struct{}
+	r     io.Reader
+	err   error
+}
+
+func newDeferredReader() *deferredReader {
+	return &deferredReader{ready: make(chan struct{})}
+}
+
+// resolve makes Read read from r, or fail with err. It must be called exactly once.
+func (d *deferredReader) resolve(r io.Reader, err error) {
+	d.r, d.err = r, err
+	close(d.ready)
+}
+
+func (d *deferredReader) Read(p []byte) (int, error) {
+	<-d.ready
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
@@ -0,0 +1,91 @@
+package download
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func TestAutoChunkSize(t *testing.T) {
+	const maxChunkSize = 125 * humanize.MiByte
+	testCases := []struct {
+		name        string
+		measurement chunkMeasurement
+		remaining   int64
+		connections int
+		expected    int64
+	}{
+		{
+			// 10 MB/s with a 100ms round trip: chunks of 2s
+			name:        "high latency",
+			measurement: chunkMeasurement{rtt: 100 * time.Millisecond, bytes: 4 * humanize.MByte, readTime: 400 * time.Millisecond},
+			remaining:   humanize.GByte,
+			connections: 16,
+			expected:    20 * humanize.MByte,
+		},
+		{
+			// 100 MB/s with a negligible round trip: chunks of autoMinChunkDuration
+			name:        "low latency",
+			measurement: chunkMeasurement{rtt: time.Millisecond, bytes: 4 * humanize.MByte, readTime: 40 * time.Millisecond},
+			remaining:   humanize.GByte,
+			connections: 16,
+			expected:    25 * humanize.MByte,
+		},
+		{
+			name:        "spread over the connections",
+			measurement: chunkMeasurement{rtt: 100 * time.Millisecond, bytes: 4 * humanize.MByte, readTime: 400 * time.Millisecond},
+			remaining:   40 * humanize.MByte,
+			connections: 16,
+			expected:    2500 * humanize.KByte,
+		},
+		{
+			name:        "at least the minimum",
+			measurement: chunkMeasurement{rtt: 100 * time.Millisecond, bytes: 4 * humanize.MByte, readTime: 400 * time.Millisecond},
+			remaining:   5 * humanize.MByte,
+			connections: 16,
+			expected:    autoMinChunkSize,
+		},
+		{
+			name:        "capped",
+			measurement: chunkMeasurement{rtt: time.Second, bytes: 4 * humanize.MByte, readTime: 40 * time.Millisecond},
+			remaining:   100 * humanize.GByte,
+			connections: 4,
+			expected:    maxChunkSize,
+		},
+		{
+			name:        "unmeasured",
+			measurement: chunkMeasurement{rtt: time.Millisecond, bytes: 4 * humanize.MByte},
+			remaining:   80 * humanize.MByte,
+			connections: 4,
+			expected:    20 * humanize.MByte,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			size := autoChunkSize(tc.measurement, tc.remaining, tc.connections, maxChunkSize)
+			assert.InDelta(t, tc.expected, size, 1)
+		})
+	}
+}
+
+func TestBufferModeAutoChunkSize(t *testing.T) {
+	for _, size := range []int64{1000, autoInitialChunkSize, 3*autoInitialChunkSize + 123} {
+		content := generateTestContent(size)
+		server := newTestServer(t, content)
+
+		bufferMode := GetBufferMode(Options{Client: client.Options{}, AutoChunkSize: true, MaxConcurrency: 4})
+		reader, fileSize, err := bufferMode.Fetch(context.Background(), server.URL+"/"+testFilePath)
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, size, fileSize)
+		assert.Equal(t, content, data)
+	}
+}
@@ -0,0 +1,64 @@
+package download
+
+import (
+	"sync"
+)
+
+// bufferBudget bounds the bytes of the chunks of a queue which are being downloaded or waiting for the consumer to
+// read them. A chunk acquires its size of the budget before it is submitted to the queue, and releases it once it
+// has been read, so that when the consumer (e.g. extraction to a slow disk) falls behind the network, chunk
+// scheduling pauses rather than every worker filling its buffer far ahead of the reader.
+//
+// Each download submits its chunks in order, so the chunk its consumer waits for is never held up by later chunks
+// of the same download holding the budget. A chunk larger than the budget is submitted once nothing else holds any.
+type bufferBudget struct {
+	limit int64
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buffered int64
+}
+
+// newBufferBudget returns nil, which never waits, if limit is not positive.
+func newBufferBudget(limit int64) *bufferBudget {
+	if limit <= 0 {
+		return nil
+	}
+	b := &bufferBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire waits until n bytes fit in the budget, and takes them.
+func (b *bufferBudget) acquire(n int64) {
+	defer stats.bufferedBytes.Add(n)
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.fits(n) {
+		stats.pausedChunks.Add(1)
+		defer stats.pausedChunks.Add(-1)
+	}
+	for !b.fits(n) {
+		b.cond.Wait()
+	}
+	b.buffered += n
+}
+
+func (b *bufferBudget) fits(n int64) bool {
+	return b.buffered == 0 || b.buffered+n <= b.limit
+}
+
+// release returns n bytes acquired with acquire.
+func (b *bufferBudget) release(n int64) {
+	stats.bufferedBytes.Add(-n)
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buffered -= n
+	b.cond.Broadcast()
+}
@@ -2,13 +2,14 @@ package download
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
+	"time"
 
-	"github.com/replicate/pget/pkg/client"
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
 )
 
 type BufferMode struct {
@@ -19,12 +20,14 @@ type BufferMode struct {
 }
 
 func GetBufferMode(opts Options) *BufferMode {
+	opts = opts.withLowMemoryLimits()
 	client := client.NewHTTPClient(opts.Client)
 	m := &BufferMode{
 		Client:  client,
 		Options: opts,
 	}
-	m.queue = newWorkQueue(opts.maxConcurrency(), m.chunkSize())
+	m.queue = newStrategyQueue(opts, m.chunkSize())
+	m.queue.pipeline = opts.PipelineChunks
 	m.queue.start()
 	return m
 }
@@ -37,33 +40,52 @@ func (m *BufferMode) chunkSize() int64 {
 	return minChunkSize
 }
 
-func (m *BufferMode) getFileSizeFromContentRange(contentRange string) (int64, error) {
-	groups := contentRangeRegexp.FindStringSubmatch(contentRange)
-	if groups == nil {
-		return -1, fmt.Errorf("couldn't parse Content-Range: %s", contentRange)
-	}
-	return strconv.ParseInt(groups[1], 10, 64)
-}
-
 type firstReqResult struct {
 	fileSize int64
 	trueURL  string
+	// encoding is the content coding of the response, if compression was negotiated
+	encoding string
 	err      error
 }
 
 func (m *BufferMode) Fetch(ctx context.Context, url string) (io.Reader, int64, error) {
 	logger := logging.GetLogger()
 
+	ctx = withFlow(ctx)
+	escalationFrom(ctx).onEscalate(func() { m.queue.escalate(ctx) })
+
+	if m.Compressed {
+		var err error
+		if ctx, err = negotiateContentCoding(ctx, m.Client, url); err != nil {
+			logger.Debug().Err(err).Str("url", url).Msg("Not requesting compression")
+		}
+	}
+
 	firstChunk := newReaderPromise()
 
+	var adaptive *adaptiveConcurrency
+	if m.AdaptiveConcurrency {
+		adaptive = newAdaptiveConcurrency(url, m.maxConcurrency())
+	}
+
+	firstChunkSize := m.chunkSize()
+	var measured chan chunkMeasurement
+	if m.AutoChunkSize {
+		firstChunkSize = autoInitialChunkSize
+		measured = make(chan chunkMeasurement, 1)
+	}
+
 	firstReqResultCh := make(chan firstReqResult)
-	m.queue.submitLow(func(buf []byte) {
+	m.queue.submitLowSized(ctx, firstChunkSize, func(buf []byte) {
 		defer close(firstReqResultCh)
-		firstChunkResp, err := m.DoRequest(ctx, 0, m.chunkSize()-1, url)
+		probeStart := time.Now()
+		// a server without range support sends the whole object, which is streamed from this response
+		firstChunkResp, err := doRangeOrWholeRequest(ctx, m.Client, 0, firstChunkSize-1, url)
 		if err != nil {
 			firstReqResultCh <- firstReqResult{err: err}
 			return
 		}
+		rtt := time.Since(probeStart)
 
 		defer firstChunkResp.Body.Close()
 
@@ -72,21 +94,46 @@ func (m *BufferMode) Fetch(ctx context.Context, url string) (io.Reader, int64, e
 			logger.Info().Str("url", url).Str("redirect_url", trueURL).Msg("Redirect")
 		}
 
-		fileSize, err := m.getFileSizeFromContentRange(firstChunkResp.Header.Get("Content-Range"))
+		fileSize, err := objectSize(ctx, m.Client, firstChunkResp, m.LenientContentRange)
+		if err != nil {
+			firstReqResultCh <- firstReqResult{err: err}
+			return
+		}
+		contentLength, err := chunkLength(firstChunkResp, m.Strict)
 		if err != nil {
 			firstReqResultCh <- firstReqResult{err: err}
 			return
 		}
-		firstReqResultCh <- firstReqResult{fileSize: fileSize, trueURL: trueURL}
+		encoding, err := responseContentCoding(firstChunkResp.Request, firstChunkResp)
+		if err != nil {
+			firstReqResultCh <- firstReqResult{err: err}
+			return
+		}
+		recordMetadata(ctx, firstChunkResp)
+		firstReqResultCh <- firstReqResult{fileSize: fileSize, trueURL: trueURL, encoding: encoding}
 
-		contentLength := firstChunkResp.ContentLength
-		n, err := io.ReadFull(firstChunkResp.Body, buf[0:contentLength])
+		var n int
+		if adaptive != nil {
+			// read the probe on its own, while the other chunks are held back
+			n, err = io.ReadFull(firstChunkResp.Body, buf[0:min(contentLength, adaptiveProbeSize)])
+			adaptive.probed(int64(n), time.Since(probeStart), err)
+			if err == nil {
+				var rest int
+				rest, err = io.ReadFull(firstChunkResp.Body, buf[n:contentLength])
+				n += rest
+			}
+		} else {
+			n, err = io.ReadFull(firstChunkResp.Body, buf[0:contentLength])
+		}
 		if err == io.ErrUnexpectedEOF {
 			logger.Warn().
 				Int("connection_interrupted_at_byte", n).
 				Msg("Resuming Chunk Download")
 			n, err = resumeDownload(firstChunkResp.Request, buf[n:contentLength], m.Client, int64(n))
 		}
+		if measured != nil {
+			measured <- chunkMeasurement{rtt: rtt, bytes: int64(n), readTime: time.Since(probeStart) - rtt, err: err}
+		}
 		firstChunk.Deliver(buf[0:n], err)
 	})
 
@@ -95,88 +142,227 @@ func (m *BufferMode) Fetch(ctx context.Context, url string) (io.Reader, int64, e
 		panic("logic error in BufferMode: first request didn't return any output")
 	}
 
+	var whole *wholeObjectError
+	if errors.As(firstReqResult.err, &whole) {
+		return m.fetchStream(ctx, url, whole)
+	}
 	if firstReqResult.err != nil {
 		return nil, -1, firstReqResult.err
 	}
 
 	fileSize := firstReqResult.fileSize
 	trueURL := firstReqResult.trueURL
+	// the remaining chunks are ranges of the same (possibly encoded) content
+	ctx = withNegotiatedCoding(ctx, firstReqResult.encoding)
 
-	if fileSize <= m.chunkSize() {
+	if fileSize <= firstChunkSize {
 		// we only need a single chunk: just download it and finish
-		return firstChunk, fileSize, nil
+		return decodeContent(ctx, url, firstChunk, fileSize)
 	}
 
-	remainingBytes := fileSize - m.chunkSize()
-	// integer divide rounding up
-	numChunks := int((remainingBytes-1)/m.chunkSize() + 1)
+	if measured != nil {
+		// the layout of the remaining chunks depends on how fast the first one arrives
+		rest := newDeferredReader()
+		go func() {
+			measurement := <-measured
+			if measurement.err != nil {
+				rest.resolve(nil, measurement.err)
+				return
+			}
+			chunkSize := autoChunkSize(measurement, fileSize-firstChunkSize, m.maxConcurrency(), m.chunkSize())
+			logger.Debug().Str("url", url).
+				Dur("rtt", measurement.rtt).
+				Str("throughput", fmt.Sprintf("%.0fB/s", measurement.bytesPerSecond())).
+				Int64("chunkSize", chunkSize).
+				Msg("Auto chunk size")
+			chunks := m.layoutChunks(url, fileSize, firstChunkSize, chunkSize)
+			readers := make([]io.Reader, len(chunks))
+			for i, chunk := range chunks {
+				readers[i] = chunk
+			}
+			rest.resolve(io.MultiReader(readers...), nil)
+			m.downloadChunks(ctx, url, trueURL, fileSize, firstChunkSize, chunkSize, chunks, adaptive)
+		}()
+		return decodeContent(ctx, url, io.MultiReader(firstChunk, rest), fileSize)
+	}
 
-	chunks := make([]io.Reader, numChunks+1)
-	chunks[0] = firstChunk
+	chunks := m.layoutChunks(url, fileSize, firstChunkSize, m.chunkSize())
+	readers := make([]io.Reader, 0, len(chunks)+1)
+	readers = append(readers, firstChunk)
+	for _, chunk := range chunks {
+		readers = append(readers, chunk)
+	}
+	go m.downloadChunks(ctx, url, trueURL, fileSize, firstChunkSize, m.chunkSize(), chunks, adaptive)
 
-	startOffset := m.chunkSize()
+	return decodeContent(ctx, url, io.MultiReader(readers...), fileSize)
+}
+
+// layoutChunks returns the promises of the chunks of chunkSize bytes after the first chunk of a download.
+func (m *BufferMode) layoutChunks(url string, fileSize, firstChunkSize, chunkSize int64) []*readerPromise {
+	logger := logging.GetLogger()
+	remainingBytes := fileSize - firstChunkSize
+	// integer divide rounding up
+	numChunks := int((remainingBytes-1)/chunkSize + 1)
 
 	logger.Debug().Str("url", url).
 		Int64("size", fileSize).
 		Int("connections", numChunks).
-		Int64("chunkSize", m.chunkSize()).
+		Int64("chunkSize", chunkSize).
 		Msg("Downloading")
 
-	for i := 0; i < numChunks; i++ {
-		chunk := newReaderPromise()
-		chunks[i+1] = chunk
+	chunks := make([]*readerPromise, numChunks)
+	for i := range chunks {
+		chunks[i] = newReaderPromise()
 	}
-	go func(chunks []io.Reader) {
-		for i, reader := range chunks {
-			chunk := reader.(*readerPromise)
-			m.queue.submitHigh(func(buf []byte) {
-				start := startOffset + m.chunkSize()*int64(i)
-				end := start + m.chunkSize() - 1
+	return chunks
+}
 
-				if i == numChunks-1 {
-					end = fileSize - 1
-				}
-				logger.Debug().Str("url", url).
-					Int64("size", fileSize).
-					Int("chunk", i).
-					Msg("Downloading chunk")
-
-				resp, err := m.DoRequest(ctx, start, end, trueURL)
-				if err != nil {
-					chunk.Deliver(nil, err)
-					return
+// downloadChunks submits the chunks after the first one, which start at startOffset, to the queue.
+func (m *BufferMode) downloadChunks(ctx context.Context, url, trueURL string, fileSize, startOffset, chunkSize int64, chunks []*readerPromise, adaptive *adaptiveConcurrency) {
+	logger := logging.GetLogger()
+	numChunks := len(chunks)
+	if adaptive != nil {
+		adaptive.waitProbe(numChunks)
+	}
+	deadlines := newChunkDeadlines(m.ChunkDeadlineFactor)
+	for i, chunk := range chunks {
+		if adaptive != nil {
+			adaptive.limiter.acquire()
+		}
+		start := startOffset + chunkSize*int64(i)
+		end := start + chunkSize - 1
+		if i == numChunks-1 {
+			end = fileSize - 1
+		}
+		size := end - start + 1
+		// the request is split from the read so that the queue can pipeline it (see priorityWorkQueue)
+		m.queue.submitHighPipelinedSized(ctx, size, func() work {
+			logger.Debug().Str("url", url).
+				Int64("size", fileSize).
+				Int("chunk", i).
+				Msg("Downloading chunk")
+
+			attemptCtx, attempt := deadlines.start(ctx, size, 0)
+			resp, contentLength, err := m.requestChunk(attemptCtx, start, end, trueURL)
+			attempt.responded()
+			return func(buf []byte) {
+				n, err := m.readChunk(resp, contentLength, err, buf, attempt)
+				for rescheduled := 1; attempt.done(n, err); rescheduled++ {
+					// rescheduled within this item rather than submitted again: the later chunks, which may hold
+					// every worker, are waiting for this one to be read
+					attemptCtx, attempt = deadlines.start(ctx, size, rescheduled)
+					resp, contentLength, err = m.requestChunk(attemptCtx, start, end, trueURL)
+					n, err = m.readChunk(resp, contentLength, err, buf, attempt)
 				}
-				defer resp.Body.Close()
-
-				contentLength := resp.ContentLength
-				n, err := io.ReadFull(resp.Body, buf[0:contentLength])
-				if err == io.ErrUnexpectedEOF {
-					logger.Warn().
-						Int("connection_interrupted_at_byte", n).
-						Msg("Resuming Chunk Download")
-					n, err = resumeDownload(resp.Request, buf[n:contentLength], m.Client, int64(n))
+				if adaptive != nil {
+					// release before Deliver, which waits for the chunk to be read
+					adaptive.limiter.release()
+					if err == nil {
+						adaptive.chunkDone(int64(n))
+					}
 				}
 				chunk.Deliver(buf[0:n], err)
-			})
-		}
-	}(chunks[1:])
+			}
+		})
+	}
+}
 
-	return io.MultiReader(chunks...), fileSize, nil
+// requestChunk requests bytes [start,end], returning the response and the length of the chunk in it.
+func (m *BufferMode) requestChunk(ctx context.Context, start, end int64, trueURL string) (*http.Response, int64, error) {
+	resp, err := m.DoRequest(ctx, start, end, trueURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	contentLength, err := chunkLength(resp, m.Strict)
+	if err != nil {
+		resp.Body.Close()
+		return nil, 0, err
+	}
+	return resp, contentLength, nil
+}
+
+// readChunk reads the chunk of contentLength bytes in resp into buf, resuming it if the connection is interrupted,
+// and closes resp. err is the error of the request, if it failed.
+func (m *BufferMode) readChunk(resp *http.Response, contentLength int64, err error, buf []byte, attempt *chunkAttempt) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	attempt.reading()
+	n, err := io.ReadFull(resp.Body, buf[0:contentLength])
+	if err == io.ErrUnexpectedEOF {
+		logger := logging.GetLogger()
+		logger.Warn().
+			Int("connection_interrupted_at_byte", n).
+			Msg("Resuming Chunk Download")
+		n, err = resumeDownload(resp.Request, buf[n:contentLength], m.Client, int64(n))
+	}
+	return n, err
 }
 
 func (m *BufferMode) DoRequest(ctx context.Context, start, end int64, trueURL string) (*http.Response, error) {
+	return doRangeRequest(ctx, m.Client, start, end, trueURL)
+}
+
+// wholeObjectError is returned by doRangeOrWholeRequest for a server which answers a range request with the whole
+// object (see ErrRangeNotSupported). It holds the response, whose body is left open so that the object can be
+// streamed from it rather than requested again.
+type wholeObjectError struct {
+	err  error
+	resp *http.Response
+}
+
+func (e *wholeObjectError) Error() string {
+	return e.err.Error()
+}
+
+func (e *wholeObjectError) Unwrap() error {
+	return e.err
+}
+
+// doRangeRequest requests bytes [start,end] of trueURL, checking that the response covers them.
+func doRangeRequest(ctx context.Context, c client.HTTPClient, start, end int64, trueURL string) (*http.Response, error) {
+	resp, err := doRangeOrWholeRequest(ctx, c, start, end, trueURL)
+	var whole *wholeObjectError
+	if errors.As(err, &whole) {
+		whole.resp.Body.Close()
+	}
+	return resp, err
+}
+
+// doRangeOrWholeRequest is doRangeRequest, returning a *wholeObjectError if the server sends the whole object
+// instead, which the caller must close the response body of.
+func doRangeOrWholeRequest(ctx context.Context, c client.HTTPClient, start, end int64, trueURL string) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", trueURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download %s: %w", trueURL, err)
 	}
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
-	resp, err := m.Client.Do(req)
+	setAcceptEncoding(req)
+	resp, err := c.Do(req)
 	if err != nil {
+		stats.hostError(req.URL.Host)
 		return nil, fmt.Errorf("error executing request for %s: %w", req.URL.String(), err)
 	}
-	if resp.StatusCode == 0 || resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("%w %s: %s", ErrUnexpectedHTTPStatus, req.URL.String(), resp.Status)
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		// the host is healthy, it just doesn't serve ranges
+		return nil, &wholeObjectError{err: fmt.Errorf("%w: %s responded %s", ErrRangeNotSupported, req.URL.String(), resp.Status), resp: resp}
+	}
+	if err := checkRangeResponse(req, resp); err != nil {
+		stats.hostError(req.URL.Host)
+		return nil, err
+	}
+	if err := validateContentRange(resp, start, end); err != nil {
+		stats.hostError(req.URL.Host)
+		resp.Body.Close()
+		logContentRangeMismatch(err, req.URL.String(), start, end)
+		return nil, err
+	}
+	if _, err := responseContentCoding(req, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
 	}
+	resp.Body = countingBody{resp.Body}
 
 	return resp, nil
 }
@@ -1,6 +1,7 @@
 package download
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -8,9 +9,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/jarcoal/httpmock"
@@ -18,7 +23,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
-	"github.com/replicate/pget/pkg/client"
+	"github.com/replicate/pget/v2/pkg/client"
 )
 
 func init() {
@@ -107,20 +112,23 @@ func TestFileToBufferChunkCountExceedsMaxChunks(t *testing.T) {
 		},
 	}
 
-	for _, tc := range tc {
-		t.Run(tc.name, func(t *testing.T) {
-			opts.MaxConcurrency = tc.maxConcurrency
-			opts.ChunkSize = tc.chunkSize
-			bufferMode := GetBufferMode(opts)
-			path, _ := url.JoinPath(server.URL, testFilePath)
-			download, size, err := bufferMode.Fetch(context.Background(), path)
-			require.NoError(t, err)
-			data, err := io.ReadAll(download)
-			assert.NoError(t, err)
-			assert.Equal(t, contentSize, size)
-			assert.Equal(t, len(content), len(data))
-			assert.Equal(t, content, data)
-		})
+	for _, pipeline := range []bool{false, true} {
+		for _, tc := range tc {
+			t.Run(fmt.Sprintf("%s (pipeline %t)", tc.name, pipeline), func(t *testing.T) {
+				opts.MaxConcurrency = tc.maxConcurrency
+				opts.ChunkSize = tc.chunkSize
+				opts.PipelineChunks = pipeline
+				bufferMode := GetBufferMode(opts)
+				path, _ := url.JoinPath(server.URL, testFilePath)
+				download, size, err := bufferMode.Fetch(context.Background(), path)
+				require.NoError(t, err)
+				data, err := io.ReadAll(download)
+				assert.NoError(t, err)
+				assert.Equal(t, contentSize, size)
+				assert.Equal(t, len(content), len(data))
+				assert.Equal(t, content, data)
+			})
+		}
 	}
 }
 
@@ -162,3 +170,178 @@ func TestReaderReturnsErrorWhenRequestFails(t *testing.T) {
 	_, err = io.ReadAll(download)
 	assert.ErrorIs(t, err, expectedErr)
 }
+
+func TestBufferModeNotFound(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			bufferMode := GetBufferMode(Options{Client: client.Options{MaxRetries: 3}})
+			_, _, err := bufferMode.Fetch(context.Background(), server.URL+"/missing")
+			assert.ErrorIs(t, err, ErrFileNotFound)
+			assert.ErrorIs(t, err, ErrUnexpectedHTTPStatus)
+			assert.Equal(t, 1, requests)
+		})
+	}
+}
+
+// unknownTotalServer serves content like a server which omits the total size from Content-Range
+func unknownTotalServer(t *testing.T, content []byte, serveHead bool) *httptest.Server {
+	fileServer := newTestServer(t, content)
+	t.Cleanup(fileServer.Close)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && !serveHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rec := httptest.NewRecorder()
+		fileServer.Config.Handler.ServeHTTP(rec, r)
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		if contentRange := rec.Header().Get("Content-Range"); contentRange != "" {
+			w.Header().Set("Content-Range", contentRange[:strings.LastIndex(contentRange, "/")]+"/*")
+		}
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(rec.Body.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBufferModeLenientContentRange(t *testing.T) {
+	tc := []struct {
+		name      string
+		size      int64
+		serveHead bool
+	}{
+		{name: "single chunk", size: 1000},
+		{name: "exact chunk", size: 4096},
+		{name: "head", size: 10000, serveHead: true},
+		{name: "probe", size: 10000},
+		{name: "probe power of two", size: 16384},
+	}
+	for _, tc := range tc {
+		t.Run(tc.name, func(t *testing.T) {
+			content := generateTestContent(tc.size)
+			server := unknownTotalServer(t, content, tc.serveHead)
+
+			strict := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 4096})
+			_, _, err := strict.Fetch(context.Background(), server.URL+"/"+testFilePath)
+			assert.ErrorContains(t, err, "couldn't parse Content-Range")
+
+			bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 4096, LenientContentRange: true})
+			reader, size, err := bufferMode.Fetch(context.Background(), server.URL+"/"+testFilePath)
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tc.size, size)
+			assert.Equal(t, content, data)
+		})
+	}
+}
+
+func TestBufferModeContentLengthMismatch(t *testing.T) {
+	mockTransport := httpmock.NewMockTransport()
+	mockTransport.RegisterResponder("GET", "http://test.example/hello.txt",
+		func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(http.StatusPartialContent, "hello")
+			resp.Request = req
+			resp.Header.Add("Content-Range", "bytes 0-4/5")
+			resp.ContentLength = 7
+			return resp, nil
+		})
+
+	bufferMode := GetBufferMode(Options{Client: client.Options{Transport: mockTransport}, ChunkSize: 16})
+	reader, size, err := bufferMode.Fetch(context.Background(), "http://test.example/hello.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+	assert.Equal(t, "hello", string(data))
+
+	strict := GetBufferMode(Options{Client: client.Options{Transport: mockTransport}, ChunkSize: 16, Strict: true})
+	_, _, err = strict.Fetch(context.Background(), "http://test.example/hello.txt")
+	assert.ErrorIs(t, err, ErrContentLengthMismatch)
+}
+
+func TestBufferModeRangeNotSupported(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// ignore the Range header and serve the whole object
+		_, _ = w.Write([]byte("hello, world!"))
+	}))
+	defer server.Close()
+
+	// the download falls back to streaming the whole object over one connection
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 4})
+	reader, size, err := bufferMode.Fetch(context.Background(), server.URL+"/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), size)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(data))
+	// the object is streamed from the response to the first request
+	assert.Equal(t, int64(1), requests.Load())
+	// which is not an error of the host
+	host := strings.TrimPrefix(server.URL, "http://")
+	assert.Zero(t, Stats().HostErrors[host])
+}
+
+func TestBufferModeRangeNotSupportedUnknownSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// flushing before writing makes the response chunked, without a Content-Length
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("hello, world!"))
+	}))
+	defer server.Close()
+
+	bufferMode := GetBufferMode(Options{Client: client.Options{}})
+	_, _, err := bufferMode.Fetch(context.Background(), server.URL+"/hello.txt")
+	assert.ErrorIs(t, err, ErrRangeNotSupported)
+}
+
+func TestBufferModeMaxBufferedBytes(t *testing.T) {
+	content := generateTestContent(10 * 1024)
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 1024, MaxConcurrency: 8, MaxBufferedBytes: 2048})
+	reader, _, err := bufferMode.Fetch(context.Background(), server.URL)
+	require.NoError(t, err)
+	// with the consumer not reading, scheduling pauses once two chunks are buffered
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(2), requests.Load())
+
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.Equal(t, int64(10), requests.Load())
+}
+
+func TestBufferModeFileURL(t *testing.T) {
+	content := generateTestContent(64 * 1024)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	require.NoError(t, os.WriteFile(path, content, 0644))
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 4 * 1024})
+
+	reader, size, err := bufferMode.Fetch(context.Background(), "file://"+filepath.ToSlash(path))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	_, _, err = bufferMode.Fetch(context.Background(), "file://"+filepath.ToSlash(path)+".missing")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
@@ -0,0 +1,51 @@
+package download
+
+import (
+	"sync"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// chunkFallbacks counts the chunks of a file which fell back from the cache to the origin. Once more than
+// Options.CacheFallbackThreshold of them have, the cache is evidently struggling with the file, and the chunks not yet
+// requested go straight to the origin instead of each trying the cache first.
+type chunkFallbacks struct {
+	url       string
+	limit     int
+	mu        sync.Mutex
+	count     int
+	bypassing bool
+}
+
+// newChunkFallbacks returns the tracker for a file of totalChunks chunks. A threshold of zero disables it.
+func newChunkFallbacks(url string, totalChunks int, threshold float64) *chunkFallbacks {
+	limit := -1
+	if threshold > 0 {
+		limit = int(threshold * float64(totalChunks))
+	}
+	return &chunkFallbacks{url: url, limit: limit}
+}
+
+// record records that a chunk fell back to the origin.
+func (c *chunkFallbacks) record() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	if c.limit < 0 || c.bypassing || c.count <= c.limit {
+		return
+	}
+	c.bypassing = true
+	logger := logging.GetLogger()
+	logger.Info().
+		Str("url", c.url).
+		Str("type", "remaining").
+		Int("fallback_chunks", c.count).
+		Msg("consistent hash fallback")
+}
+
+// bypass reports whether the remaining chunks of the file should go straight to the origin.
+func (c *chunkFallbacks) bypass() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bypassing
+}
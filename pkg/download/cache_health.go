@@ -0,0 +1,123 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+const (
+	defaultCacheHealthCheckInterval = 30 * time.Second
+	cacheHealthCheckTimeout         = 5 * time.Second
+)
+
+// cacheHealth probes a health check path on each cache host and tracks which hosts are ready. A host that is not
+// ready is treated the same as a missing SRV record, so that consistent hashing reroutes its slices up front
+// rather than after per-chunk timeouts.
+type cacheHealth struct {
+	client   client.HTTPClient
+	hosts    []string
+	path     string
+	interval time.Duration
+	ready    []atomic.Bool
+	// stopping is closed by stop, and done once the background probing has stopped
+	stopping chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newCacheHealth(opts Options) *cacheHealth {
+	interval := opts.CacheHealthCheckInterval
+	if interval == 0 {
+		interval = defaultCacheHealthCheckInterval
+	}
+	// health checks are not retried, a slow answer is as good as a failure
+	clientOpts := opts.Client
+	clientOpts.MaxRetries = 0
+	return &cacheHealth{
+		client:   client.NewHTTPClient(clientOpts),
+		hosts:    opts.CacheHosts,
+		path:     opts.CacheHealthCheckPath,
+		interval: interval,
+		ready:    make([]atomic.Bool, len(opts.CacheHosts)),
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start probes every host once, blocking until all probes are done, then keeps probing in the background until
+// stop is called.
+func (h *cacheHealth) start() {
+	h.probeAll()
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.probeAll()
+			case <-h.stopping:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops probing the hosts. The hosts keep the readiness they were last probed with. It is safe to call on a
+// nil *cacheHealth.
+func (h *cacheHealth) stop() {
+	if h == nil {
+		return
+	}
+	h.stopOnce.Do(func() { close(h.stopping) })
+}
+
+func (h *cacheHealth) isReady(index int) bool {
+	return h == nil || h.ready[index].Load()
+}
+
+func (h *cacheHealth) probeAll() {
+	wg := new(sync.WaitGroup)
+	for i, host := range h.hosts {
+		if host == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.probe(i, host)
+		}()
+	}
+	wg.Wait()
+}
+
+func (h *cacheHealth) probe(index int, host string) {
+	logger := logging.GetLogger()
+	ctx, cancel := context.WithTimeout(context.Background(), cacheHealthCheckTimeout)
+	defer cancel()
+
+	ready := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+h.path, nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = h.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			ready = resp.StatusCode >= 200 && resp.StatusCode < 300
+		}
+	}
+	if previous := h.ready[index].Swap(ready); previous != ready || !ready {
+		logger.Debug().
+			Str("host", host).
+			Int("bucket", index).
+			Bool("ready", ready).
+			Err(err).
+			Msg("Cache Health Check")
+	}
+}
@@ -0,0 +1,33 @@
+package download
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheHealthStop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	health := newCacheHealth(Options{
+		CacheHosts:               []string{strings.TrimPrefix(server.URL, "http://")},
+		CacheHealthCheckPath:     "/healthz",
+		CacheHealthCheckInterval: time.Millisecond,
+	})
+	health.start()
+	assert.True(t, health.isReady(0))
+
+	health.stop()
+	select {
+	case <-health.done:
+	case <-time.After(time.Second):
+		t.Fatal("health checks still running after stop")
+	}
+	// stopping again is harmless
+	health.stop()
+}
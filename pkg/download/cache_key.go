@@ -0,0 +1,103 @@
+package download
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// A CacheKeyRule normalizes the URL the slices of an object are mapped to cache hosts by (see CacheKey), so that
+// URLs which name the same object, such as signed URLs whose signature changes each time they are signed, map to the
+// same cache hosts. Rules must only discard what doesn't change which object, and which version of it (its ETag),
+// the URL names. Requests are still made for the URL as given.
+type CacheKeyRule interface {
+	// NormalizeCacheKey changes u in place.
+	NormalizeCacheKey(u *url.URL)
+}
+
+// Names of the built-in CacheKeyRules, as used by ParseCacheKeyRules.
+const (
+	CacheKeyStripQuery    = "strip-query"
+	CacheKeyLowercaseHost = "lowercase-host"
+	CacheKeyCanonicalPath = "canonical-path"
+)
+
+// StripQueryRule removes the query parameters named Params, e.g. X-Amz-Signature, or the whole query if Params is
+// empty. The other parameters are kept as they are, in their order.
+type StripQueryRule struct {
+	Params []string
+}
+
+func (r StripQueryRule) NormalizeCacheKey(u *url.URL) {
+	if len(r.Params) == 0 {
+		u.RawQuery, u.ForceQuery = "", false
+		return
+	}
+	var kept []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if pair != "" && !slices.Contains(r.Params, name) {
+			kept = append(kept, pair)
+		}
+	}
+	u.RawQuery = strings.Join(kept, "&")
+}
+
+// LowercaseHostRule lowercases the host, which is case-insensitive.
+type LowercaseHostRule struct{}
+
+func (LowercaseHostRule) NormalizeCacheKey(u *url.URL) {
+	u.Host = strings.ToLower(u.Host)
+}
+
+// CanonicalPathRule removes empty and dot segments from the path, keeping a trailing slash, and encodes it the
+// same way whichever way it was encoded, e.g. /a//./b/%7Ec and /a/b/~c are the same path. An encoded slash (%2F) is
+// a slash, as object stores take it.
+type CanonicalPathRule struct{}
+
+func (CanonicalPathRule) NormalizeCacheKey(u *url.URL) {
+	cleaned := path.Clean("/" + u.Path)
+	if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	u.Path, u.RawPath = cleaned, ""
+}
+
+// ParseCacheKeyRules returns the built-in CacheKeyRules specs name, in order: strip-query, or
+// strip-query=<param>,<param>... for only some parameters, lowercase-host and canonical-path.
+func ParseCacheKeyRules(specs []string) ([]CacheKeyRule, error) {
+	var rules []CacheKeyRule
+	for _, spec := range specs {
+		name, params, hasParams := strings.Cut(spec, "=")
+		switch {
+		case name == CacheKeyStripQuery && !hasParams:
+			rules = append(rules, StripQueryRule{})
+		case name == CacheKeyStripQuery && params != "":
+			rules = append(rules, StripQueryRule{Params: strings.Split(params, ",")})
+		case spec == CacheKeyLowercaseHost:
+			rules = append(rules, LowercaseHostRule{})
+		case spec == CacheKeyCanonicalPath:
+			rules = append(rules, CanonicalPathRule{})
+		default:
+			return nil, fmt.Errorf("unknown cache key rule %q, expected strip-query[=<params>], lowercase-host or canonical-path", spec)
+		}
+	}
+	return rules, nil
+}
+
+// cacheKeyURL returns u normalized by the CacheKeyRules.
+func (o *Options) cacheKeyURL(u *url.URL) *url.URL {
+	if len(o.CacheKeyRules) == 0 {
+		return u
+	}
+	normalized := *u
+	for _, rule := range o.CacheKeyRules {
+		rule.NormalizeCacheKey(&normalized)
+	}
+	return &normalized
+}
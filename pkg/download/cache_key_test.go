@@ -0,0 +1,101 @@
+package download_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+func TestCacheKeyRules(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rule     download.CacheKeyRule
+		url      string
+		expected string
+	}{
+		{"strip named params", download.StripQueryRule{Params: []string{"X-Amz-Signature", "X-Amz-Date"}},
+			"https://bucket.s3.amazonaws.com/model.bin?versionId=3&X-Amz-Date=20260101T000000Z&X-Amz-Signature=abc&b=%20",
+			"https://bucket.s3.amazonaws.com/model.bin?versionId=3&b=%20"},
+		{"strip encoded param names", download.StripQueryRule{Params: []string{"sig nature"}},
+			"https://example.com/model.bin?sig%20nature=abc&a=1", "https://example.com/model.bin?a=1"},
+		{"strip every param", download.StripQueryRule{Params: []string{"sig"}},
+			"https://example.com/model.bin?sig=abc", "https://example.com/model.bin"},
+		{"strip the query", download.StripQueryRule{}, "https://example.com/model.bin?sig=abc&a=1", "https://example.com/model.bin"},
+		{"strip an empty query", download.StripQueryRule{}, "https://example.com/model.bin?", "https://example.com/model.bin"},
+		{"lowercase host", download.LowercaseHostRule{}, "https://Weights.Example.COM:8443/Model.bin", "https://weights.example.com:8443/Model.bin"},
+		{"canonical path", download.CanonicalPathRule{}, "https://example.com/a//./b/../c/%7Emodel.bin", "https://example.com/a/c/~model.bin"},
+		{"canonical path keeps trailing slash", download.CanonicalPathRule{}, "https://example.com/a//b/", "https://example.com/a/b/"},
+		{"canonical empty path", download.CanonicalPathRule{}, "https://example.com", "https://example.com/"},
+		{"canonical encoded slash", download.CanonicalPathRule{}, "https://example.com/a%2Fb", "https://example.com/a/b"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(tc.url)
+			require.NoError(t, err)
+			tc.rule.NormalizeCacheKey(u)
+			assert.Equal(t, tc.expected, u.String())
+		})
+	}
+}
+
+func TestParseCacheKeyRules(t *testing.T) {
+	rules, err := download.ParseCacheKeyRules([]string{"strip-query=X-Amz-Signature,X-Amz-Date", "lowercase-host", "canonical-path", "strip-query"})
+	require.NoError(t, err)
+	assert.Equal(t, []download.CacheKeyRule{
+		download.StripQueryRule{Params: []string{"X-Amz-Signature", "X-Amz-Date"}},
+		download.LowercaseHostRule{},
+		download.CanonicalPathRule{},
+		download.StripQueryRule{},
+	}, rules)
+
+	rules, err = download.ParseCacheKeyRules(nil)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	for _, spec := range []string{"strip-query=", "lowercase-host=true", "sort-query"} {
+		_, err := download.ParseCacheKeyRules([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestConsistentHashingCacheKeyRules(t *testing.T) {
+	const hosts = 16
+	cacheHosts := make([]string, hosts)
+	for i := range cacheHosts {
+		cacheHosts[i] = "cache-host"
+	}
+	opts := download.Options{
+		ChunkSize:            4,
+		SliceSize:            4,
+		CacheHosts:           cacheHosts,
+		CacheableURIPrefixes: makeCacheableURIPrefixes("https://bucket.s3.amazonaws.com"),
+	}
+	signed := []string{
+		"https://bucket.s3.amazonaws.com/model.bin?X-Amz-Date=20260101T000000Z&X-Amz-Signature=abc",
+		"https://bucket.s3.amazonaws.com/model.bin?X-Amz-Date=20260102T000000Z&X-Amz-Signature=def",
+	}
+	buckets := func(strategy *download.ConsistentHashingMode, url string) []int {
+		slices, err := strategy.PlaceSlices(url, 64)
+		require.NoError(t, err)
+		var buckets []int
+		for _, slice := range slices {
+			buckets = append(buckets, slice.Bucket)
+		}
+		return buckets
+	}
+
+	// by default every signature is another object
+	strategy, err := download.GetConsistentHashingMode(opts)
+	require.NoError(t, err)
+	assert.NotEqual(t, buckets(strategy, signed[0]), buckets(strategy, signed[1]))
+
+	opts.CacheKeyRules = []download.CacheKeyRule{download.StripQueryRule{Params: []string{"X-Amz-Date", "X-Amz-Signature"}}}
+	strategy, err = download.GetConsistentHashingMode(opts)
+	require.NoError(t, err)
+	assert.Equal(t, buckets(strategy, signed[0]), buckets(strategy, signed[1]))
+	assert.Equal(t, buckets(strategy, "https://bucket.s3.amazonaws.com/model.bin"), buckets(strategy, signed[0]))
+}
@@ -0,0 +1,93 @@
+package download
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A CacheRewriter rewrites a request for an origin URL into the form a pull-through cache server expects, so that
+// cache implementations other than the default can be supported. The consistent hashing strategy calls it before
+// pointing the request at the cache host chosen for it (see Options.CacheRewriter); the Host header is always that
+// of the origin.
+type CacheRewriter interface {
+	RewriteCacheRequest(req *http.Request) error
+}
+
+// Names of the built-in CacheRewriters, as used by ParseCacheRewriter.
+const (
+	CacheRewriteHost       = "host"
+	CacheRewriteHostPrefix = "host-prefix"
+	CacheRewriteBase64URL  = "base64-url"
+	CacheRewriteHeader     = "header"
+)
+
+// HostRewriter leaves the path unchanged, so that the cache relies on the Host header to find the origin. This is
+// the default.
+type HostRewriter struct{}
+
+func (HostRewriter) RewriteCacheRequest(*http.Request) error {
+	return nil
+}
+
+// HostPrefixRewriter prepends the lowercase origin host to the path, e.g. /example.com/file.bin (the path proxy
+// mechanism).
+type HostPrefixRewriter struct{}
+
+func (HostPrefixRewriter) RewriteCacheRequest(req *http.Request) error {
+	newPath, err := url.JoinPath(strings.ToLower(req.URL.Host), req.URL.Path)
+	if err != nil {
+		return err
+	}
+	// Ensure we have a leading slash, things get weird (especially in testing) if we do not.
+	req.URL.Path = fmt.Sprintf("/%s", newPath)
+	return nil
+}
+
+// Base64URLRewriter replaces the path and query with the whole origin URL, base64url-encoded without padding, e.g.
+// /aHR0cHM6Ly9leGFtcGxlLmNvbS9maWxlLmJpbg.
+type Base64URLRewriter struct{}
+
+func (Base64URLRewriter) RewriteCacheRequest(req *http.Request) error {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(req.URL.String()))
+	req.URL.Path = "/" + encoded
+	req.URL.RawPath = ""
+	req.URL.RawQuery = ""
+	return nil
+}
+
+// defaultCacheRewriteHeader is the header HeaderRewriter sends the origin URL in if Name is empty.
+const defaultCacheRewriteHeader = "X-Original-URL"
+
+// HeaderRewriter leaves the path unchanged and sends the whole origin URL, including its scheme and query, in a
+// header.
+type HeaderRewriter struct {
+	// Name is the header. If empty, X-Original-URL is used.
+	Name string
+}
+
+func (h HeaderRewriter) RewriteCacheRequest(req *http.Request) error {
+	name := h.Name
+	if name == "" {
+		name = defaultCacheRewriteHeader
+	}
+	req.Header.Set(name, req.URL.String())
+	return nil
+}
+
+// ParseCacheRewriter returns the built-in CacheRewriter called name. An empty name selects HostRewriter.
+func ParseCacheRewriter(name string) (CacheRewriter, error) {
+	switch name {
+	case "", CacheRewriteHost:
+		return HostRewriter{}, nil
+	case CacheRewriteHostPrefix:
+		return HostPrefixRewriter{}, nil
+	case CacheRewriteBase64URL:
+		return Base64URLRewriter{}, nil
+	case CacheRewriteHeader:
+		return HeaderRewriter{}, nil
+	}
+	return nil, fmt.Errorf("unknown cache rewriter %q, expected host, host-prefix, base64-url or header", name)
+}
@@ -0,0 +1,85 @@
+package download_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+func TestCacheRewriters(t *testing.T) {
+	const origin = "https://Example.com/weights/model.bin?sig=abc"
+	testCases := []struct {
+		name         string
+		rewriter     string
+		expectedPath string
+		expectedURL  string
+		header       string
+	}{
+		{"host", download.CacheRewriteHost, "/weights/model.bin", "https://Example.com/weights/model.bin?sig=abc", ""},
+		{"host prefix", download.CacheRewriteHostPrefix, "/example.com/weights/model.bin", "https://Example.com/example.com/weights/model.bin?sig=abc", ""},
+		{"base64 url", download.CacheRewriteBase64URL, "/" + base64.RawURLEncoding.EncodeToString([]byte(origin)), "https://Example.com/" + base64.RawURLEncoding.EncodeToString([]byte(origin)), ""},
+		{"header", download.CacheRewriteHeader, "/weights/model.bin", origin, origin},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rewriter, err := download.ParseCacheRewriter(tc.rewriter)
+			require.NoError(t, err)
+			req, err := http.NewRequest("GET", origin, nil)
+			require.NoError(t, err)
+			require.NoError(t, rewriter.RewriteCacheRequest(req))
+			assert.Equal(t, tc.expectedPath, req.URL.Path)
+			assert.Equal(t, tc.expectedURL, req.URL.String())
+			assert.Equal(t, tc.header, req.Header.Get("X-Original-URL"))
+			assert.Equal(t, "Example.com", req.Host)
+		})
+	}
+
+	rewriter, err := download.ParseCacheRewriter("")
+	require.NoError(t, err)
+	assert.Equal(t, download.HostRewriter{}, rewriter)
+	_, err = download.ParseCacheRewriter("rot13")
+	assert.Error(t, err)
+}
+
+func TestConsistentHashingCacheRewriter(t *testing.T) {
+	const origin = "http://test.replicate.com/hello.txt"
+	// a cache server addressing the origin by the base64url-encoded URL in the path
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil || string(decoded) != origin {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer ts.Close()
+	cacheURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	strategy, err := download.GetConsistentHashingMode(download.Options{
+		Client:               client.Options{},
+		ChunkSize:            3,
+		SliceSize:            3,
+		CacheHosts:           []string{cacheURL.Host},
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://test.replicate.com"),
+		CacheRewriter:        download.Base64URLRewriter{},
+	})
+	require.NoError(t, err)
+	reader, _, err := strategy.Fetch(context.Background(), origin)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+}
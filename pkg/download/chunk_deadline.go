@@ -0,0 +1,130 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// maxChunkReschedules is the number of times a chunk is rescheduled, after which its last attempt has no deadline
+const maxChunkReschedules = 2
+
+// minChunkDeadline is the shortest deadline of a chunk, so that chunks which are quick to download aren't rescheduled
+// over a hiccup. It is a variable for tests.
+var minChunkDeadline = 10 * time.Second
+
+var errChunkDeadline = errors.New("chunk deadline exceeded")
+
+// chunkDeadlines gives the chunks of a download a soft deadline of Options.ChunkDeadlineFactor times as long as they
+// are expected to take at the throughput of its chunks so far, so that a connection which stalls without failing
+// doesn't hold up the whole download: a chunk which misses its deadline is cancelled and rescheduled. Chunks have no
+// deadline until one of the download has been read. A nil chunkDeadlines sets no deadlines.
+type chunkDeadlines struct {
+	factor float64
+
+	mu sync.Mutex
+	// bytes and elapsed add up the chunks read so far, and the time their bodies took to read
+	bytes   int64
+	elapsed time.Duration
+}
+
+func newChunkDeadlines(factor float64) *chunkDeadlines {
+	if factor <= 0 {
+		return nil
+	}
+	return &chunkDeadlines{factor: factor}
+}
+
+// deadline returns the deadline of a chunk of size bytes, or false if there isn't one yet.
+func (d *chunkDeadlines) deadline(size int64) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.bytes == 0 || d.elapsed <= 0 {
+		return 0, false
+	}
+	expected := time.Duration(float64(d.elapsed) * float64(size) / float64(d.bytes))
+	return max(time.Duration(d.factor*float64(expected)), minChunkDeadline), true
+}
+
+func (d *chunkDeadlines) record(bytes int64, elapsed time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bytes += bytes
+	d.elapsed += elapsed
+}
+
+// chunkAttempt is an attempt at downloading a chunk, which is cancelled once it misses its deadline.
+type chunkAttempt struct {
+	deadlines *chunkDeadlines
+	parent    context.Context
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	size      int64
+	deadline  time.Duration
+	timer     *time.Timer
+	started   time.Time
+}
+
+// start starts the attempt at a chunk of size bytes which has been rescheduled rescheduled times. Its requests must
+// be made with the returned context, which is cancelled once the chunk misses its deadline; the attempt must be
+// ended with done.
+func (d *chunkDeadlines) start(ctx context.Context, size int64, rescheduled int) (context.Context, *chunkAttempt) {
+	if d == nil {
+		return ctx, nil
+	}
+	a := &chunkAttempt{deadlines: d, parent: ctx, size: size, started: time.Now()}
+	a.ctx, a.cancel = context.WithCancelCause(ctx)
+	if deadline, ok := d.deadline(size); ok && rescheduled < maxChunkReschedules {
+		a.deadline = deadline
+		a.timer = time.AfterFunc(deadline, func() { a.cancel(errChunkDeadline) })
+	}
+	return a.ctx, a
+}
+
+// responded pauses the deadline once the response has been received, until its body is read (see reading), which
+// with pipelining (see priorityWorkQueue) waits for the worker to finish its current chunk.
+func (a *chunkAttempt) responded() {
+	if a != nil && a.timer != nil {
+		a.timer.Stop()
+	}
+}
+
+// reading restarts the deadline once the body of the response is about to be read.
+func (a *chunkAttempt) reading() {
+	if a == nil {
+		return
+	}
+	if a.timer != nil {
+		a.timer.Reset(a.deadline)
+	}
+	a.started = time.Now()
+}
+
+// done ends the attempt, which read n bytes of the chunk and failed with err, if not nil. It reports whether the
+// attempt missed its deadline, in which case the chunk should be rescheduled.
+func (a *chunkAttempt) done(n int, err error) bool {
+	if a == nil {
+		return false
+	}
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	missed := err != nil && a.parent.Err() == nil && errors.Is(context.Cause(a.ctx), errChunkDeadline)
+	a.cancel(nil)
+	if err == nil && n > 0 {
+		a.deadlines.record(int64(n), time.Since(a.started))
+	}
+	if missed {
+		logger := logging.GetLogger()
+		logger.Warn().
+			Int64("size", a.size).
+			Int("read", n).
+			Dur("deadline", a.deadline).
+			Msg("Chunk Deadline Exceeded, Rescheduling")
+		recordRetry(a.parent)
+	}
+	return missed
+}
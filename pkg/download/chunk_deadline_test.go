@@ -0,0 +1,129 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkDeadline(t *testing.T) {
+	assert.Nil(t, newChunkDeadlines(0))
+	deadlines := newChunkDeadlines(3)
+	_, ok := deadlines.deadline(100)
+	assert.False(t, ok, "no deadline before a chunk has been read")
+
+	deadlines.record(1000, 10*time.Second)
+	deadline, ok := deadlines.deadline(2000)
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Second, deadline)
+	deadline, _ = deadlines.deadline(10)
+	assert.Equal(t, minChunkDeadline, deadline)
+}
+
+func TestChunkAttempt(t *testing.T) {
+	defer func(d time.Duration) { minChunkDeadline = d }(minChunkDeadline)
+	minChunkDeadline = 10 * time.Millisecond
+
+	// without chunkDeadlines there are no deadlines
+	var none *chunkDeadlines
+	ctx, attempt := none.start(context.Background(), 100, 0)
+	attempt.reading()
+	assert.False(t, attempt.done(0, errors.New("failed")))
+	assert.NoError(t, ctx.Err())
+
+	deadlines := newChunkDeadlines(1)
+	deadlines.record(100, time.Millisecond)
+	ctx, attempt = deadlines.start(context.Background(), 100, 0)
+	<-ctx.Done()
+	assert.True(t, attempt.done(0, ctx.Err()))
+
+	// a failure other than the deadline isn't rescheduled
+	ctx, attempt = deadlines.start(context.Background(), 100, 0)
+	assert.False(t, attempt.done(0, errors.New("failed")))
+	assert.Error(t, ctx.Err())
+
+	// neither is a cancelled download
+	parent, cancel := context.WithCancel(context.Background())
+	ctx, attempt = deadlines.start(parent, 100, 0)
+	cancel()
+	<-ctx.Done()
+	assert.False(t, attempt.done(0, ctx.Err()))
+
+	// the last attempt has no deadline
+	ctx, attempt = deadlines.start(context.Background(), 100, maxChunkReschedules)
+	time.Sleep(5 * minChunkDeadline)
+	assert.NoError(t, ctx.Err())
+	assert.False(t, attempt.done(100, nil))
+}
+
+// stallingServer serves content, except that the first request for the chunk at offset 40, across every server
+// sharing stalled, gets the response headers but never the body.
+func stallingServer(t *testing.T, content []byte, stalled *atomic.Bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=40-") && stalled.CompareAndSwap(false, true) {
+			w.Header().Set("Content-Range", "bytes 40-47/64")
+			w.Header().Set("Content-Length", "8")
+			w.WriteHeader(http.StatusPartialContent)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(w, r, testFilePath, time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBufferModeReschedulesStalledChunk(t *testing.T) {
+	defer func(d time.Duration) { minChunkDeadline = d }(minChunkDeadline)
+	minChunkDeadline = 50 * time.Millisecond
+
+	content := generateTestContent(64)
+	var stalled atomic.Bool
+	server := stallingServer(t, content, &stalled)
+
+	strategy := GetBufferMode(Options{ChunkSize: 8, MaxConcurrency: 1, ChunkDeadlineFactor: 2})
+	metadata := &Metadata{}
+	reader, _, err := strategy.Fetch(WithMetadata(context.Background(), metadata), server.URL)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.True(t, stalled.Load())
+	assert.Equal(t, int64(1), metadata.Retries())
+}
+
+func TestStripedModeReschedulesStalledChunk(t *testing.T) {
+	defer func(d time.Duration) { minChunkDeadline = d }(minChunkDeadline)
+	minChunkDeadline = 50 * time.Millisecond
+
+	content := generateTestContent(64)
+	var stalled atomic.Bool
+	primary := stallingServer(t, content, &stalled)
+	mirror := stallingServer(t, content, &stalled)
+
+	strategy := GetStripedMode(Options{
+		ChunkSize:           8,
+		MaxConcurrency:      1,
+		ChunkDeadlineFactor: 2,
+		Mirrors:             []string{mirror.URL},
+	})
+	metadata := &Metadata{}
+	reader, _, err := strategy.Fetch(WithMetadata(context.Background(), metadata), primary.URL)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.True(t, stalled.Load())
+	assert.Equal(t, int64(1), metadata.Retries())
+}
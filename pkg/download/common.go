@@ -11,20 +11,38 @@ import (
 
 	"github.com/dustin/go-humanize"
 
-	"github.com/replicate/pget/pkg/client"
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
 )
 
 const defaultChunkSize = 125 * humanize.MiByte
 
 var (
-	contentRangeRegexp = regexp.MustCompile(`^bytes .*/([0-9]+)$`)
+	contentRangeRegexp       = regexp.MustCompile(`^bytes .*/([0-9]+)$`)
+	contentRangeWindowRegexp = regexp.MustCompile(`^bytes ([0-9]+)-([0-9]+)/([0-9]+|\*)$`)
+
+	// ErrContentRangeMismatch is returned when a partial content response does not cover the byte window that was
+	// requested.
+	ErrContentRangeMismatch = errors.New("content range mismatch")
+
+	// ErrContentLengthMismatch is returned, if Options.Strict is set, when the Content-Length of a partial content
+	// response disagrees with the window of its Content-Range.
+	ErrContentLengthMismatch = errors.New("content length mismatch")
 
 	errMalformedRangeHeader = errors.New("malformed range header")
 	errMissingRangeHeader   = errors.New("missing range header")
 	errInvalidContentRange  = errors.New("invalid content range")
 )
 
+// ObjectSize returns the size of the whole object from the Content-Range header of a partial content response.
+func ObjectSize(resp *http.Response) (int64, error) {
+	groups := contentRangeRegexp.FindStringSubmatch(resp.Header.Get("Content-Range"))
+	if groups == nil {
+		return -1, fmt.Errorf("couldn't parse Content-Range: %s", resp.Header.Get("Content-Range"))
+	}
+	return strconv.ParseInt(groups[1], 10, 64)
+}
+
 func resumeDownload(req *http.Request, buffer []byte, client client.HTTPClient, bytesReceived int64) (int, error) {
 	var startByte int
 	logger := logging.GetLogger()
@@ -41,11 +59,19 @@ func resumeDownload(req *http.Request, buffer []byte, client client.HTTPClient,
 
 		resp, err := client.Do(req)
 		if err != nil {
+			stats.hostError(req.URL.Host)
 			return int(totalBytesReceived), err
 		}
 		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusPartialContent {
-			return int(totalBytesReceived), fmt.Errorf("expected status code %d, got %d", http.StatusPartialContent, resp.StatusCode)
+		resp.Body = countingBody{resp.Body}
+		if err := checkRangeResponse(req, resp); err != nil {
+			return int(totalBytesReceived), err
+		}
+		// the range header was just set by updateRangeRequestHeader, it is always parseable here
+		start, end, _ := parseRangeHeader(req.Header.Get("Range"))
+		if err := validateContentRange(resp, start, end); err != nil {
+			logContentRangeMismatch(err, req.URL.String(), start, end)
+			return int(totalBytesReceived), err
 		}
 		n, err = io.ReadFull(resp.Body, buffer[startByte:])
 		totalBytesReceived += int64(n)
@@ -71,35 +97,117 @@ func updateRangeRequestHeader(req *http.Request, receivedBytes int64) error {
 		return errMissingRangeHeader
 	}
 
+	start, end, err := parseRangeHeader(rangeHeader)
+	if err != nil {
+		return err
+	}
+
+	start = start + receivedBytes
+	newRangeHeader := fmt.Sprintf("bytes=%d-%d", start, end)
+
+	if start > end {
+		return fmt.Errorf("%w: %s", errInvalidContentRange, newRangeHeader)
+	}
+
+	req.Header.Set("Range", newRangeHeader)
+
+	return nil
+}
+
+// parseRangeHeader parses a Range request header of the form "bytes=start-end".
+func parseRangeHeader(rangeHeader string) (start, end int64, err error) {
 	// Expected format: "bytes=start-end"
 	if !strings.HasPrefix(rangeHeader, "bytes=") {
-		return fmt.Errorf("%w: %s", errMalformedRangeHeader, rangeHeader)
+		return 0, 0, fmt.Errorf("%w: %s", errMalformedRangeHeader, rangeHeader)
 	}
 
 	rangeValues := strings.TrimPrefix(rangeHeader, "bytes=")
 	parts := strings.Split(rangeValues, "-")
 	if len(parts) != 2 {
-		return fmt.Errorf("%w: %s", errMalformedRangeHeader, rangeHeader)
+		return 0, 0, fmt.Errorf("%w: %s", errMalformedRangeHeader, rangeHeader)
 	}
 
-	start, err := strconv.ParseInt(parts[0], 10, 64)
+	start, err = strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		return fmt.Errorf("%w: %s", errMalformedRangeHeader, rangeHeader)
+		return 0, 0, fmt.Errorf("%w: %s", errMalformedRangeHeader, rangeHeader)
 	}
 
-	end, err := strconv.ParseInt(parts[1], 10, 64)
+	end, err = strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
-		return fmt.Errorf("%w: %s", errMalformedRangeHeader, rangeHeader)
+		return 0, 0, fmt.Errorf("%w: %s", errMalformedRangeHeader, rangeHeader)
 	}
+	return start, end, nil
+}
 
-	start = start + receivedBytes
-	newRangeHeader := fmt.Sprintf("bytes=%d-%d", start, end)
-
-	if start > end {
-		return fmt.Errorf("%w: %s", errInvalidContentRange, newRangeHeader)
+// validateContentRange checks that the Content-Range of a partial content response echoes the window that was
+// requested. Some misconfigured servers return the wrong window, which would otherwise silently corrupt the output.
+// The server is allowed to truncate the end of the window to the end of the file.
+func validateContentRange(resp *http.Response, start, end int64) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil
+	}
+	contentRange := resp.Header.Get("Content-Range")
+	groups := contentRangeWindowRegexp.FindStringSubmatch(contentRange)
+	if groups == nil {
+		return fmt.Errorf("%w: requested bytes %d-%d, got Content-Range %q", ErrContentRangeMismatch, start, end, contentRange)
+	}
+	respStart, errStart := strconv.ParseInt(groups[1], 10, 64)
+	respEnd, errEnd := strconv.ParseInt(groups[2], 10, 64)
+	if errStart != nil || errEnd != nil {
+		return fmt.Errorf("%w: requested bytes %d-%d, got Content-Range %q", ErrContentRangeMismatch, start, end, contentRange)
+	}
+	expectedEnd := end
+	if groups[3] != "*" {
+		fileSize, err := strconv.ParseInt(groups[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: requested bytes %d-%d, got Content-Range %q", ErrContentRangeMismatch, start, end, contentRange)
+		}
+		if fileSize-1 < expectedEnd {
+			expectedEnd = fileSize - 1
+		}
+	} else if respEnd < expectedEnd {
+		// without a known size we can't tell a truncated final window from a short one
+		expectedEnd = respEnd
 	}
+	if respStart != start || respEnd != expectedEnd {
+		return fmt.Errorf("%w: requested bytes %d-%d, got Content-Range %q", ErrContentRangeMismatch, start, end, contentRange)
+	}
+	return nil
+}
 
-	req.Header.Set("Range", newRangeHeader)
+// chunkLength returns the number of bytes in the body of a partial content response. DoRequest has checked the
+// window of its Content-Range against the request, so if the Content-Length disagrees with it, the window is
+// preferred (and the disagreement logged), unless strict is set, in which case it is an error.
+func chunkLength(resp *http.Response, strict bool) (int64, error) {
+	groups := contentRangeWindowRegexp.FindStringSubmatch(resp.Header.Get("Content-Range"))
+	if groups == nil {
+		return resp.ContentLength, nil
+	}
+	respStart, _ := strconv.ParseInt(groups[1], 10, 64)
+	respEnd, _ := strconv.ParseInt(groups[2], 10, 64)
+	length := respEnd - respStart + 1
+	if resp.ContentLength < 0 || resp.ContentLength == length {
+		return length, nil
+	}
+	if strict {
+		return -1, fmt.Errorf("%w: %s has Content-Length %d but Content-Range %s", ErrContentLengthMismatch, resp.Request.URL.String(), resp.ContentLength, resp.Header.Get("Content-Range"))
+	}
+	logger := logging.GetLogger()
+	logger.Warn().
+		Str("url", resp.Request.URL.String()).
+		Int64("content_length", resp.ContentLength).
+		Str("content_range", resp.Header.Get("Content-Range")).
+		Int64("length", length).
+		Msg("Content-Length disagrees with Content-Range, using the Content-Range")
+	return length, nil
+}
 
-	return nil
+func logContentRangeMismatch(err error, url string, start, end int64) {
+	logger := logging.GetLogger()
+	logger.Error().
+		Err(err).
+		Str("url", url).
+		Int64("start", start).
+		Int64("end", end).
+		Msg("Content-Range Mismatch: server returned the wrong byte window")
 }
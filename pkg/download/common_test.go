@@ -98,13 +98,13 @@ func TestResumeDownload(t *testing.T) {
 							return &http.Response{
 								StatusCode: http.StatusPartialContent,
 								Body:       io.NopCloser(bytes.NewReader([]byte("56789"))),
-								Header:     http.Header{"Content-Range": []string{"bytes 15-20/21"}},
+								Header:     http.Header{"Content-Range": []string{"bytes 15-19/20"}},
 							}, nil
 						case "bytes=13-19":
 							return &http.Response{
 								StatusCode: http.StatusPartialContent,
 								Body:       io.NopCloser(bytes.NewReader([]byte("34"))),
-								Header:     http.Header{"Content-Range": []string{"bytes 13-20/21"}},
+								Header:     http.Header{"Content-Range": []string{"bytes 13-19/20"}},
 							}, nil
 						}
 					}
@@ -188,3 +188,39 @@ func TestUpdateRangeRequestHeader(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateContentRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		contentRange  string
+		start         int64
+		end           int64
+		expectedError error
+	}{
+		{"exact window", http.StatusPartialContent, "bytes 0-9/100", 0, 9, nil},
+		{"truncated at end of file", http.StatusPartialContent, "bytes 90-99/100", 90, 109, nil},
+		{"unknown size", http.StatusPartialContent, "bytes 10-19/*", 10, 19, nil},
+		{"not partial content", http.StatusOK, "", 0, 9, nil},
+		{"wrong start", http.StatusPartialContent, "bytes 10-19/100", 0, 9, ErrContentRangeMismatch},
+		{"wrong end", http.StatusPartialContent, "bytes 0-19/100", 0, 9, ErrContentRangeMismatch},
+		{"short window", http.StatusPartialContent, "bytes 0-4/100", 0, 9, ErrContentRangeMismatch},
+		{"missing header", http.StatusPartialContent, "", 0, 9, ErrContentRangeMismatch},
+		{"malformed header", http.StatusPartialContent, "bytes */100", 0, 9, ErrContentRangeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.contentRange != "" {
+				resp.Header.Set("Content-Range", tt.contentRange)
+			}
+			err := validateContentRange(resp, tt.start, tt.end)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package download
+
+import (
+	"cmp"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// acceptEncodings is the Accept-Encoding header sent with Options.Compressed, in order of preference.
+const acceptEncodings = "zstd, gzip"
+
+// ErrContentEncodingMismatch is returned when a chunk of a compressed download is not encoded as the first chunk
+// was, or the server responds with a content coding which was not requested.
+var ErrContentEncodingMismatch = errors.New("content encoding mismatch")
+
+type contentCodingKey struct{}
+
+// contentCoding is the content coding negotiation of a download with Options.Compressed.
+type contentCoding struct {
+	// accept is the Accept-Encoding header to send: acceptEncodings until the first response arrives, and then its
+	// content coding (identity if it had none), which every other response must have
+	accept     string
+	negotiated bool
+	// decodedSize is the size of the object without content coding
+	decodedSize int64
+}
+
+func withContentCoding(ctx context.Context, coding contentCoding) context.Context {
+	return context.WithValue(ctx, contentCodingKey{}, coding)
+}
+
+func contentCodingFrom(ctx context.Context) (contentCoding, bool) {
+	coding, ok := ctx.Value(contentCodingKey{}).(contentCoding)
+	return coding, ok
+}
+
+// negotiateContentCoding returns a context requesting compressed responses for the download of url, if its size
+// without content coding can be discovered with a HEAD request. Chunks are counted against the encoded size, which
+// is all the responses report, but the download as a whole is accounted for with the decoded size.
+func negotiateContentCoding(ctx context.Context, c client.HTTPClient, url string) (context.Context, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return ctx, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := c.Do(req)
+	if err != nil {
+		return ctx, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ctx, fmt.Errorf("%w %s: %s", ErrUnexpectedHTTPStatus, url, resp.Status)
+	}
+	if resp.ContentLength < 0 || resp.Header.Get("Content-Encoding") != "" {
+		return ctx, fmt.Errorf("HEAD %s did not report the size of the uncompressed object", url)
+	}
+	return withContentCoding(ctx, contentCoding{accept: acceptEncodings, decodedSize: resp.ContentLength}), nil
+}
+
+// setAcceptEncoding sets the Accept-Encoding header of req if its context negotiates a content coding. Setting it
+// also stops http.Transport from requesting gzip, and decoding the response, itself.
+func setAcceptEncoding(req *http.Request) {
+	if coding, ok := contentCodingFrom(req.Context()); ok {
+		req.Header.Set("Accept-Encoding", coding.accept)
+	}
+}
+
+// responseContentCoding returns the content coding of resp, "" if it has none, checking it against what was
+// requested.
+func responseContentCoding(req *http.Request, resp *http.Response) (string, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		encoding = ""
+	}
+	coding, ok := contentCodingFrom(req.Context())
+	switch {
+	case !ok, encoding == "" && !coding.negotiated:
+		return encoding, nil
+	case coding.negotiated:
+		if expected := strings.TrimPrefix(coding.accept, "identity"); encoding != expected {
+			return "", fmt.Errorf("%w: %s responded with Content-Encoding %q, expected %s", ErrContentEncodingMismatch, req.URL.String(), encoding, coding.accept)
+		}
+	case encoding != "zstd" && encoding != "gzip":
+		return "", fmt.Errorf("%w: %s responded with unsupported Content-Encoding %q", ErrContentEncodingMismatch, req.URL.String(), encoding)
+	}
+	return encoding, nil
+}
+
+// withNegotiatedCoding returns ctx with the content coding of a download settled to encoding, the content coding of
+// its first response, if it requested compression.
+func withNegotiatedCoding(ctx context.Context, encoding string) context.Context {
+	coding, ok := contentCodingFrom(ctx)
+	if !ok {
+		return ctx
+	}
+	coding.accept, coding.negotiated = cmp.Or(encoding, "identity"), true
+	return withContentCoding(ctx, coding)
+}
+
+// decodeContent returns the reader and size of a download whose content, of size bytes, is read from r: if the
+// download negotiated a content coding, r is decoded and the size is that of the decoded content.
+func decodeContent(ctx context.Context, url string, r io.Reader, size int64) (io.Reader, int64, error) {
+	coding, ok := contentCodingFrom(ctx)
+	if !ok || !coding.negotiated || coding.accept == "identity" {
+		return r, size, nil
+	}
+	logger := logging.GetLogger()
+	logger.Debug().
+		Str("url", url).
+		Str("encoding", coding.accept).
+		Int64("encoded_size", size).
+		Int64("size", coding.decodedSize).
+		Msg("Compressed transfer")
+	return newDecodingReader(coding.accept, r), coding.decodedSize, nil
+}
+
+// decodingReader decodes the content of a download from its content coding. The decoder is created on the first
+// read, so that creating the reader doesn't wait for the first bytes, and released once the content is read.
+type decodingReader struct {
+	encoding string
+	src      io.Reader
+	dec      io.Reader
+	close    func()
+	err      error
+}
+
+func newDecodingReader(encoding string, src io.Reader) *decodingReader {
+	return &decodingReader{encoding: encoding, src: src}
+}
+
+func (r *decodingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.dec == nil {
+		if r.err = r.open(); r.err != nil {
+			return 0, r.err
+		}
+	}
+	n, err := r.dec.Read(p)
+	if err != nil {
+		r.err = err
+		r.close()
+	}
+	return n, err
+}
+
+func (r *decodingReader) open() error {
+	switch r.encoding {
+	case "zstd":
+		dec, err := zstd.NewReader(r.src, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("error decoding zstd content: %w", err)
+		}
+		r.dec, r.close = dec, dec.Close
+	case "gzip":
+		dec, err := gzip.NewReader(r.src)
+		if err != nil {
+			return fmt.Errorf("error decoding gzip content: %w", err)
+		}
+		r.dec, r.close = dec, func() { dec.Close() }
+	default:
+		return fmt.Errorf("%w: unsupported Content-Encoding %q", ErrContentEncodingMismatch, r.encoding)
+	}
+	return nil
+}
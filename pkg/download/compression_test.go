@@ -0,0 +1,115 @@
+package download
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func gzipped(t *testing.T, content []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// precompressedServer serves content, and its gzip encoding with range support to requests accepting gzip, as
+// object stores do for objects stored with a Content-Encoding.
+func precompressedServer(t *testing.T, content []byte, encodedChunks func(r *http.Request) bool) *httptest.Server {
+	encoded := gzipped(t, content)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") && encodedChunks(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(encoded))
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestBufferModeCompressed(t *testing.T) {
+	content := bytes.Repeat([]byte("hello, world! "), 1000)
+	server := precompressedServer(t, content, func(*http.Request) bool { return true })
+	defer server.Close()
+
+	// the encoded object is downloaded in several chunks, and decoded as a whole
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 16, Compressed: true})
+	reader, size, err := bufferMode.Fetch(context.Background(), server.URL+"/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestBufferModeCompressedEncodingMismatch(t *testing.T) {
+	content := bytes.Repeat([]byte("hello, world! "), 1000)
+	server := precompressedServer(t, content, func(r *http.Request) bool {
+		// only the first chunk is encoded
+		return r.Header.Get("Range") == "bytes=0-15"
+	})
+	defer server.Close()
+
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 16, Compressed: true})
+	reader, _, err := bufferMode.Fetch(context.Background(), server.URL+"/hello.txt")
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, ErrContentEncodingMismatch)
+}
+
+func TestBufferModeCompressedOnTheFly(t *testing.T) {
+	content := bytes.Repeat([]byte("hello, world! "), 1000)
+	var acceptEncoding atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "14000")
+			return
+		}
+		// compress the whole object, ignoring the Range header
+		acceptEncoding.Store(r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", "zstd")
+		enc, err := zstd.NewWriter(w)
+		require.NoError(t, err)
+		_, _ = enc.Write(content)
+		_ = enc.Close()
+	}))
+	defer server.Close()
+
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 16, Compressed: true})
+	reader, size, err := bufferMode.Fetch(context.Background(), server.URL+"/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.Equal(t, "zstd, gzip", acceptEncoding.Load())
+}
+
+func TestBufferModeCompressedNotSupported(t *testing.T) {
+	content := []byte("hello, world!")
+	server := precompressedServer(t, content, func(*http.Request) bool { return false })
+	defer server.Close()
+
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 4, Compressed: true})
+	reader, size, err := bufferMode.Fetch(context.Background(), server.URL+"/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
@@ -9,22 +9,35 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/replicate/pget/pkg/client"
-	"github.com/replicate/pget/pkg/config"
-	"github.com/replicate/pget/pkg/consistent"
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/consistent"
+	"github.com/replicate/pget/v2/pkg/logging"
 )
 
+// CacheDeadlineHeader is sent with each request to a cache host when the download has a deadline (such as
+// pget.Options.HardTimeout), with the number of milliseconds left before it, so that the cache host can shed or
+// deprioritize requests whose client will give up anyway.
+const CacheDeadlineHeader = "X-PGet-Deadline"
+
+// CacheHashVersionHeader is sent with each request to a cache host with the consistent.Version which mapped the
+// request's slice to it, so that cache hosts can tell the versions apart while a fleet migrates between them.
+const CacheHashVersionHeader = "X-PGet-Hash-Version"
+
 type ConsistentHashingMode struct {
 	Client client.HTTPClient
 	Options
 	// TODO: allow this to be configured and not just "BufferMode"
 	FallbackStrategy Strategy
 
-	queue *priorityWorkQueue
+	queue   *priorityWorkQueue
+	health  *cacheHealth
+	aliases map[string][]uriAlias
 }
 
+// CacheKey identifies a slice of a file, by its URL normalized by Options.CacheKeyRules. Its cache host is
+// consistent.SliceBucket of the URL and slice, which is also the consistent.HashBucket of the CacheKey.
 type CacheKey struct {
 	URL   *url.URL `hash:"string"`
 	Slice int64
@@ -34,6 +47,25 @@ func GetConsistentHashingMode(opts Options) (*ConsistentHashingMode, error) {
 	if opts.SliceSize == 0 {
 		return nil, fmt.Errorf("must specify slice size in consistent hashing mode")
 	}
+	if opts.CacheHashVersion != 0 {
+		if _, err := consistent.ParseVersion(int(opts.CacheHashVersion)); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CacheHashRollout < 0 || opts.CacheHashRollout > 1 {
+		return nil, fmt.Errorf("cache hash rollout %g is not a fraction between 0 and 1", opts.CacheHashRollout)
+	}
+	// cache hosts are contacted over plaintext HTTP; refuse up front rather than fail every chunk, or every health check
+	for _, host := range opts.CacheHosts {
+		if host != "" && !opts.Client.TransportOpts.HTTPSOnly.AllowsPlaintext(host) {
+			return nil, fmt.Errorf("%w: cache host %s is contacted over HTTP, allow it with --https-only-allowed-host", client.ErrPlaintextNotAllowed, host)
+		}
+	}
+	opts = opts.withLowMemoryLimits()
+	if opts.CachePrewarmConnections > 0 && opts.Client.Transport == nil {
+		// the prewarmed connections are pooled by the transport, so every client has to share one
+		opts.Client.Transport = client.NewTransport(opts.Client.TransportOpts)
+	}
 	client := client.NewHTTPClient(opts.Client)
 
 	fallbackStrategy := &BufferMode{
@@ -45,13 +77,25 @@ func GetConsistentHashingMode(opts Options) (*ConsistentHashingMode, error) {
 		Client:           client,
 		Options:          opts,
 		FallbackStrategy: fallbackStrategy,
+		aliases:          indexURIAliases(opts.CacheURIAliases),
 	}
-	m.queue = newWorkQueue(opts.maxConcurrency(), m.chunkSize())
+	m.queue = newStrategyQueue(opts, m.chunkSize())
 	m.queue.start()
 	fallbackStrategy.queue = m.queue
+	if opts.CacheHealthCheckPath != "" {
+		m.health = newCacheHealth(opts)
+		m.health.start()
+	}
+	prewarmConnections(opts, m.health)
 	return m, nil
 }
 
+// Close stops the background health checks of the cache hosts (see Options.CacheHealthCheckPath).
+func (m *ConsistentHashingMode) Close() error {
+	m.health.stop()
+	return nil
+}
+
 func (m *ConsistentHashingMode) chunkSize() int64 {
 	chunkSize := m.ChunkSize
 	if chunkSize == 0 {
@@ -63,14 +107,6 @@ func (m *ConsistentHashingMode) chunkSize() int64 {
 	return chunkSize
 }
 
-func (m *ConsistentHashingMode) getFileSizeFromContentRange(contentRange string) (int64, error) {
-	groups := contentRangeRegexp.FindStringSubmatch(contentRange)
-	if groups == nil {
-		return -1, fmt.Errorf("couldn't parse Content-Range: %s", contentRange)
-	}
-	return strconv.ParseInt(groups[1], 10, 64)
-}
-
 func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io.Reader, int64, error) {
 	logger := logging.GetLogger()
 
@@ -78,15 +114,24 @@ func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io
 	if err != nil {
 		return nil, -1, err
 	}
-	shouldContinue := false
-	if prefixes, ok := m.CacheableURIPrefixes[parsed.Host]; ok {
-		for _, pfx := range prefixes {
-			if pfx.Path == "/" || strings.HasPrefix(parsed.Path, pfx.Path) {
-				shouldContinue = true
-				break
-			}
-		}
+	if resolved, ok := resolveURIAlias(m.aliases, parsed); ok {
+		logger.Debug().
+			Str("url", urlString).
+			Str("resolved_url", resolved.String()).
+			Msg("URI alias")
+		parsed = resolved
+		urlString = resolved.String()
 	}
+	shouldContinue := m.cacheable(parsed)
+	escalation := escalationFrom(ctx)
+	if shouldContinue && escalation.Escalated() {
+		logger.Debug().
+			Str("url", urlString).
+			Str("reason", "download escalated").
+			Msg("fallback strategy")
+		return m.FallbackStrategy.Fetch(ctx, urlString)
+	}
+
 	// Use our fallback mode if we're not downloading from a consistent-hashing enabled domain
 	if !shouldContinue {
 		logger.Debug().
@@ -96,25 +141,35 @@ func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io
 		return m.FallbackStrategy.Fetch(ctx, urlString)
 	}
 
+	ctx = withFlow(ctx)
+	escalation.onEscalate(func() { m.queue.escalate(ctx) })
+	tracker := newSliceTracker(urlString, m.OnSliceComplete)
+	digests := newSliceDigests(urlString)
 	firstChunk := newReaderPromise()
 	firstReqResultCh := make(chan firstReqResult)
-	m.queue.submitLow(func(buf []byte) {
+	m.queue.submitLow(ctx, func(buf []byte) {
 		defer close(firstReqResultCh)
-		firstChunkResp, err := m.DoRequest(ctx, 0, m.chunkSize()-1, urlString)
+		firstChunkResp, cacheHost, err := m.doRequest(ctx, 0, m.chunkSize()-1, urlString)
 		if err != nil {
 			firstReqResultCh <- firstReqResult{err: err}
 			return
 		}
 		defer firstChunkResp.Body.Close()
 
-		fileSize, err := m.getFileSizeFromContentRange(firstChunkResp.Header.Get("Content-Range"))
+		fileSize, err := objectSize(ctx, m.Client, firstChunkResp, m.LenientContentRange)
+		if err != nil {
+			firstReqResultCh <- firstReqResult{err: err}
+			return
+		}
+		contentLength, err := chunkLength(firstChunkResp, m.Strict)
 		if err != nil {
 			firstReqResultCh <- firstReqResult{err: err}
 			return
 		}
+		recordMetadata(ctx, firstChunkResp)
+		digests.record(0, cacheHost, firstChunkResp)
 		firstReqResultCh <- firstReqResult{fileSize: fileSize}
 
-		contentLength := firstChunkResp.ContentLength
 		n, err := io.ReadFull(firstChunkResp.Body, buf[0:contentLength])
 		if err == io.ErrUnexpectedEOF {
 			logger.Warn().
@@ -122,6 +177,7 @@ func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io
 				Msg("Resuming Chunk Download")
 			n, err = resumeDownload(firstChunkResp.Request, buf[n:contentLength], m.Client, int64(n))
 		}
+		tracker.chunkDone(0, cacheHost, err)
 		firstChunk.Deliver(buf[0:n], err)
 	})
 	firstReqResult, ok := <-firstReqResultCh
@@ -139,6 +195,7 @@ func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io
 				Str("type", "file").
 				Err(err).
 				Msg("consistent hash fallback")
+			recordFallback(ctx)
 			return m.FallbackStrategy.Fetch(ctx, urlString)
 		}
 		return nil, -1, firstReqResult.err
@@ -147,7 +204,8 @@ func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io
 
 	if fileSize <= m.chunkSize() {
 		// we only need a single chunk: just download it and finish
-		return firstChunk, fileSize, nil
+		tracker.expect(0, 0, fileSize-1, 1)
+		return digests.verifier(0, firstChunk), fileSize, nil
 	}
 
 	totalSlices := fileSize / m.SliceSize
@@ -157,6 +215,7 @@ func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io
 
 	readers := make([]io.Reader, 0)
 	slices := make([][]*readerPromise, totalSlices)
+	totalChunks := 0
 	logger.Debug().Str("url", urlString).
 		Int64("size", fileSize).
 		Int("concurrency", m.maxConcurrency()).
@@ -169,7 +228,10 @@ func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io
 		}
 		// integer divide rounding up
 		numChunks := int(((sliceSize - 1) / m.chunkSize()) + 1)
+		tracker.expect(int64(slice), m.SliceSize*int64(slice), m.SliceSize*int64(slice)+sliceSize-1, numChunks)
 		chunks := make([]*readerPromise, numChunks)
+		sliceReaders := make([]io.Reader, numChunks)
+		totalChunks += numChunks
 		for i := 0; i < numChunks; i++ {
 			var chunk *readerPromise
 			if slice == 0 && i == 0 {
@@ -178,16 +240,29 @@ func (m *ConsistentHashingMode) Fetch(ctx context.Context, urlString string) (io
 				chunk = newReaderPromise()
 			}
 			chunks[i] = chunk
-			readers = append(readers, chunk)
+			sliceReaders[i] = chunk
 		}
 		slices[slice] = chunks
+		readers = append(readers, digests.verifier(int64(slice), io.MultiReader(sliceReaders...)))
 	}
-	go m.downloadRemainingChunks(ctx, urlString, slices)
+	fallbacks := newChunkFallbacks(urlString, totalChunks, m.CacheFallbackThreshold)
+	go m.downloadRemainingChunks(ctx, urlString, slices, tracker, digests, fallbacks)
 	return io.MultiReader(readers...), fileSize, nil
 }
 
-func (m *ConsistentHashingMode) downloadRemainingChunks(ctx context.Context, urlString string, slices [][]*readerPromise) {
+// cacheable reports whether u is under one of the CacheableURIPrefixes, so that it is downloaded from the cache hosts.
+func (m *ConsistentHashingMode) cacheable(u *url.URL) bool {
+	for _, pfx := range m.CacheableURIPrefixes[u.Host] {
+		if pfx.Path == "/" || strings.HasPrefix(u.Path, pfx.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ConsistentHashingMode) downloadRemainingChunks(ctx context.Context, urlString string, slices [][]*readerPromise, tracker *sliceTracker, digests *sliceDigests, fallbacks *chunkFallbacks) {
 	logger := logging.GetLogger()
+	deadlines := newChunkDeadlines(m.ChunkDeadlineFactor)
 	for slice, sliceChunks := range slices {
 		sliceStart := m.SliceSize * int64(slice)
 		sliceEnd := m.SliceSize*int64(slice+1) - 1
@@ -196,42 +271,76 @@ func (m *ConsistentHashingMode) downloadRemainingChunks(ctx context.Context, url
 				// this is the first chunk, already handled above
 				continue
 			}
-			m.queue.submitHigh(func(buf []byte) {
+			m.queue.submitHigh(ctx, func(buf []byte) {
 				chunkStart := sliceStart + int64(i)*m.chunkSize()
 				chunkEnd := chunkStart + m.chunkSize() - 1
 				if chunkEnd > sliceEnd {
 					chunkEnd = sliceEnd
 				}
 
-				logger.Debug().Int64("start", chunkStart).Int64("end", chunkEnd).Msg("starting request")
-				resp, err := m.DoRequest(ctx, chunkStart, chunkEnd, urlString)
-				if err != nil {
-					// in the case that an error indicating an issue with the cache server, networking, etc is returned,
-					// this will use the fallback strategy. This is a case where the whole file will perform the fall-back
-					// for the specified chunk instead of the whole file.
-					if errors.Is(err, client.ErrStrategyFallback) {
-						// TODO(morgan): we should indicate the fallback strategy we're using in the logs
-						logger.Info().
-							Str("url", urlString).
-							Str("type", "chunk").
-							Err(err).
-							Msg("consistent hash fallback")
-						resp, err = m.FallbackStrategy.DoRequest(ctx, chunkStart, chunkEnd, urlString)
+				// attemptChunk downloads the chunk into buf, rescheduled times after it missed its deadline, and
+				// reports whether it missed its deadline again
+				attemptChunk := func(rescheduled int) (int, string, bool, error) {
+					logger.Debug().Int64("start", chunkStart).Int64("end", chunkEnd).Msg("starting request")
+					attemptCtx, attempt := deadlines.start(ctx, chunkEnd-chunkStart+1, rescheduled)
+					var resp *http.Response
+					var cacheHost string
+					var err error
+					switch {
+					case escalationFrom(ctx).Escalated() || fallbacks.bypass():
+						// the download is running late, or too many of its chunks have already fallen back: go
+						// straight to the origin rather than through the cache
+						resp, err = m.FallbackStrategy.DoRequest(attemptCtx, chunkStart, chunkEnd, urlString)
+					case rescheduled > 0:
+						// the cache host was too slow: try the origin instead
+						fallbacks.record()
+						recordFallback(ctx)
+						resp, err = m.FallbackStrategy.DoRequest(attemptCtx, chunkStart, chunkEnd, urlString)
+					default:
+						resp, cacheHost, err = m.doRequest(attemptCtx, chunkStart, chunkEnd, urlString)
 					}
 					if err != nil {
-						chunk.Deliver(nil, err)
-						return
+						// in the case that an error indicating an issue with the cache server, networking, etc is returned,
+						// this will use the fallback strategy. This is a case where the whole file will perform the fall-back
+						// for the specified chunk instead of the whole file.
+						if errors.Is(err, client.ErrStrategyFallback) {
+							// TODO(morgan): we should indicate the fallback strategy we're using in the logs
+							logger.Info().
+								Str("url", urlString).
+								Str("type", "chunk").
+								Err(err).
+								Msg("consistent hash fallback")
+							fallbacks.record()
+							recordFallback(ctx)
+							resp, err = m.FallbackStrategy.DoRequest(attemptCtx, chunkStart, chunkEnd, urlString)
+						}
+						if err != nil {
+							return 0, "", attempt.done(0, err), err
+						}
 					}
+					defer resp.Body.Close()
+					digests.record(int64(slice), cacheHost, resp)
+					contentLength, err := chunkLength(resp, m.Strict)
+					if err != nil {
+						attempt.done(0, nil)
+						return 0, cacheHost, false, err
+					}
+					n, err := io.ReadFull(resp.Body, buf[0:contentLength])
+					if err == io.ErrUnexpectedEOF {
+						logger.Warn().
+							Int("connection_interrupted_at_byte", n).
+							Msg("Resuming Chunk Download")
+						n, err = resumeDownload(resp.Request, buf[n:contentLength], m.Client, int64(n))
+					}
+					return n, cacheHost, attempt.done(n, err), err
 				}
-				defer resp.Body.Close()
-				contentLength := resp.ContentLength
-				n, err := io.ReadFull(resp.Body, buf[0:contentLength])
-				if err == io.ErrUnexpectedEOF {
-					logger.Warn().
-						Int("connection_interrupted_at_byte", n).
-						Msg("Resuming Chunk Download")
-					n, err = resumeDownload(resp.Request, buf[n:contentLength], m.Client, int64(n))
+				n, cacheHost, missed, err := attemptChunk(0)
+				for rescheduled := 1; missed; rescheduled++ {
+					// rescheduled within this item rather than submitted again: the later chunks, which may hold
+					// every worker, are waiting for this one to be read
+					n, cacheHost, missed, err = attemptChunk(rescheduled)
 				}
+				tracker.chunkDone(int64(slice), cacheHost, err)
 				chunk.Deliver(buf[0:n], err)
 			})
 		}
@@ -239,33 +348,64 @@ func (m *ConsistentHashingMode) downloadRemainingChunks(ctx context.Context, url
 }
 
 func (m *ConsistentHashingMode) DoRequest(ctx context.Context, start, end int64, urlString string) (*http.Response, error) {
-	chContext := context.WithValue(ctx, config.ConsistentHashingStrategyKey, true)
-	req, err := http.NewRequestWithContext(chContext, "GET", urlString, nil)
+	resp, _, err := m.doRequest(ctx, start, end, urlString)
+	return resp, err
+}
+
+// doRequest is DoRequest, additionally returning the cache host that served the response.
+func (m *ConsistentHashingMode) doRequest(ctx context.Context, start, end int64, urlString string) (*http.Response, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", urlString, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download %s: %w", req.URL.String(), err)
+		return nil, "", fmt.Errorf("failed to download %s: %w", req.URL.String(), err)
 	}
 	resp, cachePodIndex, err := m.doRequestToCacheHost(req, urlString, start, end)
+	if err == nil {
+		err = m.validateCacheResponse(resp, urlString, start, end)
+	}
 	if err != nil {
-		if errors.Is(err, client.ErrStrategyFallback) {
+		if errors.Is(err, client.ErrStrategyFallback) || errors.Is(err, ErrContentRangeMismatch) {
 			origErr := err
-			req, err := http.NewRequestWithContext(chContext, "GET", urlString, nil)
+			req, err = http.NewRequestWithContext(ctx, "GET", urlString, nil)
 			if err != nil {
-				return nil, fmt.Errorf("failed to download %s: %w", req.URL.String(), err)
+				return nil, "", fmt.Errorf("failed to download %s: %w", req.URL.String(), err)
 			}
 			resp, _, err = m.doRequestToCacheHost(req, urlString, start, end, cachePodIndex)
+			if err == nil {
+				err = m.validateCacheResponse(resp, urlString, start, end)
+			}
 			if err != nil {
+				if errors.Is(origErr, ErrContentRangeMismatch) {
+					// a cache host serving the wrong window is treated like an unavailable one so that
+					// the chunk is fetched from the origin instead
+					return nil, "", fmt.Errorf("%w: %w", client.ErrStrategyFallback, origErr)
+				}
 				// return origErr so that we can use our regular fallback strategy
-				return nil, origErr
+				return nil, "", origErr
 			}
 		} else {
-			return nil, fmt.Errorf("error executing request for %s: %w", req.URL.String(), err)
+			return nil, "", fmt.Errorf("error executing request for %s: %w", req.URL.String(), err)
 		}
 	}
-	if resp.StatusCode == 0 || resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("%w %s: %s", ErrUnexpectedHTTPStatus, req.URL.String(), resp.Status)
+	if err := checkRangeResponse(req, resp); err != nil {
+		stats.hostError(req.URL.Host)
+		return nil, "", err
 	}
+	recordCacheStatus(ctx, resp)
+	resp.Body = countingBody{resp.Body}
+
+	return resp, req.URL.Host, nil
+}
 
-	return resp, nil
+// validateCacheResponse checks that the cache host returned the window we asked for, closing the response body
+// if it did not.
+func (m *ConsistentHashingMode) validateCacheResponse(resp *http.Response, urlString string, start, end int64) error {
+	if err := validateContentRange(resp, start, end); err != nil {
+		stats.hostError(resp.Request.URL.Host)
+		resp.Body.Close()
+		logContentRangeMismatch(err, urlString, start, end)
+		return err
+	}
+	return nil
 }
 
 func (m *ConsistentHashingMode) doRequestToCacheHost(req *http.Request, urlString string, start int64, end int64, previousPodIndexes ...int) (*http.Response, int, error) {
@@ -275,13 +415,27 @@ func (m *ConsistentHashingMode) doRequestToCacheHost(req *http.Request, urlStrin
 		return nil, cachePodIndex, err
 	}
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if deadline, ok := req.Context().Deadline(); ok {
+		req.Header.Set(CacheDeadlineHeader, strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 0), 10))
+	}
 
 	logger.Debug().Str("url", urlString).Str("munged_url", req.URL.String()).Str("host", req.Host).Int64("start", start).Int64("end", end).Msg("request")
 
-	resp, err := m.Client.Do(req)
+	resp, err := client.Do(m.Client, &client.Request{Request: req, StrategyFallback: true})
+	if err != nil {
+		stats.hostError(req.URL.Host)
+	}
 	return resp, cachePodIndex, err
 }
 
+// hashVersion returns the version of the consistent hash mapping the slices of the object at url to cache hosts.
+func (m *ConsistentHashingMode) hashVersion(url string) consistent.Version {
+	if m.CacheHashVersion == 0 {
+		return consistent.HashVersion
+	}
+	return m.CacheHashVersion.ForObject(url, m.CacheHashRollout)
+}
+
 func (m *ConsistentHashingMode) rewriteRequestToCacheHost(req *http.Request, start int64, end int64, previousPodIndexes ...int) (int, error) {
 	logger := logging.GetLogger()
 	if start/m.SliceSize != end/m.SliceSize {
@@ -289,44 +443,42 @@ func (m *ConsistentHashingMode) rewriteRequestToCacheHost(req *http.Request, sta
 	}
 	slice := start / m.SliceSize
 
-	key := CacheKey{URL: req.URL, Slice: slice}
+	key := CacheKey{URL: m.cacheKeyURL(req.URL), Slice: slice}
 
-	cachePodIndex, err := consistent.HashBucket(key, len(m.CacheHosts), previousPodIndexes...)
+	version := m.hashVersion(key.URL.String())
+	cachePodIndex, err := version.SliceBucket(key.URL.String(), slice, len(m.CacheHosts), previousPodIndexes...)
 	if err != nil {
 		return -1, err
 	}
-	if m.CacheUsePathProxy {
-		// prepend the hostname to the start of the path. The consistent-hash nodes will use this to determine the proxy
-		newPath, err := url.JoinPath(strings.ToLower(req.URL.Host), req.URL.Path)
-		if err != nil {
-			return -1, err
-		}
-		// Ensure wr have a leading slash, things get weird (especially in testing) if we do not.
-		req.URL.Path = fmt.Sprintf("/%s", newPath)
+	if err := m.cacheRewriter().RewriteCacheRequest(req); err != nil {
+		return -1, err
 	}
 	cacheHost := m.CacheHosts[cachePodIndex]
-	if cacheHost == "" {
-		// this can happen if an SRV record is missing due to a not-ready pod
+	if cacheHost == "" || !m.health.isReady(cachePodIndex) {
+		// this can happen if an SRV record is missing due to a not-ready pod, or if the pod failed its health check
 		logger.Debug().
 			Str("cache_key", fmt.Sprintf("%+v", key)).
 			Int64("start", start).
 			Int64("end", end).
 			Int64("slice_size", m.SliceSize).
+			Int("hash_version", int(version)).
 			Int("bucket", cachePodIndex).
 			Ints("previous_pod_indexes", previousPodIndexes).
 			Msg("cache host for bucket not ready, falling back")
-		return cachePodIndex, client.ErrStrategyFallback
+		return cachePodIndex, fmt.Errorf("%w: %w", client.ErrStrategyFallback, client.ErrCacheUnreachable)
 	}
 	logger.Debug().
 		Str("cache_key", fmt.Sprintf("%+v", key)).
 		Int64("start", start).
 		Int64("end", end).
 		Int64("slice_size", m.SliceSize).
+		Int("hash_version", int(version)).
 		Int("bucket", cachePodIndex).
 		Ints("previous_pod_indexes", previousPodIndexes).
 		Msg("consistent hashing")
 	req.URL.Scheme = "http"
 	req.URL.Host = cacheHost
+	req.Header.Set(CacheHashVersionHeader, strconv.Itoa(int(version)))
 
 	return cachePodIndex, nil
 }
@@ -2,8 +2,11 @@ package download_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -11,15 +14,18 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
-	"github.com/replicate/pget/pkg/client"
-	"github.com/replicate/pget/pkg/download"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/consistent"
+	"github.com/replicate/pget/v2/pkg/download"
 )
 
 var testFSes = []fstest.MapFS{
@@ -360,6 +366,44 @@ func TestConsistentHashRetries(t *testing.T) {
 	assert.Equal(t, "3344761726165516", string(bytes))
 }
 
+func TestConsistentHashRetriesContentRangeMismatch(t *testing.T) {
+	hostnames, mockTransport := fakeCacheHosts(8, 16)
+	// deliberately "break" one cache host so that it always serves the first byte, whatever range was requested
+	hostnames[0] = "wrong-window-host"
+	mockTransport.RegisterResponder("GET", "http://wrong-window-host/hello.txt",
+		func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(http.StatusPartialContent, "x")
+			resp.Request = req
+			resp.Header.Add("Content-Range", "bytes 0-0/16")
+			resp.ContentLength = 1
+			return resp, nil
+		})
+
+	opts := download.Options{
+		Client:               client.Options{Transport: mockTransport},
+		MaxConcurrency:       8,
+		ChunkSize:            1,
+		CacheHosts:           hostnames,
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+		SliceSize:            1,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	strategy, err := download.GetConsistentHashingMode(opts)
+	require.NoError(t, err)
+
+	reader, _, err := strategy.Fetch(ctx, "http://fake.replicate.delivery/hello.txt")
+	require.NoError(t, err)
+	bytes, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	// slice 0 legitimately lives on the broken host and is served correctly; every other slice
+	// that hashes to it gets rerouted exactly as if the host were down
+	assert.Equal(t, "x344761726165516", string(bytes))
+}
+
 func TestConsistentHashRetriesMissingHostname(t *testing.T) {
 	hostnames, mockTransport := fakeCacheHosts(8, 16)
 
@@ -395,6 +439,150 @@ func TestConsistentHashRetriesMissingHostname(t *testing.T) {
 	assert.Equal(t, "3344761726165516", string(bytes))
 }
 
+func TestConsistentHashingHTTPSOnly(t *testing.T) {
+	hostnames, _ := fakeCacheHosts(8, 16)
+	opts := download.Options{
+		Client: client.Options{
+			TransportOpts: client.TransportOptions{HTTPSOnly: client.HTTPSOnlyOptions{Enabled: true}},
+		},
+		CacheHosts: hostnames,
+		SliceSize:  1,
+	}
+	_, err := download.GetConsistentHashingMode(opts)
+	assert.ErrorIs(t, err, client.ErrPlaintextNotAllowed)
+
+	opts.Client.TransportOpts.HTTPSOnly.AllowedHosts = hostnames
+	_, err = download.GetConsistentHashingMode(opts)
+	assert.NoError(t, err)
+}
+
+func TestConsistentHashRetriesUnhealthyHost(t *testing.T) {
+	hostnames, mockTransport := fakeCacheHosts(8, 16)
+	for i, hostname := range hostnames {
+		status := http.StatusOK
+		if i == 0 {
+			// deliberately fail the health check of this cache host; it would still serve content
+			status = http.StatusServiceUnavailable
+		}
+		mockTransport.RegisterResponder("GET", fmt.Sprintf("http://%s/healthz", hostname), httpmock.NewStringResponder(status, ""))
+	}
+
+	opts := download.Options{
+		Client:               client.Options{Transport: mockTransport},
+		MaxConcurrency:       8,
+		ChunkSize:            1,
+		CacheHosts:           hostnames,
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+		SliceSize:            1,
+		CacheHealthCheckPath: "/healthz",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	strategy, err := download.GetConsistentHashingMode(opts)
+	require.NoError(t, err)
+
+	reader, _, err := strategy.Fetch(ctx, "http://fake.replicate.delivery/hello.txt")
+	require.NoError(t, err)
+	bytes, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	// the unhealthy host is routed around exactly as if its SRV record was missing
+	assert.Equal(t, "3344761726165516", string(bytes))
+	assert.Equal(t, 0, mockTransport.GetCallCountInfo()["GET http://cache-host-0/hello.txt"])
+}
+
+func TestConsistentHashingPrewarmConnections(t *testing.T) {
+	var conns, requests atomic.Int32
+	cacheHost := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// hold the connection, so that every request needs its own
+		time.Sleep(10 * time.Millisecond)
+	}))
+	cacheHost.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	cacheHost.Start()
+	defer cacheHost.Close()
+	u, err := url.Parse(cacheHost.URL)
+	require.NoError(t, err)
+
+	opts := download.Options{
+		Client:                  client.Options{TransportOpts: client.TransportOptions{MaxConnPerHost: 3}},
+		CacheHosts:              []string{u.Host, ""},
+		SliceSize:               1,
+		CachePrewarmConnections: 4,
+	}
+	_, err = download.GetConsistentHashingMode(opts)
+	require.NoError(t, err)
+	// capped by MaxConnPerHost
+	assert.Equal(t, int32(3), conns.Load())
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestConsistentHashingSliceDigest(t *testing.T) {
+	const content = "0123456789abcdef"
+	const sliceSize = 4
+	testCases := []struct {
+		name    string
+		corrupt int64
+		err     error
+	}{
+		{"digests match", -1, nil},
+		{"corrupt slice", 2, download.ErrChecksumMismatch},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockTransport := httpmock.NewMockTransport()
+			hostnames := make([]string, 4)
+			for i := range hostnames {
+				hostnames[i] = fmt.Sprintf("cache-host-%d", i)
+				respond := rangeResponder(200, content)
+				mockTransport.RegisterResponder("GET", fmt.Sprintf("http://%s/hello.txt", hostnames[i]), func(req *http.Request) (*http.Response, error) {
+					resp, err := respond(req)
+					if err != nil {
+						return nil, err
+					}
+					var start int64
+					_, _ = fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-", &start)
+					slice := start / sliceSize
+					sliceContent := []byte(content[slice*sliceSize : min(slice*sliceSize+sliceSize, int64(len(content)))])
+					if slice == tc.corrupt {
+						// the cache host holds a different slice from the one it published the digest of
+						sliceContent[0]++
+					}
+					sum := sha256.Sum256(sliceContent)
+					resp.Header.Set(download.SliceDigestHeader, hex.EncodeToString(sum[:]))
+					return resp, nil
+				})
+			}
+			opts := download.Options{
+				Client:               client.Options{Transport: mockTransport},
+				MaxConcurrency:       4,
+				ChunkSize:            2,
+				CacheHosts:           hostnames,
+				CacheableURIPrefixes: makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+				SliceSize:            sliceSize,
+			}
+			strategy, err := download.GetConsistentHashingMode(opts)
+			require.NoError(t, err)
+
+			reader, _, err := strategy.Fetch(context.Background(), "http://fake.replicate.delivery/hello.txt")
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+		})
+	}
+}
+
 // with only two hosts, we should *always* fall back to the other host
 func TestConsistentHashRetriesTwoHosts(t *testing.T) {
 	hostnames, mockTransport := fakeCacheHosts(2, 16)
@@ -557,7 +745,7 @@ func TestConsistentHashingChunkFallback(t *testing.T) {
 			w.WriteHeader(http.StatusBadGateway)
 		} else {
 			w.Header().Set("Content-Range", "bytes 0-2/4")
-			w.WriteHeader(http.StatusOK)
+			w.WriteHeader(http.StatusPartialContent)
 			_, _ = w.Write([]byte("000"))
 		}
 	}
@@ -615,3 +803,318 @@ func TestConsistentHashingChunkFallback(t *testing.T) {
 		})
 	}
 }
+
+func TestConsistentHashingEscalated(t *testing.T) {
+	hostnames, mockTransport := fakeCacheHosts(8, 3)
+	mockTransport.RegisterResponder("GET", "http://fake.replicate.delivery/hello.txt", rangeResponder(http.StatusOK, "originoriginorig"))
+
+	opts := download.Options{
+		Client:               client.Options{Transport: mockTransport},
+		MaxConcurrency:       8,
+		ChunkSize:            4,
+		CacheHosts:           hostnames,
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+		SliceSize:            8,
+	}
+
+	escalation := download.NewEscalation()
+	escalation.Escalate()
+	ctx := download.WithEscalation(context.Background(), escalation)
+
+	strategy, err := download.GetConsistentHashingMode(opts)
+	require.NoError(t, err)
+	strategy.FallbackStrategy = download.GetBufferMode(opts)
+
+	reader, _, err := strategy.Fetch(ctx, "http://fake.replicate.delivery/hello.txt")
+	require.NoError(t, err)
+	bytes, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, "originoriginorig", string(bytes))
+	assert.Positive(t, mockTransport.GetCallCountInfo()["GET http://fake.replicate.delivery/hello.txt"])
+	for _, hostname := range hostnames {
+		assert.Zero(t, mockTransport.GetCallCountInfo()["GET http://"+hostname+"/hello.txt"])
+	}
+}
+
+func TestConsistentHashingSliceEvents(t *testing.T) {
+	hostnames, mockTransport := fakeCacheHosts(2, 16)
+
+	var mu sync.Mutex
+	events := make(map[int64]download.SliceEvent)
+	opts := download.Options{
+		Client:               client.Options{Transport: mockTransport},
+		MaxConcurrency:       8,
+		ChunkSize:            2,
+		CacheHosts:           hostnames,
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://test.replicate.com"),
+		SliceSize:            3,
+		OnSliceComplete: func(event download.SliceEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, seen := events[event.Slice]
+			assert.False(t, seen, "slice %d reported twice", event.Slice)
+			events[event.Slice] = event
+		},
+	}
+
+	strategy, err := download.GetConsistentHashingMode(opts)
+	require.NoError(t, err)
+
+	reader, _, err := strategy.Fetch(context.Background(), "http://test.replicate.com/hello.txt")
+	require.NoError(t, err)
+	bytes, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "1111110000000000", string(bytes))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, events, 6)
+	for slice, event := range events {
+		expectedHost := hostnames[0]
+		if slice < 2 {
+			expectedHost = hostnames[1]
+		}
+		assert.Equal(t, "http://test.replicate.com/hello.txt", event.URL)
+		assert.Equal(t, slice*3, event.Start)
+		assert.Equal(t, min(slice*3+2, 15), event.End)
+		assert.Equal(t, []string{expectedHost}, event.CacheHosts)
+		assert.False(t, event.Fallback)
+	}
+}
+
+func TestConsistentHashingURIAliases(t *testing.T) {
+	hostnames, mockTransport := fakeCacheHosts(8, 16)
+	mockTransport.RegisterResponder("GET", "http://alias-two.example.com/other/hello.txt", rangeResponder(http.StatusOK, "originoriginorig"))
+
+	opts := download.Options{
+		Client:               client.Options{Transport: mockTransport},
+		MaxConcurrency:       8,
+		ChunkSize:            1,
+		CacheHosts:           hostnames,
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://test.replicate.com"),
+		CacheURIAliases: map[string][]*url.URL{
+			"http://test.replicate.com": helperURLs(t, "http://alias-one.example.com", "http://alias-two.example.com/mirror"),
+		},
+		SliceSize: 3,
+	}
+	strategy, err := download.GetConsistentHashingMode(opts)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		url            string
+		expectedOutput string
+	}{
+		// aliases are hashed like the target, so they hit the same cache hosts as TestConsistentHashing
+		{name: "target", url: "http://test.replicate.com/hello.txt", expectedOutput: "3334446666667776"},
+		{name: "host alias", url: "http://alias-one.example.com/hello.txt", expectedOutput: "3334446666667776"},
+		{name: "path alias", url: "http://alias-two.example.com/mirror/hello.txt", expectedOutput: "3334446666667776"},
+		{name: "outside path alias", url: "http://alias-two.example.com/other/hello.txt", expectedOutput: "originoriginorig"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader, _, err := strategy.Fetch(context.Background(), tc.url)
+			require.NoError(t, err)
+			bytes, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedOutput, string(bytes))
+		})
+	}
+}
+
+func helperURLs(t *testing.T, uris ...string) []*url.URL {
+	t.Helper()
+	var urls []*url.URL
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		require.NoError(t, err)
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+func TestConsistentHashingFallbackThreshold(t *testing.T) {
+	const body = "0123456789abcdef"
+	for _, tc := range []struct {
+		name          string
+		threshold     float64
+		cacheRequests int
+	}{
+		// every chunk but the first tries the cache before the origin
+		{"disabled", 0, 1 + 15},
+		// 4 of 16 chunks may fall back; the 5th trips the threshold and the remaining 10 skip the cache
+		{"quarter", 0.25, 1 + 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mockTransport := httpmock.NewMockTransport()
+			// the cache host serves the first chunk, then fails
+			firstChunk := rangeResponder(200, body)
+			mockTransport.RegisterResponder("GET", "http://cache-host-0/hello.txt", func(req *http.Request) (*http.Response, error) {
+				if req.Header.Get("Range") == "bytes=0-0" {
+					return firstChunk(req)
+				}
+				return httpmock.NewStringResponse(503, "fake broken host"), nil
+			})
+			mockTransport.RegisterResponder("GET", "http://fake.replicate.delivery/hello.txt", rangeResponder(200, body))
+
+			strategy, err := download.GetConsistentHashingMode(download.Options{
+				Client:                 client.Options{Transport: mockTransport},
+				MaxConcurrency:         1,
+				ChunkSize:              1,
+				SliceSize:              1,
+				CacheHosts:             []string{"cache-host-0"},
+				CacheableURIPrefixes:   makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+				CacheFallbackThreshold: tc.threshold,
+			})
+			require.NoError(t, err)
+
+			reader, _, err := strategy.Fetch(context.Background(), "http://fake.replicate.delivery/hello.txt")
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(data))
+			assert.Equal(t, tc.cacheRequests, mockTransport.GetCallCountInfo()["GET http://cache-host-0/hello.txt"])
+		})
+	}
+}
+
+func TestConsistentHashingDeadlineHeader(t *testing.T) {
+	var mu sync.Mutex
+	var deadlines []string
+	mockTransport := httpmock.NewMockTransport()
+	cache := rangeResponder(200, "0123456789")
+	mockTransport.RegisterResponder("GET", "http://cache-host-0/hello.txt", func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		deadlines = append(deadlines, req.Header.Get(download.CacheDeadlineHeader))
+		mu.Unlock()
+		return cache(req)
+	})
+	strategy, err := download.GetConsistentHashingMode(download.Options{
+		Client:               client.Options{Transport: mockTransport},
+		ChunkSize:            5,
+		SliceSize:            5,
+		CacheHosts:           []string{"cache-host-0"},
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+	})
+	require.NoError(t, err)
+
+	fetch := func(ctx context.Context) {
+		reader, _, err := strategy.Fetch(ctx, "http://fake.replicate.delivery/hello.txt")
+		require.NoError(t, err)
+		_, err = io.ReadAll(reader)
+		require.NoError(t, err)
+	}
+
+	fetch(context.Background())
+	assert.Equal(t, []string{"", ""}, deadlines)
+
+	deadlines = nil
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	fetch(ctx)
+	require.Len(t, deadlines, 2)
+	for _, deadline := range deadlines {
+		remaining, err := strconv.Atoi(deadline)
+		require.NoError(t, err)
+		assert.Greater(t, remaining, 50*1000)
+		assert.LessOrEqual(t, remaining, 60*1000)
+	}
+}
+
+func TestConsistentHashingHashVersion(t *testing.T) {
+	const hosts = 4
+	const fileURL = "http://fake.replicate.delivery/hello.txt"
+	var mu sync.Mutex
+	type request struct {
+		host    int
+		slice   int64
+		version string
+	}
+	var requests []request
+	mockTransport := httpmock.NewMockTransport()
+	cache := rangeResponder(200, "0123456789")
+	for host := 0; host < hosts; host++ {
+		mockTransport.RegisterResponder("GET", fmt.Sprintf("http://cache-host-%d/hello.txt", host), func(req *http.Request) (*http.Response, error) {
+			start, _, _ := strings.Cut(strings.TrimPrefix(req.Header.Get("Range"), "bytes="), "-")
+			offset, err := strconv.ParseInt(start, 10, 64)
+			require.NoError(t, err)
+			mu.Lock()
+			requests = append(requests, request{host: host, slice: offset / 2, version: req.Header.Get(download.CacheHashVersionHeader)})
+			mu.Unlock()
+			return cache(req)
+		})
+	}
+	cacheHosts := make([]string, hosts)
+	for host := range cacheHosts {
+		cacheHosts[host] = fmt.Sprintf("cache-host-%d", host)
+	}
+
+	for _, version := range []consistent.Version{0, consistent.V1, consistent.V2} {
+		t.Run(fmt.Sprintf("version %d", version), func(t *testing.T) {
+			requests = nil
+			strategy, err := download.GetConsistentHashingMode(download.Options{
+				Client:               client.Options{Transport: mockTransport},
+				ChunkSize:            2,
+				SliceSize:            2,
+				CacheHosts:           cacheHosts,
+				CacheableURIPrefixes: makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+				CacheHashVersion:     version,
+			})
+			require.NoError(t, err)
+			reader, _, err := strategy.Fetch(context.Background(), fileURL)
+			require.NoError(t, err)
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "0123456789", string(data))
+
+			expectedVersion := version
+			if version == 0 {
+				expectedVersion = consistent.HashVersion
+			}
+			require.Len(t, requests, 5)
+			for _, req := range requests {
+				bucket, err := expectedVersion.SliceBucket(fileURL, req.slice, hosts)
+				require.NoError(t, err)
+				assert.Equal(t, bucket, req.host, "slice %d", req.slice)
+				assert.Equal(t, strconv.Itoa(int(expectedVersion)), req.version)
+			}
+		})
+	}
+
+	_, err := download.GetConsistentHashingMode(download.Options{SliceSize: 2, CacheHashVersion: 3})
+	assert.Error(t, err)
+	_, err = download.GetConsistentHashingMode(download.Options{SliceSize: 2, CacheHashRollout: 1.5})
+	assert.Error(t, err)
+}
+
+func TestConsistentHashingCacheStatus(t *testing.T) {
+	mockTransport := httpmock.NewMockTransport()
+	cache := rangeResponder(200, "0123456789")
+	var requests atomic.Int32
+	mockTransport.RegisterResponder("GET", "http://cache-host-0/hello.txt", func(req *http.Request) (*http.Response, error) {
+		resp, err := cache(req)
+		if err == nil && requests.Add(1) == 1 {
+			resp.Header.Set(download.CacheStatusHeader, "MISS")
+		} else if err == nil {
+			resp.Header.Set(download.CacheStatusHeader, "HIT")
+		}
+		return resp, err
+	})
+	strategy, err := download.GetConsistentHashingMode(download.Options{
+		Client:               client.Options{Transport: mockTransport},
+		ChunkSize:            3,
+		SliceSize:            3,
+		CacheHosts:           []string{"cache-host-0"},
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+	})
+	require.NoError(t, err)
+
+	metadata := &download.Metadata{}
+	reader, _, err := strategy.Fetch(download.WithMetadata(context.Background(), metadata), "http://fake.replicate.delivery/hello.txt")
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), metadata.CacheHits())
+	assert.Equal(t, int64(1), metadata.CacheMisses())
+}
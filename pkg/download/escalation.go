@@ -0,0 +1,94 @@
+package download
+
+import (
+	"context"
+	"sync"
+)
+
+// An Escalation signals that a download is taking longer than expected and that the strategy should trade
+// efficiency for speed for the remainder of the download: consistent hashing stops routing chunks through the
+// cache, and the work queue adds extra workers for the download until the Escalation is closed.
+//
+// Chunks are not hedged, i.e. requested a second time when they are slow: that would hold a second buffer for each
+// hedged chunk, and a stalled chunk is already requested again once it misses its deadline (see
+// Options.ChunkDeadlineFactor).
+type Escalation struct {
+	mu        sync.Mutex
+	escalated bool
+	callbacks []func()
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type escalationKey struct{}
+
+func NewEscalation() *Escalation {
+	return &Escalation{done: make(chan struct{})}
+}
+
+// WithEscalation returns a context which, when passed to Strategy.Fetch, lets e escalate that download.
+func WithEscalation(ctx context.Context, e *Escalation) context.Context {
+	return context.WithValue(ctx, escalationKey{}, e)
+}
+
+func escalationFrom(ctx context.Context) *Escalation {
+	e, _ := ctx.Value(escalationKey{}).(*Escalation)
+	return e
+}
+
+// Escalate triggers the escalation. Only the first call has any effect.
+func (e *Escalation) Escalate() {
+	e.mu.Lock()
+	if e.escalated {
+		e.mu.Unlock()
+		return
+	}
+	e.escalated = true
+	callbacks := e.callbacks
+	e.callbacks = nil
+	e.mu.Unlock()
+	for _, f := range callbacks {
+		f()
+	}
+}
+
+// Close ends the escalation once the download is over, stopping the workers it added. It must be called once the
+// download has been read in full or abandoned.
+func (e *Escalation) Close() {
+	e.closeOnce.Do(func() { close(e.done) })
+}
+
+// closed returns a channel which is closed by Close. It is safe to call on a nil *Escalation, in which case the
+// channel is never closed.
+func (e *Escalation) closed() <-chan struct{} {
+	if e == nil {
+		return nil
+	}
+	return e.done
+}
+
+// Escalated returns true once Escalate has been called. It is safe to call on a nil *Escalation.
+func (e *Escalation) Escalated() bool {
+	if e == nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.escalated
+}
+
+// onEscalate registers f to be called on escalation, or calls it immediately if that already happened. It is safe
+// to call on a nil *Escalation, in which case f is never called.
+func (e *Escalation) onEscalate(f func()) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if !e.escalated {
+		e.callbacks = append(e.callbacks, f)
+		e.mu.Unlock()
+		return
+	}
+	e.mu.Unlock()
+	f()
+}
@@ -0,0 +1,177 @@
+package download
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+type flowKey struct{}
+
+const (
+	// unflowedItems is the flow of the items submitted without one
+	unflowedItems int64 = 0
+	// startingItems is the flow of the items starting a download, which share a flow whichever download they start
+	startingItems int64 = -1
+)
+
+var flowIDs atomic.Int64
+
+// withFlow returns a context whose items are scheduled as the items of a download of their own (see fairItems).
+// Each Fetch calls it, so that a download which falls back to another strategy is a new download in that
+// strategy's queue.
+func withFlow(ctx context.Context) context.Context {
+	return context.WithValue(ctx, flowKey{}, flowIDs.Add(1))
+}
+
+func flowFrom(ctx context.Context) int64 {
+	id, _ := ctx.Value(flowKey{}).(int64)
+	return id
+}
+
+// fairItems holds the items submitted without a priority, and shares the workers between the downloads they belong
+// to with start-time fair queuing: each item is tagged with the virtual time at which its download's share of the
+// workers lets it run, which advances by the item's buffer size for each item of the download, and workers take
+// the item with the earliest tag. A download with a few small chunks is thus not held up behind one with many large
+// ones which was started first, whose chunks are taken in turn with its own.
+//
+// Items which start a download are tagged as a single download: new downloads together get the share of one
+// download, so that the queue prefers finishing the downloads it has started, without starving new ones. Among
+// items with the same tag, those continuing a download come first, and are otherwise taken in submission order.
+type fairItems struct {
+	mu    sync.Mutex
+	items []*fairItem
+	seq   int64
+	// vtime is the virtual time of the queue, the tag of the last item taken
+	vtime int64
+	// finish holds, by flow, the virtual time at which the flow's next item may run, while it is after vtime
+	finish map[int64]int64
+	// ready holds a token while there may be items waiting, to wake an idle worker
+	ready chan struct{}
+	// flowReady holds, for the flows with workers of their own (see popFlow), a token while there may be items of
+	// the flow waiting
+	flowReady map[int64]chan struct{}
+}
+
+type fairItem struct {
+	tag int64
+	// starts is set for items which start a download
+	starts bool
+	// flow is the download the item belongs to, even if it starts it
+	flow  int64
+	seq   int64
+	item  queueItem
+	taken chan struct{}
+}
+
+func newFairItems() *fairItems {
+	return &fairItems{finish: make(map[int64]int64), ready: make(chan struct{}, 1), flowReady: make(map[int64]chan struct{})}
+}
+
+// submit queues item, of the download flow, and blocks until a worker takes it.
+func (f *fairItems) submit(flow int64, starts bool, item queueItem) {
+	fair := &fairItem{starts: starts, flow: flow, item: item, taken: make(chan struct{})}
+	if starts {
+		flow = startingItems
+	}
+	f.mu.Lock()
+	fair.tag = max(f.vtime, f.finish[flow])
+	f.finish[flow] = fair.tag + item.bufSize
+	fair.seq = f.seq
+	f.seq++
+	i, _ := slices.BinarySearchFunc(f.items, fair, compareFair)
+	f.items = slices.Insert(f.items, i, fair)
+	flowReady := f.flowReady[fair.flow]
+	f.mu.Unlock()
+	f.signal()
+	if flowReady != nil {
+		signal(flowReady)
+	}
+	<-fair.taken
+}
+
+// pop takes the item with the earliest tag, if there is one. If continuing is set, only items continuing a
+// download are taken.
+func (f *fairItems) pop(continuing bool) (queueItem, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := 0
+	if continuing {
+		i = slices.IndexFunc(f.items, func(item *fairItem) bool { return !item.starts })
+	}
+	return f.take(i)
+}
+
+// popFlow takes the earliest item of flow, if there is one.
+func (f *fairItems) popFlow(flow int64) (queueItem, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.take(slices.IndexFunc(f.items, func(item *fairItem) bool { return item.flow == flow }))
+}
+
+// watchFlow returns a channel which holds a token while there may be items of flow waiting, for workers which only
+// take the items of flow. The returned function stops watching it.
+func (f *fairItems) watchFlow(flow int64) (chan struct{}, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ready, ok := f.flowReady[flow]
+	if !ok {
+		ready = make(chan struct{}, 1)
+		f.flowReady[flow] = ready
+	}
+	// the items may have been submitted before
+	signal(ready)
+	return ready, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.flowReady, flow)
+	}
+}
+
+// take removes the item at index i, if there is one, with f.mu held.
+func (f *fairItems) take(i int) (queueItem, bool) {
+	if i < 0 || i >= len(f.items) {
+		return queueItem{}, false
+	}
+	fair := f.items[i]
+	f.items = slices.Delete(f.items, i, i+1)
+	close(fair.taken)
+	f.vtime = max(f.vtime, fair.tag)
+	for flow, finish := range f.finish {
+		// the flow's next item is tagged with vtime either way
+		if finish <= f.vtime {
+			delete(f.finish, flow)
+		}
+	}
+	if len(f.items) > 0 {
+		// pass the token on to another idle worker
+		f.signal()
+	}
+	return fair.item, true
+}
+
+func (f *fairItems) signal() {
+	signal(f.ready)
+}
+
+func signal(ready chan<- struct{}) {
+	select {
+	case ready <- struct{}{}:
+	default:
+	}
+}
+
+func compareFair(a, b *fairItem) int {
+	if c := cmp.Compare(a.tag, b.tag); c != 0 {
+		return c
+	}
+	if a.starts != b.starts {
+		if a.starts {
+			return 1
+		}
+		return -1
+	}
+	return cmp.Compare(a.seq, b.seq)
+}
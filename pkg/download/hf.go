@@ -0,0 +1,86 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/hf"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// HFMode downloads hf:// URLs of files in Hugging Face Hub repositories (see hf.ParseURL) with FallbackStrategy,
+// from their resolve URL on Options.HFEndpoint. Requests to the Hub are authenticated with Options.HFToken; the
+// Hub redirects files stored with LFS or Xet to its CDN with a pre-authorized URL, which the token is never sent to.
+//
+// Other URLs are downloaded by FallbackStrategy.
+type HFMode struct {
+	Options
+	FallbackStrategy Strategy
+
+	authHost string
+}
+
+func GetHFMode(opts Options, fallback Strategy) (*HFMode, error) {
+	endpoint := opts.HFEndpoint
+	if endpoint == "" {
+		endpoint = hf.DefaultEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Hugging Face endpoint %q", endpoint)
+	}
+	opts.HFEndpoint = endpoint
+	return &HFMode{Options: opts, FallbackStrategy: fallback, authHost: u.Host}, nil
+}
+
+// resolve returns the URL hf:// URL u is downloaded from, and a context authenticating the requests for it.
+func (m *HFMode) resolve(ctx context.Context, u string) (context.Context, string, error) {
+	file, err := hf.ParseURL(u)
+	if err != nil {
+		return ctx, "", err
+	}
+	if m.HFToken != "" {
+		ctx = client.WithAuthorization(ctx, m.authHost, "Bearer "+m.HFToken)
+	}
+	return ctx, file.ResolveURL(m.HFEndpoint), nil
+}
+
+func (m *HFMode) Fetch(ctx context.Context, url string) (io.Reader, int64, error) {
+	if !hf.IsURL(url) {
+		return m.FallbackStrategy.Fetch(ctx, url)
+	}
+	ctx, resolved, err := m.resolve(ctx, url)
+	if err != nil {
+		return nil, -1, err
+	}
+	logger := logging.GetLogger()
+	logger.Debug().
+		Str("url", url).
+		Str("resolved_url", resolved).
+		Bool("authenticated", m.HFToken != "").
+		Msg("Hugging Face: resolved")
+	reader, fileSize, err := m.FallbackStrategy.Fetch(ctx, resolved)
+	if err != nil {
+		if m.HFToken == "" {
+			return nil, -1, fmt.Errorf("error downloading %s (gated and private repositories need HF_TOKEN): %w", url, err)
+		}
+		return nil, -1, fmt.Errorf("error downloading %s: %w", url, err)
+	}
+	return reader, fileSize, nil
+}
+
+// DoRequest requests the range of an hf:// URL from its resolve URL, and of other URLs, with FallbackStrategy.
+func (m *HFMode) DoRequest(ctx context.Context, start, end int64, url string) (*http.Response, error) {
+	if !hf.IsURL(url) {
+		return m.FallbackStrategy.DoRequest(ctx, start, end, url)
+	}
+	ctx, resolved, err := m.resolve(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return m.FallbackStrategy.DoRequest(ctx, start, end, resolved)
+}
@@ -0,0 +1,88 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+// hubServers starts a Hub which serves org/repo, only with the token hf_secret, redirecting the LFS file model.bin to
+// a CDN which serves content. cdnAuthorized counts the requests to the CDN which had an Authorization header.
+func hubServers(t *testing.T, content string) (hub *httptest.Server, cdnAuthorized *atomic.Int64) {
+	cdnAuthorized = new(atomic.Int64)
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			cdnAuthorized.Add(1)
+		}
+		if r.URL.Query().Get("signature") != "ok" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(content)))
+	}))
+	t.Cleanup(cdn.Close)
+	hub = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hf_secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/old-org/repo/resolve/v1/model.bin":
+			// a renamed repository
+			http.Redirect(w, r, "/org/repo/resolve/v1/model.bin", http.StatusTemporaryRedirect)
+		case "/org/repo/resolve/v1/model.bin":
+			http.Redirect(w, r, cdn.URL+"/lfs/abc?signature=ok", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(hub.Close)
+	return hub, cdnAuthorized
+}
+
+func TestHFMode(t *testing.T) {
+	hub, cdnAuthorized := hubServers(t, "hello world\n")
+	opts := Options{
+		// the token is not forwarded to the CDN even if Authorization headers are forwarded on redirects
+		Client:     client.Options{Redirects: client.RedirectOptions{Auth: client.RedirectAuthAlways}},
+		ChunkSize:  4,
+		HFEndpoint: hub.URL,
+		HFToken:    "hf_secret",
+	}
+	hfMode, err := GetHFMode(opts, GetBufferMode(opts))
+	require.NoError(t, err)
+
+	for _, url := range []string{"hf://org/repo/model.bin@v1", "hf://old-org/repo/model.bin@v1"} {
+		reader, size, err := hfMode.Fetch(context.Background(), url)
+		require.NoError(t, err, url)
+		assert.Equal(t, int64(12), size)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world\n", string(data))
+	}
+	assert.Zero(t, cdnAuthorized.Load())
+}
+
+func TestHFModeUnauthorized(t *testing.T) {
+	hub, _ := hubServers(t, "hello world\n")
+	opts := Options{Client: client.Options{}, HFEndpoint: hub.URL}
+	hfMode, err := GetHFMode(opts, GetBufferMode(opts))
+	require.NoError(t, err)
+
+	_, _, err = hfMode.Fetch(context.Background(), "hf://org/repo/model.bin@v1")
+	assert.ErrorIs(t, err, ErrUnexpectedHTTPStatus)
+	assert.ErrorContains(t, err, "HF_TOKEN")
+
+	_, _, err = hfMode.Fetch(context.Background(), "hf://org/repo")
+	assert.Error(t, err)
+}
@@ -0,0 +1,178 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/ipfs"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// ipfsMinRaceGrace is the least time given to the other gateways to answer once the first one has, in case the
+// first answer was only a little faster.
+const ipfsMinRaceGrace = 100 * time.Millisecond
+
+// IPFSMode downloads ipfs://CID[/path] URLs from the HTTP gateways in Options.IPFSGateways. A first single-byte
+// request races the gateways, since how quickly a gateway finds a CID varies widely; the chunks are then striped
+// across the gateways which answered (see StripedMode), weighted towards the fastest ones. Unless
+// Options.IPFSSkipVerify is set, the content of a URL without a path is checked against its CID as it is read (see
+// ipfs.Verifier), failing the read at the end with ErrChecksumMismatch.
+//
+// Other URLs are downloaded by FallbackStrategy.
+type IPFSMode struct {
+	Options
+	FallbackStrategy Strategy
+
+	stripedOnce sync.Once
+	striped     *StripedMode
+}
+
+func GetIPFSMode(opts Options, fallback Strategy) (*IPFSMode, error) {
+	if len(opts.IPFSGateways) == 0 {
+		return nil, fmt.Errorf("must specify at least one gateway in IPFS mode")
+	}
+	return &IPFSMode{Options: opts, FallbackStrategy: fallback}, nil
+}
+
+// stripedMode returns the strategy the chunks are downloaded with, which is created on first use, so that its
+// workers don't exist unless there are ipfs:// URLs to download.
+func (m *IPFSMode) stripedMode() *StripedMode {
+	m.stripedOnce.Do(func() {
+		opts := m.Options
+		opts.Mirrors = nil
+		opts.MirrorLatencyWeighted = true
+		m.striped = GetStripedMode(opts)
+	})
+	return m.striped
+}
+
+// gatewayURLs returns the URLs of the content of an ipfs:// URL on each gateway.
+func (m *IPFSMode) gatewayURLs(cid ipfs.CID, path string) []string {
+	urls := make([]string, len(m.IPFSGateways))
+	for i, gateway := range m.IPFSGateways {
+		urls[i] = strings.TrimSuffix(gateway, "/") + "/ipfs/" + cid.String() + path
+	}
+	return urls
+}
+
+func (m *IPFSMode) Fetch(ctx context.Context, url string) (io.Reader, int64, error) {
+	if !ipfs.IsURL(url) {
+		return m.FallbackStrategy.Fetch(ctx, url)
+	}
+	logger := logging.GetLogger()
+	cid, path, err := ipfs.ParseURL(url)
+	if err != nil {
+		return nil, -1, err
+	}
+	gateways, err := m.race(ctx, m.gatewayURLs(cid, path))
+	if err != nil {
+		return nil, -1, fmt.Errorf("no IPFS gateway could serve %s: %w", url, err)
+	}
+	logger.Debug().
+		Str("url", url).
+		Strs("gateways", gateways).
+		Msg("IPFS: gateways answered")
+	reader, fileSize, err := m.stripedMode().fetch(ctx, gateways[0], gateways[1:])
+	if err != nil {
+		return nil, -1, err
+	}
+	if m.IPFSSkipVerify {
+		return reader, fileSize, nil
+	}
+	if path != "" {
+		// the CID is of a directory, the file's own CID is not known
+		logger.Warn().
+			Str("url", url).
+			Msg("IPFS: content of a path within a CID can't be verified")
+		return reader, fileSize, nil
+	}
+	return &cidVerifyingReader{r: reader, url: url, verifier: ipfs.NewVerifier(cid)}, fileSize, nil
+}
+
+// race requests the first byte of urls in parallel, and returns the ones which answered in the order they did. Once
+// the first one has answered, the others have as long again (at least ipfsMinRaceGrace) before they are given up on.
+func (m *IPFSMode) race(ctx context.Context, urls []string) ([]string, error) {
+	type result struct {
+		url     string
+		latency time.Duration
+		err     error
+	}
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(urls))
+	start := time.Now()
+	for _, url := range urls {
+		go func() {
+			resp, err := m.stripedMode().DoRequest(raceCtx, 0, 0, url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			results <- result{url: url, latency: time.Since(start), err: err}
+		}()
+	}
+
+	var answered []string
+	var errs []error
+	var deadline <-chan time.Time
+	for range urls {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				errs = append(errs, r.err)
+				continue
+			}
+			answered = append(answered, r.url)
+			if deadline == nil {
+				deadline = time.After(max(r.latency, ipfsMinRaceGrace))
+			}
+		case <-deadline:
+			return answered, nil
+		}
+	}
+	if len(answered) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return answered, nil
+}
+
+// DoRequest requests the range of an ipfs:// URL from the first gateway, and of other URLs with FallbackStrategy.
+func (m *IPFSMode) DoRequest(ctx context.Context, start, end int64, url string) (*http.Response, error) {
+	if !ipfs.IsURL(url) {
+		return m.FallbackStrategy.DoRequest(ctx, start, end, url)
+	}
+	cid, path, err := ipfs.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return m.stripedMode().DoRequest(ctx, start, end, m.gatewayURLs(cid, path)[0])
+}
+
+// cidVerifyingReader checks the content of an ipfs:// URL against its CID as it is read.
+type cidVerifyingReader struct {
+	r        io.Reader
+	url      string
+	verifier *ipfs.Verifier
+	// err is the result of the read which reached the end of the content
+	err error
+}
+
+func (c *cidVerifyingReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.r.Read(p)
+	_, _ = c.verifier.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if actual, verifyErr := c.verifier.Verify(); verifyErr != nil {
+			err = fmt.Errorf("%w: %s has CID %s, content added to IPFS with other than the default settings can't be verified (see --ipfs-skip-verify)", ErrChecksumMismatch, c.url, actual)
+		}
+		c.err = err
+	}
+	return n, err
+}
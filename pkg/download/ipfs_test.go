@@ -0,0 +1,62 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+// the CID `ipfs add` gives "hello world\n"
+const helloWorldCID = "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+
+// gatewayServer serves content as the CID helloWorldCID
+func gatewayServer(t *testing.T, content string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ipfs/"+helloWorldCID {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(content)))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIPFSMode(t *testing.T) {
+	missing := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(missing.Close)
+	gateway := gatewayServer(t, "hello world\n")
+
+	ipfsMode, err := GetIPFSMode(Options{
+		Client:       client.Options{},
+		ChunkSize:    4,
+		IPFSGateways: []string{missing.URL, gateway.URL + "/"},
+	}, nil)
+	require.NoError(t, err)
+	reader, size, err := ipfsMode.Fetch(context.Background(), "ipfs://"+helloWorldCID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world\n", string(data))
+}
+
+func TestIPFSModeCIDMismatch(t *testing.T) {
+	gateway := gatewayServer(t, "hello world?")
+
+	ipfsMode, err := GetIPFSMode(Options{Client: client.Options{}, IPFSGateways: []string{gateway.URL}}, nil)
+	require.NoError(t, err)
+	reader, _, err := ipfsMode.Fetch(context.Background(), "ipfs://"+helloWorldCID)
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
@@ -0,0 +1,128 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// maxProbedObjectSize bounds the search of probeObjectSize.
+const maxProbedObjectSize = 1 << 50
+
+// objectSize returns the size of the whole object from the first response of a download. If lenient is set and
+// the server omits the total from the Content-Range (`bytes 0-99/*`), the size is discovered with further requests
+// instead of failing.
+func objectSize(ctx context.Context, c client.HTTPClient, resp *http.Response, lenient bool) (int64, error) {
+	size, err := ObjectSize(resp)
+	if err == nil || !lenient || !unknownTotal(resp) {
+		return size, err
+	}
+	// the window is known to be well-formed, DoRequest has validated it
+	groups := contentRangeWindowRegexp.FindStringSubmatch(resp.Header.Get("Content-Range"))
+	respEnd, _ := strconv.ParseInt(groups[2], 10, 64)
+	start, end, _ := parseRangeHeader(resp.Request.Header.Get("Range"))
+	if respEnd < end {
+		// the server truncated the window at the end of the object
+		return respEnd + 1, nil
+	}
+	size, err = discoverObjectSize(ctx, c, resp.Request.URL.String(), max(start, respEnd))
+	if err != nil {
+		return -1, fmt.Errorf("couldn't discover the size of %s, Content-Range %s has no total: %w", resp.Request.URL.String(), resp.Header.Get("Content-Range"), err)
+	}
+	logger := logging.GetLogger()
+	logger.Debug().
+		Str("url", resp.Request.URL.String()).
+		Str("content_range", resp.Header.Get("Content-Range")).
+		Int64("size", size).
+		Msg("Discovered object size")
+	return size, nil
+}
+
+// unknownTotal reports whether the Content-Range of resp omits the total size of the object.
+func unknownTotal(resp *http.Response) bool {
+	groups := contentRangeWindowRegexp.FindStringSubmatch(resp.Header.Get("Content-Range"))
+	return groups != nil && groups[3] == "*"
+}
+
+// discoverObjectSize finds the size of the object at url, which is known to be larger than lastByte. It uses the
+// Content-Length of a HEAD request, or failing that probes for the end of the object with probeObjectSize.
+func discoverObjectSize(ctx context.Context, c client.HTTPClient, url string, lastByte int64) (int64, error) {
+	logger := logging.GetLogger()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := c.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		// a compressed or otherwise encoded length is not the length of the ranges
+		if resp.StatusCode == http.StatusOK && resp.ContentLength > lastByte && resp.Header.Get("Content-Encoding") == "" {
+			return resp.ContentLength, nil
+		}
+	}
+	logger.Debug().
+		Err(err).
+		Str("url", url).
+		Msg("HEAD request did not give the object size, probing")
+	return probeObjectSize(ctx, c, url, lastByte)
+}
+
+// probeObjectSize finds the size of the object at url, which is known to be larger than lastByte, with single-byte
+// range requests: a byte within the object is served, while one past its end is answered with 416 Range Not
+// Satisfiable. The offset is doubled until it is past the end, then the end is bisected, so it takes about
+// 2*log2(size) requests.
+func probeObjectSize(ctx context.Context, c client.HTTPClient, url string, lastByte int64) (int64, error) {
+	exists := func(offset int64) (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset))
+		resp, err := c.Do(req)
+		if err != nil {
+			return false, err
+		}
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			resp.Body.Close()
+			return false, nil
+		}
+		if err := checkRangeResponse(req, resp); err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		return true, nil
+	}
+
+	// lo is known to exist, hi is not known to exist
+	lo, hi := lastByte, max(2*lastByte, 1)
+	for {
+		ok, err := exists(hi)
+		if err != nil {
+			return -1, err
+		}
+		if !ok {
+			break
+		}
+		if hi >= maxProbedObjectSize {
+			return -1, fmt.Errorf("object is larger than %d bytes", int64(maxProbedObjectSize))
+		}
+		lo, hi = hi, 2*hi
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := exists(mid)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
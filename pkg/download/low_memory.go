@@ -0,0 +1,36 @@
+package download
+
+import (
+	"math"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// lowMemoryChunkSize is the largest chunk, and so the largest buffer of each worker, with Options.LowMemory.
+	lowMemoryChunkSize = 8 * humanize.MiByte
+	// lowMemoryMaxConcurrency is the largest number of workers with Options.LowMemory. With lowMemoryChunkSize, the
+	// chunk buffers of all downloads together take at most 16 MiB.
+	lowMemoryMaxConcurrency = 2
+)
+
+// is32Bit is set on platforms with a 32-bit address space, such as 32-bit ARM, where the buffers of every worker at
+// the default chunk size would not fit in memory at all, let alone next to a few gigabytes of file being written.
+const is32Bit = math.MaxInt == math.MaxInt32
+
+// max32BitBufferMemory bounds the chunk buffers of each queue on 32-bit platforms (see newStrategyQueue).
+const max32BitBufferMemory = 512 * humanize.MiByte
+
+// withLowMemoryLimits returns the options with the chunk size, concurrency and pipelining bounded as described by
+// Options.LowMemory, or o unchanged if it is not set. Explicit settings below the limits are kept.
+func (o Options) withLowMemoryLimits() Options {
+	if !o.LowMemory {
+		return o
+	}
+	if o.ChunkSize == 0 || o.ChunkSize > lowMemoryChunkSize {
+		o.ChunkSize = lowMemoryChunkSize
+	}
+	o.MaxConcurrency = min(o.maxConcurrency(), lowMemoryMaxConcurrency)
+	o.PipelineChunks = false
+	return o
+}
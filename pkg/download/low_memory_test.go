@@ -0,0 +1,39 @@
+package download
+
+import (
+	"testing"
+
+	"github.com/dustin/go-humanize"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithLowMemoryLimits(t *testing.T) {
+	opts := Options{MaxConcurrency: 16, PipelineChunks: true}
+	assert.Equal(t, opts, opts.withLowMemoryLimits())
+
+	limited := Options{LowMemory: true, MaxConcurrency: 16, PipelineChunks: true}.withLowMemoryLimits()
+	assert.Equal(t, int64(lowMemoryChunkSize), limited.ChunkSize)
+	assert.Equal(t, lowMemoryMaxConcurrency, limited.MaxConcurrency)
+	assert.False(t, limited.PipelineChunks)
+
+	// settings already below the limits are kept
+	limited = Options{LowMemory: true, MaxConcurrency: 1, ChunkSize: humanize.MiByte}.withLowMemoryLimits()
+	assert.Equal(t, int64(humanize.MiByte), limited.ChunkSize)
+	assert.Equal(t, 1, limited.MaxConcurrency)
+}
+
+func TestNewStrategyQueue(t *testing.T) {
+	q := newStrategyQueue(Options{MaxConcurrency: 16}, defaultChunkSize)
+	if is32Bit {
+		// 512 MiB of 125 MiB buffers
+		assert.Equal(t, 4, q.concurrency)
+		assert.True(t, q.lowMemory)
+	} else {
+		assert.Equal(t, 16, q.concurrency)
+		assert.False(t, q.lowMemory)
+	}
+
+	q = newStrategyQueue(Options{MaxConcurrency: 16, LowMemory: true}, lowMemoryChunkSize)
+	assert.True(t, q.lowMemory)
+	assert.True(t, q.growBuffers)
+}
@@ -0,0 +1,110 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+// Metadata collects details of the object fetched by Strategy.Fetch, taken from the response to its first request,
+// and counts the retries, cache fallbacks, cache hits and cache misses of its requests.
+type Metadata struct {
+	mu   sync.Mutex
+	etag string
+
+	retries     atomic.Int64
+	fallbacks   atomic.Int64
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a context which, when passed to Strategy.Fetch, has the strategy fill in m.
+func WithMetadata(ctx context.Context, m *Metadata) context.Context {
+	ctx = client.WithRetryCounter(ctx, &m.retries)
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// ETag returns the object's entity tag, or an empty string if the server did not send one.
+func (m *Metadata) ETag() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.etag
+}
+
+// Retries returns the number of times requests for the object were retried.
+func (m *Metadata) Retries() int64 {
+	return m.retries.Load()
+}
+
+// Fallbacks returns the number of requests for the object which fell back from a cache host to the origin; a
+// fallback of the whole file counts once.
+func (m *Metadata) Fallbacks() int64 {
+	return m.fallbacks.Load()
+}
+
+// CacheHits returns the number of responses for the object which cache hosts served from their cache, and
+// CacheMisses the number they had to fetch from the origin, as reported in their CacheStatusHeader. Responses
+// without the header count as neither.
+func (m *Metadata) CacheHits() int64 {
+	return m.cacheHits.Load()
+}
+
+func (m *Metadata) CacheMisses() int64 {
+	return m.cacheMisses.Load()
+}
+
+// recordRetry counts a chunk retried other than by the client, e.g. rescheduled after missing its deadline.
+func recordRetry(ctx context.Context) {
+	if m, ok := ctx.Value(metadataKey{}).(*Metadata); ok {
+		m.retries.Add(1)
+	}
+}
+
+func recordFallback(ctx context.Context) {
+	if m, ok := ctx.Value(metadataKey{}).(*Metadata); ok {
+		m.fallbacks.Add(1)
+	}
+}
+
+func recordMetadata(ctx context.Context, resp *http.Response) {
+	m, ok := ctx.Value(metadataKey{}).(*Metadata)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.etag = resp.Header.Get("ETag")
+}
+
+// CacheStatusHeader is the response header in which cache hosts report whether they served a request from their
+// cache: HIT, STALE, UPDATING or REVALIDATED count as hits and MISS, EXPIRED or BYPASS as misses (as nginx's
+// $upstream_cache_status), in any case, and optionally followed by more details, e.g. "HIT from cache-0".
+const CacheStatusHeader = "X-Cache"
+
+// recordCacheStatus counts resp, a response from a cache host, towards the cache hits or misses of its download and
+// of the process.
+func recordCacheStatus(ctx context.Context, resp *http.Response) {
+	fields := strings.FieldsFunc(resp.Header.Get(CacheStatusHeader), func(r rune) bool { return r == ' ' || r == ',' })
+	if len(fields) == 0 {
+		return
+	}
+	m, _ := ctx.Value(metadataKey{}).(*Metadata)
+	switch strings.ToUpper(fields[0]) {
+	case "HIT", "STALE", "UPDATING", "REVALIDATED":
+		stats.cacheHits.Add(1)
+		if m != nil {
+			m.cacheHits.Add(1)
+		}
+	case "MISS", "EXPIRED", "BYPASS":
+		stats.cacheMisses.Add(1)
+		if m != nil {
+			m.cacheMisses.Add(1)
+		}
+	}
+}
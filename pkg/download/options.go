@@ -3,8 +3,10 @@ package download
 import (
 	"net/url"
 	"runtime"
+	"time"
 
-	"github.com/replicate/pget/pkg/client"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/consistent"
 )
 
 type Options struct {
@@ -12,6 +14,11 @@ type Options struct {
 	// will be used.
 	MaxConcurrency int
 
+	// MaxBufferedBytes, if set, pauses chunk scheduling while the chunks being downloaded or waiting to be read by the
+	// consumer would take more than this many bytes, so that memory stays bounded when the consumer (e.g. extraction
+	// to a slow disk) can't keep up with the network. It applies to each strategy's work queue.
+	MaxBufferedBytes int64
+
 	// SliceSize is the number of bytes per slice in nginx.
 	// See https://nginx.org/en/docs/http/ngx_http_slice_module.html
 	SliceSize int64
@@ -19,23 +26,137 @@ type Options struct {
 	// Number of bytes per chunk. If set to zero, 125 MiB will be used.
 	ChunkSize int64
 
+	// AutoChunkSize, if set, makes the buffer strategy start each download with a small chunk, measure the
+	// round trip and throughput of the connection with it, and choose the size of the remaining chunks from them
+	// (see autoChunkSize), up to ChunkSize. Buffers are then only allocated as large as the chunks need.
+	AutoChunkSize bool
+
+	// LowMemory, if set, bounds the memory held by downloads for devices with little of it: chunks are at most
+	// lowMemoryChunkSize, there are at most lowMemoryMaxConcurrency workers, which are never escalated, chunks are
+	// not pipelined, and workers only hold a buffer while they have a chunk to download. See withLowMemoryLimits.
+	LowMemory bool
+
 	Client client.Options
 
+	// AdaptiveConcurrency, if set, makes the buffer strategy measure whether parallel connections actually speed up
+	// each download, and collapse the download to a few connections if they do not. See adaptiveConcurrency.
+	AdaptiveConcurrency bool
+
+	// ChunkDeadlineFactor, if set, cancels a chunk which takes more than this many times as long as expected from the
+	// throughput of its download's chunks so far (and at least 10 seconds), and reschedules it: the buffer strategy
+	// requests it again, the consistent hashing strategy from the origin, and the striped strategy from another
+	// mirror. A chunk is rescheduled at most twice. See chunkDeadlines.
+	ChunkDeadlineFactor float64
+
+	// Strict, if set, fails downloads on inconsistent responses which are otherwise worked around, such as a
+	// Content-Length which disagrees with the Content-Range (see ErrContentLengthMismatch).
+	Strict bool
+
+	// PipelineChunks, if set, makes the buffer strategy request each worker's next chunk as soon as the response
+	// headers of its current chunk arrive, rather than once the chunk has been read, so that there is no idle round
+	// trip between chunks. Each worker may then hold two connections.
+	PipelineChunks bool
+
+	// LenientContentRange, if set, accepts responses whose Content-Range omits the total size of the object
+	// (`bytes 0-99/*`), as some servers do. The size is then taken from a HEAD request or, failing that, discovered
+	// by probing for the end of the object with single-byte range requests.
+	LenientContentRange bool
+
+	// Compressed, if set, makes the buffer strategy request zstd or gzip content coding (Accept-Encoding) from the
+	// origin, decoding the content as it is read. Servers which compress on the fly usually do so without range
+	// support, in which case the download is streamed over a single connection. The decoded size is discovered with a
+	// HEAD request first; without it, the download is not compressed.
+	Compressed bool
+
+	// Mirrors are URLs which serve the same content as the URL being downloaded. The striped strategy spreads the
+	// chunks of a download across the URL and its mirrors.
+	Mirrors []string
+
+	// MirrorLatencyWeighted, if set, makes the striped strategy assign each chunk to the mirror expected to finish it
+	// soonest, based on the throughput of its earlier chunks, instead of round-robin.
+	MirrorLatencyWeighted bool
+
+	// IPFSGateways are the base URLs of the HTTP gateways (e.g. https://ipfs.io) the IPFS strategy downloads ipfs://
+	// URLs from. IPFSSkipVerify, if set, skips checking the downloaded content against the CID.
+	IPFSGateways   []string
+	IPFSSkipVerify bool
+
+	// HFEndpoint is the Hugging Face Hub the HF strategy downloads hf:// URLs from (hf.DefaultEndpoint if empty), and
+	// HFToken the access token its requests to it are authenticated with, if any.
+	HFEndpoint string
+	HFToken    string
+
+	// ReplicateCredentialsEndpoint, if set, signs replicate:// URLs and refreshes the signed URLs of Replicate weights
+	// as they expire (see ReplicateMode). ReplicateAPIToken authenticates the requests to it.
+	ReplicateCredentialsEndpoint string
+	ReplicateAPIToken            string
+
 	// CacheableURIPrefixes is an allowlist of domains+path-prefixes which may
 	// be routed via a pull-through cache
 	CacheableURIPrefixes map[string][]*url.URL
 
+	// CacheURIAliases maps a target URI prefix to alias prefixes which serve the same content. The consistent
+	// hashing strategy rewrites a URL under an alias to the same path under its target before anything else, so that
+	// it is checked against CacheableURIPrefixes, hashed and fetched (including on fallback) as the target.
+	CacheURIAliases map[string][]*url.URL
+
 	// CacheUsePathProxy is a flag to indicate whether to use the path proxy mechanism or the host-based mechanism
 	// The default is to use the host-based mechanism, the path proxy mechanism is used when this flag is set to true
 	// and involves prepending the host to the path of the request to the cache. In both cases the Hosts header is
-	// sent to the cache.
+	// sent to the cache. It is equivalent to setting CacheRewriter to HostPrefixRewriter.
 	CacheUsePathProxy bool
 
+	// CacheRewriter, if set, rewrites requests into the form the cache hosts expect, and takes precedence over
+	// CacheUsePathProxy. See CacheRewriter.
+	CacheRewriter CacheRewriter
+
+	// CacheKeyRules, if set, normalize the URL the slices of an object are mapped to cache hosts by, in order, so
+	// that URLs naming the same object map to the same cache hosts. See CacheKeyRule.
+	CacheKeyRules []CacheKeyRule
+
+	// CacheFallbackThreshold, if set, is the fraction (e.g. 0.25) of a file's chunks which may fall back from the
+	// cache to the origin before the consistent hashing strategy gives up on the cache for that file and requests
+	// its remaining chunks straight from the origin, rather than each trying the cache first.
+	CacheFallbackThreshold float64
+
 	// CacheHosts is a slice of hostnames to use as pull-through caches.
 	// The ordering is significant and will be used with the consistent
 	// hashing algorithm.  The slice may contain empty entries which
 	// correspond to a cache host which is currently unavailable.
 	CacheHosts []string
+
+	// CacheHealthCheckPath, if set, is requested on each cache host when the consistent hashing strategy is created
+	// and then every CacheHealthCheckInterval (30s if zero). Hosts which do not respond with a 2xx status are treated
+	// as unavailable until they pass a later check.
+	CacheHealthCheckPath     string
+	CacheHealthCheckInterval time.Duration
+
+	// CachePrewarmConnections, if set, is the number of connections the consistent hashing strategy opens to each
+	// cache host when it is created, up to Client.TransportOpts.MaxConnPerHost, so that the first chunks don't wait
+	// for connection handshakes. The connections are only kept for the chunks if the strategy's clients share
+	// Client.Transport, which is set to a new Transport if it is nil.
+	CachePrewarmConnections int
+
+	// CacheHashVersion is the version of the consistent hash mapping slices to cache hosts (consistent.HashVersion
+	// if zero). While a cache fleet migrates to a new version, CacheHashRollout, if set, is the fraction of objects
+	// mapped with it, the others being mapped with the version before it (see consistent.Version.ForObject).
+	CacheHashVersion consistent.Version
+	CacheHashRollout float64
+
+	// OnSliceComplete, if set, is called by the consistent hashing strategy once every chunk of a slice has been
+	// downloaded, so that cache tiers can track which slices they have been populated with. It is called from the
+	// download workers, so it must be safe for concurrent use and should return quickly.
+	OnSliceComplete func(SliceEvent)
+}
+
+func (o *Options) cacheRewriter() CacheRewriter {
+	if o.CacheRewriter != nil {
+		return o.CacheRewriter
+	}
+	if o.CacheUsePathProxy {
+		return HostPrefixRewriter{}
+	}
+	return HostRewriter{}
 }
 
 func (o *Options) maxConcurrency() int {
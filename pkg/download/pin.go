@@ -0,0 +1,70 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+// Pin pins a download to one version of an object, so that an object replaced while it is downloaded fails with
+// ErrObjectChanged, instead of producing a file made of chunks of both versions.
+type Pin struct {
+	// ETag, if set, is sent as the If-Match header of every request for the object (see WithPin).
+	ETag string
+	// Version, if set, is added to the URL of the object (see URL): the generation of a Google Cloud Storage
+	// object, or the versionId of an S3 object.
+	Version string
+}
+
+// IsZero reports whether p pins nothing.
+func (p Pin) IsZero() bool {
+	return p.ETag == "" && p.Version == ""
+}
+
+// URL returns rawURL with p.Version added to its query, as generation for storage.googleapis.com and as versionId
+// otherwise. A presigned URL must already have been signed with the version in it, which leaves it unchanged.
+func (p Pin) URL(rawURL string) (string, error) {
+	if p.Version == "" {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s: %w", rawURL, err)
+	}
+	param := "versionId"
+	if host := u.Hostname(); host == "storage.googleapis.com" || strings.HasSuffix(host, ".storage.googleapis.com") {
+		param = "generation"
+	}
+	query := u.Query()
+	if existing := query.Get(param); existing != "" {
+		if existing != p.Version {
+			return "", fmt.Errorf("%s already pins %s %s, not %s", rawURL, param, existing, p.Version)
+		}
+		return rawURL, nil
+	}
+	query.Set(param, p.Version)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// WithPin returns a context which has the requests made with it send p.ETag as their If-Match header. Servers
+// answer them with 412 Precondition Failed once the object has another ETag, which fails the download with
+// ErrObjectChanged.
+func WithPin(ctx context.Context, p Pin) context.Context {
+	if p.ETag == "" {
+		return ctx
+	}
+	return client.WithIfMatch(ctx, QuoteETag(p.ETag))
+}
+
+// QuoteETag returns etag as an entity tag, quoting it if it isn't, so that ETags can be given with or without the
+// quotes servers send them with.
+func QuoteETag(etag string) string {
+	if etag == "*" || strings.HasSuffix(etag, `"`) && (strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`)) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
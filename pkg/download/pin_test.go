@@ -0,0 +1,82 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func TestPinURL(t *testing.T) {
+	testCases := []struct {
+		name     string
+		url      string
+		version  string
+		expected string
+		err      bool
+	}{
+		{"no version", "https://bucket.s3.amazonaws.com/key", "", "https://bucket.s3.amazonaws.com/key", false},
+		{"s3", "https://bucket.s3.amazonaws.com/key", "abc", "https://bucket.s3.amazonaws.com/key?versionId=abc", false},
+		{"gcs", "https://storage.googleapis.com/bucket/key?alt=media", "123", "https://storage.googleapis.com/bucket/key?alt=media&generation=123", false},
+		{"gcs virtual host", "https://bucket.storage.googleapis.com/key", "123", "https://bucket.storage.googleapis.com/key?generation=123", false},
+		{"already signed", "https://bucket.s3.amazonaws.com/key?X-Amz-Signature=x&versionId=abc", "abc", "https://bucket.s3.amazonaws.com/key?X-Amz-Signature=x&versionId=abc", false},
+		{"conflicting", "https://bucket.s3.amazonaws.com/key?versionId=abc", "def", "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := Pin{Version: tc.version}.URL(tc.url)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestQuoteETag(t *testing.T) {
+	assert.Equal(t, `"abc"`, QuoteETag("abc"))
+	assert.Equal(t, `"abc"`, QuoteETag(`"abc"`))
+	assert.Equal(t, `W/"abc"`, QuoteETag(`W/"abc"`))
+	assert.Equal(t, "*", QuoteETag("*"))
+}
+
+func TestBufferModeObjectChanged(t *testing.T) {
+	contents := [][]byte{bytes.Repeat([]byte("a"), 4096), bytes.Repeat([]byte("b"), 4096)}
+	// the object is replaced after the first request
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := min(int(requests.Add(1))-1, 1)
+		w.Header().Set("ETag", []string{`"v1"`, `"v2"`}[version])
+		http.ServeContent(w, r, "object", time.Time{}, bytes.NewReader(contents[version]))
+	}))
+	defer server.Close()
+
+	opts := Options{Client: client.Options{MaxRetries: 2}, ChunkSize: 1024, MaxConcurrency: 1}
+	bufferMode := GetBufferMode(opts)
+	ctx := WithPin(context.Background(), Pin{ETag: "v1"})
+	reader, _, err := bufferMode.Fetch(ctx, server.URL)
+	if err == nil {
+		_, err = io.ReadAll(reader)
+	}
+	assert.ErrorIs(t, err, ErrObjectChanged)
+	assert.ErrorIs(t, err, ErrUnexpectedHTTPStatus)
+
+	// unpinned, the download interleaves both versions
+	requests.Store(0)
+	reader, _, err = GetBufferMode(opts).Fetch(context.Background(), server.URL)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "ab")
+}
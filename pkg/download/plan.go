@@ -0,0 +1,231 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/consistent"
+	"github.com/replicate/pget/v2/pkg/hf"
+)
+
+// Strategy names of a Plan.
+const (
+	PlanBuffer            = "buffer"
+	PlanConsistentHashing = "consistent-hashing"
+	PlanOther             = "other"
+)
+
+// PlanStrategy is a Strategy which can work out how it would download an object without downloading it, from the
+// first request of the download alone, whose body isn't read. BufferMode, ConsistentHashingMode, HFMode and
+// ReplicateMode implement it.
+type PlanStrategy interface {
+	Strategy
+
+	// Plan returns the Plan of the download of url.
+	Plan(ctx context.Context, url string) (*Plan, error)
+}
+
+var (
+	_ PlanStrategy = &BufferMode{}
+	_ PlanStrategy = &ConsistentHashingMode{}
+	_ PlanStrategy = &HFMode{}
+	_ PlanStrategy = &ReplicateMode{}
+)
+
+// Plan is how a strategy would download an object.
+type Plan struct {
+	// URL is the URL the object is downloaded from, after any URI alias, hf:// or Replicate URL resolution, or
+	// redirect.
+	URL string
+	// Size is the size of the object, or -1 if the server doesn't say.
+	Size int64
+	// Strategy is PlanBuffer, PlanConsistentHashing, or PlanOther for strategies which don't implement PlanStrategy,
+	// of which only the Size is known.
+	Strategy string
+	// Reason is why an object under consistent hashing is downloaded from the origin instead of the cache hosts.
+	Reason string
+	// SingleConnection is set if the server doesn't support range requests, so the object is downloaded over a
+	// single connection rather than in chunks.
+	SingleConnection bool
+	// FirstChunkSize is the size requested by the first request, and ChunkSize the size of the chunks after it,
+	// which is only a minimum with AutoChunkSize. Chunks is the number of chunks, including the first.
+	FirstChunkSize int64
+	ChunkSize      int64
+	AutoChunkSize  bool
+	Chunks         int
+	// SliceSize and Slices are set with PlanConsistentHashing.
+	SliceSize int64
+	Slices    []SlicePlan
+}
+
+// SlicePlan is the cache host a slice of an object is downloaded from.
+type SlicePlan struct {
+	Slice       int64
+	Start       int64
+	End         int64
+	Chunks      int
+	HashVersion consistent.Version
+	Bucket      int
+	// CacheHost is the host of Bucket, or empty if it has none or failed its health check, in which case the chunks
+	// of the slice are downloaded from the next bucket, or the origin.
+	CacheHost string
+}
+
+// PlanDownload returns the Plan of the download of url by s. For strategies which don't implement PlanStrategy, it
+// only has the size of the object, from a single-byte request.
+func PlanDownload(ctx context.Context, s Strategy, url string) (*Plan, error) {
+	if planner, ok := s.(PlanStrategy); ok {
+		return planner.Plan(ctx, url)
+	}
+	resp, err := s.DoRequest(ctx, 0, 0, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	size, err := ObjectSize(resp)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{URL: resp.Request.URL.String(), Size: size, Strategy: PlanOther}, nil
+}
+
+func (m *BufferMode) Plan(ctx context.Context, url string) (*Plan, error) {
+	plan := &Plan{URL: url, Strategy: PlanBuffer, FirstChunkSize: m.chunkSize(), ChunkSize: m.chunkSize(), AutoChunkSize: m.AutoChunkSize}
+	if m.AutoChunkSize {
+		plan.FirstChunkSize = autoInitialChunkSize
+	}
+	resp, err := m.DoRequest(ctx, 0, plan.FirstChunkSize-1, url)
+	if errors.Is(err, ErrRangeNotSupported) {
+		plan.Size, plan.SingleConnection, plan.Chunks = -1, true, 1
+		return plan, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	plan.URL = resp.Request.URL.String()
+	if plan.Size, err = objectSize(ctx, m.Client, resp, m.LenientContentRange); err != nil {
+		return nil, err
+	}
+	plan.Chunks = 1
+	if plan.Size > plan.FirstChunkSize {
+		// integer divide rounding up
+		plan.Chunks += int((plan.Size-plan.FirstChunkSize-1)/plan.ChunkSize + 1)
+	}
+	return plan, nil
+}
+
+func (m *ConsistentHashingMode) Plan(ctx context.Context, urlString string) (*Plan, error) {
+	parsed, err := url.Parse(urlString)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, ok := resolveURIAlias(m.aliases, parsed); ok {
+		parsed = resolved
+		urlString = resolved.String()
+	}
+	if !m.cacheable(parsed) {
+		return m.fallbackPlan(ctx, urlString, fmt.Sprintf("consistent hashing not enabled for %s", parsed.Host))
+	}
+
+	resp, _, err := m.doRequest(ctx, 0, m.chunkSize()-1, urlString)
+	if errors.Is(err, client.ErrStrategyFallback) {
+		return m.fallbackPlan(ctx, urlString, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	size, err := objectSize(ctx, m.Client, resp, m.LenientContentRange)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		URL:            urlString,
+		Size:           size,
+		Strategy:       PlanConsistentHashing,
+		FirstChunkSize: m.chunkSize(),
+		ChunkSize:      m.chunkSize(),
+		SliceSize:      m.SliceSize,
+	}
+	if plan.Slices, err = m.placeSlices(parsed, size); err != nil {
+		return nil, err
+	}
+	for _, slice := range plan.Slices {
+		plan.Chunks += slice.Chunks
+	}
+	return plan, nil
+}
+
+// PlaceSlices returns the cache hosts the slices of an object of size bytes at urlString are mapped to, as Plan does
+// but without making any request. It fails for URLs which consistent hashing isn't enabled for.
+func (m *ConsistentHashingMode) PlaceSlices(urlString string, size int64) ([]SlicePlan, error) {
+	parsed, err := url.Parse(urlString)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, ok := resolveURIAlias(m.aliases, parsed); ok {
+		parsed = resolved
+	}
+	if !m.cacheable(parsed) {
+		return nil, fmt.Errorf("consistent hashing not enabled for %s", parsed.Host)
+	}
+	return m.placeSlices(parsed, size)
+}
+
+func (m *ConsistentHashingMode) placeSlices(u *url.URL, size int64) ([]SlicePlan, error) {
+	// the slices are mapped to cache hosts by the URL as requests have it, normalized by the cache key rules
+	key := m.cacheKeyURL(u).String()
+	version := m.hashVersion(key)
+	var slices []SlicePlan
+	for start := int64(0); start < size; start += m.SliceSize {
+		slice := SlicePlan{Slice: start / m.SliceSize, Start: start, End: min(start+m.SliceSize, size) - 1, HashVersion: version}
+		// integer divide rounding up
+		slice.Chunks = int((slice.End-slice.Start)/m.chunkSize() + 1)
+		var err error
+		if slice.Bucket, err = version.SliceBucket(key, slice.Slice, len(m.CacheHosts)); err != nil {
+			return nil, err
+		}
+		if m.health.isReady(slice.Bucket) {
+			slice.CacheHost = m.CacheHosts[slice.Bucket]
+		}
+		slices = append(slices, slice)
+	}
+	return slices, nil
+}
+
+// fallbackPlan returns the Plan of FallbackStrategy for urlString, which is downloaded from the origin for reason.
+func (m *ConsistentHashingMode) fallbackPlan(ctx context.Context, urlString, reason string) (*Plan, error) {
+	plan, err := PlanDownload(ctx, m.FallbackStrategy, urlString)
+	if err != nil {
+		return nil, err
+	}
+	plan.Reason = reason
+	return plan, nil
+}
+
+func (m *HFMode) Plan(ctx context.Context, url string) (*Plan, error) {
+	if !hf.IsURL(url) {
+		return PlanDownload(ctx, m.FallbackStrategy, url)
+	}
+	ctx, resolved, err := m.resolve(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return PlanDownload(ctx, m.FallbackStrategy, resolved)
+}
+
+func (m *ReplicateMode) Plan(ctx context.Context, url string) (*Plan, error) {
+	if !IsReplicateURL(url) {
+		return PlanDownload(ctx, m.FallbackStrategy, url)
+	}
+	ctx, resolved, err := m.resolve(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return PlanDownload(ctx, m.FallbackStrategy, resolved)
+}
@@ -0,0 +1,95 @@
+package download_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/consistent"
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+func TestBufferModePlan(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "hello.txt", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	t.Cleanup(server.Close)
+
+	strategy := download.GetBufferMode(download.Options{ChunkSize: 3})
+	plan, err := download.PlanDownload(context.Background(), strategy, server.URL+"/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, &download.Plan{
+		URL:            server.URL + "/hello.txt",
+		Size:           10,
+		Strategy:       download.PlanBuffer,
+		FirstChunkSize: 3,
+		ChunkSize:      3,
+		Chunks:         4,
+	}, plan)
+	assert.Equal(t, 1, requests)
+}
+
+func TestConsistentHashingPlan(t *testing.T) {
+	const hosts = 4
+	const fileURL = "http://fake.replicate.delivery/hello.txt"
+	mockTransport := httpmock.NewMockTransport()
+	cacheHosts := make([]string, hosts)
+	for host := range cacheHosts {
+		cacheHosts[host] = fmt.Sprintf("cache-host-%d", host)
+		mockTransport.RegisterResponder("GET", fmt.Sprintf("http://%s/hello.txt", cacheHosts[host]), rangeResponder(200, "0123456789"))
+	}
+	mockTransport.RegisterResponder("GET", "http://uncached.example.com/hello.txt", rangeResponder(200, "0123456789"))
+
+	strategy, err := download.GetConsistentHashingMode(download.Options{
+		Client:               client.Options{Transport: mockTransport},
+		ChunkSize:            2,
+		SliceSize:            4,
+		CacheHosts:           cacheHosts,
+		CacheableURIPrefixes: makeCacheableURIPrefixes("http://fake.replicate.delivery"),
+	})
+	require.NoError(t, err)
+	strategy.FallbackStrategy = download.GetBufferMode(download.Options{Client: client.Options{Transport: mockTransport}, ChunkSize: 4})
+
+	plan, err := download.PlanDownload(context.Background(), strategy, fileURL)
+	require.NoError(t, err)
+	assert.Equal(t, download.PlanConsistentHashing, plan.Strategy)
+	assert.Equal(t, int64(10), plan.Size)
+	assert.Equal(t, 5, plan.Chunks)
+	require.Len(t, plan.Slices, 3)
+	for i, slice := range plan.Slices {
+		assert.Equal(t, int64(i), slice.Slice)
+		assert.Equal(t, consistent.HashVersion, slice.HashVersion)
+		bucket, err := consistent.HashVersion.SliceBucket(fileURL, slice.Slice, hosts)
+		require.NoError(t, err)
+		assert.Equal(t, bucket, slice.Bucket, "slice %d", i)
+		assert.Equal(t, cacheHosts[bucket], slice.CacheHost, "slice %d", i)
+	}
+	assert.Equal(t, []int64{0, 3, 4, 7, 8, 9}, []int64{plan.Slices[0].Start, plan.Slices[0].End, plan.Slices[1].Start, plan.Slices[1].End, plan.Slices[2].Start, plan.Slices[2].End})
+	assert.Equal(t, []int{2, 2, 1}, []int{plan.Slices[0].Chunks, plan.Slices[1].Chunks, plan.Slices[2].Chunks})
+
+	// placing the slices without a request agrees with the plan
+	placed, err := strategy.PlaceSlices(fileURL, 10)
+	require.NoError(t, err)
+	assert.Equal(t, plan.Slices, placed)
+	_, err = strategy.PlaceSlices("http://uncached.example.com/hello.txt", 10)
+	assert.Error(t, err)
+
+	// URLs which aren't cacheable are planned by the fallback strategy
+	plan, err = download.PlanDownload(context.Background(), strategy, "http://uncached.example.com/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, download.PlanBuffer, plan.Strategy)
+	assert.Equal(t, 3, plan.Chunks)
+	assert.Contains(t, plan.Reason, "uncached.example.com")
+	assert.Empty(t, plan.Slices)
+}
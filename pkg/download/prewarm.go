@@ -0,0 +1,65 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+const prewarmTimeout = 5 * time.Second
+
+// prewarmConnections opens Options.CachePrewarmConnections connections to each available cache host, so that the
+// first chunks requested from it find them idle in the connection pool instead of waiting for the handshakes. The
+// connections are opened by sending that many HEAD requests to the host at once, for the health check path if
+// there is one, over the strategy's transport; the responses don't matter. It blocks until every host is done, or
+// prewarmTimeout.
+func prewarmConnections(opts Options, health *cacheHealth) {
+	connections := opts.CachePrewarmConnections
+	if limit := opts.Client.TransportOpts.MaxConnPerHost; limit > 0 {
+		connections = min(connections, limit)
+	}
+	if connections <= 0 {
+		return
+	}
+	// like health checks, a failed request is not worth retrying
+	clientOpts := opts.Client
+	clientOpts.MaxRetries = 0
+	c := client.NewHTTPClient(clientOpts)
+	logger := logging.GetLogger()
+	ctx, cancel := context.WithTimeout(context.Background(), prewarmTimeout)
+	defer cancel()
+
+	start := time.Now()
+	wg := new(sync.WaitGroup)
+	for i, host := range opts.CacheHosts {
+		if host == "" || !health.isReady(i) {
+			continue
+		}
+		for range connections {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := http.NewRequestWithContext(ctx, http.MethodHead, "http://"+host+opts.CacheHealthCheckPath, nil)
+				if err != nil {
+					return
+				}
+				resp, err := c.Do(req)
+				if err != nil {
+					logger.Debug().Str("host", host).Err(err).Msg("Prewarm Connection")
+					return
+				}
+				resp.Body.Close()
+			}()
+		}
+	}
+	wg.Wait()
+	logger.Debug().
+		Int("hosts", len(opts.CacheHosts)).
+		Int("connections_per_host", connections).
+		Dur("elapsed", time.Since(start)).
+		Msg("Prewarmed Connections")
+}
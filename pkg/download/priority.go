@@ -0,0 +1,97 @@
+package download
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context which, when passed to Strategy.Fetch, gives the chunks of that download precedence
+// over those of downloads without a priority, or with a greater one: a worker which becomes free takes the chunk
+// with the lowest priority value waiting, so that the downloads with the lowest values complete first. priority
+// must be positive.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func priorityFrom(ctx context.Context) (int, bool) {
+	priority, ok := ctx.Value(priorityKey{}).(int)
+	return priority, ok && priority > 0
+}
+
+// rankedItems holds the items submitted with a priority, which workers take before any other item, lowest priority
+// first. Among items of the same priority, those continuing a download come before those starting one, as in
+// priorityWorkQueue, and are otherwise taken in submission order.
+type rankedItems struct {
+	mu    sync.Mutex
+	items []*rankedItem
+	seq   int64
+	// ready holds a token while there may be items waiting, to wake an idle worker
+	ready chan struct{}
+}
+
+type rankedItem struct {
+	priority int
+	// starts is set for items which start a download
+	starts bool
+	seq    int64
+	item   queueItem
+	taken  chan struct{}
+}
+
+func newRankedItems() *rankedItems {
+	return &rankedItems{ready: make(chan struct{}, 1)}
+}
+
+// submit queues item and blocks until a worker takes it.
+func (r *rankedItems) submit(priority int, starts bool, item queueItem) {
+	ranked := &rankedItem{priority: priority, starts: starts, item: item, taken: make(chan struct{})}
+	r.mu.Lock()
+	ranked.seq = r.seq
+	r.seq++
+	i, _ := slices.BinarySearchFunc(r.items, ranked, compareRanked)
+	r.items = slices.Insert(r.items, i, ranked)
+	r.mu.Unlock()
+	r.signal()
+	<-ranked.taken
+}
+
+// pop takes the first item, if there is one.
+func (r *rankedItems) pop() (queueItem, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.items) == 0 {
+		return queueItem{}, false
+	}
+	ranked := r.items[0]
+	r.items = r.items[1:]
+	close(ranked.taken)
+	if len(r.items) > 0 {
+		// pass the token on to another idle worker
+		r.signal()
+	}
+	return ranked.item, true
+}
+
+func (r *rankedItems) signal() {
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+func compareRanked(a, b *rankedItem) int {
+	if c := cmp.Compare(a.priority, b.priority); c != 0 {
+		return c
+	}
+	if a.starts != b.starts {
+		if a.starts {
+			return 1
+		}
+		return -1
+	}
+	return cmp.Compare(a.seq, b.seq)
+}
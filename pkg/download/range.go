@@ -0,0 +1,152 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// RangeStrategy is a Strategy which can also download a window of an object, for consumers which only need part of
+// a large one. BufferMode and ConsistentHashingMode implement it.
+type RangeStrategy interface {
+	Strategy
+
+	// FetchRange retrieves bytes start to end (inclusive) of the content at url, downloaded in parallel chunks as
+	// Fetch downloads the whole object, and returns them as an io.Reader along with their number. A window reaching
+	// past the end of the object is cut short at it. ErrRangeNotSupported is returned for servers which can't serve
+	// ranges.
+	FetchRange(ctx context.Context, url string, start, end int64) (io.Reader, int64, error)
+}
+
+var (
+	_ RangeStrategy = &BufferMode{}
+	_ RangeStrategy = &ConsistentHashingMode{}
+)
+
+func (m *BufferMode) FetchRange(ctx context.Context, url string, start, end int64) (io.Reader, int64, error) {
+	return fetchRange(ctx, m, m.queue, m.Client, m.Options, url, start, end, m.chunkSize(), 0)
+}
+
+func (m *ConsistentHashingMode) FetchRange(ctx context.Context, url string, start, end int64) (io.Reader, int64, error) {
+	return fetchRange(ctx, m, m.queue, m.Client, m.Options, url, start, end, m.chunkSize(), m.SliceSize)
+}
+
+// fetchRange downloads bytes start to end of the content at url through s, in chunks run on q. Chunks are aligned
+// on multiples of chunkSize within each slice of sliceSize bytes (if not zero), so that none straddles a slice.
+func fetchRange(ctx context.Context, s Strategy, q *priorityWorkQueue, c client.HTTPClient, opts Options, url string, start, end, chunkSize, sliceSize int64) (io.Reader, int64, error) {
+	logger := logging.GetLogger()
+	if start < 0 || end < start {
+		return nil, -1, fmt.Errorf("invalid range %d-%d of %s", start, end, url)
+	}
+	ctx = withFlow(ctx)
+
+	// the size of the object, and so where the window ends, is only known from the first response
+	firstEnd := rangeChunkEnd(start, end, chunkSize, sliceSize)
+	firstChunk := newReaderPromise()
+	firstReqResultCh := make(chan firstReqResult)
+	q.submitLowSized(ctx, firstEnd-start+1, func(buf []byte) {
+		defer close(firstReqResultCh)
+		resp, err := s.DoRequest(ctx, start, firstEnd, url)
+		if err != nil {
+			firstReqResultCh <- firstReqResult{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		fileSize, err := objectSize(ctx, c, resp, opts.LenientContentRange)
+		if err != nil {
+			firstReqResultCh <- firstReqResult{err: err}
+			return
+		}
+		contentLength, err := chunkLength(resp, opts.Strict)
+		if err != nil {
+			firstReqResultCh <- firstReqResult{err: err}
+			return
+		}
+		recordMetadata(ctx, resp)
+		firstReqResultCh <- firstReqResult{fileSize: fileSize}
+		firstChunk.Deliver(readRangeChunk(resp, buf[0:contentLength], c))
+	})
+
+	firstReqResult, ok := <-firstReqResultCh
+	if !ok {
+		panic("logic error in fetchRange: first request didn't return any output")
+	}
+	if firstReqResult.err != nil {
+		return nil, -1, firstReqResult.err
+	}
+
+	end = min(end, firstReqResult.fileSize-1)
+	type rangeChunk struct {
+		start, end int64
+		promise    *readerPromise
+	}
+	var chunks []rangeChunk
+	readers := []io.Reader{firstChunk}
+	for chunkStart := firstEnd + 1; chunkStart <= end; {
+		chunk := rangeChunk{start: chunkStart, end: rangeChunkEnd(chunkStart, end, chunkSize, sliceSize), promise: newReaderPromise()}
+		chunks = append(chunks, chunk)
+		readers = append(readers, chunk.promise)
+		chunkStart = chunk.end + 1
+	}
+	logger.Debug().Str("url", url).
+		Int64("start", start).
+		Int64("end", end).
+		Int("connections", len(chunks)+1).
+		Msg("Downloading range")
+
+	go func() {
+		for _, chunk := range chunks {
+			// the request is split from the read so that the queue can pipeline it (see priorityWorkQueue)
+			q.submitHighPipelinedSized(ctx, chunk.end-chunk.start+1, func() work {
+				var contentLength int64
+				resp, err := s.DoRequest(ctx, chunk.start, chunk.end, url)
+				if err == nil {
+					contentLength, err = chunkLength(resp, opts.Strict)
+					if err != nil {
+						resp.Body.Close()
+					}
+				}
+				return func(buf []byte) {
+					if err != nil {
+						chunk.promise.Deliver(nil, err)
+						return
+					}
+					defer resp.Body.Close()
+					chunk.promise.Deliver(readRangeChunk(resp, buf[0:contentLength], c))
+				}
+			})
+		}
+	}()
+	return io.MultiReader(readers...), end - start + 1, nil
+}
+
+// rangeChunkEnd returns the end of the chunk starting at start of a window ending at end, at the next multiple of
+// chunkSize within the slice of sliceSize bytes (if not zero) start is in.
+func rangeChunkEnd(start, end, chunkSize, sliceSize int64) int64 {
+	var sliceStart int64
+	if sliceSize > 0 {
+		sliceStart = start - start%sliceSize
+	}
+	chunkEnd := sliceStart + ((start-sliceStart)/chunkSize+1)*chunkSize - 1
+	if sliceSize > 0 {
+		chunkEnd = min(chunkEnd, sliceStart+sliceSize-1)
+	}
+	return min(chunkEnd, end)
+}
+
+// readRangeChunk reads the body of resp into buf, resuming the request if the connection is interrupted.
+func readRangeChunk(resp *http.Response, buf []byte, c client.HTTPClient) ([]byte, error) {
+	n, err := io.ReadFull(resp.Body, buf)
+	if err == io.ErrUnexpectedEOF {
+		logger := logging.GetLogger()
+		logger.Warn().
+			Int("connection_interrupted_at_byte", n).
+			Msg("Resuming Chunk Download")
+		n, err = resumeDownload(resp.Request, buf[n:], c, int64(n))
+	}
+	return buf[0:n], err
+}
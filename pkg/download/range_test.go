@@ -0,0 +1,59 @@
+package download
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func TestFetchRange(t *testing.T) {
+	content := generateTestContent(64 * 1024)
+	var requests atomic.Int64
+	server := countingServer(t, content, &requests)
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 4 * 1024})
+	url := server.URL + "/" + testFilePath
+
+	// the window is downloaded in chunks aligned on the chunk size
+	r, size, err := bufferMode.FetchRange(context.Background(), url, 1000, 30000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(29001), size)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content[1000:30001], data)
+	assert.Equal(t, int64(8), requests.Load())
+
+	// a window past the end of the object is cut short
+	r, size, err = bufferMode.FetchRange(context.Background(), url, 60000, 100000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)-60000), size)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content[60000:], data)
+
+	_, _, err = bufferMode.FetchRange(context.Background(), url, 10, 5)
+	assert.Error(t, err)
+}
+
+func TestRangeChunkEnd(t *testing.T) {
+	for _, tc := range []struct {
+		name                             string
+		start, end, chunkSize, sliceSize int64
+		expected                         int64
+	}{
+		{"aligned", 0, 100, 10, 0, 9},
+		{"unaligned start", 3, 100, 10, 0, 9},
+		{"end of window", 95, 97, 10, 0, 97},
+		{"end of slice", 20, 100, 15, 25, 24},
+		{"start of slice", 25, 100, 15, 25, 39},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, rangeChunkEnd(tc.start, tc.end, tc.chunkSize, tc.sliceSize))
+		})
+	}
+}
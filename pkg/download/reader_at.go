@@ -0,0 +1,233 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+const (
+	// readerAtBlockSize is the unit in which a RandomAccessStrategy downloads an object for small reads, which are
+	// served from a cache of the blocks most recently read, and into which it splits large reads, whose blocks are
+	// downloaded in parallel. Smaller chunk sizes make smaller blocks.
+	readerAtBlockSize = 8 * 1024 * 1024
+	// readerAtReadAhead is the number of blocks downloaded ahead of a small read, for consumers which read
+	// (mostly) sequentially in small pieces, e.g. through a decompressor
+	readerAtReadAhead = 4
+	// readerAtCachedBlocks bounds the memory a reader holds on to at readerAtCachedBlocks blocks, leaving room for
+	// a few sequential readers at different offsets, such as a zip extractor writing several files at once
+	readerAtCachedBlocks = 4 * readerAtReadAhead
+)
+
+// RandomAccessStrategy is a Strategy which can also give random access to an object, for consumers which read it out
+// of order (see consumer.ReaderAtConsumer), such as a zip extractor, which starts from the central directory at the
+// end of the archive. BufferMode and ConsistentHashingMode implement it.
+type RandomAccessStrategy interface {
+	Strategy
+
+	// FetchAt returns random access to the content at url, along with its size. Unlike Fetch, nothing is downloaded
+	// ahead of the reads, other than a few blocks after a small one: each read is downloaded with range requests
+	// (see DoRequest) as it is made. It is safe for concurrent use until ctx is done. ErrRangeNotSupported is
+	// returned for servers which can't serve ranges, whose content has to be read with Fetch.
+	FetchAt(ctx context.Context, url string) (io.ReaderAt, int64, error)
+}
+
+var (
+	_ RandomAccessStrategy = &BufferMode{}
+	_ RandomAccessStrategy = &ConsistentHashingMode{}
+)
+
+func (m *BufferMode) FetchAt(ctx context.Context, url string) (io.ReaderAt, int64, error) {
+	return fetchAt(ctx, m, m.Client, url, m.Options, m.chunkSize())
+}
+
+func (m *ConsistentHashingMode) FetchAt(ctx context.Context, url string) (io.ReaderAt, int64, error) {
+	return fetchAt(ctx, m, m.Client, url, m.Options, m.chunkSize())
+}
+
+// fetchAt discovers the size of the content at url with a single-byte request through s, and returns a reader
+// downloading it through s.
+func fetchAt(ctx context.Context, s Strategy, c client.HTTPClient, url string, opts Options, chunkSize int64) (io.ReaderAt, int64, error) {
+	resp, err := s.DoRequest(ctx, 0, 0, url)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer resp.Body.Close()
+	size, err := objectSize(ctx, c, resp, opts.LenientContentRange)
+	if err != nil {
+		return nil, -1, err
+	}
+	recordMetadata(ctx, resp)
+	return &rangeReaderAt{
+		ctx:         ctx,
+		strategy:    s,
+		client:      c,
+		url:         url,
+		size:        size,
+		blockSize:   min(readerAtBlockSize, chunkSize),
+		concurrency: opts.maxConcurrency(),
+		blocks:      make(map[int64]*cachedBlock),
+	}, size, nil
+}
+
+// rangeReaderAt is the io.ReaderAt of a RandomAccessStrategy.
+type rangeReaderAt struct {
+	ctx         context.Context
+	strategy    Strategy
+	client      client.HTTPClient
+	url         string
+	size        int64
+	blockSize   int64
+	concurrency int
+
+	mu     sync.Mutex
+	blocks map[int64]*cachedBlock
+	// recent lists the indexes of the cached blocks, least recently used first
+	recent []int64
+}
+
+type cachedBlock struct {
+	ready chan struct{}
+	data  []byte
+	err   error
+}
+
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), r.size-off))
+	var err error
+	if int64(n) >= r.blockSize {
+		err = r.readBlocks(p[:n], off)
+	} else {
+		err = r.readCached(p[:n], off)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readBlocks reads p from off, downloading each block of it in parallel, bypassing the cache.
+func (r *rangeReaderAt) readBlocks(p []byte, off int64) error {
+	eg := new(errgroup.Group)
+	eg.SetLimit(r.concurrency)
+	for start := int64(0); start < int64(len(p)); start += r.blockSize {
+		block := p[start:min(start+r.blockSize, int64(len(p)))]
+		blockOff := off + start
+		eg.Go(func() error {
+			return r.readRange(block, blockOff)
+		})
+	}
+	return eg.Wait()
+}
+
+// readCached reads p from off out of the cached blocks, downloading those which aren't cached.
+func (r *rangeReaderAt) readCached(p []byte, off int64) error {
+	for len(p) > 0 {
+		index := off / r.blockSize
+		block := r.block(index)
+		select {
+		case <-block.ready:
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+		if block.err != nil {
+			return block.err
+		}
+		n := copy(p, block.data[off-index*r.blockSize:])
+		p = p[n:]
+		off += int64(n)
+	}
+	return nil
+}
+
+// block returns the cached block index, starting to download it if it isn't cached, along with the blocks after it.
+func (r *rangeReaderAt) block(index int64) *cachedBlock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	block := r.cache(index)
+	lastBlock := (r.size - 1) / r.blockSize
+	for ahead := index + 1; ahead <= min(index+readerAtReadAhead, lastBlock); ahead++ {
+		if _, ok := r.blocks[ahead]; !ok {
+			r.cache(ahead)
+		}
+	}
+	// the block just read from is the most recently used, whatever was read ahead
+	r.touch(index)
+	return block
+}
+
+// cache returns the cached block index, starting to download it if it isn't cached, and evicts the least recently
+// used blocks over readerAtCachedBlocks. r.mu must be held.
+func (r *rangeReaderAt) cache(index int64) *cachedBlock {
+	if block, ok := r.blocks[index]; ok {
+		r.touch(index)
+		return block
+	}
+	block := &cachedBlock{ready: make(chan struct{})}
+	r.blocks[index] = block
+	r.recent = append(r.recent, index)
+	for len(r.recent) > readerAtCachedBlocks {
+		// readers already waiting on an evicted block still get its content
+		delete(r.blocks, r.recent[0])
+		r.recent = r.recent[1:]
+	}
+	go r.download(index, block)
+	return block
+}
+
+// touch marks the block index as the most recently used. r.mu must be held.
+func (r *rangeReaderAt) touch(index int64) {
+	if i := slices.Index(r.recent, index); i >= 0 {
+		r.recent = append(slices.Delete(r.recent, i, i+1), index)
+	}
+}
+
+func (r *rangeReaderAt) download(index int64, block *cachedBlock) {
+	start := index * r.blockSize
+	data := make([]byte, min(r.blockSize, r.size-start))
+	err := r.readRange(data, start)
+	if err != nil {
+		// a later read downloads the block again
+		r.mu.Lock()
+		if r.blocks[index] == block {
+			delete(r.blocks, index)
+			r.recent = slices.DeleteFunc(r.recent, func(i int64) bool { return i == index })
+		}
+		r.mu.Unlock()
+	}
+	block.data, block.err = data, err
+	close(block.ready)
+}
+
+// readRange downloads len(p) bytes from off into p, resuming the request if the connection is interrupted.
+func (r *rangeReaderAt) readRange(p []byte, off int64) error {
+	resp, err := r.strategy.DoRequest(r.ctx, off, off+int64(len(p))-1, r.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	n, err := io.ReadFull(resp.Body, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		_, err = resumeDownload(resp.Request, p[n:], r.client, int64(n))
+	}
+	if err != nil {
+		return fmt.Errorf("error reading bytes %d-%d of %s: %w", off, off+int64(len(p))-1, r.url, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+func TestFetchAt(t *testing.T) {
+	content := generateTestContent(64 * 1024)
+	var requests atomic.Int64
+	server := countingServer(t, content, &requests)
+
+	bufferMode := GetBufferMode(Options{Client: client.Options{}, ChunkSize: 4 * 1024})
+	r, size, err := bufferMode.FetchAt(context.Background(), server.URL+"/"+testFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+
+	// a small read downloads its block and reads ahead
+	p := make([]byte, 100)
+	n, err := r.ReadAt(p, 10*1024)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, content[10*1024:10*1024+100], p)
+
+	// further reads of the block are served from the cache, concurrently
+	before := requests.Load()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := make([]byte, 100)
+			off := int64(8*1024 + i*500)
+			n, err := r.ReadAt(p, off)
+			assert.NoError(t, err)
+			assert.Equal(t, content[off:off+int64(n)], p[:n])
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, before, requests.Load())
+
+	// a large read is downloaded in blocks
+	p = make([]byte, 40*1024)
+	n, err = r.ReadAt(p, 3)
+	require.NoError(t, err)
+	assert.Equal(t, len(p), n)
+	assert.Equal(t, content[3:3+len(p)], p)
+
+	// a read past the end returns what there is
+	p = make([]byte, 1024)
+	n, err = r.ReadAt(p, int64(len(content)-10))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, content[len(content)-10:], p[:n])
+	_, err = r.ReadAt(p, int64(len(content)))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFetchAtRangeNotSupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("no ranges here"))
+	}))
+	defer server.Close()
+
+	bufferMode := GetBufferMode(Options{Client: client.Options{}})
+	_, _, err := bufferMode.FetchAt(context.Background(), server.URL)
+	assert.ErrorIs(t, err, ErrRangeNotSupported)
+}
@@ -0,0 +1,134 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// ReplicateWeightsHost serves the weights of Replicate models. replicate://path is shorthand for
+// https://weights.replicate.delivery/path.
+const ReplicateWeightsHost = "weights.replicate.delivery"
+
+// IsReplicateURL reports whether u is a replicate:// URL, or a URL on ReplicateWeightsHost.
+func IsReplicateURL(u string) bool {
+	return strings.HasPrefix(u, "replicate://") || strings.HasPrefix(u, "https://"+ReplicateWeightsHost+"/")
+}
+
+// ReplicateMode downloads replicate:// URLs, and the (possibly signed) URLs of Replicate weights, with
+// FallbackStrategy. If Options.ReplicateCredentialsEndpoint is set, it is asked for a signed URL whenever one
+// expires, so that a download outliving its signature carries on with a fresh one instead of failing halfway (see
+// client.WithSignedURLRefresh); a replicate:// URL is signed by it before the download starts.
+//
+// The endpoint is sent a POST request with the JSON document {"url": "<URL being downloaded>"}, authenticated with
+// Options.ReplicateAPIToken as a bearer token if set, and answers with {"url": "<signed URL>"}.
+//
+// Other URLs are downloaded by FallbackStrategy.
+type ReplicateMode struct {
+	Options
+	FallbackStrategy Strategy
+
+	client client.HTTPClient
+}
+
+func GetReplicateMode(opts Options, fallback Strategy) (*ReplicateMode, error) {
+	if endpoint := opts.ReplicateCredentialsEndpoint; endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid Replicate credentials endpoint %q", endpoint)
+		}
+	}
+	return &ReplicateMode{Options: opts, FallbackStrategy: fallback, client: client.NewHTTPClient(opts.Client)}, nil
+}
+
+type signedURLDocument struct {
+	URL string `json:"url"`
+}
+
+// signURL asks the credentials endpoint for a signed URL to download u from.
+func (m *ReplicateMode) signURL(ctx context.Context, u string) (string, error) {
+	body, err := json.Marshal(signedURLDocument{URL: u})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.ReplicateCredentialsEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.ReplicateAPIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+m.ReplicateAPIToken)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error signing %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w signing %s: %s", ErrUnexpectedHTTPStatus, u, resp.Status)
+	}
+	var signed signedURLDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&signed); err != nil {
+		return "", fmt.Errorf("error signing %s: invalid response from credentials endpoint: %w", u, err)
+	}
+	if parsed, err := url.Parse(signed.URL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return "", fmt.Errorf("error signing %s: credentials endpoint returned an invalid URL %q", u, signed.URL)
+	}
+	return signed.URL, nil
+}
+
+// resolve returns the URL u is downloaded from, and a context refreshing its signature.
+func (m *ReplicateMode) resolve(ctx context.Context, u string) (context.Context, string, error) {
+	resolved := u
+	if path, ok := strings.CutPrefix(u, "replicate://"); ok {
+		resolved = "https://" + ReplicateWeightsHost + "/" + path
+	}
+	if m.ReplicateCredentialsEndpoint == "" {
+		return ctx, resolved, nil
+	}
+	if resolved != u {
+		var err error
+		if resolved, err = m.signURL(ctx, u); err != nil {
+			return ctx, "", err
+		}
+	}
+	ctx = client.WithSignedURLRefresh(ctx, func(ctx context.Context) (string, error) {
+		return m.signURL(ctx, u)
+	})
+	return ctx, resolved, nil
+}
+
+func (m *ReplicateMode) Fetch(ctx context.Context, url string) (io.Reader, int64, error) {
+	if !IsReplicateURL(url) {
+		return m.FallbackStrategy.Fetch(ctx, url)
+	}
+	ctx, resolved, err := m.resolve(ctx, url)
+	if err != nil {
+		return nil, -1, err
+	}
+	logger := logging.GetLogger()
+	logger.Debug().
+		Str("url", url).
+		Bool("refresh", m.ReplicateCredentialsEndpoint != "").
+		Msg("Replicate: resolved")
+	return m.FallbackStrategy.Fetch(ctx, resolved)
+}
+
+// DoRequest requests the range of a Replicate URL as Fetch does, and of other URLs with FallbackStrategy.
+func (m *ReplicateMode) DoRequest(ctx context.Context, start, end int64, url string) (*http.Response, error) {
+	if !IsReplicateURL(url) {
+		return m.FallbackStrategy.DoRequest(ctx, start, end, url)
+	}
+	ctx, resolved, err := m.resolve(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return m.FallbackStrategy.DoRequest(ctx, start, end, resolved)
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+// signingServers starts an origin serving content at /weights with a valid signature, which expires after
+// expireAfter requests, and a credentials endpoint signing replicate://model/weights for it.
+func signingServers(t *testing.T, content string, expireAfter int64) (endpoint *httptest.Server, signed *atomic.Int64) {
+	var mu sync.Mutex
+	signature := 0
+	requests := new(atomic.Int64)
+	signed = new(atomic.Int64)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if requests.Add(1) == expireAfter {
+			signature = -1
+		}
+		valid := r.URL.Path == "/weights" && r.URL.Query().Get("sig") == fmt.Sprint(signature)
+		mu.Unlock()
+		if !valid {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader([]byte(content)))
+	}))
+	t.Cleanup(origin.Close)
+	endpoint = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var doc signedURLDocument
+		if r.Header.Get("Authorization") != "Bearer r8_token" || json.NewDecoder(r.Body).Decode(&doc) != nil || doc.URL != "replicate://model/weights" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		signature++
+		sig := signature
+		mu.Unlock()
+		signed.Add(1)
+		_ = json.NewEncoder(w).Encode(signedURLDocument{URL: fmt.Sprintf("%s/weights?sig=%d", origin.URL, sig)})
+	}))
+	t.Cleanup(endpoint.Close)
+	return endpoint, signed
+}
+
+func TestReplicateModeRefresh(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	endpoint, signed := signingServers(t, content, 4)
+	opts := Options{
+		Client:                       client.Options{},
+		ChunkSize:                    10,
+		MaxConcurrency:               4,
+		ReplicateCredentialsEndpoint: endpoint.URL,
+		ReplicateAPIToken:            "r8_token",
+	}
+	replicateMode, err := GetReplicateMode(opts, GetBufferMode(opts))
+	require.NoError(t, err)
+
+	reader, size, err := replicateMode.Fetch(context.Background(), "replicate://model/weights")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), size)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+	// signed once to start, and refreshed once however many chunks were refused
+	assert.Equal(t, int64(2), signed.Load())
+}
+
+func TestReplicateModeRefreshFails(t *testing.T) {
+	endpoint, _ := signingServers(t, "weights", 2)
+	opts := Options{
+		Client:                       client.Options{},
+		ChunkSize:                    2,
+		ReplicateCredentialsEndpoint: endpoint.URL,
+		// the endpoint refuses to sign without the token
+	}
+	replicateMode, err := GetReplicateMode(opts, GetBufferMode(opts))
+	require.NoError(t, err)
+	_, _, err = replicateMode.Fetch(context.Background(), "replicate://model/weights")
+	assert.ErrorIs(t, err, ErrUnexpectedHTTPStatus)
+	assert.ErrorContains(t, err, "signing replicate://model/weights")
+}
+
+func TestIsReplicateURL(t *testing.T) {
+	assert.True(t, IsReplicateURL("replicate://default/model.tar"))
+	assert.True(t, IsReplicateURL("https://weights.replicate.delivery/default/model.tar?sig=1"))
+	assert.False(t, IsReplicateURL("https://weights.replicate.delivery.example.com/model.tar"))
+	assert.False(t, IsReplicateURL("https://example.com/model.tar"))
+}
@@ -0,0 +1,132 @@
+package download
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/replicate/pget/v2/pkg/integrity"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// SliceDigestHeader is the response header in which a cache host may publish the digest of the whole slice a chunk
+// belongs to, in any format integrity.Parse accepts (e.g. sha256-<base64>). The consistent hashing strategy hashes
+// each slice as it is read and fails the download with ErrChecksumMismatch if it doesn't match, catching corruption
+// in the cache tier without a digest of the whole file from the origin.
+const SliceDigestHeader = "X-Slice-Digest"
+
+// sliceDigests collects the digests cache hosts publish for the slices of one download.
+type sliceDigests struct {
+	url string
+
+	mu      sync.Mutex
+	digests map[int64]*sliceDigest
+}
+
+type sliceDigest struct {
+	expected  *integrity.Integrity
+	cacheHost string
+	// err is set if cache hosts published different digests for the slice
+	err error
+}
+
+func newSliceDigests(url string) *sliceDigests {
+	return &sliceDigests{url: url, digests: make(map[int64]*sliceDigest)}
+}
+
+// record records the digest published with resp, a chunk of slice served by cacheHost. It must be called before the
+// chunk is delivered, so that the digest is known by the time the slice is read.
+func (d *sliceDigests) record(slice int64, cacheHost string, resp *http.Response) {
+	value := resp.Header.Get(SliceDigestHeader)
+	if cacheHost == "" || value == "" {
+		return
+	}
+	logger := logging.GetLogger()
+	expected, err := integrity.Parse(value)
+	if err != nil {
+		logger.Warn().
+			Str("url", d.url).
+			Int64("slice", slice).
+			Str("cache_host", cacheHost).
+			Err(err).
+			Msg("Ignoring " + SliceDigestHeader)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	digest, ok := d.digests[slice]
+	if !ok {
+		d.digests[slice] = &sliceDigest{expected: expected, cacheHost: cacheHost}
+		return
+	}
+	if digest.err == nil && !digest.expected.Equal(expected) {
+		digest.err = fmt.Errorf("%w: cache hosts %s and %s published different digests for slice %d of %s",
+			ErrChecksumMismatch, digest.cacheHost, cacheHost, slice, d.url)
+	}
+}
+
+func (d *sliceDigests) get(slice int64) (sliceDigest, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	digest, ok := d.digests[slice]
+	if !ok {
+		return sliceDigest{}, false
+	}
+	return *digest, true
+}
+
+// verifier returns r, the content of slice, checking it against the slice's digest as it is read. Whether to hash
+// the slice is decided once its first chunk has been delivered: a slice whose first chunk came from the origin, or
+// from a cache host which published no digest, is not verified.
+func (d *sliceDigests) verifier(slice int64, r io.Reader) io.Reader {
+	return &sliceVerifier{digests: d, slice: slice, r: r}
+}
+
+type sliceVerifier struct {
+	digests *sliceDigests
+	slice   int64
+	r       io.Reader
+
+	started bool
+	hash    hash.Hash
+}
+
+func (v *sliceVerifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if n > 0 && !v.started {
+		v.started = true
+		if digest, ok := v.digests.get(v.slice); ok {
+			v.hash = digest.expected.New()
+		}
+	}
+	if v.hash != nil {
+		v.hash.Write(p[:n])
+	}
+	if err == io.EOF && v.hash != nil {
+		if verifyErr := v.verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+func (v *sliceVerifier) verify() error {
+	digest, _ := v.digests.get(v.slice)
+	if digest.err != nil {
+		return digest.err
+	}
+	sum := v.hash.Sum(nil)
+	if !digest.expected.Matches(sum) {
+		return fmt.Errorf("%w: slice %d of %s is %s, cache host %s published %s", ErrChecksumMismatch,
+			v.slice, v.digests.url, digest.expected.Format(sum), digest.cacheHost, digest.expected)
+	}
+	logger := logging.GetLogger()
+	logger.Debug().
+		Str("url", v.digests.url).
+		Int64("slice", v.slice).
+		Str("cache_host", digest.cacheHost).
+		Msg("Slice Digest Verified")
+	return nil
+}
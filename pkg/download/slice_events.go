@@ -0,0 +1,115 @@
+package download
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// A SliceEvent reports that every chunk of one slice of a file has been downloaded by the consistent hashing
+// strategy, and that each chunk's Content-Range matched the requested window.
+type SliceEvent struct {
+	URL   string
+	Slice int64
+	// Start and End are the inclusive byte offsets of the slice within the file
+	Start int64
+	End   int64
+	// CacheHosts are the cache hosts that served chunks of the slice. There is normally exactly one, but there
+	// may be more if a host was retried, or none if the whole slice came from the origin.
+	CacheHosts []string
+	// Fallback is true if any chunk of the slice was fetched from the origin instead of a cache host, in which
+	// case the cache tier may not hold the full slice.
+	Fallback bool
+}
+
+// sliceTracker counts completed chunks per slice and calls onComplete once a slice is done. Chunks may complete
+// before the number of chunks in their slice is known (the first chunk is requested before the file size is), so
+// a slice is only reported once both expect and enough chunkDone calls have been made.
+type sliceTracker struct {
+	url        string
+	onComplete func(SliceEvent)
+
+	mu     sync.Mutex
+	slices map[int64]*sliceState
+}
+
+type sliceState struct {
+	event  SliceEvent
+	chunks int // zero until expect is called
+	done   int
+	failed bool
+}
+
+// newSliceTracker returns nil if onComplete is nil; all methods are safe to call on a nil *sliceTracker.
+func newSliceTracker(url string, onComplete func(SliceEvent)) *sliceTracker {
+	if onComplete == nil {
+		return nil
+	}
+	return &sliceTracker{
+		url:        url,
+		onComplete: onComplete,
+		slices:     make(map[int64]*sliceState),
+	}
+}
+
+func (t *sliceTracker) state(slice int64) *sliceState {
+	s, ok := t.slices[slice]
+	if !ok {
+		s = &sliceState{event: SliceEvent{URL: t.url, Slice: slice}}
+		t.slices[slice] = s
+	}
+	return s
+}
+
+// expect records the byte range of a slice and the number of chunks it is downloaded in.
+func (t *sliceTracker) expect(slice, start, end int64, chunks int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	s := t.state(slice)
+	s.event.Start = start
+	s.event.End = end
+	s.chunks = chunks
+	t.complete(slice, s)
+}
+
+// chunkDone records a downloaded chunk. cacheHost is empty if the chunk came from the origin. A slice with a
+// failed chunk is never reported.
+func (t *sliceTracker) chunkDone(slice int64, cacheHost string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	s := t.state(slice)
+	s.done++
+	if err != nil {
+		s.failed = true
+	}
+	if cacheHost == "" {
+		s.event.Fallback = true
+	} else if !slices.Contains(s.event.CacheHosts, cacheHost) {
+		s.event.CacheHosts = append(s.event.CacheHosts, cacheHost)
+	}
+	t.complete(slice, s)
+}
+
+// complete must be called with t.mu held, and releases it.
+func (t *sliceTracker) complete(slice int64, s *sliceState) {
+	if s.chunks == 0 || s.done < s.chunks || s.failed {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.slices, slice)
+	t.mu.Unlock()
+
+	logger := logging.GetLogger()
+	logger.Debug().
+		Str("url", s.event.URL).
+		Int64("slice", s.event.Slice).
+		Strs("cache_hosts", s.event.CacheHosts).
+		Bool("fallback", s.event.Fallback).
+		Msg("Slice Complete")
+	t.onComplete(s.event)
+}
@@ -0,0 +1,113 @@
+package download
+
+import (
+	"io"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statsWindow is the period over which StatsSnapshot.BytesPerSecond is averaged.
+const statsWindow = 10 * time.Second
+
+// StatsSnapshot is a point-in-time view of the downloads of all strategies in the process.
+type StatsSnapshot struct {
+	// ActiveChunks is the number of chunks being downloaded by a worker.
+	ActiveChunks int64
+	// QueuedChunks is the number of chunks waiting for a worker.
+	QueuedChunks int64
+	// BufferedBytes is the size of the chunks being downloaded or waiting to be read by their consumer.
+	BufferedBytes int64
+	// PausedChunks is the number of chunks waiting for BufferedBytes to fall below Options.MaxBufferedBytes
+	// before they are scheduled.
+	PausedChunks int64
+	// BytesDownloaded is the total number of bytes of response bodies read.
+	BytesDownloaded int64
+	// BytesPerSecond is the download rate averaged over the last 10 seconds.
+	BytesPerSecond float64
+	// HostErrors counts the failed requests (including those which were retried or fell back) by host.
+	HostErrors map[string]int64
+	// CacheHits and CacheMisses count the responses of cache hosts by the status they reported (see
+	// CacheStatusHeader).
+	CacheHits   int64
+	CacheMisses int64
+}
+
+type statsCollector struct {
+	activeChunks    atomic.Int64
+	queuedChunks    atomic.Int64
+	bufferedBytes   atomic.Int64
+	pausedChunks    atomic.Int64
+	bytesDownloaded atomic.Int64
+	cacheHits       atomic.Int64
+	cacheMisses     atomic.Int64
+
+	mu         sync.Mutex
+	rate       [statsWindow / time.Second]int64
+	rateSecond [statsWindow / time.Second]int64
+	hostErrors map[string]int64
+}
+
+var stats = &statsCollector{hostErrors: make(map[string]int64)}
+
+// Stats returns a snapshot of the download statistics of the process. It is cheap enough to poll frequently.
+func Stats() StatsSnapshot {
+	return stats.snapshot(time.Now())
+}
+
+func (s *statsCollector) snapshot(now time.Time) StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var windowBytes int64
+	second := now.Unix()
+	for i, bucketSecond := range s.rateSecond {
+		// the current second is still filling up, so it is left out of the average
+		if bucketSecond < second && bucketSecond >= second-int64(len(s.rate)) {
+			windowBytes += s.rate[i]
+		}
+	}
+	return StatsSnapshot{
+		ActiveChunks:    s.activeChunks.Load(),
+		QueuedChunks:    s.queuedChunks.Load(),
+		BufferedBytes:   s.bufferedBytes.Load(),
+		PausedChunks:    s.pausedChunks.Load(),
+		BytesDownloaded: s.bytesDownloaded.Load(),
+		BytesPerSecond:  float64(windowBytes) / float64(len(s.rate)),
+		HostErrors:      maps.Clone(s.hostErrors),
+		CacheHits:       s.cacheHits.Load(),
+		CacheMisses:     s.cacheMisses.Load(),
+	}
+}
+
+func (s *statsCollector) addBytes(now time.Time, n int64) {
+	s.bytesDownloaded.Add(n)
+	second := now.Unix()
+	i := second % int64(len(s.rate))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rateSecond[i] != second {
+		s.rateSecond[i] = second
+		s.rate[i] = 0
+	}
+	s.rate[i] += n
+}
+
+func (s *statsCollector) hostError(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hostErrors[host]++
+}
+
+// countingBody counts the bytes read from a response body towards the download statistics.
+type countingBody struct {
+	io.ReadCloser
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		stats.addBytes(time.Now(), int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,72 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsRollingRate(t *testing.T) {
+	s := &statsCollector{hostErrors: make(map[string]int64)}
+	now := time.Unix(1000, 0)
+	s.addBytes(now.Add(-20*time.Second), 1000) // outside the window
+	s.addBytes(now.Add(-5*time.Second), 300)
+	s.addBytes(now.Add(-1*time.Second), 700)
+	s.addBytes(now, 5000) // the current second is not averaged yet
+
+	snapshot := s.snapshot(now)
+	assert.Equal(t, int64(7000), snapshot.BytesDownloaded)
+	assert.InDelta(t, 100.0, snapshot.BytesPerSecond, 0.001)
+
+	// buckets are reused as time moves on
+	s.addBytes(now.Add(10*time.Second), 50)
+	snapshot = s.snapshot(now.Add(11 * time.Second))
+	assert.InDelta(t, 5.0, snapshot.BytesPerSecond, 0.001)
+}
+
+func TestStatsHostErrors(t *testing.T) {
+	s := &statsCollector{hostErrors: make(map[string]int64)}
+	s.hostError("a.example.com")
+	s.hostError("a.example.com")
+	s.hostError("b.example.com")
+
+	snapshot := s.snapshot(time.Now())
+	assert.Equal(t, map[string]int64{"a.example.com": 2, "b.example.com": 1}, snapshot.HostErrors)
+
+	// the snapshot is a copy
+	snapshot.HostErrors["a.example.com"] = 0
+	assert.Equal(t, int64(2), s.snapshot(time.Now()).HostErrors["a.example.com"])
+}
+
+func TestStatsCountDownloadedBytes(t *testing.T) {
+	before := Stats().BytesDownloaded
+	body := countingBody{io.NopCloser(strings.NewReader("hello world"))}
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.GreaterOrEqual(t, Stats().BytesDownloaded-before, int64(len(data)))
+}
+
+func TestRecordCacheStatus(t *testing.T) {
+	metadata := &Metadata{}
+	ctx := WithMetadata(context.Background(), metadata)
+	before := Stats()
+	for _, status := range []string{"HIT", "hit from cache-0", "STALE", "MISS", "EXPIRED, HIT", "", "DYNAMIC"} {
+		resp := &http.Response{Header: http.Header{}}
+		if status != "" {
+			resp.Header.Set(CacheStatusHeader, status)
+		}
+		recordCacheStatus(ctx, resp)
+	}
+	assert.Equal(t, int64(3), metadata.CacheHits())
+	assert.Equal(t, int64(2), metadata.CacheMisses())
+	after := Stats()
+	assert.GreaterOrEqual(t, after.CacheHits-before.CacheHits, int64(3))
+	assert.GreaterOrEqual(t, after.CacheMisses-before.CacheMisses, int64(2))
+}
@@ -3,11 +3,39 @@ package download
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/replicate/pget/v2/pkg/client"
 )
 
-var ErrUnexpectedHTTPStatus = errors.New("unexpected http status")
+// Errors returned by the strategies, which callers can branch on with errors.Is. The strategies wrap them along
+// with the underlying error, so the message still carries the details.
+var (
+	ErrUnexpectedHTTPStatus = errors.New("unexpected http status")
+
+	// ErrFileNotFound is returned, along with ErrUnexpectedHTTPStatus, when the server responds with 404 Not Found
+	// or 410 Gone. These are not retried: the object is not going to appear by asking again.
+	ErrFileNotFound = errors.New("file not found")
+
+	// ErrObjectChanged is returned, along with ErrUnexpectedHTTPStatus, when the server responds with 412
+	// Precondition Failed to a request pinned to a version of the object (see Pin): the object has been replaced, and
+	// carrying on would interleave chunks of both versions.
+	ErrObjectChanged = errors.New("object changed during download")
+
+	// ErrRangeNotSupported is returned when a server answers a range request with a success status other than
+	// 206 Partial Content, typically 200 with the whole object, so the object can't be downloaded in chunks. The
+	// buffer strategy then downloads it over a single connection, only returning this if that isn't possible either.
+	ErrRangeNotSupported = errors.New("server does not support range requests")
+
+	// ErrCacheUnreachable is returned (as client.ErrCacheUnreachable) when no cache host could be reached for a
+	// request. The consistent hashing strategy normally falls back to the origin on it.
+	ErrCacheUnreachable = client.ErrCacheUnreachable
+
+	// ErrChecksumMismatch is returned when downloaded content does not match its expected checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
 
 type Strategy interface {
 	// Fetch retrieves the content from a given URL and returns it as an io.Reader along with the file size.
@@ -26,3 +54,28 @@ type Strategy interface {
 	// The trueURL parameter is the actual URL after any redirects.
 	DoRequest(ctx context.Context, start, end int64, url string) (*http.Response, error)
 }
+
+// checkRangeResponse returns the error for a response to a range request which is not 206 Partial Content,
+// closing its body.
+func checkRangeResponse(req *http.Request, resp *http.Response) error {
+	if resp.StatusCode == http.StatusPartialContent {
+		return nil
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		resp.Body.Close()
+		return fmt.Errorf("%w: %s responded %s", ErrRangeNotSupported, req.URL.String(), resp.Status)
+	}
+	return unexpectedStatusError(req, resp)
+}
+
+// unexpectedStatusError returns the error for a response with a non-2xx status, closing its body.
+func unexpectedStatusError(req *http.Request, resp *http.Response) error {
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("%w %s: %s: %w", ErrUnexpectedHTTPStatus, req.URL.String(), resp.Status, ErrFileNotFound)
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w %s: %s: %w", ErrUnexpectedHTTPStatus, req.URL.String(), resp.Status, ErrObjectChanged)
+	}
+	return fmt.Errorf("%w %s: %s", ErrUnexpectedHTTPStatus, req.URL.String(), resp.Status)
+}
@@ -0,0 +1,62 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// fetchStream streams the download of url from the response to its first chunk, for servers which answered it with
+// the whole object (see ErrRangeNotSupported). The response body is returned as the reader, so the object is
+// streamed to the consumer rather than buffered, and an interrupted download can't be resumed.
+func (m *BufferMode) fetchStream(ctx context.Context, url string, whole *wholeObjectError) (io.Reader, int64, error) {
+	logger := logging.GetLogger()
+	resp := whole.resp
+	req := resp.Request
+	encoding, err := responseContentCoding(req, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, -1, err
+	}
+	if encoding != "" {
+		// the size is that of the decoded content, discovered by negotiateContentCoding
+		ctx = withNegotiatedCoding(ctx, encoding)
+		logger.Warn().
+			Str("url", url).
+			Str("encoding", encoding).
+			Msg("Server compresses without range requests, downloading over a single connection")
+		recordMetadata(ctx, resp)
+		return decodeContent(ctx, url, &streamBody{body: countingBody{resp.Body}}, resp.ContentLength)
+	}
+	if resp.ContentLength < 0 {
+		resp.Body.Close()
+		return nil, -1, fmt.Errorf("%w, and its response has no Content-Length", whole.err)
+	}
+	logger.Warn().
+		Str("url", url).
+		Int64("size", resp.ContentLength).
+		Msg("Server does not support range requests, downloading over a single connection")
+	recordMetadata(ctx, resp)
+	return &streamBody{body: countingBody{resp.Body}}, resp.ContentLength, nil
+}
+
+// streamBody is the reader of a download streamed by fetchStream. It closes the response body once it has been
+// read to the end, or failed.
+type streamBody struct {
+	body io.ReadCloser
+	err  error
+}
+
+func (s *streamBody) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.body.Read(p)
+	if err != nil {
+		s.err = err
+		s.body.Close()
+	}
+	return n, err
+}
@@ -0,0 +1,341 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+var (
+	// ErrNoMirrorsLeft is returned by the striped strategy when every mirror of a download has failed.
+	ErrNoMirrorsLeft = errors.New("no mirrors left")
+
+	errMirrorSizeMismatch = errors.New("mirror serves a different size")
+)
+
+// StripedMode downloads a file which is available from several mirrors by spreading its chunks across them, to
+// aggregate their bandwidth. The URL passed to Fetch is the primary mirror; Options.Mirrors are the others, which
+// must serve identical content.
+//
+// A mirror which fails a chunk (after the client's retries) or reports a different size is taken out of the
+// rotation for the rest of the download, and the chunk is retried on the remaining mirrors. The download only fails
+// once no mirror is left.
+type StripedMode struct {
+	Client client.HTTPClient
+	Options
+
+	queue *priorityWorkQueue
+}
+
+func GetStripedMode(opts Options) *StripedMode {
+	opts = opts.withLowMemoryLimits()
+	client := client.NewHTTPClient(opts.Client)
+	m := &StripedMode{
+		Client:  client,
+		Options: opts,
+	}
+	m.queue = newStrategyQueue(opts, m.chunkSize())
+	m.queue.start()
+	return m
+}
+
+func (m *StripedMode) chunkSize() int64 {
+	if m.ChunkSize == 0 {
+		return defaultChunkSize
+	}
+	return m.ChunkSize
+}
+
+func (m *StripedMode) Fetch(ctx context.Context, url string) (io.Reader, int64, error) {
+	return m.fetch(ctx, url, m.Mirrors)
+}
+
+// fetch downloads url, striping its chunks across url and mirrorURLs.
+func (m *StripedMode) fetch(ctx context.Context, url string, mirrorURLs []string) (io.Reader, int64, error) {
+	logger := logging.GetLogger()
+
+	ctx = withFlow(ctx)
+	escalationFrom(ctx).onEscalate(func() { m.queue.escalate(ctx) })
+
+	mirrors := newMirrorSet(url, mirrorURLs, m.MirrorLatencyWeighted)
+	firstChunk := newReaderPromise()
+
+	firstReqResultCh := make(chan firstReqResult, 1)
+	m.queue.submitLow(ctx, func(buf []byte) {
+		var sent bool
+		n, err := m.downloadChunk(ctx, mirrors, 0, m.chunkSize()-1, buf, func(resp *http.Response, fileSize int64) {
+			if sent {
+				// the first mirror failed part way through the chunk
+				return
+			}
+			recordMetadata(ctx, resp)
+			firstReqResultCh <- firstReqResult{fileSize: fileSize}
+			sent = true
+		}, nil)
+		if !sent {
+			firstReqResultCh <- firstReqResult{err: err}
+			return
+		}
+		firstChunk.Deliver(buf[0:n], err)
+	})
+
+	firstReqResult := <-firstReqResultCh
+	if firstReqResult.err != nil {
+		return nil, -1, firstReqResult.err
+	}
+
+	fileSize := firstReqResult.fileSize
+	if fileSize <= m.chunkSize() {
+		return firstChunk, fileSize, nil
+	}
+
+	remainingBytes := fileSize - m.chunkSize()
+	// integer divide rounding up
+	numChunks := int((remainingBytes-1)/m.chunkSize() + 1)
+
+	chunks := make([]io.Reader, numChunks+1)
+	chunks[0] = firstChunk
+
+	startOffset := m.chunkSize()
+
+	logger.Debug().Str("url", url).
+		Int64("size", fileSize).
+		Int("connections", numChunks).
+		Int("mirrors", len(mirrors.mirrors)).
+		Int64("chunkSize", m.chunkSize()).
+		Msg("Downloading")
+
+	for i := 0; i < numChunks; i++ {
+		chunks[i+1] = newReaderPromise()
+	}
+	deadlines := newChunkDeadlines(m.ChunkDeadlineFactor)
+	go func(chunks []io.Reader) {
+		for i, reader := range chunks {
+			chunk := reader.(*readerPromise)
+			m.queue.submitHigh(ctx, func(buf []byte) {
+				start := startOffset + m.chunkSize()*int64(i)
+				end := start + m.chunkSize() - 1
+				if i == numChunks-1 {
+					end = fileSize - 1
+				}
+				n, err := m.downloadChunk(ctx, mirrors, start, end, buf, nil, deadlines)
+				chunk.Deliver(buf[0:n], err)
+			})
+		}
+	}(chunks[1:])
+
+	return io.MultiReader(chunks...), fileSize, nil
+}
+
+// DoRequest requests the range from url only; the mirrors are used by Fetch.
+func (m *StripedMode) DoRequest(ctx context.Context, start, end int64, url string) (*http.Response, error) {
+	return doRangeRequest(ctx, m.Client, start, end, url)
+}
+
+// downloadChunk downloads bytes [start,end] into buf, moving on to another mirror whenever one fails. onResponse, if
+// set, is called with each successful response and the size of the file before its body is read. A mirror which
+// misses the chunk's deadline (see chunkDeadlines) is kept, but the chunk moves on to another mirror too.
+func (m *StripedMode) downloadChunk(ctx context.Context, mirrors *mirrorSet, start, end int64, buf []byte, onResponse func(*http.Response, int64), deadlines *chunkDeadlines) (int, error) {
+	logger := logging.GetLogger()
+	rescheduled := 0
+	for {
+		mirror, err := mirrors.pick()
+		if err != nil {
+			return 0, err
+		}
+		logger.Debug().Str("url", mirror.url).
+			Int64("start", start).
+			Int64("end", end).
+			Msg("Downloading chunk")
+		chunkStart := time.Now()
+		attemptCtx, attempt := deadlines.start(ctx, end-start+1, rescheduled)
+		n, err := m.readChunk(attemptCtx, mirrors, mirror.url, start, end, buf, onResponse)
+		if attempt.done(n, err) {
+			// the mirror is slow rather than failed; one that stalled counts as having read a single byte, so that
+			// latency weighting avoids it
+			mirrors.done(mirror, max(int64(n), 1), time.Since(chunkStart), nil)
+			rescheduled++
+			continue
+		}
+		if err != nil && ctx.Err() != nil {
+			// the download was aborted, which is not the mirror's fault
+			mirrors.done(mirror, 0, 0, nil)
+			return n, err
+		}
+		mirrors.done(mirror, int64(n), time.Since(chunkStart), err)
+		if err == nil {
+			return n, nil
+		}
+		logger.Warn().
+			Err(err).
+			Str("url", mirror.url).
+			Int64("start", start).
+			Int64("end", end).
+			Msg("Mirror failed, retrying chunk on another mirror")
+	}
+}
+
+func (m *StripedMode) readChunk(ctx context.Context, mirrors *mirrorSet, url string, start, end int64, buf []byte, onResponse func(*http.Response, int64)) (int, error) {
+	logger := logging.GetLogger()
+	resp, err := m.DoRequest(ctx, start, end, url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	contentLength, err := chunkLength(resp, m.Strict)
+	if err != nil {
+		return 0, err
+	}
+	if onResponse != nil {
+		fileSize, err := objectSize(ctx, m.Client, resp, m.LenientContentRange)
+		if err != nil {
+			return 0, err
+		}
+		if err := mirrors.checkSize(fileSize); err != nil {
+			return 0, err
+		}
+		onResponse(resp, fileSize)
+	} else if !m.LenientContentRange || !unknownTotal(resp) {
+		fileSize, err := ObjectSize(resp)
+		if err != nil {
+			return 0, err
+		}
+		if err := mirrors.checkSize(fileSize); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.ReadFull(resp.Body, buf[0:contentLength])
+	if err == io.ErrUnexpectedEOF {
+		logger.Warn().
+			Int("connection_interrupted_at_byte", n).
+			Msg("Resuming Chunk Download")
+		n, err = resumeDownload(resp.Request, buf[n:contentLength], m.Client, int64(n))
+	}
+	return n, err
+}
+
+// mirror is one of the URLs of a striped download.
+type mirror struct {
+	url      string
+	failed   bool
+	inFlight int
+	// secondsPerByte is a moving average of the time the mirror has taken per byte of a chunk, or zero if it has not
+	// completed a chunk yet.
+	secondsPerByte float64
+}
+
+// mirrorSet chooses the mirror for each chunk of a striped download and keeps track of the failed ones.
+type mirrorSet struct {
+	latencyWeighted bool
+
+	mu      sync.Mutex
+	mirrors []*mirror
+	next    int
+	size    int64
+	lastErr error
+}
+
+func newMirrorSet(url string, mirrorURLs []string, latencyWeighted bool) *mirrorSet {
+	s := &mirrorSet{latencyWeighted: latencyWeighted, size: -1}
+	seen := make(map[string]bool)
+	for _, u := range append([]string{url}, mirrorURLs...) {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		s.mirrors = append(s.mirrors, &mirror{url: u})
+	}
+	return s
+}
+
+// pick returns the mirror for the next chunk: the next healthy one in round-robin order or, if latency weighting is
+// enabled, the one expected to finish the chunk soonest given its throughput so far and its chunks in flight. The
+// caller must report the outcome with done.
+func (s *mirrorSet) pick() (*mirror, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var measured, totalSecondsPerByte float64
+	for _, m := range s.mirrors {
+		if !m.failed && m.secondsPerByte > 0 {
+			measured++
+			totalSecondsPerByte += m.secondsPerByte
+		}
+	}
+
+	var best *mirror
+	var bestIndex int
+	var bestCost float64
+	for j := range s.mirrors {
+		i := (s.next + j) % len(s.mirrors)
+		m := s.mirrors[i]
+		if m.failed {
+			continue
+		}
+		if !s.latencyWeighted {
+			best, bestIndex = m, i
+			break
+		}
+		// mirrors without a measurement yet are assumed to be average
+		estimate := m.secondsPerByte
+		if estimate == 0 {
+			estimate = 1
+			if measured > 0 {
+				estimate = totalSecondsPerByte / measured
+			}
+		}
+		cost := float64(m.inFlight+1) * estimate
+		if best == nil || cost < bestCost {
+			best, bestIndex, bestCost = m, i, cost
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoMirrorsLeft, s.lastErr)
+	}
+	s.next = bestIndex + 1
+	best.inFlight++
+	return best, nil
+}
+
+// done records the outcome of a chunk downloaded from m. A mirror which failed is not picked again.
+func (s *mirrorSet) done(m *mirror, n int64, elapsed time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.inFlight--
+	if err != nil {
+		m.failed = true
+		s.lastErr = err
+		return
+	}
+	if n <= 0 || elapsed <= 0 {
+		return
+	}
+	secondsPerByte := elapsed.Seconds() / float64(n)
+	if m.secondsPerByte == 0 {
+		m.secondsPerByte = secondsPerByte
+	} else {
+		m.secondsPerByte = (m.secondsPerByte + secondsPerByte) / 2
+	}
+}
+
+// checkSize checks that a mirror serves the same size as the first response of the download.
+func (s *mirrorSet) checkSize(size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size < 0 {
+		s.size = size
+		return nil
+	}
+	if size != s.size {
+		return fmt.Errorf("%w: %d bytes instead of %d", errMirrorSizeMismatch, size, s.size)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package download
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+)
+
+// countingServer serves content and counts the requests it receives
+func countingServer(t *testing.T, content []byte, requests *atomic.Int64) *httptest.Server {
+	fileServer := newTestServer(t, content)
+	t.Cleanup(fileServer.Close)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fileServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStripedModeSpreadsChunks(t *testing.T) {
+	content := generateTestContent(64 * 1024)
+	var primaryRequests, mirrorRequests atomic.Int64
+	primary := countingServer(t, content, &primaryRequests)
+	mirror := countingServer(t, content, &mirrorRequests)
+
+	stripedMode := GetStripedMode(Options{
+		Client:         client.Options{},
+		MaxConcurrency: 4,
+		ChunkSize:      4 * 1024,
+		Mirrors:        []string{mirror.URL + "/" + testFilePath},
+	})
+	reader, size, err := stripedMode.Fetch(context.Background(), primary.URL+"/"+testFilePath)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, content, data)
+	assert.Equal(t, int64(8), primaryRequests.Load())
+	assert.Equal(t, int64(8), mirrorRequests.Load())
+}
+
+func TestStripedModeFailedMirror(t *testing.T) {
+	content := generateTestContent(64 * 1024)
+	var primaryRequests, brokenRequests atomic.Int64
+	primary := countingServer(t, content, &primaryRequests)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		brokenRequests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	short := countingServer(t, content[:1000], new(atomic.Int64))
+
+	stripedMode := GetStripedMode(Options{
+		Client:         client.Options{MaxRetries: 0},
+		MaxConcurrency: 4,
+		ChunkSize:      4 * 1024,
+		Mirrors:        []string{broken.URL + "/" + testFilePath, short.URL + "/" + testFilePath},
+	})
+	reader, size, err := stripedMode.Fetch(context.Background(), primary.URL+"/"+testFilePath)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, content, data)
+	// the broken mirror is dropped once it fails, so it sees at most the chunks picked concurrently with the first
+	assert.LessOrEqual(t, brokenRequests.Load(), int64(4))
+}
+
+func TestStripedModeNoMirrorsLeft(t *testing.T) {
+	broken := httptest.NewServer(http.NotFoundHandler())
+	defer broken.Close()
+
+	stripedMode := GetStripedMode(Options{
+		Client:  client.Options{MaxRetries: 0},
+		Mirrors: []string{broken.URL + "/mirror"},
+	})
+	_, _, err := stripedMode.Fetch(context.Background(), broken.URL+"/primary")
+	assert.ErrorIs(t, err, ErrNoMirrorsLeft)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestMirrorSetPick(t *testing.T) {
+	t.Run("round-robin", func(t *testing.T) {
+		s := newMirrorSet("a", []string{"b", "a", "c"}, false)
+		var picked []string
+		for i := 0; i < 4; i++ {
+			m, err := s.pick()
+			require.NoError(t, err)
+			picked = append(picked, m.url)
+		}
+		assert.Equal(t, []string{"a", "b", "c", "a"}, picked)
+	})
+
+	t.Run("skips failed", func(t *testing.T) {
+		s := newMirrorSet("a", []string{"b"}, false)
+		m, err := s.pick()
+		require.NoError(t, err)
+		s.done(m, 0, 0, io.ErrUnexpectedEOF)
+		for i := 0; i < 2; i++ {
+			m, err = s.pick()
+			require.NoError(t, err)
+			assert.Equal(t, "b", m.url)
+		}
+		s.done(m, 0, 0, io.ErrUnexpectedEOF)
+		_, err = s.pick()
+		assert.ErrorIs(t, err, ErrNoMirrorsLeft)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("latency weighted", func(t *testing.T) {
+		s := newMirrorSet("fast", []string{"slow"}, true)
+		fast, err := s.pick()
+		require.NoError(t, err)
+		slow, err := s.pick()
+		require.NoError(t, err)
+		require.Equal(t, "slow", slow.url)
+		s.done(fast, 1000, time.Second, nil)
+		s.done(slow, 1000, 4500*time.Millisecond, nil)
+
+		// the fast mirror takes chunks until it has 4 in flight
+		var picked []string
+		for i := 0; i < 5; i++ {
+			m, err := s.pick()
+			require.NoError(t, err)
+			picked = append(picked, m.url)
+		}
+		assert.Equal(t, []string{"fast", "fast", "fast", "fast", "slow"}, picked)
+	})
+}
@@ -0,0 +1,69 @@
+package download
+
+import (
+	"cmp"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// uriAlias maps URLs under alias to the same path under target.
+type uriAlias struct {
+	alias  *url.URL
+	target *url.URL
+}
+
+// indexURIAliases turns the target->aliases map of Options.CacheURIAliases into a map from alias host to the
+// aliases on that host, longest path first so that the most specific alias wins.
+func indexURIAliases(aliases map[string][]*url.URL) map[string][]uriAlias {
+	logger := logging.GetLogger()
+	index := make(map[string][]uriAlias)
+	for targetString, aliasURLs := range aliases {
+		target, err := url.Parse(targetString)
+		if err != nil || target.Host == "" || target.Scheme == "" {
+			logger.Error().
+				Err(err).
+				Str("target", targetString).
+				Str("requirements", "requires at minimum scheme and host").
+				Msg("Cache URI Aliases")
+			continue
+		}
+		for _, alias := range aliasURLs {
+			index[alias.Host] = append(index[alias.Host], uriAlias{alias: alias, target: target})
+		}
+	}
+	for _, hostAliases := range index {
+		slices.SortFunc(hostAliases, func(a, b uriAlias) int {
+			if c := cmp.Compare(len(b.alias.Path), len(a.alias.Path)); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.alias.String(), b.alias.String())
+		})
+	}
+	return index
+}
+
+// resolveURIAlias returns u rewritten to its alias target, or false if u is not under any alias.
+func resolveURIAlias(index map[string][]uriAlias, u *url.URL) (*url.URL, bool) {
+	for _, a := range index[u.Host] {
+		if a.alias.Scheme != u.Scheme || !pathHasPrefix(u.Path, a.alias.Path) {
+			continue
+		}
+		resolved := *u
+		resolved.Scheme = a.target.Scheme
+		resolved.Host = a.target.Host
+		resolved.Path = strings.TrimSuffix(a.target.Path, "/") + "/" +
+			strings.TrimPrefix(strings.TrimPrefix(u.Path, strings.TrimSuffix(a.alias.Path, "/")), "/")
+		resolved.RawPath = ""
+		return &resolved, true
+	}
+	return nil, false
+}
+
+// pathHasPrefix reports whether path is prefix or below it, treating prefix as a directory.
+func pathHasPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
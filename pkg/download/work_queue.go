@@ -1,55 +1,246 @@
 package download
 
+import (
+	"context"
+	"sync"
+)
+
 // priorityWorkQueue takes work items and executes them, with n parallel
-// workers.  It allows for a simple high/low priority split between work.  We
-// use this to prefer finishing existing downloads over starting new downloads.
+// workers.  Low priority items start a download and high priority items
+// continue one.  The workers are shared fairly between the downloads, with new
+// downloads together getting the share of one, so that we prefer finishing
+// existing downloads over starting new downloads, without a large download
+// holding up the others (see fairItems).
+//
+// work items are provided with a buffer of the size they were submitted with (by default the bufSize of the queue).
+// Each worker keeps its buffer for later items; if growBuffers is set, workers start without one and allocate it on
+// demand, growing it only when an item needs more, so that a queue which only ever sees small items does not
+// allocate bufSize for every worker. If lowMemory is set, workers also drop their buffer whenever they run out of
+// items, so that the buffers of a finished download can be freed, and escalate adds no workers.
+//
+// The queue schedules by worker (in effect, by connection) rather than by item: a work item may be split into a
+// request and the work that reads its response, and if pipelining is enabled, a worker starts the request of its
+// next high priority item as soon as its current item's request is done, so that the next response is already
+// waiting when the worker has finished reading the current one. This hides the round trip between chunks on
+// high-latency links, at the cost of up to two connections per worker.
 //
-// work items are provided with a fixed-size buffer.
+// Items submitted with a context carrying a priority (see WithPriority) are taken before any other item.
+//
+// If the queue has a budget, an item holds its buffer size of it from when it is submitted until it has run, which
+// for a chunk is once the consumer has read it, and items are not submitted while the budget is spent (see
+// bufferBudget).
 type priorityWorkQueue struct {
-	concurrency  int
-	lowPriority  chan work
-	highPriority chan work
-	bufSize      int64
+	concurrency int
+	pipeline    bool
+	growBuffers bool
+	lowMemory   bool
+	ranked      *rankedItems
+	fair        *fairItems
+	bufSize     int64
+	budget      *bufferBudget
 }
 
 type work func([]byte)
 
+// pipelinedWork is a work item split in two: it makes its request, which doesn't need the worker's buffer, and
+// returns the work which reads the response into the buffer.
+type pipelinedWork func() work
+
+type queueItem struct {
+	// bufSize is the size of the buffer the item's work needs
+	bufSize int64
+	start   pipelinedWork
+}
+
 func newWorkQueue(concurrency int, bufSize int64) *priorityWorkQueue {
 	return &priorityWorkQueue{
-		concurrency:  concurrency,
-		lowPriority:  make(chan work),
-		highPriority: make(chan work),
-		bufSize:      bufSize,
+		concurrency: concurrency,
+		ranked:      newRankedItems(),
+		fair:        newFairItems(),
+		bufSize:     bufSize,
+	}
+}
+
+// newStrategyQueue returns the queue of a strategy with opts, whose items need buffers of bufSize bytes. On 32-bit
+// platforms the queue always behaves as with Options.LowMemory, and has no more workers than fit
+// max32BitBufferMemory of buffers.
+func newStrategyQueue(opts Options, bufSize int64) *priorityWorkQueue {
+	concurrency := opts.maxConcurrency()
+	if is32Bit {
+		concurrency = min(concurrency, int(max(max32BitBufferMemory/bufSize, 1)))
+	}
+	q := newWorkQueue(concurrency, bufSize)
+	q.lowMemory = opts.LowMemory || is32Bit
+	q.growBuffers = q.lowMemory || opts.AutoChunkSize
+	q.budget = newBufferBudget(opts.MaxBufferedBytes)
+	return q
+}
+
+func (q *priorityWorkQueue) submitLow(ctx context.Context, w work) {
+	q.submitLowSized(ctx, q.bufSize, w)
+}
+
+// submitLowSized submits a low priority item which needs a buffer of bufSize bytes.
+func (q *priorityWorkQueue) submitLowSized(ctx context.Context, bufSize int64, w work) {
+	q.budget.acquire(bufSize)
+	stats.queuedChunks.Add(1)
+	defer stats.queuedChunks.Add(-1)
+	item := queueItem{bufSize: bufSize, start: func() work { return w }}
+	if priority, ok := priorityFrom(ctx); ok {
+		q.ranked.submit(priority, true, item)
+		return
 	}
+	q.fair.submit(flowFrom(ctx), true, item)
 }
 
-func (q *priorityWorkQueue) submitLow(w work) {
-	q.lowPriority <- w
+func (q *priorityWorkQueue) submitHigh(ctx context.Context, w work) {
+	q.submitHighPipelined(ctx, func() work { return w })
 }
 
-func (q *priorityWorkQueue) submitHigh(w work) {
-	q.highPriority <- w
+// submitHighPipelined submits a high priority item whose request can be started before its worker is free.
+func (q *priorityWorkQueue) submitHighPipelined(ctx context.Context, w pipelinedWork) {
+	q.submitHighPipelinedSized(ctx, q.bufSize, w)
+}
+
+// submitHighPipelinedSized is submitHighPipelined for an item which needs a buffer of bufSize bytes.
+func (q *priorityWorkQueue) submitHighPipelinedSized(ctx context.Context, bufSize int64, w pipelinedWork) {
+	q.budget.acquire(bufSize)
+	stats.queuedChunks.Add(1)
+	defer stats.queuedChunks.Add(-1)
+	item := queueItem{bufSize: bufSize, start: w}
+	if priority, ok := priorityFrom(ctx); ok {
+		q.ranked.submit(priority, false, item)
+		return
+	}
+	q.fair.submit(flowFrom(ctx), false, item)
 }
 
 func (q *priorityWorkQueue) start() {
 	for i := 0; i < q.concurrency; i++ {
-		go q.run(make([]byte, q.bufSize))
+		go q.run(q.newBuffer())
+	}
+}
+
+func (q *priorityWorkQueue) newBuffer() []byte {
+	if q.growBuffers {
+		return nil
+	}
+	return make([]byte, q.bufSize)
+}
+
+// escalate adds as many workers as the queue has for the download of ctx (see withFlow), which only run the
+// download's items submitted without a priority. They stop once the download's Escalation is closed or ctx is done,
+// so that escalating one download adds no workers for the others. The extra workers allocate their buffers on
+// demand, for items which hold their size of the budget already.
+func (q *priorityWorkQueue) escalate(ctx context.Context) {
+	if q.lowMemory {
+		return
+	}
+	flow := flowFrom(ctx)
+	ready, stopWatching := q.fair.watchFlow(flow)
+	done := escalationFrom(ctx).closed()
+	var workers sync.WaitGroup
+	for i := 0; i < q.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			q.runFlow(ctx, flow, ready, done)
+		}()
+	}
+	go func() {
+		workers.Wait()
+		stopWatching()
+	}()
+}
+
+// runFlow runs the items of flow as they are submitted, until done is closed or ctx is done.
+func (q *priorityWorkQueue) runFlow(ctx context.Context, flow int64, ready chan struct{}, done <-chan struct{}) {
+	var buf []byte
+	for {
+		next, ok := q.fair.popFlow(flow)
+		if !ok {
+			select {
+			case <-ready:
+				continue
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		// another extra worker may take the next item
+		signal(ready)
+		if int64(len(buf)) < next.bufSize {
+			buf = make([]byte, next.bufSize)
+		}
+		runItem(next.start(), buf)
+		q.budget.release(next.bufSize)
 	}
 }
 
 func (q *priorityWorkQueue) run(buf []byte) {
+	var prefetched chan work
+	var prefetchedSize int64
 	for {
-		// read items off the high priority queue until it's empty
-		select {
-		case item := <-q.highPriority:
-			item(buf)
-		default:
-			select { // read one item from either queue, then go round the loop again
-			case item := <-q.highPriority:
-				item(buf)
-			case item := <-q.lowPriority:
-				item(buf)
+		var item work
+		var bufSize int64
+		if prefetched != nil {
+			item, bufSize = <-prefetched, prefetchedSize
+			prefetched = nil
+		} else {
+			next, ok := q.tryNext()
+			if !ok {
+				if q.lowMemory {
+					// idle: let the buffer be freed until an item needs it
+					buf = nil
+				}
+				next = q.next()
+			}
+			item, bufSize = next.start(), next.bufSize
+		}
+		if q.pipeline {
+			// the item's request is done: start the next one while this one is read
+			next, ok := q.ranked.pop()
+			if !ok {
+				next, ok = q.fair.pop(true)
+			}
+			if ok {
+				prefetched, prefetchedSize = make(chan work, 1), next.bufSize
+				go func(ch chan<- work) { ch <- next.start() }(prefetched)
 			}
 		}
+		if int64(len(buf)) < bufSize {
+			buf = make([]byte, bufSize)
+		}
+		runItem(item, buf)
+		q.budget.release(bufSize)
+	}
+}
+
+// tryNext takes the next item to run, preferring items with a priority, if there is one waiting.
+func (q *priorityWorkQueue) tryNext() (queueItem, bool) {
+	if item, ok := q.ranked.pop(); ok {
+		return item, true
+	}
+	return q.fair.pop(false)
+}
+
+// next takes the next item to run, preferring items with a priority.
+func (q *priorityWorkQueue) next() queueItem {
+	for {
+		if item, ok := q.tryNext(); ok {
+			return item
+		}
+		select { // wait for an item on either queue
+		case <-q.ranked.ready:
+		case <-q.fair.ready:
+			// another worker may have taken the item first
+		}
 	}
 }
+
+func runItem(item work, buf []byte) {
+	stats.activeChunks.Add(1)
+	defer stats.activeChunks.Add(-1)
+	item(buf)
+}
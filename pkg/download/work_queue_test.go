@@ -0,0 +1,225 @@
+package download
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkQueuePipeline(t *testing.T) {
+	for _, pipeline := range []bool{false, true} {
+		q := newWorkQueue(1, 1)
+		q.pipeline = pipeline
+		q.start()
+
+		firstRequesting := make(chan struct{})
+		secondRequested := make(chan struct{})
+		firstRead := make(chan bool, 1)
+		go q.submitHighPipelined(context.Background(), func() work {
+			close(firstRequesting)
+			// give the second item time to be queued
+			time.Sleep(10 * time.Millisecond)
+			return func([]byte) {
+				// with pipelining, the second request is made while the first item is still being read
+				select {
+				case <-secondRequested:
+					firstRead <- true
+				case <-time.After(50 * time.Millisecond):
+					firstRead <- false
+				}
+			}
+		})
+		<-firstRequesting
+		done := make(chan struct{})
+		go q.submitHighPipelined(context.Background(), func() work {
+			close(secondRequested)
+			return func([]byte) { close(done) }
+		})
+
+		assert.Equal(t, pipeline, <-firstRead)
+		<-done
+	}
+}
+
+func TestWorkQueueLowMemoryReleasesIdleBuffers(t *testing.T) {
+	for _, lowMemory := range []bool{false, true} {
+		q := newWorkQueue(1, 8)
+		q.lowMemory = lowMemory
+		q.start()
+
+		q.submitHigh(context.Background(), func(buf []byte) { buf[0] = 1 })
+		// let the worker run out of items
+		time.Sleep(10 * time.Millisecond)
+		reused := make(chan bool, 1)
+		q.submitHigh(context.Background(), func(buf []byte) { reused <- buf[0] == 1 })
+
+		assert.Equal(t, !lowMemory, <-reused)
+	}
+}
+
+func TestWorkQueuePriority(t *testing.T) {
+	q := newWorkQueue(1, 1)
+	q.start()
+
+	release := make(chan struct{})
+	q.submitHigh(context.Background(), func([]byte) { <-release })
+
+	// queued while the only worker is busy
+	order := make(chan string, 4)
+	submit := func(ctx context.Context, name string, low bool) {
+		if low {
+			go q.submitLow(ctx, func([]byte) { order <- name })
+		} else {
+			go q.submitHigh(ctx, func([]byte) { order <- name })
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	submit(context.Background(), "high", false)
+	submit(WithPriority(context.Background(), 2), "priority 2", false)
+	submit(WithPriority(context.Background(), 1), "priority 1 start", true)
+	submit(WithPriority(context.Background(), 1), "priority 1", false)
+	close(release)
+
+	var got []string
+	for range 4 {
+		got = append(got, <-order)
+	}
+	assert.Equal(t, []string{"priority 1", "priority 1 start", "priority 2", "high"}, got)
+}
+
+func TestWorkQueueFairness(t *testing.T) {
+	q := newWorkQueue(1, 1)
+	q.start()
+
+	release := make(chan struct{})
+	q.submitHigh(context.Background(), func([]byte) { <-release })
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) work {
+		return func([]byte) {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	// a large download, whose chunks are queued first
+	large := withFlow(context.Background())
+	largeDone := make(chan struct{})
+	go func() {
+		for range 50 {
+			q.submitHigh(large, record("large"))
+		}
+		close(largeDone)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// a small download, started after it
+	small := withFlow(context.Background())
+	smallDone := make(chan struct{})
+	go func() {
+		q.submitLow(small, record("small"))
+		for range 2 {
+			q.submitHigh(small, record("small"))
+		}
+		close(smallDone)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	<-smallDone
+	<-largeDone
+	time.Sleep(10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the small download's chunks are taken in turn with the large one's, rather than after all of them
+	assert.Len(t, order, 53)
+	assert.NotContains(t, order[10:], "small")
+}
+
+func TestWorkQueueBudget(t *testing.T) {
+	q := newWorkQueue(4, 10)
+	q.budget = newBufferBudget(20)
+	q.start()
+
+	// items block until released, as chunks do until the consumer reads them
+	var started atomic.Int64
+	release := make(chan struct{})
+	submitted := make(chan struct{})
+	go func() {
+		for i := 0; i < 4; i++ {
+			q.submitHigh(context.Background(), func([]byte) {
+				started.Add(1)
+				<-release
+			})
+		}
+		close(submitted)
+	}()
+
+	// two items fit in the budget, although there are workers for all of them
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(2), started.Load())
+	close(release)
+	<-submitted
+	assert.Eventually(t, func() bool { return started.Load() == 4 }, time.Second, time.Millisecond)
+}
+
+func TestBufferBudgetOversizedItem(t *testing.T) {
+	b := newBufferBudget(10)
+	// an item larger than the budget is let through once nothing else holds any
+	b.acquire(25)
+	acquired := make(chan struct{})
+	go func() {
+		b.acquire(5)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired while the budget was spent")
+	case <-time.After(10 * time.Millisecond):
+	}
+	b.release(25)
+	<-acquired
+}
+
+func TestWorkQueueEscalate(t *testing.T) {
+	q := newWorkQueue(1, 1)
+	q.start()
+
+	release := make(chan struct{})
+	q.submitHigh(context.Background(), func([]byte) { <-release })
+
+	escalation := NewEscalation()
+	escalated := withFlow(WithEscalation(context.Background(), escalation))
+	other := withFlow(context.Background())
+	q.escalate(escalated)
+
+	ran := make(chan string, 3)
+	go q.submitHigh(other, func([]byte) { ran <- "other" })
+	go q.submitHigh(escalated, func([]byte) { ran <- "escalated" })
+	// only the escalated download has an extra worker
+	assert.Equal(t, "escalated", <-ran)
+	select {
+	case name := <-ran:
+		t.Fatalf("%s ran while the only worker is busy", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// once closed, the escalation's worker stops
+	escalation.Close()
+	time.Sleep(10 * time.Millisecond)
+	go q.submitHigh(escalated, func([]byte) { ran <- "escalated after close" })
+	select {
+	case name := <-ran:
+		t.Fatalf("%s ran while the only worker is busy", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	assert.ElementsMatch(t, []string{"other", "escalated after close"}, []string{<-ran, <-ran})
+}
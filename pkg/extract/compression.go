@@ -10,7 +10,7 @@ import (
 	"github.com/pierrec/lz4"
 	"github.com/ulikunitz/xz"
 
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/logging"
 )
 
 const (
@@ -25,6 +25,11 @@ var (
 	lz4Magic  = []byte{0x18, 0x4D, 0x22, 0x04}
 )
 
+// CompressionFormats returns the names of the compression formats detectFormat recognizes.
+func CompressionFormats() []string {
+	return []string{"gzip", "bzip2", "xz", "lzw", "lz4"}
+}
+
 var _ decompressor = gzipDecompressor{}
 var _ decompressor = bzip2Decompressor{}
 var _ decompressor = xzDecompressor{}
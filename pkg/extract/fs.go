@@ -0,0 +1,143 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/vfs"
+)
+
+// checkFS returns an error if opts select features which opts.FS can't support.
+func (opts Options) checkFS() error {
+	switch {
+	case opts.Preserve.any():
+		return fmt.Errorf("metadata can't be preserved when extracting to %s", opts.FS)
+	case len(opts.Index) > 0:
+		return fmt.Errorf("an index can't be used when extracting to %s", opts.FS)
+	}
+	return nil
+}
+
+// extractEntriesToFS reads every entry of tarReader, writing regular files to opts.FS (through pool, if it is
+// non-nil). A link is an error, as it can't be represented in an object store.
+func extractEntriesToFS(tarReader *tar.Reader, opts Options, pool *writePool) error {
+	logger := logging.GetLogger()
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		name, err := vfs.CleanName(header.Name)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrZipSlip, err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := opts.FS.MkdirAll(name, cleanFileMode(os.FileMode(header.Mode).Perm())); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			logger.Debug().
+				Str("dest", opts.FS.String()).
+				Str("target", name).
+				Int64("size", header.Size).
+				Msg("Tar: File")
+			if pool != nil && header.Size <= parallelWriteMaxSize {
+				data := make([]byte, header.Size)
+				if _, err := io.ReadFull(tarReader, data); err != nil {
+					return err
+				}
+				if err := pool.submit(name, header, data); err != nil {
+					return err
+				}
+				continue
+			}
+			if pool != nil {
+				if err := pool.sync(name); err != nil {
+					return err
+				}
+			}
+			if err := writeEntry(name, header, opts, tarReader); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return fmt.Errorf("link %s can't be extracted to %s", header.Name, opts.FS)
+		default:
+			return fmt.Errorf("unsupported file type for %s, typeflag %s", header.Name, string(header.Typeflag))
+		}
+	}
+}
+
+// writeEntryToFS writes a regular file entry to opts.FS.
+func writeEntryToFS(name string, header *tar.Header, opts Options, r io.Reader) error {
+	if err := opts.FS.WriteFile(context.Background(), name, r, header.Size, cleanFileMode(os.FileMode(header.Mode).Perm())); err != nil {
+		return fmt.Errorf("error writing %s to %s: %w", name, opts.FS, err)
+	}
+	return nil
+}
+
+// extractZipToFS writes the regular files of zipReader to opts.FS, with up to opts.Concurrency at a time.
+func extractZipToFS(zipReader *zip.Reader, opts Options) error {
+	logger := logging.GetLogger()
+	errGroup := new(errgroup.Group)
+	errGroup.SetLimit(max(opts.Concurrency, 1))
+	for _, f := range zipReader.File {
+		name, err := vfs.CleanName(f.Name)
+		if err != nil {
+			_ = errGroup.Wait()
+			return fmt.Errorf("%w: %w", ErrZipSlip, err)
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := opts.FS.MkdirAll(name, cleanFileMode(mode.Perm())); err != nil {
+				_ = errGroup.Wait()
+				return err
+			}
+		case mode.IsRegular():
+			logger.Debug().
+				Str("dest", opts.FS.String()).
+				Str("target", name).
+				Uint64("size", f.UncompressedSize64).
+				Msg("Zip: File")
+			errGroup.Go(func() error {
+				rc, err := f.Open()
+				if err != nil {
+					return fmt.Errorf("error opening %s: %w", f.Name, err)
+				}
+				defer rc.Close()
+				// the zip reader verifies the entry's checksum once it has been read to the end, but an upload of
+				// the declared size stops short of that: read on, so that a corrupt entry fails the extraction
+				err = opts.FS.WriteFile(context.Background(), name, rc, int64(f.UncompressedSize64), cleanFileMode(mode.Perm()))
+				if err == nil {
+					_, err = io.Copy(io.Discard, rc)
+				}
+				if err != nil {
+					return fmt.Errorf("error writing %s to %s: %w", name, opts.FS, err)
+				}
+				return nil
+			})
+		case mode&os.ModeSymlink != 0:
+			_ = errGroup.Wait()
+			return fmt.Errorf("link %s can't be extracted to %s", f.Name, opts.FS)
+		default:
+			_ = errGroup.Wait()
+			return fmt.Errorf("unsupported file type for %s, mode %s", f.Name, mode)
+		}
+	}
+	return errGroup.Wait()
+}
@@ -0,0 +1,108 @@
+package extract
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memFS is a vfs.FS keeping the files written to it in memory, like an object store has no directories.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func (m *memFS) MkdirAll(string, fs.FileMode) error {
+	return nil
+}
+
+func (m *memFS) WriteFile(_ context.Context, name string, r io.Reader, size int64, _ fs.FileMode) error {
+	data, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = string(data)
+	return nil
+}
+
+func (m *memFS) String() string {
+	return "mem://"
+}
+
+func TestTarFileToFS(t *testing.T) {
+	large := string(bytes.Repeat([]byte("x"), parallelWriteMaxSize+1))
+	testCases := []struct {
+		name    string
+		headers []*tar.Header
+		content []string
+		files   map[string]string
+		err     error
+	}{
+		{
+			name: "files",
+			headers: []*tar.Header{
+				{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "a/b.txt", Typeflag: tar.TypeReg, Mode: 0644},
+				{Name: "./c.txt", Typeflag: tar.TypeReg, Mode: 0644},
+				{Name: "large.bin", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+			content: []string{"", "hello", "world", large},
+			files:   map[string]string{"a/b.txt": "hello", "c.txt": "world", "large.bin": large},
+		},
+		{
+			name:    "link",
+			headers: []*tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "target"}},
+			content: []string{""},
+			files:   map[string]string{},
+		},
+		{
+			name:    "outside destination",
+			headers: []*tar.Header{{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0644}},
+			content: []string{"escape"},
+			files:   map[string]string{},
+			err:     ErrZipSlip,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for i, header := range tc.headers {
+				header.Size = int64(len(tc.content[i]))
+				require.NoError(t, tw.WriteHeader(header))
+				_, err := tw.Write([]byte(tc.content[i]))
+				require.NoError(t, err)
+			}
+			require.NoError(t, tw.Close())
+
+			fsys := &memFS{files: make(map[string]string)}
+			err := TarFile(bufio.NewReader(&buf), "", Options{FS: fsys, Concurrency: 4})
+			switch {
+			case tc.err != nil:
+				assert.ErrorIs(t, err, tc.err)
+			case tc.name == "link":
+				assert.ErrorContains(t, err, "can't be extracted to mem://")
+			default:
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.files, fsys.files)
+		})
+	}
+}
+
+func TestTarFileToFSRejectsPreserve(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, tar.NewWriter(&buf).Close())
+	err := TarFile(bufio.NewReader(&buf), "", Options{FS: &memFS{}, Preserve: Preserve{Times: true}})
+	assert.ErrorContains(t, err, "metadata can't be preserved")
+}
@@ -0,0 +1,190 @@
+package extract
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// An IndexEntry is one entry of a listing of a tar archive, read by ReadIndex.
+type IndexEntry struct {
+	Name string
+	Dir  bool
+	// Size is the size of a regular file, or -1 if the listing does not give it.
+	Size int64
+}
+
+// ReadIndex reads a listing of the entries of a tar archive, as used by --extract-index. Each line is either the
+// name of an entry, as printed by `tar -tf`, or its size in bytes and name separated by a tab. Directory names end
+// with a slash. Blank lines are ignored.
+func ReadIndex(r io.Reader) ([]IndexEntry, error) {
+	var entries []IndexEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		entry := IndexEntry{Name: text, Size: -1}
+		if sizeText, name, ok := strings.Cut(text, "\t"); ok {
+			size, err := strconv.ParseInt(sizeText, 10, 64)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("line %d of index: invalid size %q", line, sizeText)
+			}
+			entry.Name, entry.Size = name, size
+		}
+		if strings.HasSuffix(entry.Name, "/") {
+			entry.Dir = true
+			entry.Size = -1
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading index: %w", err)
+	}
+	return entries, nil
+}
+
+// preparedTree records the files and directories created by prepareTree. Their entries are extracted in place,
+// rather than under Options.Overwrite, and given the permission bits of the archive.
+type preparedTree struct {
+	mu    sync.Mutex
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+// take reports whether target is in set, and removes it, so that a later entry with the same name is extracted as
+// usual.
+func (t *preparedTree) take(set map[string]bool, target string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prepared := set[target]
+	delete(set, target)
+	return prepared
+}
+
+func (t *preparedTree) takeFile(target string) bool {
+	return t != nil && t.take(t.files, target)
+}
+
+func (t *preparedTree) takeDir(target string) bool {
+	return t != nil && t.take(t.dirs, target)
+}
+
+// removeUnused removes the files created by prepareTree which were not extracted, because the index listed entries
+// which are not in the archive.
+func (t *preparedTree) removeUnused() error {
+	if t == nil {
+		return nil
+	}
+	logger := logging.GetLogger()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for target := range t.files {
+		logger.Warn().
+			Str("target", target).
+			Msg("Tar: removing file listed in index but not in archive")
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+		delete(t.files, target)
+	}
+	return nil
+}
+
+// prepareTree creates the directories of opts.Index in destDir, and allocates the space of the regular files
+// larger than parallelWriteMaxSize, before the archive is read. Files which already exist are left alone, unless
+// the overwrite policy forbids them, in which case the extraction fails before anything is read.
+func prepareTree(destDir string, opts Options) (*preparedTree, error) {
+	logger := logging.GetLogger()
+	tree := &preparedTree{files: make(map[string]bool), dirs: make(map[string]bool)}
+	created := make(map[string]bool)
+	mkdir := func(dir string) error {
+		if created[dir] {
+			return nil
+		}
+		created[dir] = true
+		return os.MkdirAll(dir, 0755)
+	}
+	var preallocated int64
+	for _, entry := range opts.Index {
+		if err := guardEntryName(entry.Name, destDir); err != nil {
+			return nil, err
+		}
+		target := filepath.Join(destDir, entry.Name)
+		if entry.Dir {
+			if _, err := os.Stat(target); errors.Is(err, fs.ErrNotExist) {
+				tree.dirs[target] = true
+			}
+			if err := mkdir(target); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := mkdir(filepath.Dir(target)); err != nil {
+			return nil, err
+		}
+		if entry.Size <= parallelWriteMaxSize || tree.files[target] {
+			continue
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if errors.Is(err, fs.ErrExist) && opts.Overwrite.Allowed() {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = preallocate(f, entry.Size)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error allocating %s: %w", target, err)
+		}
+		tree.files[target] = true
+		preallocated += entry.Size
+	}
+	logger.Debug().
+		Int("entries", len(opts.Index)).
+		Int("directories", len(tree.dirs)).
+		Int("files", len(tree.files)).
+		Int64("preallocated_bytes", preallocated).
+		Msg("Tar: prepared tree from index")
+	return tree, nil
+}
+
+// writePrepared writes the content of a regular file entry over the space allocated by prepareTree, then sets its
+// size and permission bits from header, and applies the metadata selected by opts.Preserve.
+func writePrepared(target string, header *tar.Header, opts Options, r io.Reader) error {
+	targetFile, err := os.OpenFile(target, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(targetFile, r); err != nil {
+		targetFile.Close()
+		return err
+	}
+	// the index may have overestimated the size
+	if err := targetFile.Truncate(header.Size); err != nil {
+		targetFile.Close()
+		return fmt.Errorf("error truncating file %s: %w", target, err)
+	}
+	if err := targetFile.Chmod(cleanFileMode(os.FileMode(header.Mode).Perm())); err != nil {
+		targetFile.Close()
+		return fmt.Errorf("error setting permissions of %s: %w", target, err)
+	}
+	if err := targetFile.Close(); err != nil {
+		return fmt.Errorf("error closing file %s: %w", target, err)
+	}
+	return applyMetadata(target, header, opts.Preserve)
+}
@@ -0,0 +1,136 @@
+package extract
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var ErrExternalLink = errors.New("archive contains link pointing outside of target directory")
+
+// A LinkPolicy decides what happens to links in an archive whose target is outside the target directory.
+type LinkPolicy string
+
+const (
+	// LinkPolicyDenyExternal fails the extraction. This is the default.
+	LinkPolicyDenyExternal LinkPolicy = "deny-external"
+	// LinkPolicyRewrite resolves link targets as if the target directory were the filesystem root, so that
+	// absolute targets and targets escaping with ".." point inside it.
+	LinkPolicyRewrite LinkPolicy = "rewrite"
+	// LinkPolicyAllow creates links as they are in the archive.
+	LinkPolicyAllow LinkPolicy = "allow"
+)
+
+// ParseLinkPolicy parses the value of --extract-links. An empty value selects LinkPolicyDenyExternal.
+func ParseLinkPolicy(value string) (LinkPolicy, error) {
+	switch policy := LinkPolicy(value); policy {
+	case "":
+		return LinkPolicyDenyExternal, nil
+	case LinkPolicyDenyExternal, LinkPolicyRewrite, LinkPolicyAllow:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown --extract-links value %q, expected deny-external, rewrite or allow", value)
+}
+
+// checkLink applies policy to l and returns the link name to create it with. The checks are made against the
+// filesystem as it is when the link is created, with symlinks resolved, so that a chain of links which are each
+// harmless on their own cannot reach outside destDir either.
+func checkLink(l *link, destDir string, policy LinkPolicy) (string, error) {
+	if policy == LinkPolicyAllow {
+		return l.oldName, nil
+	}
+	root, err := filepath.Abs(destDir)
+	if err != nil {
+		return "", err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(l.newName))
+	if err != nil {
+		return "", err
+	}
+	parent, err = filepath.Abs(parent)
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(root, parent) {
+		return "", fmt.Errorf("%w: `%s` is created outside of `%s`", ErrExternalLink, l.newName, root)
+	}
+
+	// hard link names are relative to the root of the archive, symlink names to the directory of the link
+	base := root
+	if l.linkType != tar.TypeLink {
+		base = parent
+	}
+	if isInside(root, base, l.oldName) {
+		return l.oldName, nil
+	}
+	if policy != LinkPolicyRewrite {
+		return "", fmt.Errorf("%w: `%s` points to `%s`", ErrExternalLink, l.newName, l.oldName)
+	}
+
+	rooted := l.oldName
+	if !filepath.IsAbs(rooted) {
+		rel, err := filepath.Rel(root, base)
+		if err != nil {
+			return "", err
+		}
+		rooted = filepath.Join(string(filepath.Separator), rel, rooted)
+	}
+	// cleaning a rooted path drops any ".." which would climb above the root
+	rooted = filepath.Clean(string(filepath.Separator) + rooted)
+	rewritten, err := filepath.Rel(base, filepath.Join(root, rooted))
+	if err != nil {
+		return "", err
+	}
+	if !isInside(root, base, rewritten) {
+		return "", fmt.Errorf("%w: `%s` points to `%s`, even when rewritten to `%s`", ErrExternalLink, l.newName, l.oldName, rewritten)
+	}
+	return rewritten, nil
+}
+
+// isInside reports whether name, relative to base (both within root), stays inside root once the symlinks which
+// already exist along it are resolved.
+func isInside(root, base, name string) bool {
+	if filepath.IsAbs(name) {
+		return false
+	}
+	// walk the components ourselves rather than cleaning the path, since ".." after a symlink is relative to the
+	// symlink's target
+	current := base
+	components := strings.Split(filepath.ToSlash(name), "/")
+	for i, component := range components {
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			next := filepath.Join(current, component)
+			resolved, err := filepath.EvalSymlinks(next)
+			if err != nil {
+				// the rest of the path does not exist yet; anything may be created there later (including links),
+				// so only accept it if it cannot climb back up
+				for _, rest := range components[i+1:] {
+					if rest == ".." {
+						return false
+					}
+				}
+				return withinDir(root, next)
+			}
+			current = resolved
+		}
+		if !withinDir(root, current) {
+			return false
+		}
+	}
+	return true
+}
+
+func withinDir(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
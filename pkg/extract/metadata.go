@@ -0,0 +1,46 @@
+package extract
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const paxXattrPrefix = "SCHILY.xattr."
+
+// applyMetadata applies the metadata selected by preserve from header to target, which must already exist. The
+// owner is set first, as changing it may clear some extended attributes, and the times last, as setting
+// attributes may update them.
+func applyMetadata(target string, header *tar.Header, preserve Preserve) error {
+	if preserve.Owner && os.Geteuid() == 0 {
+		if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+			return fmt.Errorf("error setting owner of %s: %w", target, err)
+		}
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		// times and extended attributes would be applied to the link target rather than the link
+		return nil
+	}
+	if preserve.Xattrs {
+		for key, value := range header.PAXRecords {
+			name, ok := strings.CutPrefix(key, paxXattrPrefix)
+			if !ok {
+				continue
+			}
+			if err := setXattr(target, name, []byte(value)); err != nil {
+				return fmt.Errorf("error setting extended attribute %s of %s: %w", name, target, err)
+			}
+		}
+	}
+	if preserve.Times {
+		atime := header.AccessTime
+		if atime.IsZero() {
+			atime = header.ModTime
+		}
+		if err := os.Chtimes(target, atime, header.ModTime); err != nil {
+			return fmt.Errorf("error setting times of %s: %w", target, err)
+		}
+	}
+	return nil
+}
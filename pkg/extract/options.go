@@ -0,0 +1,65 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/replicate/pget/v2/pkg/overwrite"
+	"github.com/replicate/pget/v2/pkg/vfs"
+)
+
+// Options control how an archive is extracted.
+type Options struct {
+	// Overwrite is the policy for files and links which already exist. If empty, overwrite.Never is used.
+	Overwrite overwrite.Policy
+	// Concurrency is the number of goroutines writing extracted files. If less than 2, files are written by the
+	// goroutine reading the archive.
+	Concurrency int
+	Preserve    Preserve
+	// Links is the policy for links pointing outside the target directory. If empty, LinkPolicyDenyExternal is
+	// used.
+	Links LinkPolicy
+	// Index, if set, lists the entries of a tar archive (see ReadIndex). Its directories are created, and the space
+	// of its large files allocated, before the archive is read. Entries missing from it are still extracted.
+	Index []IndexEntry
+	// FS, if set, is the destination extracted entries are written to instead of the local directory passed to
+	// TarFile or ZipFile, e.g. an object store bucket (see vfs.Open). Only regular files and directories can be
+	// extracted to it, and Overwrite, Preserve and Index do not apply.
+	FS vfs.FS
+
+	prepared *preparedTree
+}
+
+// Preserve selects which metadata recorded in an archive is applied to the extracted entries. Permission bits
+// (without setuid, setgid and sticky) are always applied.
+type Preserve struct {
+	// Owner sets the uid and gid of each entry. It is ignored unless pget is running as root.
+	Owner bool
+	// Times sets the modification and access times of files and directories.
+	Times bool
+	// Xattrs sets the extended attributes recorded in PAX headers on files and directories.
+	Xattrs bool
+}
+
+// ParsePreserve parses a comma-separated list of "owner", "times" and "xattrs", as used by --extract-preserve.
+func ParsePreserve(value string) (Preserve, error) {
+	var p Preserve
+	for _, item := range strings.Split(value, ",") {
+		switch strings.TrimSpace(item) {
+		case "":
+		case "owner":
+			p.Owner = true
+		case "times":
+			p.Times = true
+		case "xattrs":
+			p.Xattrs = true
+		default:
+			return Preserve{}, fmt.Errorf("unknown --extract-preserve value %q, expected owner, times or xattrs", item)
+		}
+	}
+	return p, nil
+}
+
+func (p Preserve) any() bool {
+	return p.Owner || p.Times || p.Xattrs
+}
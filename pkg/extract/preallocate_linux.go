@@ -0,0 +1,21 @@
+//go:build linux
+
+package extract
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preallocation reports whether preallocate allocates disk space.
+const Preallocation = true
+
+// preallocate allocates size bytes of disk space to f, which must be empty, and sets its size.
+func preallocate(f *os.File, size int64) error {
+	if err := unix.Fallocate(int(f.Fd()), 0, 0, size); err == nil {
+		return nil
+	}
+	// not every filesystem supports fallocate; setting the size is all that can be done then
+	return f.Truncate(size)
+}
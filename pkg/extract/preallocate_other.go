@@ -0,0 +1,13 @@
+//go:build !linux
+
+package extract
+
+import "os"
+
+// Preallocation reports whether preallocate allocates disk space.
+const Preallocation = false
+
+// preallocate sets the size of f, which must be empty. Disk space is only allocated on Linux.
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
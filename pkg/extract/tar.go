@@ -11,7 +11,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/overwrite"
 )
 
 var ErrZipSlip = errors.New("archive (tar) file contains file outside of target directory")
@@ -23,8 +24,19 @@ type link struct {
 	newName  string
 }
 
-func TarFile(r *bufio.Reader, destDir string, overwrite bool) error {
+// deferredMetadata is the metadata of a directory or symlink, which is applied once the directory has been filled
+// or the symlink created.
+type deferredMetadata struct {
+	target string
+	header *tar.Header
+}
+
+// TarFile extracts the (optionally compressed) tar archive read from r into destDir, or opts.FS if it is set. With a
+// concurrency greater than one, small regular files are written by that many goroutines while the archive is being
+// read; directories are always created, and links made, by the calling goroutine.
+func TarFile(r *bufio.Reader, destDir string, opts Options) error {
 	var links []*link
+	var deferred []deferredMetadata
 	var reader io.Reader = r
 
 	log := logging.GetLogger()
@@ -43,17 +55,87 @@ func TarFile(r *bufio.Reader, destDir string, overwrite bool) error {
 			Str("decompressor", fmt.Sprintf("%T", decompressor)).
 			Msg("Tar Compression Detected: Compression can significantly slowdown pget (e.g. for model weights)")
 	}
+	if opts.FS != nil {
+		if err := opts.checkFS(); err != nil {
+			return err
+		}
+	}
 	tarReader := tar.NewReader(reader)
 	logger := logging.GetLogger()
 
 	logger.Debug().
 		Str("extractor", "tar").
 		Str("status", "starting").
+		Int("concurrency", opts.Concurrency).
+		Msg("Extract")
+	if len(opts.Index) > 0 {
+		opts.prepared, err = prepareTree(destDir, opts)
+		if err != nil {
+			return fmt.Errorf("error preparing tree from index: %w", err)
+		}
+	}
+	pool := newWritePool(opts)
+	if opts.FS != nil {
+		err = extractEntriesToFS(tarReader, opts, pool)
+	} else {
+		err = extractEntries(tarReader, destDir, opts, pool, &links, &deferred)
+	}
+	if err != nil {
+		if pool != nil {
+			_ = pool.wait()
+		}
+		return err
+	}
+	if pool != nil {
+		// links may point at files which are still being written
+		if err := pool.wait(); err != nil {
+			return err
+		}
+	}
+
+	if err := opts.prepared.removeUnused(); err != nil {
+		return fmt.Errorf("error removing unused files: %w", err)
+	}
+	if err := createLinks(links, destDir, opts.Overwrite, opts.Links); err != nil {
+		return fmt.Errorf("error creating links: %w", err)
+	}
+	// in reverse, so that a directory's times are set after those of the directories inside it
+	for i := len(deferred) - 1; i >= 0; i-- {
+		if err := applyMetadata(deferred[i].target, deferred[i].header, opts.Preserve); err != nil {
+			return err
+		}
+	}
+
+	// Read the rest of the bytes from the archive and verify they are all null bytes
+	// This is for validation that the byte count is correct
+	padding, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("error reading padding bytes: %w", err)
+	}
+	for _, b := range padding {
+		if b != 0x00 {
+			return fmt.Errorf("unexpected non-null byte in padding: %x", b)
+		}
+	}
+
+	elapsed := time.Since(startTime).Seconds()
+	logger.Debug().
+		Str("extractor", "tar").
+		Float64("elapsed_time", elapsed).
+		Str("status", "complete").
 		Msg("Extract")
+	return nil
+}
+
+// extractEntries reads every entry of tarReader, creating directories and writing regular files (through pool, if
+// it is non-nil), and collecting links to be created once all files exist, and the metadata of directories and
+// symlinks to be applied at the end.
+func extractEntries(tarReader *tar.Reader, destDir string, opts Options, pool *writePool, links *[]*link, deferred *[]deferredMetadata) error {
+	logger := logging.GetLogger()
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
 			return err
@@ -83,25 +165,37 @@ func TarFile(r *bufio.Reader, destDir string, overwrite bool) error {
 			if err := os.MkdirAll(target, cleanFileMode(os.FileMode(header.Mode))); err != nil {
 				return err
 			}
-		case tar.TypeReg:
-			openFlags := os.O_CREATE | os.O_WRONLY
-			if overwrite {
-				openFlags |= os.O_TRUNC
+			if opts.prepared.takeDir(target) {
+				if err := os.Chmod(target, cleanFileMode(os.FileMode(header.Mode).Perm())); err != nil {
+					return fmt.Errorf("error setting permissions of %s: %w", target, err)
+				}
+			}
+			if opts.Preserve.any() {
+				*deferred = append(*deferred, deferredMetadata{target: target, header: header})
 			}
+		case tar.TypeReg:
 			logger.Debug().
 				Str("target", target).
 				Str("perms", fmt.Sprintf("%o", header.Mode)).
 				Msg("Tar: File")
-			targetFile, err := os.OpenFile(target, openFlags, cleanFileMode(os.FileMode(header.Mode)))
-			if err != nil {
-				return err
+			if pool != nil && header.Size <= parallelWriteMaxSize {
+				data := make([]byte, header.Size)
+				if _, err := io.ReadFull(tarReader, data); err != nil {
+					return err
+				}
+				if err := pool.submit(target, header, data); err != nil {
+					return err
+				}
+				continue
 			}
-			if _, err := io.Copy(targetFile, tarReader); err != nil {
-				targetFile.Close()
-				return err
+			if pool != nil {
+				// an earlier entry with the same name may still be queued
+				if err := pool.sync(target); err != nil {
+					return err
+				}
 			}
-			if err := targetFile.Close(); err != nil {
-				return fmt.Errorf("error closing file %s: %w", target, err)
+			if err := writeEntry(target, header, opts, tarReader); err != nil {
+				return err
 			}
 		case tar.TypeSymlink, tar.TypeLink:
 			// Defer creation of
@@ -109,44 +203,35 @@ func TarFile(r *bufio.Reader, destDir string, overwrite bool) error {
 				Str("old_name", header.Linkname).
 				Str("new_name", target).
 				Msg("Tar: (Defer) Link")
-			links = append(links, &link{linkType: header.Typeflag, oldName: header.Linkname, newName: target})
+			*links = append(*links, &link{linkType: header.Typeflag, oldName: header.Linkname, newName: target})
+			if header.Typeflag == tar.TypeSymlink && opts.Preserve.any() {
+				*deferred = append(*deferred, deferredMetadata{target: target, header: header})
+			}
 		default:
 			return fmt.Errorf("unsupported file type for %s, typeflag %s", header.Name, string(header.Typeflag))
 		}
 	}
-
-	if err := createLinks(links, destDir, overwrite); err != nil {
-		return fmt.Errorf("error creating links: %w", err)
-	}
-
-	// Read the rest of the bytes from the archive and verify they are all null bytes
-	// This is for validation that the byte count is correct
-	padding, err := io.ReadAll(r)
-	if err != nil {
-		return fmt.Errorf("error reading padding bytes: %w", err)
-	}
-	for _, b := range padding {
-		if b != 0x00 {
-			return fmt.Errorf("unexpected non-null byte in padding: %x", b)
-		}
-	}
-
-	elapsed := time.Since(startTime).Seconds()
-	logger.Debug().
-		Str("extractor", "tar").
-		Float64("elapsed_time", elapsed).
-		Str("status", "complete").
-		Msg("Extract")
-	return nil
 }
 
-func createLinks(links []*link, destDir string, overwrite bool) error {
+func createLinks(links []*link, destDir string, overwritePolicy overwrite.Policy, policy LinkPolicy) error {
 	logger := logging.GetLogger()
 	for _, link := range links {
 		targetDir := filepath.Dir(link.newName)
 		if err := os.MkdirAll(targetDir, 0755); err != nil {
 			return err
 		}
+		oldName, err := checkLink(link, destDir, policy)
+		if err != nil {
+			return err
+		}
+		if oldName != link.oldName {
+			logger.Warn().
+				Str("new_name", link.newName).
+				Str("old_name", link.oldName).
+				Str("rewritten", oldName).
+				Msg("Tar: rewriting external link")
+			link.oldName = oldName
+		}
 		switch link.linkType {
 		case tar.TypeLink:
 			oldPath := filepath.Join(destDir, link.oldName)
@@ -154,7 +239,7 @@ func createLinks(links []*link, destDir string, overwrite bool) error {
 				Str("old_path", oldPath).
 				Str("new_path", link.newName).
 				Msg("Tar: creating hard link")
-			if err := createHardLink(oldPath, link.newName, overwrite); err != nil {
+			if err := createHardLink(oldPath, link.newName, overwritePolicy); err != nil {
 				return fmt.Errorf("error creating hard link from %s to %s: %w", oldPath, link.newName, err)
 			}
 		case tar.TypeSymlink:
@@ -162,7 +247,7 @@ func createLinks(links []*link, destDir string, overwrite bool) error {
 				Str("old_path", link.oldName).
 				Str("new_path", link.newName).
 				Msg("Tar: creating symlink")
-			if err := createSymlink(link.oldName, link.newName, overwrite); err != nil {
+			if err := createSymlink(link.oldName, link.newName, overwritePolicy); err != nil {
 				return fmt.Errorf("error creating symlink from %s to %s: %w", link.oldName, link.newName, err)
 			}
 		default:
@@ -172,8 +257,15 @@ func createLinks(links []*link, destDir string, overwrite bool) error {
 	return nil
 }
 
-func createHardLink(oldName, newName string, overwrite bool) error {
-	if overwrite {
+func createHardLink(oldName, newName string, policy overwrite.Policy) error {
+	if keepLink(policy) {
+		oldInfo, oldErr := os.Lstat(oldName)
+		newInfo, newErr := os.Lstat(newName)
+		if oldErr == nil && newErr == nil && os.SameFile(oldInfo, newInfo) {
+			return nil
+		}
+	}
+	if policy.Allowed() {
 		err := os.Remove(newName)
 		if err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("error removing existing file: %w", err)
@@ -182,8 +274,13 @@ func createHardLink(oldName, newName string, overwrite bool) error {
 	return os.Link(oldName, newName)
 }
 
-func createSymlink(oldName, newName string, overwrite bool) error {
-	if overwrite {
+func createSymlink(oldName, newName string, policy overwrite.Policy) error {
+	if keepLink(policy) {
+		if target, err := os.Readlink(newName); err == nil && target == oldName {
+			return nil
+		}
+	}
+	if policy.Allowed() {
 		err := os.Remove(newName)
 		if err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("error removing existing symlink/file: %w", err)
@@ -192,13 +289,23 @@ func createSymlink(oldName, newName string, overwrite bool) error {
 	return os.Symlink(oldName, newName)
 }
 
+// keepLink reports whether an existing link identical to the one to be created is left alone under policy.
+func keepLink(policy overwrite.Policy) bool {
+	return policy == overwrite.IfDifferent || policy == overwrite.Resume
+}
+
 func guardAgainstZipSlip(header *tar.Header, destDir string) error {
-	if header.Name == "" {
+	return guardEntryName(header.Name, destDir)
+}
+
+// guardEntryName checks that an archive entry called name is extracted inside destDir.
+func guardEntryName(name string, destDir string) error {
+	if name == "" {
 		return ErrEmptyHeaderName
 	}
-	target, err := filepath.Abs(filepath.Join(destDir, header.Name))
+	target, err := filepath.Abs(filepath.Join(destDir, name))
 	if err != nil {
-		return fmt.Errorf("error getting absolute path of destDir %s: %w", header.Name, err)
+		return fmt.Errorf("error getting absolute path of destDir %s: %w", name, err)
 	}
 	destAbs, err := filepath.Abs(destDir)
 	if err != nil {
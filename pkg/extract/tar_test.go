@@ -2,12 +2,20 @@ package extract
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"syscall"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/overwrite"
 )
 
 func TestCreateLinks(t *testing.T) {
@@ -15,7 +23,7 @@ func TestCreateLinks(t *testing.T) {
 		name                  string
 		links                 []*link
 		expectedError         bool
-		overwrite             bool
+		overwrite             overwrite.Policy
 		createFileToOverwrite bool
 	}{
 		{
@@ -45,7 +53,7 @@ func TestCreateLinks(t *testing.T) {
 		{
 			name:                  "HardLink_OverwriteEnabled_File Exists",
 			links:                 []*link{{tar.TypeLink, "", "testLinkHard"}},
-			overwrite:             true,
+			overwrite:             overwrite.Always,
 			createFileToOverwrite: true,
 		},
 		{
@@ -57,12 +65,12 @@ func TestCreateLinks(t *testing.T) {
 		{
 			name:      "HardLink_OverwriteEnabled_FileDoesNotExist",
 			links:     []*link{{tar.TypeLink, "", "testLinkHard"}},
-			overwrite: true,
+			overwrite: overwrite.Always,
 		},
 		{
 			name:                  "SymLink_OverwriteEnabled_FileExists",
 			links:                 []*link{{tar.TypeSymlink, "", "testLinkSym"}},
-			overwrite:             true,
+			overwrite:             overwrite.Always,
 			createFileToOverwrite: true,
 		},
 		{
@@ -74,7 +82,7 @@ func TestCreateLinks(t *testing.T) {
 		{
 			name:      "SymLink_OverwriteEnabled_FileDoesNotExist",
 			links:     []*link{{tar.TypeSymlink, "", "testLinkSym"}},
-			overwrite: true,
+			overwrite: overwrite.Always,
 		},
 	}
 
@@ -104,7 +112,7 @@ func TestCreateLinks(t *testing.T) {
 				}
 			}
 
-			err = createLinks(tt.links, destDir, tt.overwrite)
+			err = createLinks(tt.links, destDir, tt.overwrite, LinkPolicyDenyExternal)
 
 			// Validation
 			if tt.expectedError {
@@ -142,7 +150,7 @@ func assertHardLinkTarget(t *testing.T, oldName, newName string) {
 		t.Fatalf("Test failed, could not stat link %s: %v", newName, err)
 	}
 	assert.True(t, linkStat.Mode()&os.ModeSymlink == 0)
-	assert.Equal(t, fileStat.Sys().(*syscall.Stat_t).Ino, targetStat.Sys().(*syscall.Stat_t).Ino)
+	assert.True(t, os.SameFile(fileStat, targetStat))
 }
 
 func assertSymlinkTarget(t *testing.T, oldName, newName string) {
@@ -160,8 +168,7 @@ func assertSymlinkTarget(t *testing.T, oldName, newName string) {
 	if !assert.NoError(t, err) {
 		t.Fatalf("Test failed, could not stat link %s: %v", newName, err)
 	}
-	assert.Equal(t, fileStat.Sys().(*syscall.Stat_t).Ino,
-		realTarget.Sys().(*syscall.Stat_t).Ino)
+	assert.True(t, os.SameFile(fileStat, realTarget))
 }
 
 func TestGuardAgainstZipSlip(t *testing.T) {
@@ -280,3 +287,182 @@ func TestCleanFileMode(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePreserve(t *testing.T) {
+	p, err := ParsePreserve("")
+	assert.NoError(t, err)
+	assert.Equal(t, Preserve{}, p)
+
+	p, err = ParsePreserve("owner, times,xattrs")
+	assert.NoError(t, err)
+	assert.Equal(t, Preserve{Owner: true, Times: true, Xattrs: true}, p)
+
+	_, err = ParsePreserve("times,acls")
+	assert.Error(t, err)
+}
+
+func TestTarFilePreserveTimes(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := preserveTestArchive(t, mtime)
+
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			destDir := t.TempDir()
+			err := TarFile(bufio.NewReader(bytes.NewReader(archive)), destDir, Options{
+				Concurrency: concurrency,
+				Preserve:    Preserve{Times: true},
+			})
+			require.NoError(t, err)
+			for _, name := range []string{"dir", "dir/file"} {
+				info, err := os.Stat(filepath.Join(destDir, name))
+				require.NoError(t, err)
+				assert.True(t, mtime.Equal(info.ModTime()), "%s has mtime %s", name, info.ModTime())
+			}
+		})
+	}
+}
+
+// preserveTestArchive returns a tar archive containing dir/ and dir/file, both with the given modification time,
+// and the file with the user.pget extended attribute set to "test".
+func preserveTestArchive(t *testing.T, mtime time.Time) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:       "dir/file",
+		Mode:       0644,
+		Size:       5,
+		ModTime:    mtime,
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{"SCHILY.xattr.user.pget": "test"},
+	}))
+	_, err := tw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestCheckLink(t *testing.T) {
+	destDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(destDir, "a", "b"), 0755))
+	// a link inside destDir pointing at destDir itself, which ".." can then climb out of
+	require.NoError(t, os.Symlink(".", filepath.Join(destDir, "self")))
+
+	testCases := []struct {
+		name      string
+		linkType  byte
+		oldName   string
+		newName   string
+		policy    LinkPolicy
+		expected  string
+		expectErr bool
+	}{
+		{"relative symlink inside", tar.TypeSymlink, "../b", "a/b/link", LinkPolicyDenyExternal, "../b", false},
+		{"relative symlink escaping", tar.TypeSymlink, "../../../etc/passwd", "a/b/link", LinkPolicyDenyExternal, "", true},
+		{"absolute symlink", tar.TypeSymlink, "/etc/passwd", "a/link", LinkPolicyDenyExternal, "", true},
+		{"escaping through a symlink", tar.TypeSymlink, "self/../x", "link", LinkPolicyDenyExternal, "", true},
+		{"created through a symlink", tar.TypeSymlink, "x", "self/link", LinkPolicyDenyExternal, "x", false},
+		{"hard link escaping", tar.TypeLink, "../outside", "a/link", LinkPolicyDenyExternal, "", true},
+		{"hard link inside", tar.TypeLink, "a/b/file", "a/link", LinkPolicyDenyExternal, "a/b/file", false},
+		{"rewrite absolute symlink", tar.TypeSymlink, "/etc/passwd", "a/b/link", LinkPolicyRewrite, "../../etc/passwd", false},
+		{"rewrite relative symlink", tar.TypeSymlink, "../../../../etc/passwd", "a/b/link", LinkPolicyRewrite, "../../etc/passwd", false},
+		{"rewrite hard link", tar.TypeLink, "../../etc/passwd", "a/link", LinkPolicyRewrite, "etc/passwd", false},
+		{"allow", tar.TypeSymlink, "/etc/passwd", "a/link", LinkPolicyAllow, "/etc/passwd", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &link{linkType: tc.linkType, oldName: tc.oldName, newName: filepath.Join(destDir, tc.newName)}
+			oldName, err := checkLink(l, destDir, tc.policy)
+			if tc.expectErr {
+				assert.ErrorIs(t, err, ErrExternalLink)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, oldName)
+		})
+	}
+}
+
+func TestTarFileRejectsExternalSymlink(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/tmp"}))
+	require.NoError(t, tw.Close())
+
+	destDir := t.TempDir()
+	err := TarFile(bufio.NewReader(bytes.NewReader(buf.Bytes())), destDir, Options{})
+	assert.ErrorIs(t, err, ErrExternalLink)
+	_, err = os.Lstat(filepath.Join(destDir, "escape"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReadIndex(t *testing.T) {
+	entries, err := ReadIndex(strings.NewReader("dir/\ndir/small\n\n2097152\tdir/large\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []IndexEntry{
+		{Name: "dir/", Dir: true, Size: -1},
+		{Name: "dir/small", Size: -1},
+		{Name: "dir/large", Size: 2097152},
+	}, entries)
+
+	_, err = ReadIndex(strings.NewReader("big\tdir/large\n"))
+	assert.Error(t, err)
+}
+
+func TestTarFileWithIndex(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 2*parallelWriteMaxSize)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0700}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/large", Mode: 0640, Size: int64(len(large))}))
+	_, err := tw.Write(large)
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/small", Mode: 0644, Size: 5}))
+	_, err = tw.Write([]byte("small"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	index := []IndexEntry{
+		{Name: "dir/", Dir: true, Size: -1},
+		// the listed size is larger than the entry
+		{Name: "dir/large", Size: int64(len(large)) + 100},
+		{Name: "dir/small", Size: 5},
+		// listed but not in the archive
+		{Name: "other/stale", Size: 2 * parallelWriteMaxSize},
+	}
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			destDir := t.TempDir()
+			err := TarFile(bufio.NewReader(bytes.NewReader(buf.Bytes())), destDir, Options{
+				Concurrency: concurrency,
+				Index:       index,
+			})
+			require.NoError(t, err)
+
+			content, err := os.ReadFile(filepath.Join(destDir, "dir/large"))
+			require.NoError(t, err)
+			assert.Equal(t, large, content)
+			content, err = os.ReadFile(filepath.Join(destDir, "dir/small"))
+			require.NoError(t, err)
+			assert.Equal(t, "small", string(content))
+
+			info, err := os.Stat(filepath.Join(destDir, "dir"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+			info, err = os.Stat(filepath.Join(destDir, "dir/large"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+			_, err = os.Stat(filepath.Join(destDir, "other/stale"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+
+	t.Run("existing file", func(t *testing.T) {
+		destDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(destDir, "dir"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(destDir, "dir/large"), []byte("old"), 0644))
+		err := TarFile(bufio.NewReader(bytes.NewReader(buf.Bytes())), destDir, Options{Index: index})
+		assert.ErrorIs(t, err, fs.ErrExist)
+	})
+}
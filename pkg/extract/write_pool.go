@@ -0,0 +1,139 @@
+package extract
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/replicate/pget/v2/pkg/overwrite"
+)
+
+// parallelWriteMaxSize is the size of the largest regular file which is buffered in memory and written by the
+// pool. Larger files are written by the goroutine reading the archive: the archive has to be read sequentially
+// anyway, so there is nothing to gain from buffering them.
+const parallelWriteMaxSize = 1024 * 1024
+
+// writePool writes buffered files from a fixed number of goroutines. Files are assigned to a goroutine by hashing
+// their path, so that writes to the same path (e.g. an archive containing the same name twice) happen in archive
+// order.
+type writePool struct {
+	opts    Options
+	workers []chan writeJob
+	wg      sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+type writeJob struct {
+	target string
+	header *tar.Header
+	data   []byte
+	// done, if set, marks a barrier: it is closed once every earlier job for the same goroutine has been written
+	done chan struct{}
+}
+
+// newWritePool returns nil if opts.Concurrency is less than 2, in which case files should be written directly.
+func newWritePool(opts Options) *writePool {
+	if opts.Concurrency < 2 {
+		return nil
+	}
+	p := &writePool{
+		opts:    opts,
+		workers: make([]chan writeJob, opts.Concurrency),
+	}
+	for i := range p.workers {
+		// a small buffer lets the reader run ahead without holding more than a few files per worker in memory
+		p.workers[i] = make(chan writeJob, 2)
+		p.wg.Add(1)
+		go p.run(p.workers[i])
+	}
+	return p
+}
+
+func (p *writePool) run(jobs chan writeJob) {
+	defer p.wg.Done()
+	for job := range jobs {
+		if job.done != nil {
+			close(job.done)
+			continue
+		}
+		if p.failed() != nil {
+			// drain the queue, the first error is reported by wait
+			continue
+		}
+		if err := writeEntry(job.target, job.header, p.opts, bytes.NewReader(job.data)); err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *writePool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *writePool) worker(target string) chan writeJob {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(target))
+	return p.workers[h.Sum32()%uint32(len(p.workers))]
+}
+
+// submit queues data to be written to target. It returns the error of any earlier write that failed, so that the
+// caller can stop reading the archive.
+func (p *writePool) submit(target string, header *tar.Header, data []byte) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	p.worker(target) <- writeJob{target: target, header: header, data: data}
+	return nil
+}
+
+// sync waits until every queued write to target has completed.
+func (p *writePool) sync(target string) error {
+	done := make(chan struct{})
+	p.worker(target) <- writeJob{done: done}
+	<-done
+	return p.failed()
+}
+
+// wait stops the pool once every queued file has been written and returns the first error encountered.
+func (p *writePool) wait() error {
+	for _, jobs := range p.workers {
+		close(jobs)
+	}
+	p.wg.Wait()
+	return p.failed()
+}
+
+// writeEntry writes the content of a regular file entry to target and applies the metadata selected by
+// opts.Preserve. With opts.FS, target is the name of the file in it.
+func writeEntry(target string, header *tar.Header, opts Options, r io.Reader) error {
+	if opts.FS != nil {
+		return writeEntryToFS(target, header, opts, r)
+	}
+	if opts.prepared.takeFile(target) {
+		return writePrepared(target, header, opts, r)
+	}
+	targetFile, err := overwrite.Create(target, header.Size, cleanFileMode(os.FileMode(header.Mode)), opts.Overwrite)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(targetFile, r); err != nil {
+		targetFile.Close()
+		return err
+	}
+	if err := targetFile.Close(); err != nil {
+		return fmt.Errorf("error closing file %s: %w", target, err)
+	}
+	return applyMetadata(target, header, opts.Preserve)
+}
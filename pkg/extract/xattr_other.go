@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package extract
+
+import "errors"
+
+// Xattrs reports whether extended attributes can be preserved on this platform.
+const Xattrs = false
+
+func setXattr(path, name string, value []byte) error {
+	return errors.New("extended attributes are not supported on this platform")
+}
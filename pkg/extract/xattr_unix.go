@@ -0,0 +1,12 @@
+//go:build linux || darwin
+
+package extract
+
+import "golang.org/x/sys/unix"
+
+// Xattrs reports whether extended attributes can be preserved on this platform.
+const Xattrs = true
+
+func setXattr(path, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin
+
+package extract
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestTarFilePreserveXattrs(t *testing.T) {
+	archive := preserveTestArchive(t, time.Now())
+	destDir := t.TempDir()
+	err := TarFile(bufio.NewReader(bytes.NewReader(archive)), destDir, Options{Preserve: Preserve{Xattrs: true}})
+	if errors.Is(err, unix.ENOTSUP) {
+		t.Skip("filesystem does not support user extended attributes")
+	}
+	require.NoError(t, err)
+
+	value := make([]byte, 16)
+	n, err := unix.Getxattr(filepath.Join(destDir, "dir/file"), "user.pget", value)
+	require.NoError(t, err)
+	assert.Equal(t, "test", string(value[:n]))
+}
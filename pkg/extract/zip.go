@@ -0,0 +1,160 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/overwrite"
+)
+
+// maxZipSymlinkSize bounds the size of a symlink entry, whose content is the link target.
+const maxZipSymlinkSize = 4096
+
+// ZipFile extracts the zip archive (including zip64 archives) of the given size read from r into destDir, or opts.FS
+// if it is set.
+// Symlink entries are subject to opts.Links like those of tar archives, and are created once all files have been
+// written. With a concurrency greater than one, files are written by that many goroutines. Of opts.Preserve only
+// Times applies, as zip archives do not record owners or extended attributes.
+func ZipFile(r io.ReaderAt, size int64, destDir string, opts Options) error {
+	logger := logging.GetLogger()
+	startTime := time.Now()
+
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("error reading zip archive: %w", err)
+	}
+	logger.Debug().
+		Str("extractor", "zip").
+		Str("status", "starting").
+		Int("entries", len(zipReader.File)).
+		Int("concurrency", opts.Concurrency).
+		Msg("Extract")
+
+	if opts.FS != nil {
+		if err := opts.checkFS(); err != nil {
+			return err
+		}
+		return extractZipToFS(zipReader, opts)
+	}
+
+	var links []*link
+	var dirs []*zip.File
+	errGroup := new(errgroup.Group)
+	errGroup.SetLimit(max(opts.Concurrency, 1))
+	for _, f := range zipReader.File {
+		if err := guardEntryName(f.Name, destDir); err != nil {
+			return err
+		}
+		target := filepath.Join(destDir, f.Name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			logger.Debug().
+				Str("target", target).
+				Str("perms", fmt.Sprintf("%o", mode.Perm())).
+				Msg("Zip: Directory")
+			if err := os.MkdirAll(target, cleanFileMode(mode.Perm())); err != nil {
+				return err
+			}
+			dirs = append(dirs, f)
+		case mode&os.ModeSymlink != 0:
+			linkName, err := readZipSymlink(f)
+			if err != nil {
+				return err
+			}
+			logger.Debug().
+				Str("old_name", linkName).
+				Str("new_name", target).
+				Msg("Zip: (Defer) Symlink")
+			links = append(links, &link{linkType: tar.TypeSymlink, oldName: linkName, newName: target})
+		case mode.IsRegular():
+			logger.Debug().
+				Str("target", target).
+				Str("perms", fmt.Sprintf("%o", mode.Perm())).
+				Msg("Zip: File")
+			errGroup.Go(func() error {
+				return writeZipEntry(f, target, opts)
+			})
+		default:
+			return fmt.Errorf("unsupported file type for %s, mode %s", f.Name, mode)
+		}
+	}
+	if err := errGroup.Wait(); err != nil {
+		return err
+	}
+
+	if err := createLinks(links, destDir, opts.Overwrite, opts.Links); err != nil {
+		return fmt.Errorf("error creating links: %w", err)
+	}
+	if opts.Preserve.Times {
+		// in reverse, so that a directory's times are set after those of the directories inside it
+		for i := len(dirs) - 1; i >= 0; i-- {
+			target := filepath.Join(destDir, dirs[i].Name)
+			if err := os.Chtimes(target, dirs[i].Modified, dirs[i].Modified); err != nil {
+				return fmt.Errorf("error setting times of %s: %w", target, err)
+			}
+		}
+	}
+
+	logger.Debug().
+		Str("extractor", "zip").
+		Float64("elapsed_time", time.Since(startTime).Seconds()).
+		Str("status", "complete").
+		Msg("Extract")
+	return nil
+}
+
+func readZipSymlink(f *zip.File) (string, error) {
+	if f.UncompressedSize64 > maxZipSymlinkSize {
+		return "", fmt.Errorf("symlink %s has a %d byte target", f.Name, f.UncompressedSize64)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	linkName, err := io.ReadAll(rc)
+	if err != nil {
+		return "", fmt.Errorf("error reading symlink %s: %w", f.Name, err)
+	}
+	return string(linkName), nil
+}
+
+func writeZipEntry(f *zip.File, target string, opts Options) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	targetFile, err := overwrite.Create(target, int64(f.UncompressedSize64), cleanFileMode(f.Mode().Perm()), opts.Overwrite)
+	if err != nil {
+		return err
+	}
+	// reading to the end makes the zip reader verify the entry's checksum
+	if _, err := io.Copy(targetFile, rc); err != nil {
+		targetFile.Close()
+		return fmt.Errorf("error extracting %s: %w", f.Name, err)
+	}
+	if err := targetFile.Close(); err != nil {
+		return fmt.Errorf("error closing file %s: %w", target, err)
+	}
+	if opts.Preserve.Times {
+		if err := os.Chtimes(target, f.Modified, f.Modified); err != nil {
+			return fmt.Errorf("error setting times of %s: %w", target, err)
+		}
+	}
+	return nil
+}
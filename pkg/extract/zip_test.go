@@ -0,0 +1,179 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type zipTestEntry struct {
+	name string
+	mode os.FileMode
+	body string
+}
+
+func zipArchive(t *testing.T, entries ...zipTestEntry) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		header.SetMode(entry.mode)
+		w, err := zw.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = io.WriteString(w, entry.body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestZipFile(t *testing.T) {
+	archive := zipArchive(t,
+		zipTestEntry{name: "dir/", mode: os.ModeDir | 0755},
+		zipTestEntry{name: "dir/file.txt", mode: 0640, body: "hello"},
+		zipTestEntry{name: "top.txt", mode: 0644, body: "world"},
+		zipTestEntry{name: "dir/link", mode: os.ModeSymlink | 0777, body: "../top.txt"},
+	)
+	destDir := t.TempDir()
+	require.NoError(t, ZipFile(archive, archive.Size(), destDir, Options{Concurrency: 2}))
+
+	content, err := os.ReadFile(filepath.Join(destDir, "dir", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+	info, err := os.Stat(filepath.Join(destDir, "dir", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	target, err := os.Readlink(filepath.Join(destDir, "dir", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "../top.txt", target)
+	content, err = os.ReadFile(filepath.Join(destDir, "dir", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(content))
+}
+
+func TestZipFileRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		description   string
+		entry         zipTestEntry
+		expectedError error
+	}{
+		{
+			description:   "file outside directory",
+			entry:         zipTestEntry{name: "../escape.txt", mode: 0644, body: "x"},
+			expectedError: ErrZipSlip,
+		},
+		{
+			description:   "absolute symlink",
+			entry:         zipTestEntry{name: "escape", mode: os.ModeSymlink | 0777, body: "/tmp"},
+			expectedError: ErrExternalLink,
+		},
+		{
+			description:   "symlink climbing out of directory",
+			entry:         zipTestEntry{name: "escape", mode: os.ModeSymlink | 0777, body: "../.."},
+			expectedError: ErrExternalLink,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			archive := zipArchive(t, test.entry)
+			destDir := t.TempDir()
+			err := ZipFile(archive, archive.Size(), destDir, Options{})
+			assert.ErrorIs(t, err, test.expectedError)
+			_, err = os.Lstat(filepath.Join(destDir, "escape"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+// sparseZip is a zip archive held in memory apart from the contents of one large stored entry, which are zeros.
+type sparseZip struct {
+	head     []byte
+	dataSize int64
+	tail     []byte
+	phase    int
+}
+
+func (s *sparseZip) Write(p []byte) (int, error) {
+	switch s.phase {
+	case 0:
+		s.head = append(s.head, p...)
+	case 1:
+		s.dataSize += int64(len(p))
+	default:
+		s.tail = append(s.tail, p...)
+	}
+	return len(p), nil
+}
+
+func (s *sparseZip) Size() int64 {
+	return int64(len(s.head)) + s.dataSize + int64(len(s.tail))
+}
+
+func (s *sparseZip) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		switch {
+		case pos < int64(len(s.head)):
+			n += copy(p[n:], s.head[pos:])
+		case pos < int64(len(s.head))+s.dataSize:
+			zeros := min(int64(len(p)-n), int64(len(s.head))+s.dataSize-pos)
+			clear(p[n : n+int(zeros)])
+			n += int(zeros)
+		case pos < s.Size():
+			n += copy(p[n:], s.tail[pos-int64(len(s.head))-s.dataSize:])
+		default:
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func TestZipFileZip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a file larger than 4 GiB")
+	}
+	const largeSize int64 = math.MaxUint32 + 1024
+
+	archive := &sparseZip{}
+	zw := zip.NewWriter(archive)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "large.bin", Method: zip.Store})
+	require.NoError(t, err)
+	require.NoError(t, zw.Flush())
+	archive.phase = 1
+	zeros := make([]byte, 1<<20)
+	for written := int64(0); written < largeSize; {
+		n, err := w.Write(zeros[:min(int64(len(zeros)), largeSize-written)])
+		require.NoError(t, err)
+		written += int64(n)
+	}
+	require.NoError(t, zw.Flush())
+	archive.phase = 2
+	header := &zip.FileHeader{Name: "small.txt", Method: zip.Deflate}
+	header.SetMode(0644)
+	w, err = zw.CreateHeader(header)
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "after the large entry")
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.EqualValues(t, largeSize, archive.dataSize)
+
+	destDir := t.TempDir()
+	require.NoError(t, ZipFile(archive, archive.Size(), destDir, Options{Concurrency: 2}))
+
+	info, err := os.Stat(filepath.Join(destDir, "large.bin"))
+	require.NoError(t, err)
+	assert.EqualValues(t, largeSize, info.Size())
+	content, err := os.ReadFile(filepath.Join(destDir, "small.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "after the large entry", string(content))
+}
@@ -0,0 +1,73 @@
+package pget
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// EntryFailure is a manifest entry which failed to download, and why.
+type EntryFailure struct {
+	Entry ManifestEntry
+	Err   error
+}
+
+// FailedEntriesError is returned by DownloadFiles with Options.ContinueOnError when some entries failed to download
+// for reasons other than not being found (see MissingEntriesError). The other entries of the manifest are still
+// downloaded.
+type FailedEntriesError struct {
+	Failures []EntryFailure
+}
+
+func (e *FailedEntriesError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		failures[i] = fmt.Sprintf("%s (%s): %v", f.Entry.Dest, f.Entry.URL, f.Err)
+	}
+	return fmt.Sprintf("%d of the manifest entries failed to download: %s", len(e.Failures), strings.Join(failures, "; "))
+}
+
+func (e *FailedEntriesError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// failureTracker records the manifest entries which failed to download.
+type failureTracker struct {
+	mu       sync.Mutex
+	failures []EntryFailure
+}
+
+// failed records that entry failed to download with err.
+func (t *failureTracker) failed(entry ManifestEntry, err error) {
+	logger := logging.GetLogger()
+	logger.Error().
+		Err(err).
+		Str("url", entry.URL).
+		Str("dest", entry.Dest).
+		Msg("Download Failed")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = append(t.failures, EntryFailure{Entry: entry, Err: err})
+}
+
+// err returns a *FailedEntriesError for the entries which failed, or nil if there are none.
+func (t *failureTracker) err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.failures) == 0 {
+		return nil
+	}
+	failures := slices.Clone(t.failures)
+	slices.SortFunc(failures, func(a, b EntryFailure) int {
+		return cmp.Compare(a.Entry.Dest, b.Entry.Dest)
+	})
+	return &FailedEntriesError{Failures: failures}
+}
@@ -0,0 +1,112 @@
+// Package fileio writes files sequentially, from the start, other than through the page cache: with direct I/O, or
+// through an io_uring. Both are only supported on Linux; elsewhere their writers can't be created and the file is to
+// be written as usual.
+package fileio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// directAlignment is the alignment of the offsets, lengths and buffers of direct writes, which is a multiple of
+	// the logical block size of common devices
+	directAlignment = 4096
+	// directBufferSize is how much content is gathered before it is written
+	directBufferSize = 1024 * 1024
+)
+
+// directWriter writes a file through a second descriptor opened for direct I/O, gathering the content into aligned
+// blocks. The final partial block, and everything after a write the file system refuses, is written through the
+// regular descriptor instead.
+type directWriter struct {
+	f      *os.File
+	direct *os.File
+	buf    []byte
+	filled int
+	// offset is the offset in the file of buf
+	offset int64
+}
+
+// NewDirectWriter returns a writer of f, from its start, bypassing the page cache (with O_DIRECT), so that
+// writing more than fits in memory doesn't evict what other processes have cached. The writer must be closed before
+// f. If the file system turns out not to support direct I/O, the content is written through f after all.
+func NewDirectWriter(f *os.File) (io.WriteCloser, error) {
+	direct, err := openDirect(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &directWriter{f: f, direct: direct, buf: alignedBuffer(directBufferSize)}, nil
+}
+
+// alignedBuffer returns a buffer of size bytes whose address is a multiple of directAlignment.
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlignment)
+	misalignment := int(uintptr(unsafe.Pointer(&b[0])) & (directAlignment - 1))
+	start := 0
+	if misalignment != 0 {
+		start = directAlignment - misalignment
+	}
+	return b[start : start+size]
+}
+
+func (d *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(d.buf[d.filled:], p)
+		d.filled += n
+		written += n
+		p = p[n:]
+		if d.filled == len(d.buf) {
+			if err := d.writeBlocks(d.filled); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeBlocks writes the first n bytes of buf, a multiple of directAlignment, and moves the rest to its start.
+func (d *directWriter) writeBlocks(n int) error {
+	if n == 0 {
+		return nil
+	}
+	var err error
+	if d.direct != nil {
+		_, err = d.direct.WriteAt(d.buf[:n], d.offset)
+		if errors.Is(err, syscall.EINVAL) {
+			// the file system doesn't support direct I/O after all, or with this alignment
+			d.direct.Close()
+			d.direct = nil
+		}
+	}
+	if d.direct == nil {
+		_, err = d.f.WriteAt(d.buf[:n], d.offset)
+	}
+	if err != nil {
+		return err
+	}
+	d.offset += int64(n)
+	d.filled = copy(d.buf, d.buf[n:d.filled])
+	return nil
+}
+
+// Close writes the rest of the content: the aligned blocks directly, and the final partial block through the
+// regular descriptor.
+func (d *directWriter) Close() error {
+	err := d.writeBlocks(d.filled &^ (directAlignment - 1))
+	if err == nil && d.filled > 0 {
+		_, err = d.f.WriteAt(d.buf[:d.filled], d.offset)
+		d.offset += int64(d.filled)
+		d.filled = 0
+	}
+	if d.direct != nil {
+		if closeErr := d.direct.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package fileio
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect opens path for writing bypassing the page cache.
+func openDirect(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|syscall.O_DIRECT, 0)
+}
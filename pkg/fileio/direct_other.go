@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fileio
+
+import (
+	"errors"
+	"os"
+)
+
+// openDirect opens path for writing bypassing the page cache. Direct I/O is only supported on Linux.
+func openDirect(string) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,85 @@
+package fileio_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/fileio"
+	"github.com/replicate/pget/v2/pkg/overwrite"
+)
+
+// writers are the ways an overwrite.File can write its content, by the constructor of each writer
+var writers = map[string]func(*os.File) (io.WriteCloser, error){
+	"standard":  nil,
+	"direct-io": fileio.NewDirectWriter,
+	"io_uring":  fileio.NewURingWriter,
+}
+
+// selectWriter has f written by the named writer, and reports whether it will be.
+func selectWriter(f *overwrite.File, name string) bool {
+	if writers[name] == nil {
+		return true
+	}
+	selected, _ := f.ReplaceWriter(writers[name])
+	return selected
+}
+
+func TestWriters(t *testing.T) {
+	// whole blocks, a partial final block, and more than is gathered for one write
+	for _, size := range []int{0, 100, 8192, 9*1024*1024 + 123} {
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte(i * 7)
+		}
+		for name := range writers {
+			for _, policy := range []overwrite.Policy{overwrite.Always, overwrite.Resume} {
+				path := filepath.Join(t.TempDir(), "file")
+				f, err := overwrite.Create(path, int64(size), 0644, policy)
+				require.NoError(t, err)
+				// the content is the same whether or not the platform and file system support the writer
+				selected := selectWriter(f, name)
+				if policy == overwrite.Resume && name != "standard" {
+					assert.False(t, selected)
+				}
+				_, err = io.CopyBuffer(f, struct{ io.Reader }{bytes.NewReader(content)}, make([]byte, 1000))
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+				written, err := os.ReadFile(path)
+				require.NoError(t, err)
+				assert.Equal(t, content, written, "size %d, %s, %s", size, name, policy)
+			}
+		}
+	}
+}
+
+// BenchmarkWriters compares the writers, e.g. go test ./pkg/fileio -bench Writers -benchtime 10x, with TMPDIR on
+// the file system to compare them on.
+func BenchmarkWriters(b *testing.B) {
+	content := make([]byte, 256*1024*1024)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	for _, name := range []string{"standard", "direct-io", "io_uring"} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for range b.N {
+				path := filepath.Join(b.TempDir(), "file")
+				f, err := overwrite.Create(path, int64(len(content)), 0644, overwrite.Always)
+				require.NoError(b, err)
+				if !selectWriter(f, name) {
+					b.Skipf("%s is not supported", name)
+				}
+				// in blocks of the size io.Copy reads a download in
+				_, err = io.CopyBuffer(f, struct{ io.Reader }{bytes.NewReader(content)}, make([]byte, 32*1024))
+				require.NoError(b, err)
+				require.NoError(b, f.Close())
+			}
+		})
+	}
+}
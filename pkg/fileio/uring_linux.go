@@ -0,0 +1,264 @@
+//go:build linux
+
+package fileio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// uringDepth is the number of writes in flight, each of uringBufferSize bytes
+	uringDepth      = 8
+	uringBufferSize = 1024 * 1024
+
+	uringOpWrite       = 23 // IORING_OP_WRITE, Linux 5.6
+	uringEnterGetEvent = 1  // IORING_ENTER_GETEVENTS
+	uringOffSQRing     = 0
+	uringOffCQRing     = 0x8000000
+	uringOffSQEs       = 0x10000000
+	uringSQESize       = 64
+	uringCQESize       = 16
+)
+
+// uringParams is struct io_uring_params.
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+// uringSQOffsets is struct io_sqring_offsets.
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// uringCQOffsets is struct io_cqring_offsets.
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// uringWriter writes a file sequentially through an io_uring, with up to uringDepth writes of uringBufferSize bytes
+// queued while the next buffer is filled. A write the ring fails, such as on kernels without IORING_OP_WRITE, is
+// made again synchronously, which returns its error if it fails again.
+type uringWriter struct {
+	f      *os.File
+	ring   int
+	sq, cq []byte
+	sqes   []byte
+	params uringParams
+
+	buffers [uringDepth][]byte
+	pending [uringDepth]pendingWrite
+	// free holds the indexes of the buffers neither being filled nor written
+	free []int
+	// current is the index of the buffer being filled, or -1
+	current  int
+	filled   int
+	offset   int64
+	inFlight int
+	err      error
+}
+
+type pendingWrite struct {
+	offset int64
+	length int
+}
+
+// NewURingWriter returns a writer of f, from its start, through an io_uring, which queues the writes of the next
+// buffers while the current one is filled. The writer must be closed before f. It is experimental.
+func NewURingWriter(f *os.File) (io.WriteCloser, error) {
+	w := &uringWriter{f: f, ring: -1, current: -1}
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uringDepth, uintptr(unsafe.Pointer(&w.params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	w.ring = int(fd)
+	var err error
+	if w.sq, err = w.mmap(uringOffSQRing, int(w.params.sqOff.array+w.params.sqEntries*4)); err != nil {
+		return nil, w.closeRing(err)
+	}
+	if w.cq, err = w.mmap(uringOffCQRing, int(w.params.cqOff.cqes+w.params.cqEntries*uringCQESize)); err != nil {
+		return nil, w.closeRing(err)
+	}
+	if w.sqes, err = w.mmap(uringOffSQEs, int(w.params.sqEntries*uringSQESize)); err != nil {
+		return nil, w.closeRing(err)
+	}
+	for i := range w.buffers {
+		w.buffers[i] = make([]byte, uringBufferSize)
+		w.free = append(w.free, i)
+	}
+	return w, nil
+}
+
+func (w *uringWriter) mmap(offset int64, size int) ([]byte, error) {
+	b, err := unix.Mmap(w.ring, offset, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, fmt.Errorf("mmap io_uring: %w", err)
+	}
+	return b, nil
+}
+
+// closeRing releases the ring, returning err.
+func (w *uringWriter) closeRing(err error) error {
+	for _, b := range [][]byte{w.sq, w.cq, w.sqes} {
+		if b != nil {
+			_ = unix.Munmap(b)
+		}
+	}
+	if w.ring >= 0 {
+		unix.Close(w.ring)
+	}
+	return err
+}
+
+// field returns the uint32 at offset in a ring.
+func field(ring []byte, offset uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&ring[offset]))
+}
+
+func (w *uringWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && w.err == nil {
+		if w.current < 0 {
+			if len(w.free) == 0 {
+				if err := w.reap(1); err != nil {
+					w.err = err
+				}
+				continue
+			}
+			w.current = w.free[len(w.free)-1]
+			w.free = w.free[:len(w.free)-1]
+		}
+		n := copy(w.buffers[w.current][w.filled:], p)
+		w.filled += n
+		written += n
+		p = p[n:]
+		if w.filled == uringBufferSize {
+			w.submit()
+		}
+	}
+	return written, w.err
+}
+
+// submit queues the write of the buffer being filled.
+func (w *uringWriter) submit() {
+	index := w.current
+	w.pending[index] = pendingWrite{offset: w.offset, length: w.filled}
+	w.offset += int64(w.filled)
+	w.current, w.filled = -1, 0
+
+	tail := atomic.LoadUint32(field(w.sq, w.params.sqOff.tail))
+	slot := tail & *field(w.sq, w.params.sqOff.ringMask)
+	sqe := w.sqes[slot*uringSQESize : (slot+1)*uringSQESize]
+	clear(sqe)
+	sqe[0] = uringOpWrite
+	*(*int32)(unsafe.Pointer(&sqe[4])) = int32(w.f.Fd())
+	*(*uint64)(unsafe.Pointer(&sqe[8])) = uint64(w.pending[index].offset)
+	*(*uint64)(unsafe.Pointer(&sqe[16])) = uint64(uintptr(unsafe.Pointer(&w.buffers[index][0])))
+	*(*uint32)(unsafe.Pointer(&sqe[24])) = uint32(w.pending[index].length)
+	// the user data identifies the buffer, whose pending write is looked up on completion
+	*(*uint64)(unsafe.Pointer(&sqe[32])) = uint64(index)
+	*field(w.sq, w.params.sqOff.array+slot*4) = slot
+	atomic.StoreUint32(field(w.sq, w.params.sqOff.tail), tail+1)
+	w.inFlight++
+
+	if err := w.enter(1, 0); err != nil {
+		// nothing was submitted, so the write is taken back: only the writes the kernel has are waited for
+		atomic.StoreUint32(field(w.sq, w.params.sqOff.tail), tail)
+		w.inFlight--
+		w.free = append(w.free, index)
+		w.err = err
+	}
+}
+
+// reap waits for at least min writes to complete, and handles the completed writes.
+func (w *uringWriter) reap(min int) error {
+	if err := w.enter(0, min); err != nil {
+		return err
+	}
+	w.harvest()
+	return nil
+}
+
+// harvest handles the writes the ring has completed, without waiting for any.
+func (w *uringWriter) harvest() {
+	head := atomic.LoadUint32(field(w.cq, w.params.cqOff.head))
+	tail := atomic.LoadUint32(field(w.cq, w.params.cqOff.tail))
+	mask := *field(w.cq, w.params.cqOff.ringMask)
+	for ; head != tail; head++ {
+		cqe := w.cq[w.params.cqOff.cqes+(head&mask)*uringCQESize:]
+		index := int(*(*uint64)(unsafe.Pointer(&cqe[0])))
+		res := *(*int32)(unsafe.Pointer(&cqe[8]))
+		w.inFlight--
+		w.complete(index, int(res))
+	}
+	atomic.StoreUint32(field(w.cq, w.params.cqOff.head), head)
+}
+
+// complete finishes the write of buffer index, of which the ring wrote res bytes (negative on error), writing the
+// rest synchronously, and frees the buffer.
+func (w *uringWriter) complete(index, res int) {
+	pending := w.pending[index]
+	if res < pending.length && w.err == nil {
+		done := max(res, 0)
+		if _, err := w.f.WriteAt(w.buffers[index][done:pending.length], pending.offset+int64(done)); err != nil {
+			w.err = err
+		}
+	}
+	w.free = append(w.free, index)
+}
+
+func (w *uringWriter) enter(toSubmit, minComplete int) error {
+	var flags uintptr
+	if minComplete > 0 {
+		flags = uringEnterGetEvent
+	}
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(w.ring), uintptr(toSubmit), uintptr(minComplete), flags, 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case unix.EINTR:
+		case unix.EAGAIN, unix.EBUSY:
+			// the kernel is short of resources, or of room for more completions until those posted are handled
+			w.harvest()
+			time.Sleep(time.Millisecond)
+		default:
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+	}
+}
+
+// Close writes the rest of the content, waits for the queued writes and releases the ring. The queued writes are
+// waited for even after an error, as the kernel may still be reading their buffers.
+func (w *uringWriter) Close() error {
+	if w.current >= 0 && w.filled > 0 && w.err == nil {
+		w.submit()
+	}
+	for w.inFlight > 0 {
+		if err := w.reap(1); err != nil {
+			// the ring can't be waited on: closing it cancels the writes
+			if w.err == nil {
+				w.err = err
+			}
+			break
+		}
+	}
+	return w.closeRing(w.err)
+}
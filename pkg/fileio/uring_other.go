@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fileio
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// NewURingWriter returns a writer of f through an io_uring. io_uring is only supported on Linux.
+func NewURingWriter(*os.File) (io.WriteCloser, error) {
+	return nil, errors.ErrUnsupported
+}
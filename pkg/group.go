@@ -0,0 +1,164 @@
+package pget
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/integrity"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// ErrGroupChecksumMismatch is returned when the combined content of a group does not match its checksum. It wraps
+// download.ErrChecksumMismatch.
+var ErrGroupChecksumMismatch = fmt.Errorf("group %w", download.ErrChecksumMismatch)
+
+type groupState struct {
+	dests     []string
+	remaining int
+	size      int64
+	start     time.Time
+	// verify is cleared once a shard is consumed in a way that leaves nothing to verify the group checksum against
+	verify bool
+}
+
+// groupTracker tracks the completion of the shards of each ManifestGroup in a manifest.
+type groupTracker struct {
+	mu     sync.Mutex
+	groups map[*ManifestGroup]*groupState
+}
+
+func newGroupTracker(entries []ManifestEntry) *groupTracker {
+	t := &groupTracker{groups: make(map[*ManifestGroup]*groupState)}
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Group == nil {
+			continue
+		}
+		state, ok := t.groups[entry.Group]
+		if !ok {
+			state = &groupState{start: now, verify: true}
+			t.groups[entry.Group] = state
+		}
+		state.dests = append(state.dests, entry.Dest)
+		state.remaining++
+	}
+	return t
+}
+
+// shardComplete records the completion of one shard of group. Once every shard is complete the combined checksum
+// is verified (if verify was set for every shard and the group declares one) and the group completion is logged.
+func (t *groupTracker) shardComplete(group *ManifestGroup, size int64, verify bool) error {
+	t.mu.Lock()
+	state := t.groups[group]
+	state.remaining--
+	state.size += size
+	state.verify = state.verify && verify
+	done := state.remaining == 0
+	verify = state.verify
+	t.mu.Unlock()
+	if !done {
+		return nil
+	}
+
+	logger := logging.GetLogger()
+	expected, err := group.expected()
+	if err != nil {
+		return err
+	}
+	verify = verify && expected != nil
+	if verify {
+		if err := verifyGroupChecksum(group, expected, state.dests); err != nil {
+			return err
+		}
+	}
+	logger.Info().
+		Str("group", group.Name).
+		Int("shards", len(state.dests)).
+		Str("size", humanize.Bytes(uint64(state.size))).
+		Bool("verified", verify).
+		Str("total_elapsed", fmt.Sprintf("%.3fs", time.Since(state.start).Seconds())).
+		Msg("Group Complete")
+	return nil
+}
+
+// logIncomplete logs each group with shards which never completed, e.g. because they were not found or failed
+// with Options.ContinueOnError, so that the group was neither verified nor logged as complete.
+func (t *groupTracker) logIncomplete() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	logger := logging.GetLogger()
+	for group, state := range t.groups {
+		if state.remaining == 0 {
+			continue
+		}
+		logger.Warn().
+			Str("group", group.Name).
+			Int("shards", len(state.dests)).
+			Int("incomplete_shards", state.remaining).
+			Msg("Group Incomplete")
+	}
+}
+
+// expected returns the checksum of the group, or nil if it declares none.
+func (g *ManifestGroup) expected() (*integrity.Integrity, error) {
+	if g.Integrity != nil || g.SHA256 == "" {
+		return g.Integrity, nil
+	}
+	expected, err := integrity.Parse(g.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("group %s: %w", g.Name, err)
+	}
+	return expected, nil
+}
+
+// verifyGroupChecksum hashes the concatenation of dests and compares it against the group checksum.
+func verifyGroupChecksum(group *ManifestGroup, expected *integrity.Integrity, dests []string) error {
+	h := expected.New()
+	for _, dest := range dests {
+		f, err := os.Open(dest)
+		if err != nil {
+			return fmt.Errorf("error verifying group %s: %w", group.Name, err)
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error verifying group %s: %w", group.Name, err)
+		}
+	}
+	if sum := h.Sum(nil); !expected.Matches(sum) {
+		return fmt.Errorf("%w: group %s expected %s, got %s", ErrGroupChecksumMismatch, group.Name, expected, expected.Format(sum))
+	}
+	return nil
+}
+
+// scheduleGroupsTogether returns the entries reordered so that all shards of a group are queued consecutively, at
+// the position of the group's first shard. The relative order of all other entries is preserved.
+func scheduleGroupsTogether(entries []ManifestEntry) []ManifestEntry {
+	shards := make(map[*ManifestGroup][]ManifestEntry)
+	for _, entry := range entries {
+		if entry.Group != nil {
+			shards[entry.Group] = append(shards[entry.Group], entry)
+		}
+	}
+	if len(shards) == 0 {
+		return entries
+	}
+	scheduled := make([]ManifestEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Group == nil {
+			scheduled = append(scheduled, entry)
+			continue
+		}
+		if groupShards, ok := shards[entry.Group]; ok {
+			scheduled = append(scheduled, groupShards...)
+			delete(shards, entry.Group)
+		}
+	}
+	return scheduled
+}
@@ -0,0 +1,98 @@
+package pget
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// heartbeat tracks the progress of one file download for the periodic log lines enabled by
+// Options.HeartbeatInterval. Progress is counted as the content is handed to the consumer, so it lags the network by
+// the chunks buffered ahead of it.
+type heartbeat struct {
+	url  string
+	dest string
+	size int64
+	read atomic.Int64
+
+	// the progress at the previous heartbeat, only used by the logging goroutine
+	lastRead int64
+	lastTime time.Time
+}
+
+func newHeartbeat(url, dest string, size int64, now time.Time) *heartbeat {
+	return &heartbeat{url: url, dest: dest, size: size, lastTime: now}
+}
+
+// progress returns the percentage of the file downloaded and the throughput since the previous call, and reports
+// whether no progress was made in between.
+func (h *heartbeat) progress(now time.Time) (percent float64, bytesPerSecond float64, stalled bool) {
+	read := h.read.Load()
+	if h.size > 0 {
+		percent = 100 * float64(read) / float64(h.size)
+	}
+	if elapsed := now.Sub(h.lastTime); elapsed > 0 {
+		bytesPerSecond = float64(read-h.lastRead) / elapsed.Seconds()
+	}
+	stalled = read == h.lastRead && read < h.size
+	h.lastRead, h.lastTime = read, now
+	return percent, bytesPerSecond, stalled
+}
+
+func (h *heartbeat) log(now time.Time) {
+	logger := logging.GetLogger()
+	percent, bytesPerSecond, stalled := h.progress(now)
+	event := logger.Info()
+	if stalled {
+		event = logger.Warn()
+	}
+	event.
+		Str("url", h.url).
+		Str("dest", h.dest).
+		Str("progress", fmt.Sprintf("%.1f%%", percent)).
+		Str("downloaded", humanize.Bytes(uint64(h.lastRead))).
+		Str("size", humanize.Bytes(uint64(h.size))).
+		Str("throughput", humanize.Bytes(uint64(bytesPerSecond))+"/s").
+		Bool("stalled", stalled).
+		Msg("Heartbeat")
+}
+
+// withHeartbeat logs the progress of reading r, the content of url, every Options.HeartbeatInterval until the
+// returned function is called. It returns r unchanged if heartbeats are disabled.
+func (g *Getter) withHeartbeat(r io.Reader, url, dest string, size int64) (io.Reader, func()) {
+	interval := g.Options.HeartbeatInterval
+	if interval <= 0 {
+		return r, func() {}
+	}
+	h := newHeartbeat(url, dest, size, time.Now())
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				h.log(now)
+			}
+		}
+	}()
+	return &heartbeatReader{r: r, h: h}, func() { close(done) }
+}
+
+type heartbeatReader struct {
+	r io.Reader
+	h *heartbeat
+}
+
+func (hr *heartbeatReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.read.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,33 @@
+package pget
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatProgress(t *testing.T) {
+	start := time.Now()
+	h := newHeartbeat("http://example.com/file", "file", 1000, start)
+	r := &heartbeatReader{r: strings.NewReader(strings.Repeat("a", 1000)), h: h}
+
+	_, _ = io.CopyN(io.Discard, r, 250)
+	percent, bytesPerSecond, stalled := h.progress(start.Add(time.Second))
+	assert.Equal(t, 25.0, percent)
+	assert.Equal(t, 250.0, bytesPerSecond)
+	assert.False(t, stalled)
+
+	percent, bytesPerSecond, stalled = h.progress(start.Add(2 * time.Second))
+	assert.Equal(t, 25.0, percent)
+	assert.Equal(t, 0.0, bytesPerSecond)
+	assert.True(t, stalled)
+
+	_, _ = io.Copy(io.Discard, r)
+	percent, bytesPerSecond, stalled = h.progress(start.Add(4 * time.Second))
+	assert.Equal(t, 100.0, percent)
+	assert.Equal(t, 375.0, bytesPerSecond)
+	assert.False(t, stalled)
+}
@@ -0,0 +1,151 @@
+// Package hf resolves hf:// URLs of files in Hugging Face Hub repositories to the URLs they are downloaded from, and
+// finds the access token to download them with.
+package hf
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultEndpoint is the Hub files are downloaded from, unless HF_ENDPOINT is set.
+const DefaultEndpoint = "https://huggingface.co"
+
+// Repository types, as they prefix the repositories of hf:// URLs other than models.
+const (
+	RepoTypeModel   = "model"
+	RepoTypeDataset = "dataset"
+	RepoTypeSpace   = "space"
+)
+
+// File is a file in a Hub repository, at a revision.
+type File struct {
+	// RepoType is RepoTypeModel, RepoTypeDataset or RepoTypeSpace.
+	RepoType string
+	// Repo is the ID of the repository, e.g. openai-community/gpt2.
+	Repo string
+	// Path is the slash-separated path of the file within the repository.
+	Path string
+	// Revision is the branch, tag or commit hash of the repository the file is downloaded at.
+	Revision string
+}
+
+// IsURL reports whether u is an hf:// URL.
+func IsURL(u string) bool {
+	return strings.HasPrefix(u, "hf://")
+}
+
+// ParseURL parses an hf://[datasets/|spaces/]org/repo/path/to/file[@revision] URL. The revision, main if absent,
+// may also follow the repository, as in hf://org/repo@revision/path/to/file; a revision containing slashes (e.g.
+// refs/pr/1) may be escaped as refs%2Fpr%2F1.
+func ParseURL(u string) (File, error) {
+	rest, ok := strings.CutPrefix(u, "hf://")
+	if !ok {
+		return File{}, fmt.Errorf("not an hf:// URL: %s", u)
+	}
+	f := File{RepoType: RepoTypeModel}
+	if after, ok := strings.CutPrefix(rest, "datasets/"); ok {
+		f.RepoType, rest = RepoTypeDataset, after
+	} else if after, ok := strings.CutPrefix(rest, "spaces/"); ok {
+		f.RepoType, rest = RepoTypeSpace, after
+	} else {
+		rest = strings.TrimPrefix(rest, "models/")
+	}
+
+	segments := strings.SplitN(rest, "/", 3)
+	if len(segments) < 3 || segments[0] == "" || segments[1] == "" || segments[2] == "" {
+		return File{}, fmt.Errorf("hf:// URL %s must name a repository and a file in it, as hf://org/repo/path/to/file", u)
+	}
+	repo, revision, hasRevision := strings.Cut(segments[1], "@")
+	f.Repo, f.Path = segments[0]+"/"+repo, segments[2]
+	if path, pathRevision, ok := strings.Cut(f.Path, "@"); ok {
+		if hasRevision {
+			return File{}, fmt.Errorf("hf:// URL %s has more than one revision", u)
+		}
+		f.Path, revision, hasRevision = path, pathRevision, true
+	}
+	if hasRevision {
+		var err error
+		if f.Revision, err = url.PathUnescape(revision); err != nil || f.Revision == "" {
+			return File{}, fmt.Errorf("hf:// URL %s has an invalid revision %q", u, revision)
+		}
+	} else {
+		f.Revision = "main"
+	}
+	if !fs.ValidPath(f.Path) {
+		return File{}, fmt.Errorf("hf:// URL %s has an invalid file path %q", u, f.Path)
+	}
+	return f, nil
+}
+
+// ResolveURL returns the URL the file is downloaded from on the Hub at endpoint (DefaultEndpoint if empty). Files
+// stored with LFS or Xet are redirected from it to the Hub's CDN, with a pre-authorized URL.
+func (f File) ResolveURL(endpoint string) string {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(endpoint, "/"))
+	if f.RepoType != RepoTypeModel {
+		b.WriteString("/" + f.RepoType + "s")
+	}
+	b.WriteString("/" + f.Repo + "/resolve/" + url.PathEscape(f.Revision))
+	for _, segment := range strings.Split(f.Path, "/") {
+		b.WriteString("/" + url.PathEscape(segment))
+	}
+	return b.String()
+}
+
+// Endpoint returns the Hub to download from: HF_ENDPOINT, or DefaultEndpoint.
+func Endpoint() string {
+	if endpoint := os.Getenv("HF_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return DefaultEndpoint
+}
+
+// Token returns the access token to authenticate to the Hub with, looked up as the huggingface_hub library does: HF_TOKEN
+// (or the older HUGGING_FACE_HUB_TOKEN), or else the file saved by `huggingface-cli login`, at HF_TOKEN_PATH or
+// $HF_HOME/token (HF_HOME being ~/.cache/huggingface by default). It is empty if there is none, which is enough for
+// public repositories.
+func Token() (string, error) {
+	for _, name := range []string{"HF_TOKEN", "HUGGING_FACE_HUB_TOKEN"} {
+		if token := strings.TrimSpace(os.Getenv(name)); token != "" {
+			return token, nil
+		}
+	}
+	path := tokenPath()
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading Hugging Face token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func tokenPath() string {
+	if path := os.Getenv("HF_TOKEN_PATH"); path != "" {
+		return path
+	}
+	if home := os.Getenv("HF_HOME"); home != "" {
+		return filepath.Join(home, "token")
+	}
+	cache := os.Getenv("XDG_CACHE_HOME")
+	if cache == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		cache = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(cache, "huggingface", "token")
+}
@@ -0,0 +1,103 @@
+package hf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected File
+		resolved string
+	}{
+		{
+			url:      "hf://openai-community/gpt2/config.json",
+			expected: File{RepoType: RepoTypeModel, Repo: "openai-community/gpt2", Path: "config.json", Revision: "main"},
+			resolved: "https://huggingface.co/openai-community/gpt2/resolve/main/config.json",
+		},
+		{
+			url:      "hf://org/repo/onnx/model file.onnx@v1.0",
+			expected: File{RepoType: RepoTypeModel, Repo: "org/repo", Path: "onnx/model file.onnx", Revision: "v1.0"},
+			resolved: "https://huggingface.co/org/repo/resolve/v1.0/onnx/model%20file.onnx",
+		},
+		{
+			url:      "hf://org/repo/model.bin@refs/pr/1",
+			expected: File{RepoType: RepoTypeModel, Repo: "org/repo", Path: "model.bin", Revision: "refs/pr/1"},
+			resolved: "https://huggingface.co/org/repo/resolve/refs%2Fpr%2F1/model.bin",
+		},
+		{
+			url:      "hf://models/org/repo@refs%2Fpr%2F2/model.bin",
+			expected: File{RepoType: RepoTypeModel, Repo: "org/repo", Path: "model.bin", Revision: "refs/pr/2"},
+			resolved: "https://huggingface.co/org/repo/resolve/refs%2Fpr%2F2/model.bin",
+		},
+		{
+			url:      "hf://datasets/org/data/train/0.parquet@4b1c2d",
+			expected: File{RepoType: RepoTypeDataset, Repo: "org/data", Path: "train/0.parquet", Revision: "4b1c2d"},
+			resolved: "https://huggingface.co/datasets/org/data/resolve/4b1c2d/train/0.parquet",
+		},
+		{
+			url:      "hf://spaces/org/app/app.py",
+			expected: File{RepoType: RepoTypeSpace, Repo: "org/app", Path: "app.py", Revision: "main"},
+			resolved: "https://huggingface.co/spaces/org/app/resolve/main/app.py",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			file, err := ParseURL(tc.url)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, file)
+			assert.Equal(t, tc.resolved, file.ResolveURL(""))
+		})
+	}
+
+	for _, u := range []string{
+		"https://huggingface.co/org/repo/resolve/main/config.json",
+		"hf://org/repo",
+		"hf://org/repo/",
+		"hf://gpt2/config.json",
+		"hf://org/repo@v1/config.json@v2",
+		"hf://org/repo/config.json@",
+		"hf://org/repo/../config.json",
+	} {
+		_, err := ParseURL(u)
+		assert.Error(t, err, u)
+	}
+}
+
+func TestResolveURLEndpoint(t *testing.T) {
+	file, err := ParseURL("hf://org/repo/config.json")
+	require.NoError(t, err)
+	assert.Equal(t, "https://hf-mirror.example.com/org/repo/resolve/main/config.json", file.ResolveURL("https://hf-mirror.example.com/"))
+}
+
+func TestToken(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HF_TOKEN", "")
+	t.Setenv("HUGGING_FACE_HUB_TOKEN", "")
+	t.Setenv("HF_TOKEN_PATH", "")
+	t.Setenv("HF_HOME", home)
+
+	token, err := Token()
+	require.NoError(t, err)
+	assert.Empty(t, token)
+
+	require.NoError(t, os.WriteFile(filepath.Join(home, "token"), []byte("hf_file\n"), 0600))
+	token, err = Token()
+	require.NoError(t, err)
+	assert.Equal(t, "hf_file", token)
+
+	t.Setenv("HUGGING_FACE_HUB_TOKEN", "hf_legacy")
+	token, err = Token()
+	require.NoError(t, err)
+	assert.Equal(t, "hf_legacy", token)
+
+	t.Setenv("HF_TOKEN", "hf_env")
+	token, err = Token()
+	require.NoError(t, err)
+	assert.Equal(t, "hf_env", token)
+}
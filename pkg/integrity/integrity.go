@@ -0,0 +1,118 @@
+// Package integrity parses the expected digests of downloaded content. They may be given in the Subresource Integrity
+// (SRI) format used by web tooling and lockfiles (sha384-<base64>), or as a hex-encoded SHA-256.
+package integrity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// algorithms are the supported hash algorithms, weakest first.
+var algorithms = []struct {
+	name string
+	new  func() hash.Hash
+	size int
+}{
+	{"sha256", sha256.New, sha256.Size},
+	{"sha384", sha512.New384, sha512.Size384},
+	{"sha512", sha512.New, sha512.Size},
+}
+
+// Algorithms returns the names of the supported hash algorithms, weakest first.
+func Algorithms() []string {
+	names := make([]string, len(algorithms))
+	for i, algorithm := range algorithms {
+		names[i] = algorithm.name
+	}
+	return names
+}
+
+// Integrity is an expected digest of some content. Like an SRI string it may list several digests, of which the
+// content need only match one.
+type Integrity struct {
+	algorithm int
+	digests   [][]byte
+}
+
+// Parse parses an integrity string: either a hex-encoded SHA-256, or SRI metadata, a whitespace-separated list of
+// <algorithm>-<base64 digest> with sha256, sha384 or sha512 (options after a ? are ignored). As in SRI, only the
+// digests of the strongest algorithm listed are used.
+func Parse(s string) (*Integrity, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == hex.EncodedLen(sha256.Size) {
+		if digest, err := hex.DecodeString(s); err == nil {
+			return &Integrity{digests: [][]byte{digest}}, nil
+		}
+	}
+	i := &Integrity{algorithm: -1}
+	for _, token := range strings.Fields(s) {
+		token, _, _ = strings.Cut(token, "?")
+		name, encoded, ok := strings.Cut(token, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid integrity %q: expected <algorithm>-<base64 digest>", token)
+		}
+		algorithm := -1
+		for index, candidate := range algorithms {
+			if candidate.name == name {
+				algorithm = index
+			}
+		}
+		if algorithm < 0 {
+			return nil, fmt.Errorf("invalid integrity %q: unsupported algorithm %s, expected sha256, sha384 or sha512", token, name)
+		}
+		digest, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(digest) != algorithms[algorithm].size {
+			return nil, fmt.Errorf("invalid integrity %q: bad %s digest", token, name)
+		}
+		if algorithm > i.algorithm {
+			i.algorithm, i.digests = algorithm, nil
+		}
+		if algorithm == i.algorithm {
+			i.digests = append(i.digests, digest)
+		}
+	}
+	if len(i.digests) == 0 {
+		return nil, fmt.Errorf("invalid integrity %q: no digest", s)
+	}
+	return i, nil
+}
+
+// New returns a hash of the algorithm of the expected digests.
+func (i *Integrity) New() hash.Hash {
+	return algorithms[i.algorithm].new()
+}
+
+// Matches reports whether sum, computed with a hash returned by New, is one of the expected digests.
+func (i *Integrity) Matches(sum []byte) bool {
+	for _, digest := range i.digests {
+		if bytes.Equal(sum, digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// Format returns sum, computed with a hash returned by New, in SRI format.
+func (i *Integrity) Format(sum []byte) string {
+	return algorithms[i.algorithm].name + "-" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// Equal reports whether i and other expect the same digests.
+func (i *Integrity) Equal(other *Integrity) bool {
+	return i.String() == other.String()
+}
+
+// String returns the expected digests in SRI format.
+func (i *Integrity) String() string {
+	formatted := make([]string, len(i.digests))
+	for index, digest := range i.digests {
+		formatted[index] = i.Format(digest)
+	}
+	return strings.Join(formatted, " ")
+}
@@ -0,0 +1,83 @@
+package integrity_test
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/integrity"
+)
+
+const content = "hello, world!"
+
+func sum(t *testing.T, i *integrity.Integrity) []byte {
+	h := i.New()
+	_, err := h.Write([]byte(content))
+	require.NoError(t, err)
+	return h.Sum(nil)
+}
+
+func TestParse(t *testing.T) {
+	sha256Sum := sha256.Sum256([]byte(content))
+	sha384Sum := sha512.Sum384([]byte(content))
+	sha512Sum := sha512.Sum512([]byte(content))
+	sri256 := "sha256-" + base64.StdEncoding.EncodeToString(sha256Sum[:])
+	sri384 := "sha384-" + base64.StdEncoding.EncodeToString(sha384Sum[:])
+	sri512 := "sha512-" + base64.StdEncoding.EncodeToString(sha512Sum[:])
+	other384 := "sha384-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size384))
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+		matches  bool
+	}{
+		{"hex sha256", hex.EncodeToString(sha256Sum[:]), sri256, true},
+		{"upper case hex", strings.ToUpper(hex.EncodeToString(sha256Sum[:])), sri256, true},
+		{"sha256", sri256, sri256, true},
+		{"sha384", sri384, sri384, true},
+		{"sha512", sri512, sri512, true},
+		{"options ignored", sri384 + "?ct=application/octet-stream", sri384, true},
+		{"strongest algorithm used", sri256 + " " + sri512, sri512, true},
+		{"any digest of strongest algorithm", other384 + "\t" + sri384, other384 + " " + sri384, true},
+		{"weaker matching digest ignored", sri256 + " " + other384, other384, false},
+		{"mismatch", other384, other384, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			i, err := integrity.Parse(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, i.String())
+			assert.Equal(t, tc.matches, i.Matches(sum(t, i)))
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"abc",
+		"md5-XrY7u+Ae7tCTyyK7j1rNww==",
+		"sha384-not base64",
+		"sha256-" + base64.StdEncoding.EncodeToString([]byte("too short")),
+		"sha384",
+	} {
+		t.Run(input, func(t *testing.T) {
+			_, err := integrity.Parse(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	i, err := integrity.Parse("sha384-" + base64.StdEncoding.EncodeToString(make([]byte, sha512.Size384)))
+	require.NoError(t, err)
+	sha384Sum := sha512.Sum384([]byte(content))
+	assert.Equal(t, "sha384-"+base64.StdEncoding.EncodeToString(sha384Sum[:]), i.Format(sum(t, i)))
+}
@@ -0,0 +1,253 @@
+package integrity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SliceSumsSuffix is appended to the path of a file, or the URL of an object, to name its slice sums manifest.
+	SliceSumsSuffix = ".pget.sum"
+	// DefaultSliceSize is the size of the slices of the manifests pget writes.
+	DefaultSliceSize = 64 * 1024 * 1024
+
+	sliceSumsHeader = "pget-slice-sums v1"
+)
+
+// SliceSums is a manifest of the SHA-256 of each slice of a file, every slice but the last being SliceSize bytes.
+// Unlike a digest of the whole file, it tells which parts of a file are damaged, and parts of the file can be
+// checked on their own.
+//
+// It is written as text: a "pget-slice-sums v1" line, "size <bytes>" and "slice-size <bytes>" lines, and then a
+// "<index> <hex SHA-256>" line for each slice, in order.
+type SliceSums struct {
+	Size      int64
+	SliceSize int64
+	Sums      [][]byte
+}
+
+// SliceMismatchError reports a slice whose content doesn't match its sum.
+type SliceMismatchError struct {
+	Slice int
+	// Start and End are the offsets of the first and last bytes of the slice.
+	Start, End int64
+}
+
+func (e *SliceMismatchError) Error() string {
+	return fmt.Sprintf("slice %d (bytes %d-%d) does not match its sum", e.Slice, e.Start, e.End)
+}
+
+// ParseSliceSums parses a manifest written by SliceSums.WriteTo.
+func ParseSliceSums(r io.Reader) (*SliceSums, error) {
+	scanner := bufio.NewScanner(r)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// stop at once if r is something else, which may be large
+		if len(lines) == 0 && line != sliceSumsHeader {
+			return nil, fmt.Errorf("invalid slice sums: expected a %q header", sliceSumsHeader)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading slice sums: %w", err)
+	}
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("invalid slice sums: expected a %q header, size and slice size", sliceSumsHeader)
+	}
+	s := &SliceSums{}
+	for _, field := range []struct {
+		name  string
+		line  string
+		value *int64
+	}{{"size", lines[1], &s.Size}, {"slice-size", lines[2], &s.SliceSize}} {
+		value, ok := strings.CutPrefix(field.line, field.name+" ")
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if !ok || err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid slice sums: expected %q, got %q", field.name+" <bytes>", field.line)
+		}
+		*field.value = parsed
+	}
+	if s.SliceSize <= 0 {
+		return nil, fmt.Errorf("invalid slice sums: slice size must be positive")
+	}
+	for i, line := range lines[3:] {
+		index, encoded, ok := strings.Cut(line, " ")
+		sum, err := hex.DecodeString(encoded)
+		if !ok || index != strconv.Itoa(i) || err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid slice sums: expected \"%d <hex SHA-256>\", got %q", i, line)
+		}
+		s.Sums = append(s.Sums, sum)
+	}
+	if expected := s.slices(); int64(len(s.Sums)) != expected {
+		return nil, fmt.Errorf("invalid slice sums: %d bytes in slices of %d make %d slices, got %d sums", s.Size, s.SliceSize, expected, len(s.Sums))
+	}
+	return s, nil
+}
+
+func (s *SliceSums) slices() int64 {
+	return (s.Size + s.SliceSize - 1) / s.SliceSize
+}
+
+// bounds returns the offsets of the first and last bytes of slice.
+func (s *SliceSums) bounds(slice int) (int64, int64) {
+	start := int64(slice) * s.SliceSize
+	return start, min(start+s.SliceSize, s.Size) - 1
+}
+
+// WriteTo writes the manifest in the format ParseSliceSums reads.
+func (s *SliceSums) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\nsize %d\nslice-size %d\n", sliceSumsHeader, s.Size, s.SliceSize)
+	for i, sum := range s.Sums {
+		fmt.Fprintf(&b, "%d %s\n", i, hex.EncodeToString(sum))
+	}
+	return b.WriteTo(w)
+}
+
+// Equal reports whether s and other describe the same content.
+func (s *SliceSums) Equal(other *SliceSums) bool {
+	if s.Size != other.Size || s.SliceSize != other.SliceSize || len(s.Sums) != len(other.Sums) {
+		return false
+	}
+	for i := range s.Sums {
+		if !bytes.Equal(s.Sums[i], other.Sums[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check hashes the slices of the content read from r, which must be s.Size bytes, and returns a SliceMismatchError
+// for each which doesn't match its sum.
+func (s *SliceSums) Check(r io.Reader) ([]*SliceMismatchError, error) {
+	hasher := NewSliceHasher(s.SliceSize)
+	n, err := io.Copy(hasher, r)
+	if err != nil {
+		return nil, err
+	}
+	if n != s.Size {
+		return nil, fmt.Errorf("expected %d bytes, read %d", s.Size, n)
+	}
+	var mismatches []*SliceMismatchError
+	for i, sum := range hasher.Sums(n).Sums {
+		if !bytes.Equal(sum, s.Sums[i]) {
+			start, end := s.bounds(i)
+			mismatches = append(mismatches, &SliceMismatchError{Slice: i, Start: start, End: end})
+		}
+	}
+	return mismatches, nil
+}
+
+// SliceHasher computes the SliceSums of the content written to it.
+type SliceHasher struct {
+	sliceSize int64
+	hash      hash.Hash
+	// filled is the number of bytes of the current slice written to hash
+	filled int64
+	sums   [][]byte
+	// done, if set, is called with the index and sum of each slice as it is completed
+	done func(slice int, sum []byte) error
+}
+
+// NewSliceHasher returns a SliceHasher for slices of sliceSize bytes (DefaultSliceSize if zero).
+func NewSliceHasher(sliceSize int64) *SliceHasher {
+	if sliceSize <= 0 {
+		sliceSize = DefaultSliceSize
+	}
+	return &SliceHasher{sliceSize: sliceSize, hash: sha256.New()}
+}
+
+func (h *SliceHasher) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(int64(len(p)), h.sliceSize-h.filled)
+		h.hash.Write(p[:n])
+		h.filled += n
+		written += int(n)
+		p = p[n:]
+		if h.filled == h.sliceSize {
+			if err := h.completeSlice(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (h *SliceHasher) completeSlice() error {
+	sum := h.hash.Sum(nil)
+	h.sums = append(h.sums, sum)
+	h.hash.Reset()
+	h.filled = 0
+	if h.done != nil {
+		return h.done(len(h.sums)-1, sum)
+	}
+	return nil
+}
+
+// Sums returns the SliceSums of the size bytes written. It must be called once they all have been.
+func (h *SliceHasher) Sums(size int64) *SliceSums {
+	if h.filled > 0 {
+		// the last, short slice
+		_ = h.completeSlice()
+	}
+	return &SliceSums{Size: size, SliceSize: h.sliceSize, Sums: h.sums}
+}
+
+// Verifier returns r, the content s describes, checking each slice against its sum as it is read. A read completing
+// a slice which doesn't match returns a SliceMismatchError, as does the end of content of the wrong size.
+func (s *SliceSums) Verifier(r io.Reader) io.Reader {
+	v := &sliceVerifier{r: r, sums: s, hasher: NewSliceHasher(s.SliceSize)}
+	v.hasher.done = func(slice int, sum []byte) error {
+		if slice >= len(s.Sums) || !bytes.Equal(sum, s.Sums[slice]) {
+			start, end := s.bounds(slice)
+			return &SliceMismatchError{Slice: slice, Start: start, End: end}
+		}
+		return nil
+	}
+	return v
+}
+
+type sliceVerifier struct {
+	r      io.Reader
+	sums   *SliceSums
+	hasher *SliceHasher
+	read   int64
+	err    error
+}
+
+func (v *sliceVerifier) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.r.Read(p)
+	v.read += int64(n)
+	if _, hashErr := v.hasher.Write(p[:n]); hashErr != nil {
+		v.err = hashErr
+		return n, hashErr
+	}
+	if err == io.EOF {
+		if v.read != v.sums.Size {
+			v.err = fmt.Errorf("content is %d bytes, its slice sums are for %d", v.read, v.sums.Size)
+			return n, v.err
+		}
+		if v.hasher.filled > 0 {
+			if hashErr := v.hasher.completeSlice(); hashErr != nil {
+				v.err = hashErr
+				return n, hashErr
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,98 @@
+package integrity_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/integrity"
+)
+
+func sliceSums(t *testing.T, data []byte, sliceSize int64) *integrity.SliceSums {
+	t.Helper()
+	hasher := integrity.NewSliceHasher(sliceSize)
+	// written in pieces which don't line up with the slices
+	for _, piece := range [][]byte{data[:3], data[3:]} {
+		_, err := hasher.Write(piece)
+		require.NoError(t, err)
+	}
+	return hasher.Sums(int64(len(data)))
+}
+
+func TestSliceSumsRoundTrip(t *testing.T) {
+	sums := sliceSums(t, []byte(content), 5)
+	require.Len(t, sums.Sums, 3)
+	last := sha256.Sum256([]byte(content[10:]))
+	assert.Equal(t, last[:], sums.Sums[2])
+
+	var b bytes.Buffer
+	_, err := sums.WriteTo(&b)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(b.String(), "pget-slice-sums v1\nsize 13\nslice-size 5\n0 "))
+
+	parsed, err := integrity.ParseSliceSums(&b)
+	require.NoError(t, err)
+	assert.True(t, sums.Equal(parsed))
+}
+
+func TestParseSliceSumsInvalid(t *testing.T) {
+	var b bytes.Buffer
+	_, err := sliceSums(t, []byte(content), 5).WriteTo(&b)
+	require.NoError(t, err)
+	valid := b.String()
+	lines := strings.SplitAfter(valid, "\n")
+
+	for name, input := range map[string]string{
+		"not a manifest":  content,
+		"missing sum":     strings.Join(lines[:5], ""),
+		"misnumbered sum": strings.Replace(valid, "\n2 ", "\n3 ", 1),
+		"zero slice size": strings.Replace(valid, "slice-size 5", "slice-size 0", 1),
+		"short sum":       strings.Join(lines[:5], "") + "2 abcd\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := integrity.ParseSliceSums(strings.NewReader(input))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSliceSumsCheck(t *testing.T) {
+	sums := sliceSums(t, []byte(content), 5)
+
+	mismatches, err := sums.Check(strings.NewReader(content))
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	mismatches, err = sums.Check(strings.NewReader("hello, World!"))
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	assert.Equal(t, integrity.SliceMismatchError{Slice: 1, Start: 5, End: 9}, *mismatches[0])
+
+	_, err = sums.Check(strings.NewReader(content[:12]))
+	assert.Error(t, err)
+}
+
+func TestSliceSumsVerifier(t *testing.T) {
+	sums := sliceSums(t, []byte(content), 5)
+
+	data, err := io.ReadAll(sums.Verifier(strings.NewReader(content)))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	// the mismatch is returned with the read completing the slice, before the rest is read
+	data, err = io.ReadAll(sums.Verifier(iotest.OneByteReader(strings.NewReader("hello, World!"))))
+	var mismatch *integrity.SliceMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, 1, mismatch.Slice)
+	assert.Equal(t, "hello, Wor", string(data))
+
+	_, err = io.ReadAll(sums.Verifier(strings.NewReader(content + "!")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,172 @@
+// Package ipfs parses the content identifiers (CIDs) of ipfs:// URLs and verifies downloaded content against them.
+package ipfs
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Codecs of the content a CID addresses.
+const (
+	// Raw is content addressed as a single block of bytes.
+	Raw uint64 = 0x55
+	// DagPB is the root of a UnixFS DAG, the default for files added to IPFS.
+	DagPB uint64 = 0x70
+)
+
+const (
+	// sha256Multihash is the multihash code of SHA-256, the only hash function supported.
+	sha256Multihash = 0x12
+	sha256Length    = 32
+)
+
+// ErrUnsupportedCID is returned for CIDs which are well-formed but use a hash function or codec that is not
+// supported.
+var ErrUnsupportedCID = errors.New("unsupported CID")
+
+// A CID is a content identifier: the hash of a block, and how to interpret it.
+type CID struct {
+	Version int
+	Codec   uint64
+	// Digest is the SHA-256 digest of the block.
+	Digest []byte
+}
+
+var base32Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// ParseCID parses a CIDv0 (base58, starting with Qm) or a CIDv1 in base32 (starting with b).
+func ParseCID(s string) (CID, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		multihash, err := decodeBase58(s)
+		if err != nil {
+			return CID{}, fmt.Errorf("invalid CID %q: %w", s, err)
+		}
+		digest, err := parseMultihash(s, multihash)
+		if err != nil {
+			return CID{}, err
+		}
+		return CID{Version: 0, Codec: DagPB, Digest: digest}, nil
+	}
+	if !strings.HasPrefix(s, "b") {
+		return CID{}, fmt.Errorf("%w %q: only CIDv0 and base32 CIDv1 are supported", ErrUnsupportedCID, s)
+	}
+	data, err := base32Encoding.DecodeString(strings.ToUpper(s[1:]))
+	if err != nil {
+		return CID{}, fmt.Errorf("invalid CID %q: %w", s, err)
+	}
+	r := bytes.NewReader(data)
+	version, err := binary.ReadUvarint(r)
+	if err != nil || version != 1 {
+		return CID{}, fmt.Errorf("invalid CID %q: bad version", s)
+	}
+	codec, err := binary.ReadUvarint(r)
+	if err != nil {
+		return CID{}, fmt.Errorf("invalid CID %q: bad codec", s)
+	}
+	if codec != Raw && codec != DagPB {
+		return CID{}, fmt.Errorf("%w %q: codec 0x%x", ErrUnsupportedCID, s, codec)
+	}
+	digest, err := parseMultihash(s, data[len(data)-r.Len():])
+	if err != nil {
+		return CID{}, err
+	}
+	return CID{Version: 1, Codec: codec, Digest: digest}, nil
+}
+
+func parseMultihash(s string, multihash []byte) ([]byte, error) {
+	r := bytes.NewReader(multihash)
+	code, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CID %q: bad multihash", s)
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil || length != uint64(r.Len()) {
+		return nil, fmt.Errorf("invalid CID %q: bad multihash length", s)
+	}
+	if code != sha256Multihash || length != sha256Length {
+		return nil, fmt.Errorf("%w %q: multihash 0x%x", ErrUnsupportedCID, s, code)
+	}
+	return multihash[len(multihash)-r.Len():], nil
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	for _, c := range s {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+	// leading 1s are leading zero bytes
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+func encodeBase58(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	var out []byte
+	mod := new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, big.NewInt(58), mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, '1')
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// Bytes returns the binary form of the CID, as it appears in the links of a DAG.
+func (c CID) Bytes() []byte {
+	multihash := append([]byte{sha256Multihash, sha256Length}, c.Digest...)
+	if c.Version == 0 {
+		return multihash
+	}
+	b := binary.AppendUvarint([]byte{1}, c.Codec)
+	return append(b, multihash...)
+}
+
+func (c CID) String() string {
+	if c.Version == 0 {
+		return encodeBase58(c.Bytes())
+	}
+	return "b" + strings.ToLower(base32Encoding.EncodeToString(c.Bytes()))
+}
+
+// IsURL reports whether u is an ipfs:// URL.
+func IsURL(u string) bool {
+	return strings.HasPrefix(u, "ipfs://")
+}
+
+// ParseURL splits an ipfs://CID/path URL into its CID and the path within it, which is empty if the URL addresses
+// the CID itself.
+func ParseURL(u string) (CID, string, error) {
+	rest, ok := strings.CutPrefix(u, "ipfs://")
+	if !ok {
+		return CID{}, "", fmt.Errorf("not an ipfs:// URL: %s", u)
+	}
+	cid, path, _ := strings.Cut(rest, "/")
+	parsed, err := ParseCID(cid)
+	if err != nil {
+		return CID{}, "", err
+	}
+	if path != "" {
+		path = "/" + path
+	}
+	return parsed, path, nil
+}
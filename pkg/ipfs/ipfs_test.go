@@ -0,0 +1,66 @@
+package ipfs_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/ipfs"
+)
+
+func TestParseCID(t *testing.T) {
+	for _, s := range []string{
+		"QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH",
+		"bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+	} {
+		cid, err := ipfs.ParseCID(s)
+		require.NoError(t, err)
+		assert.Equal(t, s, cid.String())
+	}
+
+	_, err := ipfs.ParseCID("zdj7WWeQ43G6JJvLWQWZpyHuAMq6uYWRjkBXFad11vE2LHhQ7")
+	assert.ErrorIs(t, err, ipfs.ErrUnsupportedCID)
+	_, err = ipfs.ParseCID("Qm-not-base58-----------------------------------")
+	assert.Error(t, err)
+}
+
+func TestParseURL(t *testing.T) {
+	cid, path, err := ipfs.ParseURL("ipfs://QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH/weights/model.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", cid.String())
+	assert.Equal(t, "/weights/model.bin", path)
+
+	_, _, err = ipfs.ParseURL("https://example.com/model.bin")
+	assert.Error(t, err)
+}
+
+func TestVerifier(t *testing.T) {
+	testCases := []struct {
+		name    string
+		cid     string
+		content string
+	}{
+		// as printed by `ipfs add`
+		{"empty", "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", ""},
+		{"single block", "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o", "hello world\n"},
+		{"raw", "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cid, err := ipfs.ParseCID(tc.cid)
+			require.NoError(t, err)
+			v := ipfs.NewVerifier(cid)
+			_, err = v.Write([]byte(tc.content))
+			require.NoError(t, err)
+			_, err = v.Verify()
+			assert.NoError(t, err)
+
+			v = ipfs.NewVerifier(cid)
+			_, err = v.Write([]byte(tc.content + "!"))
+			require.NoError(t, err)
+			_, err = v.Verify()
+			assert.ErrorIs(t, err, ipfs.ErrMismatch)
+		})
+	}
+}
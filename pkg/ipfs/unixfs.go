@@ -0,0 +1,187 @@
+package ipfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+const (
+	// chunkSize and maxLinks are the defaults of `ipfs add`: files are split into 256 KiB blocks, which are linked
+	// from a balanced tree of nodes with at most 174 links each.
+	chunkSize = 256 * 1024
+	maxLinks  = 174
+
+	unixfsFile = 2
+)
+
+// ErrMismatch is returned by Verifier.Verify when the content does not hash to the CID.
+var ErrMismatch = errors.New("content does not match CID")
+
+// A Verifier computes the CID of the content written to it and compares it with the expected one. Raw CIDs address
+// the SHA-256 of the whole content. DagPB CIDs address the root of the UnixFS DAG built by `ipfs add` with its
+// default settings (256 KiB blocks, balanced layout, raw leaves for CIDv1), which is rebuilt from the content; content
+// added with other settings has a different CID, and fails verification.
+type Verifier struct {
+	expected CID
+
+	// raw hashes the whole content, for Raw CIDs
+	raw hash.Hash
+
+	// chunk buffers the current block of a DagPB CID
+	chunk  []byte
+	levels [][]link
+	leaves int
+}
+
+var _ io.Writer = &Verifier{}
+
+// link is a link from a node of the DAG to a child.
+type link struct {
+	cid CID
+	// size is the size of the content under the child, and treeSize the size of its blocks
+	size     uint64
+	treeSize uint64
+}
+
+func NewVerifier(expected CID) *Verifier {
+	v := &Verifier{expected: expected}
+	if expected.Codec == Raw {
+		v.raw = sha256.New()
+	} else {
+		v.chunk = make([]byte, 0, chunkSize)
+	}
+	return v
+}
+
+func (v *Verifier) Write(p []byte) (int, error) {
+	if v.raw != nil {
+		return v.raw.Write(p)
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(v.chunk) == chunkSize {
+			v.addLeaf()
+		}
+		take := min(len(p), chunkSize-len(v.chunk))
+		v.chunk = append(v.chunk, p[:take]...)
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// Verify returns the CID of the content written so far, and ErrMismatch if it is not the expected one.
+func (v *Verifier) Verify() (CID, error) {
+	var actual CID
+	if v.raw != nil {
+		actual = CID{Version: 1, Codec: Raw, Digest: v.raw.Sum(nil)}
+	} else {
+		actual = v.root()
+	}
+	if actual.Version != v.expected.Version || actual.Codec != v.expected.Codec || !bytes.Equal(actual.Digest, v.expected.Digest) {
+		return actual, ErrMismatch
+	}
+	return actual, nil
+}
+
+// addLeaf adds the buffered chunk to the DAG as a leaf.
+func (v *Verifier) addLeaf() {
+	size := uint64(len(v.chunk))
+	var l link
+	if v.expected.Version == 0 {
+		block := leafNode(v.chunk)
+		l = link{cid: blockCID(0, DagPB, block), size: size, treeSize: uint64(len(block))}
+	} else {
+		l = link{cid: blockCID(1, Raw, v.chunk), size: size, treeSize: size}
+	}
+	v.chunk = v.chunk[:0]
+	v.leaves++
+	v.push(0, l)
+}
+
+// push adds l to the node being built at level, first completing that node if it is full.
+func (v *Verifier) push(level int, l link) {
+	if level == len(v.levels) {
+		v.levels = append(v.levels, nil)
+	}
+	if len(v.levels[level]) == maxLinks {
+		v.push(level+1, v.node(v.levels[level]))
+		v.levels[level] = nil
+	}
+	v.levels[level] = append(v.levels[level], l)
+}
+
+// root completes the DAG and returns the CID of its root.
+func (v *Verifier) root() CID {
+	if len(v.chunk) > 0 || v.leaves == 0 {
+		v.addLeaf()
+	}
+	if len(v.levels) == 1 && len(v.levels[0]) == 1 {
+		// a single block is its own root
+		return v.levels[0][0].cid
+	}
+	// complete the nodes from the bottom up; pushing to a full level adds another above it
+	for level := 0; level < len(v.levels)-1; level++ {
+		if len(v.levels[level]) > 0 {
+			v.push(level+1, v.node(v.levels[level]))
+			v.levels[level] = nil
+		}
+	}
+	return v.node(v.levels[len(v.levels)-1]).cid
+}
+
+// node returns the link to a new node with links.
+func (v *Verifier) node(links []link) link {
+	var data, block []byte
+	var size, treeSize uint64
+	data = appendVarintField(data, 1, unixfsFile)
+	for _, l := range links {
+		size += l.size
+	}
+	data = appendVarintField(data, 3, size)
+	for _, l := range links {
+		data = appendVarintField(data, 4, l.size)
+	}
+	// links come before the data in the canonical encoding
+	for _, l := range links {
+		var pbLink []byte
+		pbLink = appendBytesField(pbLink, 1, l.cid.Bytes())
+		pbLink = appendBytesField(pbLink, 2, nil)
+		pbLink = appendVarintField(pbLink, 3, l.treeSize)
+		block = appendBytesField(block, 2, pbLink)
+		treeSize += l.treeSize
+	}
+	block = appendBytesField(block, 1, data)
+	return link{cid: blockCID(v.expected.Version, DagPB, block), size: size, treeSize: treeSize + uint64(len(block))}
+}
+
+// leafNode returns the block of a UnixFS file leaf holding chunk.
+func leafNode(chunk []byte) []byte {
+	var data []byte
+	data = appendVarintField(data, 1, unixfsFile)
+	if len(chunk) > 0 {
+		data = appendBytesField(data, 2, chunk)
+	}
+	data = appendVarintField(data, 3, uint64(len(chunk)))
+	return appendBytesField(nil, 1, data)
+}
+
+func blockCID(version int, codec uint64, block []byte) CID {
+	digest := sha256.Sum256(block)
+	return CID{Version: version, Codec: codec, Digest: digest[:]}
+}
+
+// appendVarintField and appendBytesField append a protobuf field.
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, value)
+}
+
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
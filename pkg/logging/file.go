@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// backupTimeFormat is the timestamp in the names of rotated log files; it sorts chronologically.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an io.Writer appending to the log file at Path. Once a write would take the file past MaxSize, the
+// file is renamed with the time of the rotation added before its extension (pget-2006-01-02T15-04-05.000.log) and a
+// new file is started. Rotated files beyond the newest MaxBackups, or older than MaxAge, are removed. A zero limit is
+// no limit.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+var _ io.WriteCloser = &RotatingFile{}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return fmt.Errorf("error creating log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("error rotating log file: %w", err)
+	}
+	f.file = nil
+	prefix, ext := f.backupPrefix()
+	backup := prefix + time.Now().Format(backupTimeFormat) + ext
+	if err := os.Rename(f.Path, backup); err != nil {
+		return fmt.Errorf("error rotating log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune(prefix, ext)
+	return nil
+}
+
+// backupPrefix returns what the names of rotated files start and end with.
+func (f *RotatingFile) backupPrefix() (prefix, ext string) {
+	ext = filepath.Ext(f.Path)
+	return strings.TrimSuffix(f.Path, ext) + "-", ext
+}
+
+// prune removes the rotated files exceeding MaxBackups or MaxAge. Failing to is not worth failing a write for.
+func (f *RotatingFile) prune(prefix, ext string) {
+	if f.MaxBackups <= 0 && f.MaxAge <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(filepath.Dir(prefix))
+	if err != nil {
+		return
+	}
+	type backup struct {
+		path    string
+		rotated time.Time
+	}
+	var backups []backup
+	namePrefix := filepath.Base(prefix)
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), namePrefix)
+		if !ok || !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		rotated, err := time.ParseInLocation(backupTimeFormat, strings.TrimSuffix(stamp, ext), time.Local)
+		if err != nil {
+			// not one of ours
+			continue
+		}
+		backups = append(backups, backup{filepath.Join(filepath.Dir(prefix), entry.Name()), rotated})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotated.After(backups[j].rotated) })
+	for i, b := range backups {
+		if (f.MaxBackups > 0 && i >= f.MaxBackups) || (f.MaxAge > 0 && time.Since(b.rotated) > f.MaxAge) {
+			_ = os.Remove(b.path)
+		}
+	}
+}
+
+// AddFileSink makes the logger write to w as well as to the console, as JSON lines. The console only receives events
+// at consoleLevel or above, w those at fileLevel or above.
+func AddFileSink(w io.Writer, consoleLevel, fileLevel zerolog.Level) {
+	writer := zerolog.MultiLevelWriter(
+		&zerolog.FilteredLevelWriter{Writer: zerolog.LevelWriterAdapter{Writer: consoleWriter()}, Level: consoleLevel},
+		&zerolog.FilteredLevelWriter{Writer: zerolog.LevelWriterAdapter{Writer: w}, Level: fileLevel},
+	)
+	log.Logger = zerolog.New(writer).With().Timestamp().Logger()
+	zerolog.SetGlobalLevel(min(consoleLevel, fileLevel))
+}
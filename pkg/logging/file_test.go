@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "pget.log")
+	f := &RotatingFile{Path: path, MaxSize: 10, MaxBackups: 2}
+	defer f.Close()
+
+	line := []byte("0123456\n")
+	for i := 0; i < 5; i++ {
+		_, err := f.Write(line)
+		require.NoError(t, err)
+		// backups are named by the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, line, current)
+	entries, err := os.ReadDir(filepath.Join(dir, "logs"))
+	require.NoError(t, err)
+	var backups []string
+	for _, entry := range entries {
+		if entry.Name() != "pget.log" {
+			backups = append(backups, entry.Name())
+		}
+	}
+	// four rotations, of which the newest two are kept
+	assert.Len(t, backups, 2)
+	for _, name := range backups {
+		assert.True(t, strings.HasPrefix(name, "pget-") && strings.HasSuffix(name, ".log"), name)
+	}
+}
+
+func TestRotatingFileMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pget.log")
+	old := filepath.Join(dir, "pget-"+time.Now().Add(-48*time.Hour).Format(backupTimeFormat)+".log")
+	unrelated := filepath.Join(dir, "pget-notes.log")
+	require.NoError(t, os.WriteFile(old, []byte("old\n"), 0644))
+	require.NoError(t, os.WriteFile(unrelated, []byte("keep\n"), 0644))
+
+	f := &RotatingFile{Path: path, MaxSize: 4, MaxAge: 24 * time.Hour}
+	defer f.Close()
+	for i := 0; i < 2; i++ {
+		_, err := f.Write([]byte("abc\n"))
+		require.NoError(t, err)
+	}
+
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, unrelated)
+}
+
+func TestAddFileSink(t *testing.T) {
+	defer func(logger zerolog.Logger, level zerolog.Level) {
+		log.Logger = logger
+		zerolog.SetGlobalLevel(level)
+	}(log.Logger, zerolog.GlobalLevel())
+
+	var file bytes.Buffer
+	AddFileSink(&file, zerolog.WarnLevel, zerolog.DebugLevel)
+	assert.Equal(t, zerolog.DebugLevel, zerolog.GlobalLevel())
+	logger := GetLogger()
+	logger.Debug().Str("key", "value").Msg("debug event")
+	assert.Contains(t, file.String(), `"key":"value"`)
+	assert.Contains(t, file.String(), `"message":"debug event"`)
+}
@@ -2,8 +2,10 @@ package logging
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -11,15 +13,47 @@ import (
 )
 
 func SetupLogger() {
+	log.Logger = zerolog.New(consoleWriter()).With().Timestamp().Logger()
+}
+
+// consoleOutput is where the console writer writes, os.Stderr unless redirected with RedirectConsole.
+type consoleOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var console = &consoleOutput{w: os.Stderr}
+
+func (c *consoleOutput) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Write(p)
+}
+
+// RedirectConsole makes the console log lines go to w instead of os.Stderr until restore is called, e.g. to print
+// them above a display drawn on the terminal.
+func RedirectConsole(w io.Writer) (restore func()) {
+	console.mu.Lock()
+	defer console.mu.Unlock()
+	previous := console.w
+	console.w = w
+	return func() {
+		console.mu.Lock()
+		defer console.mu.Unlock()
+		console.w = previous
+	}
+}
+
+func consoleWriter() zerolog.ConsoleWriter {
 	// TODO: Make color configurable? Disabled so we don't have to deal with ANSI escape codes in our logoutput
-	output := zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339, NoColor: true}
+	output := zerolog.ConsoleWriter{Out: console, TimeFormat: time.RFC3339, NoColor: true}
 	output.FormatLevel = func(i interface{}) string {
 		return strings.ToUpper(fmt.Sprintf("| %-6s|", i))
 	}
 	output.FormatMessage = func(i interface{}) string {
 		return fmt.Sprintf("[ %s ]", i)
 	}
-	log.Logger = zerolog.New(output).With().Timestamp().Logger()
+	return output
 }
 
 func GetLogger() zerolog.Logger {
@@ -0,0 +1,89 @@
+package pget
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// notFoundTTL is how long a URL which was not found is remembered by DownloadFiles. Other entries for the URL fail
+// straight away within that time instead of asking the server again.
+const notFoundTTL = 30 * time.Second
+
+// MissingEntriesError is returned by DownloadFiles when the objects of some entries were not found (see
+// download.ErrFileNotFound). The other entries of the manifest are still downloaded.
+type MissingEntriesError struct {
+	Entries []ManifestEntry
+}
+
+func (e *MissingEntriesError) Error() string {
+	dests := make([]string, len(e.Entries))
+	for i, entry := range e.Entries {
+		dests[i] = fmt.Sprintf("%s (%s)", entry.Dest, entry.URL)
+	}
+	return fmt.Sprintf("%d of the manifest entries were not found: %s", len(e.Entries), strings.Join(dests, ", "))
+}
+
+func (e *MissingEntriesError) Unwrap() error {
+	return download.ErrFileNotFound
+}
+
+// missingTracker records the manifest entries which were not found, along with a short-lived negative cache of
+// their URLs.
+type missingTracker struct {
+	mu       sync.Mutex
+	notFound map[string]time.Time
+	entries  []ManifestEntry
+}
+
+func newMissingTracker() *missingTracker {
+	return &missingTracker{notFound: make(map[string]time.Time)}
+}
+
+// cached reports whether url was found missing within the last notFoundTTL.
+func (t *missingTracker) cached(url string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expiry, ok := t.notFound[url]
+	if ok && now.After(expiry) {
+		delete(t.notFound, url)
+		return false
+	}
+	return ok
+}
+
+// missing records that the object of entry was not found.
+func (t *missingTracker) missing(entry ManifestEntry, err error, now time.Time) {
+	logger := logging.GetLogger()
+	logger.Error().
+		Err(err).
+		Str("url", entry.URL).
+		Str("dest", entry.Dest).
+		Msg("Not Found")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.notFound[entry.URL]; !ok {
+		t.notFound[entry.URL] = now.Add(notFoundTTL)
+	}
+	t.entries = append(t.entries, entry)
+}
+
+// err returns a *MissingEntriesError for the entries which were not found, or nil if there are none.
+func (t *missingTracker) err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.entries) == 0 {
+		return nil
+	}
+	entries := slices.Clone(t.entries)
+	slices.SortFunc(entries, func(a, b ManifestEntry) int {
+		return cmp.Compare(a.Dest, b.Dest)
+	})
+	return &MissingEntriesError{Entries: entries}
+}
@@ -0,0 +1,239 @@
+// Package overwrite implements the policies for writing a file over an existing one, as selected by --overwrite. They
+// are shared by the file writer and the archive extractors so that an existing destination is treated the same way
+// whichever consumer writes it.
+package overwrite
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// A Policy decides what happens when a file to be written already exists.
+type Policy string
+
+const (
+	// Never fails on an existing file. This is the default.
+	Never Policy = "never"
+	// Always truncates an existing file and writes it again.
+	Always Policy = "always"
+	// IfDifferent compares the content with the existing file as it is written, and only writes the blocks which
+	// differ, so that an unchanged file is not modified at all.
+	IfDifferent Policy = "if-different"
+	// Resume keeps the bytes of an existing file which is no larger than the new content, on the assumption that it
+	// is an interrupted earlier write of the same content, and only writes the rest. A larger existing file is
+	// written again.
+	Resume Policy = "resume"
+)
+
+// Parse parses the value of --overwrite. An empty value selects Never.
+func Parse(value string) (Policy, error) {
+	switch policy := Policy(value); policy {
+	case "":
+		return Never, nil
+	case Never, Always, IfDifferent, Resume:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown --overwrite value %q, expected never, always, if-different or resume", value)
+}
+
+// Allowed reports whether p permits an existing destination.
+func (p Policy) Allowed() bool {
+	return p != "" && p != Never
+}
+
+// File is a file being written under a Policy. The content must be written sequentially, from the start.
+type File struct {
+	f      *os.File
+	path   string
+	policy Policy
+	size   int64
+	// existing is the size of the file when it was opened, or 0 if the policy discards its content
+	existing int64
+	offset   int64
+	// kept counts the bytes which were already in the file and were not written again
+	kept         int64
+	preallocated bool
+	scratch      []byte
+	// writer, if set, writes the content instead of f (see ReplaceWriter)
+	writer io.WriteCloser
+}
+
+var _ io.WriteCloser = &File{}
+
+// Create opens path to be written with size bytes under policy, creating it with perm if it does not exist. With
+// Never, an existing file is an error satisfying errors.Is(err, fs.ErrExist).
+func Create(path string, size int64, perm os.FileMode, policy Policy) (*File, error) {
+	flags := os.O_CREATE | os.O_WRONLY
+	switch policy {
+	case "", Never:
+		flags |= os.O_EXCL
+	case Always:
+		flags |= os.O_TRUNC
+	case IfDifferent:
+		// the existing content is read back to compare it
+		flags = os.O_CREATE | os.O_RDWR
+	case Resume:
+	default:
+		return nil, fmt.Errorf("unknown overwrite policy %q", policy)
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		return nil, err
+	}
+	w := &File{f: f, path: path, policy: policy, size: size}
+	if policy == IfDifferent || policy == Resume {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		w.existing = info.Size()
+		if policy == Resume && w.existing > size {
+			if err := f.Truncate(0); err != nil {
+				f.Close()
+				return nil, err
+			}
+			w.existing = 0
+		}
+	}
+	return w, nil
+}
+
+// Rename moves the complete file at tempPath to path under policy, in a single step, so that path is never seen
+// half written. With Never, an existing file is an error satisfying errors.Is(err, fs.ErrExist), and tempPath is left
+// in place. IfDifferent and Resume write the existing file in place, so they don't rename.
+func Rename(tempPath, path string, policy Policy) error {
+	switch policy {
+	case "", Never:
+		// unlike a rename, a link fails rather than replace a file which has appeared since Create
+		err := os.Link(tempPath, path)
+		if err == nil {
+			return os.Remove(tempPath)
+		}
+		if errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		// file systems without hard links
+		if _, statErr := os.Lstat(path); statErr == nil {
+			return &fs.PathError{Op: "rename", Path: path, Err: fs.ErrExist}
+		}
+		return os.Rename(tempPath, path)
+	case Always:
+		return os.Rename(tempPath, path)
+	}
+	return fmt.Errorf("overwrite policy %q writes files in place", policy)
+}
+
+// Preallocate sets the size of the file to the size of its content before it is written, so that it can be mapped
+// while it is written.
+func (w *File) Preallocate() error {
+	if err := w.f.Truncate(w.size); err != nil {
+		return err
+	}
+	w.preallocated = true
+	return nil
+}
+
+// canReplaceWriter reports whether the content can be written by another writer than f: it is written as is, from
+// the start, with Never and Always, and nothing has been written yet.
+func (w *File) canReplaceWriter() bool {
+	return (w.policy == "" || w.policy == Never || w.policy == Always) && w.offset == 0 && w.writer == nil
+}
+
+// ReplaceWriter makes the rest of the content be written by the writer newWriter returns for the file, such as one
+// of package fileio, which is closed before the file, and reports whether it will be. It must be called before
+// anything is written. It is only supported for Never and Always, which write the whole file as is; if newWriter
+// fails, the content is written as usual.
+func (w *File) ReplaceWriter(newWriter func(*os.File) (io.WriteCloser, error)) (bool, error) {
+	if !w.canReplaceWriter() {
+		return false, nil
+	}
+	writer, err := newWriter(w.f)
+	if err != nil {
+		return false, err
+	}
+	w.writer = writer
+	return true, nil
+}
+
+func (w *File) Write(p []byte) (int, error) {
+	switch w.policy {
+	case Resume:
+		return w.writeResume(p)
+	case IfDifferent:
+		return w.writeIfDifferent(p)
+	}
+	if w.writer != nil {
+		n, err := w.writer.Write(p)
+		w.offset += int64(n)
+		return n, err
+	}
+	n, err := w.f.Write(p)
+	w.offset += int64(n)
+	return n, err
+}
+
+func (w *File) writeResume(p []byte) (int, error) {
+	skip := min(int64(len(p)), max(w.existing-w.offset, 0))
+	w.offset += skip
+	w.kept += skip
+	n, err := w.f.WriteAt(p[skip:], w.offset)
+	w.offset += int64(n)
+	return int(skip) + n, err
+}
+
+func (w *File) writeIfDifferent(p []byte) (int, error) {
+	same := min(int64(len(p)), max(w.existing-w.offset, 0))
+	if same > 0 {
+		if int64(len(w.scratch)) < same {
+			w.scratch = make([]byte, same)
+		}
+		existing := w.scratch[:same]
+		if _, err := w.f.ReadAt(existing, w.offset); err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if bytes.Equal(existing, p[:same]) {
+			w.kept += same
+		} else if _, err := w.f.WriteAt(p[:same], w.offset); err != nil {
+			return 0, err
+		}
+		w.offset += same
+	}
+	n, err := w.f.WriteAt(p[same:], w.offset)
+	w.offset += int64(n)
+	return int(same) + n, err
+}
+
+// Close closes the file, first cutting it off after the last byte written, so that neither the rest of a longer
+// existing file nor the preallocated space of an incomplete write remains (and could later be taken for content by
+// Resume).
+func (w *File) Close() error {
+	if w.writer != nil {
+		if err := w.writer.Close(); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	if w.existing > w.offset || (w.preallocated && w.size > w.offset) {
+		if err := w.f.Truncate(w.offset); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	if w.kept > 0 {
+		logger := logging.GetLogger()
+		logger.Debug().
+			Str("path", w.path).
+			Str("policy", string(w.policy)).
+			Int64("kept_bytes", w.kept).
+			Int64("written_bytes", w.offset-w.kept).
+			Msg("Overwrite: kept existing content")
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,142 @@
+package overwrite_test
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/overwrite"
+)
+
+func TestParse(t *testing.T) {
+	policy, err := overwrite.Parse("")
+	require.NoError(t, err)
+	assert.Equal(t, overwrite.Never, policy)
+	policy, err = overwrite.Parse("if-different")
+	require.NoError(t, err)
+	assert.Equal(t, overwrite.IfDifferent, policy)
+	_, err = overwrite.Parse("sometimes")
+	assert.Error(t, err)
+}
+
+// writeFile writes content to path under policy, in blocks of 4 bytes. existing, if not nil, is the content of the
+// file beforehand.
+func writeFile(t *testing.T, policy overwrite.Policy, existing *string, content string) (string, error) {
+	path := filepath.Join(t.TempDir(), "file")
+	if existing != nil {
+		require.NoError(t, os.WriteFile(path, []byte(*existing), 0644))
+	}
+	f, err := overwrite.Create(path, int64(len(content)), 0644, policy)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.CopyBuffer(f, struct{ io.Reader }{strings.NewReader(content)}, make([]byte, 4))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(written), nil
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func TestPolicies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   overwrite.Policy
+		existing *string
+		content  string
+		expected string
+		err      error
+	}{
+		{"never, missing", overwrite.Never, nil, "new content", "new content", nil},
+		{"never, exists", overwrite.Never, ptr("old"), "new content", "", fs.ErrExist},
+		{"always, shorter", overwrite.Always, ptr("old"), "new content", "new content", nil},
+		{"always, longer", overwrite.Always, ptr("much older content"), "new content", "new content", nil},
+		{"if-different, missing", overwrite.IfDifferent, nil, "new content", "new content", nil},
+		{"if-different, same", overwrite.IfDifferent, ptr("new content"), "new content", "new content", nil},
+		{"if-different, differs", overwrite.IfDifferent, ptr("new CONTENT"), "new content", "new content", nil},
+		{"if-different, longer", overwrite.IfDifferent, ptr("new content and more"), "new content", "new content", nil},
+		{"if-different, shorter", overwrite.IfDifferent, ptr("new"), "new content", "new content", nil},
+		{"resume, missing", overwrite.Resume, nil, "new content", "new content", nil},
+		// the existing prefix is trusted, not compared
+		{"resume, prefix", overwrite.Resume, ptr("NEW co"), "new content", "NEW content", nil},
+		{"resume, complete", overwrite.Resume, ptr("new content"), "new content", "new content", nil},
+		{"resume, longer", overwrite.Resume, ptr("much older content"), "new content", "new content", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			written, err := writeFile(t, tc.policy, tc.existing, tc.content)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, written)
+		})
+	}
+}
+
+func TestIfDifferentUnchangedFileNotWritten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	past := info.ModTime().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(path, past, past))
+
+	f, err := overwrite.Create(path, 7, 0644, overwrite.IfDifferent)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("content"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(past))
+}
+
+func TestPreallocatedIncompleteWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	f, err := overwrite.Create(path, 100, 0644, overwrite.Resume)
+	require.NoError(t, err)
+	require.NoError(t, f.Preallocate())
+	_, err = f.Write([]byte("partial"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// the preallocated space is not left behind for a later resume to take for content
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "partial", string(written))
+}
+
+func TestRenameNever(t *testing.T) {
+	dir := t.TempDir()
+	temp, dest := filepath.Join(dir, "temp"), filepath.Join(dir, "dest")
+	require.NoError(t, os.WriteFile(temp, []byte("new"), 0644))
+	require.NoError(t, os.WriteFile(dest, []byte("existing"), 0644))
+
+	// a destination which appeared while the temporary file was written is not replaced
+	assert.ErrorIs(t, overwrite.Rename(temp, dest, overwrite.Never), fs.ErrExist)
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "existing", string(content))
+
+	require.NoError(t, os.Remove(dest))
+	require.NoError(t, overwrite.Rename(temp, dest, overwrite.Never))
+	content, err = os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	_, err = os.Stat(temp)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
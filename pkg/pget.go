@@ -1,17 +1,28 @@
 package pget
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"runtime/debug"
 	"sync/atomic"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"golang.org/x/sync/errgroup"
 
-	"github.com/replicate/pget/pkg/consumer"
-	"github.com/replicate/pget/pkg/download"
-	"github.com/replicate/pget/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/integrity"
+	"github.com/replicate/pget/v2/pkg/logging"
+	"github.com/replicate/pget/v2/pkg/vfs"
 )
 
 type Getter struct {
@@ -22,11 +33,111 @@ type Getter struct {
 
 type Options struct {
 	MaxConcurrentFiles int
+
+	// SoftTimeout, if set, escalates a file download that has not completed within the duration (see
+	// download.Escalation) instead of failing it.
+	SoftTimeout time.Duration
+
+	// HardTimeout, if set, aborts a file download that has not completed within the duration.
+	HardTimeout time.Duration
+
+	// HeartbeatInterval, if set, logs the progress of each file download (percent complete and throughput) at this
+	// interval while it is active, at WARN level if it has made no progress since the previous one.
+	HeartbeatInterval time.Duration
+
+	// LowMemory, if set, returns the memory freed by each file download to the operating system once it is over (see
+	// debug.FreeOSMemory), rather than leaving it to the runtime. It goes with download.Options.LowMemory, which lets
+	// the chunk buffers be freed.
+	LowMemory bool
+
+	// MaxMemorySize is the largest object DownloadToMemory will download. If set to zero, 64 MiB will be used.
+	MaxMemorySize int64
+
+	// Warmup, if set, makes DownloadFiles discover the size of every entry before it starts downloading them, and log
+	// the total. A FileOrder other than FileOrderManifest implies it.
+	Warmup bool
+
+	// FileOrder is the order in which DownloadFiles schedules the entries of a manifest. If empty, FileOrderManifest
+	// is used.
+	FileOrder FileOrder
+
+	// ContinueOnError, if set, makes DownloadFiles download the remaining entries of a manifest when one fails, rather
+	// than cancelling them, and return a *FailedEntriesError once they are all done.
+	ContinueOnError bool
+
+	// OnFileComplete, if set, is called with a DownloadRecord after each file is successfully downloaded. Setting
+	// it makes the Getter hash the content as it is consumed. It may be called concurrently.
+	OnFileComplete func(DownloadRecord)
+
+	// MaxTotalBytes, if positive, stops DownloadFiles from starting further entries once the files it has completed
+	// add up to this many bytes. The entries in flight are finished, and a *BudgetExceededError lists the others.
+	MaxTotalBytes int64
+
+	// MaxTotalTime, if positive, stops DownloadFiles from starting further entries once it has run for this long, as
+	// MaxTotalBytes does.
+	MaxTotalTime time.Duration
+
+	// EmitSliceSums, if set, makes DownloadFile write the integrity.SliceSums of each file it writes with a
+	// consumer.FileWriter next to it, at its destination with integrity.SliceSumsSuffix appended.
+	EmitSliceSums bool
+
+	// VerifySliceSums, if set, makes DownloadFile look for the slice sums of each object, at its URL with
+	// integrity.SliceSumsSuffix appended, and check each slice against its sum as it is consumed. Objects without
+	// them are downloaded as usual.
+	VerifySliceSums bool
+
+	// DedupeStrategy is how DownloadFiles handles manifest entries with the same URL and different destinations,
+	// when writing files with a consumer.FileWriter. If empty, DedupeOff is used.
+	DedupeStrategy DedupeStrategy
+
+	// Progress, if set, tracks the progress of each file download, e.g. for a live display of them.
+	Progress *Progress
+}
+
+const defaultMaxMemorySize = 64 * humanize.MiByte
+
+var (
+	// ErrHardTimeout is returned when a file download is aborted by Options.HardTimeout.
+	ErrHardTimeout = errors.New("hard timeout exceeded")
+	// ErrTooLarge is returned by DownloadToMemory for objects larger than Options.MaxMemorySize.
+	ErrTooLarge = errors.New("object too large to download to memory")
+)
+
+func (o Options) maxMemorySize() int64 {
+	if o.MaxMemorySize <= 0 {
+		return defaultMaxMemorySize
+	}
+	// the object is read into a single buffer, along with a byte more to tell that it is too large
+	return min(o.MaxMemorySize, math.MaxInt-1)
 }
 
 type ManifestEntry struct {
 	URL  string
 	Dest string
+	// Group is set if the entry is one shard of a larger logical artifact
+	Group *ManifestGroup
+	// Integrity, if set, is the expected digest of the entry's content, see DownloadVerifiedFile
+	Integrity *integrity.Integrity
+	// Priority, if positive, makes DownloadFiles schedule the entry before the entries without one, lowest value
+	// first, and its chunks take precedence over theirs (see download.WithPriority)
+	Priority int
+	// Pin, if set, pins the download to one version of the object, failing it with download.ErrObjectChanged if
+	// the object is replaced while it is downloaded
+	Pin download.Pin
+	// Unchanged is set if Dest is already up to date, e.g. as reported by a conditional request. DownloadFiles
+	// doesn't download the entry, but still counts it towards its Group.
+	Unchanged bool
+}
+
+// A ManifestGroup declares a set of manifest entries to be the shards of one logical artifact. The shards are
+// scheduled together and a single completion event is logged for the group once all of them are downloaded.
+type ManifestGroup struct {
+	Name string
+	// SHA256 is the hex-encoded SHA-256 digest of the concatenation of the shards, in manifest order. If empty the
+	// combined content is not verified.
+	SHA256 string
+	// Integrity, if set, is used instead of SHA256, and may also use SHA-384 or SHA-512.
+	Integrity *integrity.Integrity
 }
 
 // A Manifest is a slice of ManifestEntry, with a helper method to add entries
@@ -36,22 +147,53 @@ func (m Manifest) AddEntry(url string, destination string) Manifest {
 	return append(m, ManifestEntry{URL: url, Dest: destination})
 }
 
+// AddGroupEntry adds an entry that is a shard of group. All shards of a group must share the same *ManifestGroup.
+func (m Manifest) AddGroupEntry(url string, destination string, group *ManifestGroup) Manifest {
+	return append(m, ManifestEntry{URL: url, Dest: destination, Group: group})
+}
+
 func (g *Getter) DownloadFile(ctx context.Context, url string, dest string) (int64, time.Duration, error) {
+	return g.DownloadVerifiedFile(ctx, url, dest, nil)
+}
+
+// DownloadVerifiedFile is DownloadFile, also checking the content against expected (if not nil) as it is consumed.
+// If it doesn't match, an error wrapping download.ErrChecksumMismatch is returned once the content has been consumed.
+func (g *Getter) DownloadVerifiedFile(ctx context.Context, url string, dest string, expected *integrity.Integrity) (int64, time.Duration, error) {
+	fileSize, elapsed, record, err := g.downloadVerifiedFile(ctx, url, dest, expected)
+	if record != nil {
+		g.Options.OnFileComplete(*record)
+	}
+	return fileSize, elapsed, err
+}
+
+// downloadVerifiedFile is DownloadVerifiedFile, returning the DownloadRecord of the file for Options.OnFileComplete
+// (if set) instead of calling it.
+func (g *Getter) downloadVerifiedFile(ctx context.Context, url string, dest string, expected *integrity.Integrity) (int64, time.Duration, *DownloadRecord, error) {
 	if g.Consumer == nil {
 		g.Consumer = &consumer.FileWriter{}
 	}
 	logger := logging.GetLogger()
 	downloadStartTime := time.Now()
-	buffer, fileSize, err := g.Downloader.Fetch(ctx, url)
-	if err != nil {
-		return fileSize, 0, err
+	if g.Options.LowMemory {
+		defer debug.FreeOSMemory()
 	}
-	// downloadElapsed := time.Since(downloadStartTime)
-	// writeStartTime := time.Now()
 
-	err = g.Consumer.Consume(buffer, dest, fileSize)
+	ctx, stopTimeouts := g.withTimeouts(ctx, url)
+	defer stopTimeouts()
+
+	metadata := &download.Metadata{}
+	ctx = download.WithMetadata(ctx, metadata)
+	tracked := g.Options.Progress.track(url, dest, metadata)
+
+	fileSize, consumed, err := g.consumeAt(ctx, url, dest, expected)
+	// checksum is the SHA-256 of the content, computed if Options.OnFileComplete is set
+	var checksum []byte
+	if !consumed {
+		fileSize, checksum, err = g.consumeStream(ctx, url, dest, expected, tracked)
+	}
+	tracked.finish(err)
 	if err != nil {
-		return fileSize, 0, fmt.Errorf("error writing file: %w", err)
+		return fileSize, 0, nil, err
 	}
 
 	// writeElapsed := time.Since(writeStartTime)
@@ -60,7 +202,7 @@ func (g *Getter) DownloadFile(ctx context.Context, url string, dest string) (int
 	size := humanize.Bytes(uint64(fileSize))
 	// downloadThroughput := humanize.Bytes(uint64(float64(fileSize) / downloadElapsed.Seconds()))
 	// writeThroughput := humanize.Bytes(uint64(float64(fileSize) / writeElapsed.Seconds()))
-	logger.Info().
+	event := logger.Info().
 		Str("dest", dest).
 		Str("url", url).
 		Str("size", size).
@@ -68,9 +210,271 @@ func (g *Getter) DownloadFile(ctx context.Context, url string, dest string) (int
 		// Str("download_elapsed", fmt.Sprintf("%.3fs", downloadElapsed.Seconds())).
 		// Str("write_throughput", fmt.Sprintf("%s/s", writeThroughput)).
 		// Str("write_elapsed", fmt.Sprintf("%.3fs", writeElapsed.Seconds())).
-		Str("total_elapsed", fmt.Sprintf("%.3fs", totalElapsed.Seconds())).
-		Msg("Complete")
-	return fileSize, totalElapsed, nil
+		Str("total_elapsed", fmt.Sprintf("%.3fs", totalElapsed.Seconds()))
+	if ratio, ok := cacheHitRatio(metadata.CacheHits(), metadata.CacheMisses()); ok {
+		event = event.
+			Int64("cache_hits", metadata.CacheHits()).
+			Int64("cache_misses", metadata.CacheMisses()).
+			Float64("cache_hit_ratio", ratio)
+	}
+	event.Msg("Complete")
+	var record *DownloadRecord
+	if g.Options.OnFileComplete != nil {
+		record = &DownloadRecord{
+			URL:         url,
+			Dest:        dest,
+			Size:        fileSize,
+			ETag:        metadata.ETag(),
+			SHA256:      hex.EncodeToString(checksum),
+			Duration:    totalElapsed,
+			Retries:     metadata.Retries(),
+			Fallbacks:   metadata.Fallbacks(),
+			CacheHits:   metadata.CacheHits(),
+			CacheMisses: metadata.CacheMisses(),
+		}
+	}
+	return fileSize, totalElapsed, record, nil
+}
+
+// consumeAt has the consumer read the content at url with random access, if it and the download strategy support it
+// (see consumer.ReaderAtConsumer) and nothing needs to see the content in order: no checksum is to be computed or
+// verified, and no progress reported. consumed is false if the content has to be streamed instead.
+func (g *Getter) consumeAt(ctx context.Context, url, dest string, expected *integrity.Integrity) (fileSize int64, consumed bool, err error) {
+	c, ok := g.Consumer.(consumer.ReaderAtConsumer)
+	strategy, strategyOK := g.Downloader.(download.RandomAccessStrategy)
+	if !ok || !strategyOK || expected != nil || g.Options.OnFileComplete != nil || g.Options.VerifySliceSums ||
+		g.Options.HeartbeatInterval > 0 || g.Options.Progress != nil {
+		return 0, false, nil
+	}
+	r, fileSize, err := strategy.FetchAt(ctx, url)
+	if errors.Is(err, download.ErrRangeNotSupported) {
+		// Fetch downloads it over a single connection instead
+		return 0, false, nil
+	}
+	if err != nil {
+		return fileSize, true, g.timeoutError(ctx, err)
+	}
+	logger := logging.GetLogger()
+	logger.Debug().Str("url", url).Str("dest", dest).Int64("size", fileSize).Msg("Random Access")
+	if err := c.ConsumeAt(r, dest, fileSize); err != nil {
+		return fileSize, true, fmt.Errorf("error writing file: %w", g.timeoutError(ctx, err))
+	}
+	return fileSize, true, nil
+}
+
+// consumeStream has the consumer read the content at url as a stream, checking it against expected (if not nil) and
+// any slice sums, and counting it in tracked (if not nil), and returns its size and, if Options.OnFileComplete is
+// set, its SHA-256.
+func (g *Getter) consumeStream(ctx context.Context, url, dest string, expected *integrity.Integrity, tracked *trackedFile) (int64, []byte, error) {
+	var sliceSums *integrity.SliceSums
+	if g.Options.VerifySliceSums {
+		sliceSums = g.fetchSliceSums(ctx, url)
+	}
+
+	buffer, fileSize, err := g.Downloader.Fetch(ctx, url)
+	if err != nil {
+		return fileSize, nil, g.timeoutError(ctx, err)
+	}
+	buffer, stopHeartbeat := g.withHeartbeat(buffer, url, dest, fileSize)
+	defer stopHeartbeat()
+	buffer = tracked.reader(buffer, fileSize)
+
+	var checksum hash.Hash
+	if g.Options.OnFileComplete != nil {
+		checksum = sha256.New()
+		buffer = io.TeeReader(buffer, checksum)
+	}
+	var verifier hash.Hash
+	if expected != nil {
+		verifier = expected.New()
+		buffer = io.TeeReader(buffer, verifier)
+	}
+	if sliceSums != nil {
+		if sliceSums.Size != fileSize {
+			// the chunk buffers have to be released
+			_, _ = io.Copy(io.Discard, buffer)
+			return fileSize, nil, fmt.Errorf("%w: %s is %d bytes, its slice sums are for %d", download.ErrChecksumMismatch, url, fileSize, sliceSums.Size)
+		}
+		buffer = sliceSums.Verifier(buffer)
+	}
+	var sliceHasher *integrity.SliceHasher
+	if _, ok := g.fileWriter(); ok && g.Options.EmitSliceSums && sliceSums == nil {
+		sliceHasher = integrity.NewSliceHasher(integrity.DefaultSliceSize)
+		buffer = io.TeeReader(buffer, sliceHasher)
+	}
+
+	err = g.Consumer.Consume(buffer, dest, fileSize)
+	if mismatch := (*integrity.SliceMismatchError)(nil); errors.As(err, &mismatch) {
+		return fileSize, nil, fmt.Errorf("%w: %s: %w", download.ErrChecksumMismatch, url, mismatch)
+	}
+	if err != nil {
+		return fileSize, nil, fmt.Errorf("error writing file: %w", g.timeoutError(ctx, err))
+	}
+	if expected != nil {
+		if sum := verifier.Sum(nil); !expected.Matches(sum) {
+			return fileSize, nil, fmt.Errorf("%w: %s expected %s, got %s", download.ErrChecksumMismatch, url, expected, expected.Format(sum))
+		}
+	}
+	if sliceHasher != nil {
+		sliceSums = sliceHasher.Sums(fileSize)
+	}
+	if _, ok := g.fileWriter(); ok && g.Options.EmitSliceSums {
+		if err := writeSliceSums(dest+integrity.SliceSumsSuffix, sliceSums); err != nil {
+			return fileSize, nil, err
+		}
+	}
+	if checksum != nil {
+		return fileSize, checksum.Sum(nil), nil
+	}
+	return fileSize, nil, nil
+}
+
+// fetchSliceSums returns the slice sums published for the object at url, or nil if there are none or they can't be
+// used.
+func (g *Getter) fetchSliceSums(ctx context.Context, url string) *integrity.SliceSums {
+	logger := logging.GetLogger()
+	sumsURL := url + integrity.SliceSumsSuffix
+	data, err := g.DownloadToMemory(ctx, sumsURL)
+	if errors.Is(err, download.ErrFileNotFound) {
+		logger.Debug().Str("url", sumsURL).Msg("No Slice Sums")
+		return nil
+	}
+	if err == nil {
+		var sums *integrity.SliceSums
+		if sums, err = integrity.ParseSliceSums(bytes.NewReader(data)); err == nil {
+			return sums
+		}
+	}
+	logger.Warn().Str("url", sumsURL).Err(err).Msg("Ignoring Slice Sums")
+	return nil
+}
+
+// writeSliceSums writes sums to path, replacing it if it exists.
+func writeSliceSums(path string, sums *integrity.SliceSums) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error writing slice sums: %w", err)
+	}
+	if _, err := sums.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing slice sums to %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing slice sums to %s: %w", path, err)
+	}
+	return nil
+}
+
+// DownloadToMemory downloads the object at url into memory, using the same strategy, retries and timeouts as
+// DownloadFile. ErrTooLarge is returned if it is larger than Options.MaxMemorySize, as soon as that is known.
+func (g *Getter) DownloadToMemory(ctx context.Context, url string) ([]byte, error) {
+	ctx, stopTimeouts := g.withTimeouts(ctx, url)
+	defer stopTimeouts()
+	// cancelled to stop downloading an object which is too large
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	maxSize := g.Options.maxMemorySize()
+	reader, fileSize, err := g.Downloader.Fetch(ctx, url)
+	if err != nil {
+		return nil, g.timeoutError(ctx, err)
+	}
+	tooLarge := func(err error) error {
+		cancel()
+		// the chunks which were already downloaded have to be read to release their buffers
+		_, _ = io.Copy(io.Discard, reader)
+		return err
+	}
+	if fileSize > maxSize {
+		return nil, tooLarge(fmt.Errorf("%w: %s is %d bytes, the limit is %d", ErrTooLarge, url, fileSize, maxSize))
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(max(fileSize, 0)))
+	// the size is only what the server reported, so the read is capped as well
+	if _, err := buf.ReadFrom(io.LimitReader(reader, maxSize+1)); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", url, g.timeoutError(ctx, err))
+	}
+	if int64(buf.Len()) > maxSize {
+		return nil, tooLarge(fmt.Errorf("%w: %s is larger than the limit of %d bytes", ErrTooLarge, url, maxSize))
+	}
+	return buf.Bytes(), nil
+}
+
+// DownloadToWriter streams the object at url into w, using the same strategy, retries and timeouts as DownloadFile,
+// for sinks which are not files, such as an upload to another store. Chunks are written to w in order as they
+// arrive. It returns the number of bytes written.
+func (g *Getter) DownloadToWriter(ctx context.Context, url string, w io.Writer) (int64, error) {
+	if g.Options.LowMemory {
+		defer debug.FreeOSMemory()
+	}
+	ctx, stopTimeouts := g.withTimeouts(ctx, url)
+	defer stopTimeouts()
+
+	reader, fileSize, err := g.Downloader.Fetch(ctx, url)
+	if err != nil {
+		return 0, g.timeoutError(ctx, err)
+	}
+	reader, stopHeartbeat := g.withHeartbeat(reader, url, "", fileSize)
+	defer stopHeartbeat()
+
+	written, err := io.Copy(w, reader)
+	if err != nil {
+		return written, fmt.Errorf("error streaming %s: %w", url, g.timeoutError(ctx, err))
+	}
+	if written != fileSize {
+		return written, fmt.Errorf("error streaming %s: expected %d bytes, wrote %d", url, fileSize, written)
+	}
+	return written, nil
+}
+
+// DownloadToWriterAt downloads the object at url into w, starting at offset 0, as DownloadFile would with a
+// consumer.WriterAtConsumer. To download into a region of memory, such as an mmapped file, set Consumer to a
+// consumer.MemoryWriter and call DownloadFile instead. It returns the size of the object.
+func (g *Getter) DownloadToWriterAt(ctx context.Context, url string, w io.WriterAt) (int64, error) {
+	getter := *g
+	getter.Consumer = &consumer.WriterAtConsumer{W: w}
+	size, _, err := getter.DownloadFile(ctx, url, "")
+	return size, err
+}
+
+// withTimeouts applies Options.HardTimeout and Options.SoftTimeout to a download. The returned function must be
+// called once the download is over.
+func (g *Getter) withTimeouts(ctx context.Context, url string) (context.Context, func()) {
+	logger := logging.GetLogger()
+	var stops []func()
+	if g.Options.HardTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, g.Options.HardTimeout, ErrHardTimeout)
+		stops = append(stops, cancel)
+	}
+	if g.Options.SoftTimeout > 0 {
+		escalation := download.NewEscalation()
+		ctx = download.WithEscalation(ctx, escalation)
+		timer := time.AfterFunc(g.Options.SoftTimeout, func() {
+			logger.Warn().
+				Str("url", url).
+				Str("soft_timeout", g.Options.SoftTimeout.String()).
+				Msg("Soft Timeout: escalating download")
+			escalation.Escalate()
+		})
+		stops = append(stops, func() {
+			timer.Stop()
+			escalation.Close()
+		})
+	}
+	return ctx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// timeoutError makes err match ErrHardTimeout if the download was aborted by the hard timeout.
+func (g *Getter) timeoutError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, ErrHardTimeout) && !errors.Is(err, ErrHardTimeout) {
+		return fmt.Errorf("%w (%s): %w", ErrHardTimeout, g.Options.HardTimeout, err)
+	}
+	return err
 }
 
 func (g *Getter) DownloadFiles(ctx context.Context, manifest Manifest) (int64, time.Duration, error) {
@@ -87,7 +491,15 @@ func (g *Getter) DownloadFiles(ctx context.Context, manifest Manifest) (int64, t
 	totalSize := new(atomic.Int64)
 	multifileDownloadStart := time.Now()
 
-	err := g.downloadFilesFromManifest(ctx, errGroup, manifest, totalSize)
+	missing := newMissingTracker()
+	failures := &failureTracker{}
+	budget := newBudgetTracker(g.Options, totalSize, multifileDownloadStart)
+	groups := newGroupTracker(manifest)
+	manifest, err := g.skipUnchanged(manifest, groups)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = g.downloadFilesFromManifest(ctx, errGroup, manifest, totalSize, groups, missing, failures, budget)
 	if err != nil {
 		return 0, 0, fmt.Errorf("error initiating download of files from manifest: %w", err)
 	}
@@ -95,31 +507,143 @@ func (g *Getter) DownloadFiles(ctx context.Context, manifest Manifest) (int64, t
 	if err != nil {
 		return 0, 0, fmt.Errorf("error downloading files: %w", err)
 	}
+	groups.logIncomplete()
 	elapsedTime := time.Since(multifileDownloadStart)
-	return totalSize.Load(), elapsedTime, nil
+	return totalSize.Load(), elapsedTime, errors.Join(missing.err(), failures.err(), budget.err())
 }
 
-func (g *Getter) downloadFilesFromManifest(ctx context.Context, eg *errgroup.Group, entries []ManifestEntry, totalSize *atomic.Int64) error {
+// skipUnchanged returns the entries of manifest which are not Unchanged, completing the shards of groups among the
+// others with the size of their destination.
+func (g *Getter) skipUnchanged(manifest []ManifestEntry, groups *groupTracker) ([]ManifestEntry, error) {
+	pending := make([]ManifestEntry, 0, len(manifest))
+	for _, entry := range manifest {
+		if !entry.Unchanged {
+			pending = append(pending, entry)
+			continue
+		}
+		if entry.Group == nil {
+			continue
+		}
+		info, err := os.Stat(entry.Dest)
+		if err != nil {
+			return nil, fmt.Errorf("error reading unchanged shard of group %s: %w", entry.Group.Name, err)
+		}
+		if err := groups.shardComplete(entry.Group, info.Size(), g.verifiesGroup(entry.Dest)); err != nil {
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// downloadFilesFromManifest schedules the download of the entries of manifest, each followed by writing its
+// duplicates (see dedupe).
+func (g *Getter) downloadFilesFromManifest(ctx context.Context, eg *errgroup.Group, manifest []ManifestEntry, totalSize *atomic.Int64, groups *groupTracker, missing *missingTracker, failures *failureTracker, budget *budgetTracker) error {
 	logger := logging.GetLogger()
+	entries, duplicates := g.dedupe(manifest)
 
-	for _, entry := range entries {
-		// Avoid the `entry` loop variable being captured by the
-		// goroutine by creating new variables
-		url, dest := entry.URL, entry.Dest
-		logger.Debug().Str("url", url).Str("dest", dest).Msg("Queueing Download")
+	for _, entry := range schedulePriorities(g.schedule(ctx, entries)) {
+		logger.Debug().Str("url", entry.URL).Str("dest", entry.Dest).Msg("Queueing Download")
 
 		eg.Go(func() error {
-			return g.downloadAndMeasure(ctx, url, dest, totalSize)
+			dups := duplicates[entry.Dest]
+			if !budget.allow(entry, time.Now()) {
+				// the budget is used up, so the duplicates are recorded as skipped too
+				for _, dup := range dups {
+					budget.allow(dup, time.Now())
+				}
+				return nil
+			}
+			record, err := g.downloadAndMeasure(ctx, entry, totalSize, groups, missing)
+			if err := g.entryDone(ctx, failures, entry, err); err != nil {
+				return err
+			}
+			for _, dup := range dups {
+				dupErr := err
+				switch {
+				case err == nil && record == nil:
+					missing.missing(dup, fmt.Errorf("%w (duplicate of %s): %s", download.ErrFileNotFound, entry.Dest, dup.URL), time.Now())
+				case err == nil:
+					dupErr = g.writeDuplicate(*record, dup, groups)
+				}
+				if err := g.entryDone(ctx, failures, dup, dupErr); err != nil {
+					return err
+				}
+			}
+			return nil
 		})
 	}
 	return nil
 }
 
-func (g *Getter) downloadAndMeasure(ctx context.Context, url, dest string, totalSize *atomic.Int64) error {
-	fileSize, _, err := g.DownloadFile(ctx, url, dest)
+// entryDone returns the error of an entry, nil if it succeeded, which aborts the run, unless Options.ContinueOnError
+// is set, in which case it is recorded in failures instead.
+func (g *Getter) entryDone(ctx context.Context, failures *failureTracker, entry ManifestEntry, err error) error {
+	// an error caused by the caller cancelling ctx still aborts the run
+	if err != nil && g.Options.ContinueOnError && ctx.Err() == nil {
+		failures.failed(entry, err)
+		return nil
+	}
+	return err
+}
+
+// downloadAndMeasure downloads one entry of a manifest, returning the record of the file downloaded. An entry whose
+// object is not found is recorded in missing rather than failing the whole manifest, so that the other entries
+// proceed, and a nil record is returned.
+func (g *Getter) downloadAndMeasure(ctx context.Context, entry ManifestEntry, totalSize *atomic.Int64, groups *groupTracker, missing *missingTracker) (*DownloadRecord, error) {
+	if missing.cached(entry.URL, time.Now()) {
+		missing.missing(entry, fmt.Errorf("%w (cached): %s", download.ErrFileNotFound, entry.URL), time.Now())
+		return nil, nil
+	}
+	if entry.Priority > 0 {
+		ctx = download.WithPriority(ctx, entry.Priority)
+	}
+	url, err := entry.Pin.URL(entry.URL)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	ctx = download.WithPin(ctx, entry.Pin)
+	fileSize, _, record, err := g.downloadVerifiedFile(ctx, url, entry.Dest, entry.Integrity)
+	if errors.Is(err, download.ErrFileNotFound) {
+		missing.missing(entry, err, time.Now())
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record != nil {
+		g.Options.OnFileComplete(*record)
+	} else {
+		record = &DownloadRecord{URL: url, Dest: entry.Dest, Size: fileSize}
 	}
 	totalSize.Add(fileSize)
-	return nil
+	if entry.Group != nil {
+		return record, groups.shardComplete(entry.Group, fileSize, g.verifiesGroup(entry.Dest))
+	}
+	return record, nil
+}
+
+// verifiesGroup returns true if the consumer leaves the downloaded content of a shard as the local file dest, which
+// a group checksum can be verified against. Other consumers discard the content, keep it in memory, extract it into
+// the destination directory or upload it to an object store, leaving nothing to verify.
+func (g *Getter) verifiesGroup(dest string) bool {
+	if vfs.IsRemote(dest) {
+		return false
+	}
+	_, ok := g.fileWriter()
+	return ok
+}
+
+// fileWriter returns the FileWriter the content is written with, looking through wrappers which consume the content
+// with another consumer, or false if it isn't written to files.
+func (g *Getter) fileWriter() (*consumer.FileWriter, bool) {
+	c := g.Consumer
+	for {
+		wrapper, ok := c.(interface{ Unwrap() consumer.Consumer })
+		if !ok {
+			break
+		}
+		c = wrapper.Unwrap()
+	}
+	writer, ok := c.(*consumer.FileWriter)
+	return writer, ok
 }
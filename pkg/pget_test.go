@@ -1,7 +1,15 @@
 package pget_test
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -10,18 +18,24 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
 	"testing/iotest"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
-	pget "github.com/replicate/pget/pkg"
-	"github.com/replicate/pget/pkg/client"
-	"github.com/replicate/pget/pkg/download"
+	pget "github.com/replicate/pget/v2/pkg"
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/consumer"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/integrity"
 )
 
 var testFS = fstest.MapFS{
@@ -211,3 +225,756 @@ func TestManifest_AddEntry(t *testing.T) {
 	assert.Equal(t, "/tmp/file2.txt", entries[1].Dest)
 
 }
+
+func TestDownloadGroup(t *testing.T) {
+	shards := fstest.MapFS{
+		"shard-1": {Data: []byte("hello, ")},
+		"shard-2": {Data: []byte("world!")},
+	}
+	ts := httptest.NewServer(http.FileServer(http.FS(shards)))
+	defer ts.Close()
+
+	sum := sha256.Sum256([]byte("hello, world!"))
+	sha384Sum := sha512.Sum384([]byte("hello, world!"))
+	sri, err := integrity.Parse("sha384-" + base64.StdEncoding.EncodeToString(sha384Sum[:]))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		checksum    string
+		integrity   *integrity.Integrity
+		expectedErr error
+	}{
+		{"matching checksum", hex.EncodeToString(sum[:]), nil, nil},
+		{"no checksum", "", nil, nil},
+		{"mismatched checksum", strings.Repeat("00", sha256.Size), nil, pget.ErrGroupChecksumMismatch},
+		{"matching integrity", "", sri, nil},
+		{"integrity used instead of checksum", strings.Repeat("00", sha256.Size), sri, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+			group := &pget.ManifestGroup{Name: "greeting", SHA256: tc.checksum, Integrity: tc.integrity}
+			manifest := make(pget.Manifest, 0)
+			manifest = manifest.AddGroupEntry(ts.URL+"/shard-1", filepath.Join(outputDir, "shard-1"), group)
+			manifest = manifest.AddEntry(ts.URL+"/shard-2", filepath.Join(outputDir, "ungrouped"))
+			manifest = manifest.AddGroupEntry(ts.URL+"/shard-2", filepath.Join(outputDir, "shard-2"), group)
+
+			getter := makeGetter(defaultOpts)
+			totalSize, _, err := getter.DownloadFiles(context.Background(), manifest)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.ErrorIs(t, err, download.ErrChecksumMismatch)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(len("hello, world!")+len("world!")), totalSize)
+		})
+	}
+}
+
+func TestDownloadGroupUnchangedShard(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(fstest.MapFS{"shard-2": {Data: []byte("world!")}})))
+	defer ts.Close()
+
+	outputDir := t.TempDir()
+	// the first shard is already up to date, so only the second one is downloaded
+	require.NoError(t, os.WriteFile(filepath.Join(outputDir, "shard-1"), []byte("hello, "), 0644))
+	sum := sha256.Sum256([]byte("hello, world!"))
+	group := &pget.ManifestGroup{Name: "greeting", SHA256: hex.EncodeToString(sum[:])}
+	manifest := pget.Manifest{
+		{URL: ts.URL + "/shard-1", Dest: filepath.Join(outputDir, "shard-1"), Group: group, Unchanged: true},
+		{URL: ts.URL + "/shard-2", Dest: filepath.Join(outputDir, "shard-2"), Group: group},
+	}
+
+	getter := makeGetter(defaultOpts)
+	totalSize, _, err := getter.DownloadFiles(context.Background(), manifest)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("world!")), totalSize)
+}
+
+func TestDownloadGroupNotVerified(t *testing.T) {
+	tarShard := func(name, content string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		return buf.Bytes()
+	}
+	shards := fstest.MapFS{
+		"shard-1":     {Data: []byte("hello, ")},
+		"shard-2":     {Data: []byte("world!")},
+		"shard-1.tar": {Data: tarShard("hello.txt", "hello, ")},
+		"shard-2.tar": {Data: tarShard("world.txt", "world!")},
+	}
+	ts := httptest.NewServer(http.FileServer(http.FS(shards)))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	objectStore := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut || err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		objects[r.URL.Path] = body
+	}))
+	defer objectStore.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL_S3", objectStore.URL)
+
+	// the content isn't left at a local destination to verify the checksum against, so the mismatch goes unnoticed
+	mismatched := strings.Repeat("00", sha256.Size)
+	t.Run("object store destination", func(t *testing.T) {
+		group := &pget.ManifestGroup{Name: "greeting", SHA256: mismatched}
+		manifest := make(pget.Manifest, 0)
+		manifest = manifest.AddGroupEntry(ts.URL+"/shard-1", "s3://bucket/greeting/shard-1", group)
+		manifest = manifest.AddGroupEntry(ts.URL+"/shard-2", "s3://bucket/greeting/shard-2", group)
+
+		getter := makeGetter(defaultOpts)
+		getter.Consumer = &consumer.FileWriter{}
+		_, _, err := getter.DownloadFiles(context.Background(), manifest)
+		require.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []byte("hello, "), objects["/bucket/greeting/shard-1"])
+		assert.Equal(t, []byte("world!"), objects["/bucket/greeting/shard-2"])
+	})
+	t.Run("extracted", func(t *testing.T) {
+		outputDir := t.TempDir()
+		group := &pget.ManifestGroup{Name: "greeting", SHA256: mismatched}
+		manifest := make(pget.Manifest, 0)
+		manifest = manifest.AddGroupEntry(ts.URL+"/shard-1.tar", outputDir, group)
+		manifest = manifest.AddGroupEntry(ts.URL+"/shard-2.tar", outputDir, group)
+
+		getter := makeGetter(defaultOpts)
+		getter.Consumer = &consumer.TarExtractor{}
+		_, _, err := getter.DownloadFiles(context.Background(), manifest)
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(outputDir, "hello.txt"))
+		assert.FileExists(t, filepath.Join(outputDir, "world.txt"))
+	})
+}
+
+func TestDownloadVerifiedFile(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(testFS)))
+	defer ts.Close()
+
+	sum := sha512.Sum384(testFS["hello.txt"].Data)
+	matching, err := integrity.Parse("sha384-" + base64.StdEncoding.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	mismatched, err := integrity.Parse(strings.Repeat("00", sha256.Size))
+	require.NoError(t, err)
+
+	getter := makeGetter(defaultOpts)
+	_, _, err = getter.DownloadVerifiedFile(context.Background(), ts.URL+"/hello.txt", tempFilename(), matching)
+	assert.NoError(t, err)
+	_, _, err = getter.DownloadVerifiedFile(context.Background(), ts.URL+"/hello.txt", tempFilename(), mismatched)
+	assert.ErrorIs(t, err, download.ErrChecksumMismatch)
+
+	manifest := pget.Manifest{{URL: ts.URL + "/hello.txt", Dest: tempFilename(), Integrity: mismatched}}
+	_, _, err = getter.DownloadFiles(context.Background(), manifest)
+	assert.ErrorIs(t, err, download.ErrChecksumMismatch)
+}
+
+func TestDownloadSliceSums(t *testing.T) {
+	data := testFS["hello.txt"].Data
+	hasher := integrity.NewSliceHasher(5)
+	_, _ = hasher.Write(data)
+	var published, corrupted strings.Builder
+	_, err := hasher.Sums(int64(len(data))).WriteTo(&published)
+	require.NoError(t, err)
+	hasher = integrity.NewSliceHasher(5)
+	_, _ = hasher.Write([]byte("hello, World!"))
+	_, err = hasher.Sums(int64(len(data))).WriteTo(&corrupted)
+	require.NoError(t, err)
+	ts := httptest.NewServer(http.FileServer(http.FS(fstest.MapFS{
+		"hello.txt":              testFS["hello.txt"],
+		"hello.txt.pget.sum":     {Data: []byte(published.String())},
+		"corrupted.txt":          testFS["hello.txt"],
+		"corrupted.txt.pget.sum": {Data: []byte(corrupted.String())},
+		"unpublished.txt":        testFS["hello.txt"],
+		"invalid.txt":            testFS["hello.txt"],
+		"invalid.txt.pget.sum":   {Data: []byte("not slice sums")},
+	})))
+	defer ts.Close()
+
+	getter := makeGetter(defaultOpts)
+	getter.Options.EmitSliceSums = true
+	getter.Options.VerifySliceSums = true
+
+	// the published sums are checked and written next to the file
+	dest := tempFilename()
+	defer os.Remove(dest)
+	defer os.Remove(dest + integrity.SliceSumsSuffix)
+	_, _, err = getter.DownloadFile(context.Background(), ts.URL+"/hello.txt", dest)
+	require.NoError(t, err)
+	emitted, err := os.ReadFile(dest + integrity.SliceSumsSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, published.String(), string(emitted))
+
+	// without published sums, they are computed
+	for _, name := range []string{"unpublished.txt", "invalid.txt"} {
+		dest := tempFilename()
+		defer os.Remove(dest)
+		defer os.Remove(dest + integrity.SliceSumsSuffix)
+		_, _, err = getter.DownloadFile(context.Background(), ts.URL+"/"+name, dest)
+		require.NoError(t, err)
+		f, err := os.Open(dest + integrity.SliceSumsSuffix)
+		require.NoError(t, err)
+		sums, err := integrity.ParseSliceSums(f)
+		f.Close()
+		require.NoError(t, err)
+		assert.Equal(t, int64(integrity.DefaultSliceSize), sums.SliceSize)
+		assert.Len(t, sums.Sums, 1)
+	}
+
+	dest = tempFilename()
+	defer os.Remove(dest)
+	_, _, err = getter.DownloadFile(context.Background(), ts.URL+"/corrupted.txt", dest)
+	assert.ErrorIs(t, err, download.ErrChecksumMismatch)
+	var mismatch *integrity.SliceMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, 1, mismatch.Slice)
+	assert.NoFileExists(t, dest+integrity.SliceSumsSuffix)
+}
+
+// wrappingConsumer consumes the content with another consumer, like the consumer multifile records validators with.
+type wrappingConsumer struct{ consumer.Consumer }
+
+func (w wrappingConsumer) Unwrap() consumer.Consumer { return w.Consumer }
+
+func TestDownloadSliceSumsWrappedConsumer(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(testFS)))
+	defer ts.Close()
+
+	getter := makeGetter(defaultOpts)
+	getter.Consumer = wrappingConsumer{&consumer.FileWriter{}}
+	getter.Options.EmitSliceSums = true
+
+	// the content is still written to a file, so its slice sums are written next to it
+	dest := tempFilename()
+	defer os.Remove(dest)
+	defer os.Remove(dest + integrity.SliceSumsSuffix)
+	_, _, err := getter.DownloadFile(context.Background(), ts.URL+"/hello.txt", dest)
+	require.NoError(t, err)
+	assert.FileExists(t, dest+integrity.SliceSumsSuffix)
+}
+
+func TestDownloadFilesOrder(t *testing.T) {
+	files := fstest.MapFS{
+		"small":   {Data: []byte("a")},
+		"medium":  {Data: []byte("abc")},
+		"large":   {Data: []byte("abcdefgh")},
+		"shard-1": {Data: []byte("ab")},
+		"shard-2": {Data: []byte("ab")},
+	}
+	ts := httptest.NewServer(http.FileServer(http.FS(files)))
+	defer ts.Close()
+
+	testCases := []struct {
+		order    pget.FileOrder
+		expected []string
+	}{
+		{pget.FileOrderManifest, []string{"medium", "shard-1", "shard-2", "large", "missing", "small"}},
+		// the group is 4 bytes; the missing entry's size is unknown, so it goes last
+		{pget.FileOrderSmallestFirst, []string{"small", "medium", "shard-1", "shard-2", "large", "missing"}},
+		{pget.FileOrderLargestFirst, []string{"large", "shard-1", "shard-2", "medium", "small", "missing"}},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.order), func(t *testing.T) {
+			var mu sync.Mutex
+			var requested []string
+			getter := makeGetter(defaultOpts)
+			getter.Downloader = &recordingStrategy{Strategy: getter.Downloader, mu: &mu, fetched: &requested}
+			getter.Options.MaxConcurrentFiles = 1
+			getter.Options.FileOrder = tc.order
+			getter.Options.Warmup = true
+
+			outputDir := t.TempDir()
+			group := &pget.ManifestGroup{Name: "model"}
+			manifest := make(pget.Manifest, 0)
+			manifest = manifest.AddEntry(ts.URL+"/medium", filepath.Join(outputDir, "medium"))
+			manifest = manifest.AddGroupEntry(ts.URL+"/shard-1", filepath.Join(outputDir, "shard-1"), group)
+			manifest = manifest.AddEntry(ts.URL+"/large", filepath.Join(outputDir, "large"))
+			manifest = manifest.AddEntry(ts.URL+"/missing", filepath.Join(outputDir, "missing"))
+			manifest = manifest.AddGroupEntry(ts.URL+"/shard-2", filepath.Join(outputDir, "shard-2"), group)
+			manifest = manifest.AddEntry(ts.URL+"/small", filepath.Join(outputDir, "small"))
+			_, _, err := getter.DownloadFiles(context.Background(), manifest)
+			assert.ErrorIs(t, err, download.ErrFileNotFound)
+			assert.Equal(t, tc.expected, requested)
+		})
+	}
+
+	order, err := pget.ParseFileOrder("shortest-first")
+	require.NoError(t, err)
+	assert.Equal(t, pget.FileOrderSmallestFirst, order)
+	_, err = pget.ParseFileOrder("random")
+	assert.Error(t, err)
+}
+
+func TestDownloadFilesPriority(t *testing.T) {
+	files := fstest.MapFS{
+		"small":  {Data: []byte("a")},
+		"medium": {Data: []byte("abc")},
+		"large":  {Data: []byte("abcdefgh")},
+		"huge":   {Data: []byte("abcdefghijklmnop")},
+	}
+	ts := httptest.NewServer(http.FileServer(http.FS(files)))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var requested []string
+	getter := makeGetter(defaultOpts)
+	getter.Downloader = &recordingStrategy{Strategy: getter.Downloader, mu: &mu, fetched: &requested}
+	getter.Options.MaxConcurrentFiles = 1
+	getter.Options.FileOrder = pget.FileOrderSmallestFirst
+
+	outputDir := t.TempDir()
+	manifest := pget.Manifest{
+		{URL: ts.URL + "/small", Dest: filepath.Join(outputDir, "small")},
+		{URL: ts.URL + "/large", Dest: filepath.Join(outputDir, "large"), Priority: 2},
+		{URL: ts.URL + "/huge", Dest: filepath.Join(outputDir, "huge"), Priority: 1},
+		{URL: ts.URL + "/medium", Dest: filepath.Join(outputDir, "medium")},
+	}
+	_, _, err := getter.DownloadFiles(context.Background(), manifest)
+	require.NoError(t, err)
+	// entries with a priority go first, then the others in --file-order
+	assert.Equal(t, []string{"huge", "large", "small", "medium"}, requested)
+}
+
+// recordingStrategy records the paths of the URLs fetched through it, in order.
+type recordingStrategy struct {
+	download.Strategy
+	mu      *sync.Mutex
+	fetched *[]string
+}
+
+func (s *recordingStrategy) Fetch(ctx context.Context, url string) (io.Reader, int64, error) {
+	s.mu.Lock()
+	*s.fetched = append(*s.fetched, url[strings.LastIndex(url, "/")+1:])
+	s.mu.Unlock()
+	return s.Strategy.Fetch(ctx, url)
+}
+
+func TestDownloadHardTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	getter := makeGetter(download.Options{Client: client.Options{MaxRetries: 0}})
+	getter.Options.HardTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, _, err := getter.DownloadFile(context.Background(), ts.URL+"/slow", tempFilename())
+	assert.ErrorIs(t, err, pget.ErrHardTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestDownloadRecords(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+r.URL.Path+`"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("hello, world!"))
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var records []pget.DownloadRecord
+	getter := makeGetter(defaultOpts)
+	getter.Options.OnFileComplete = func(record pget.DownloadRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, record)
+	}
+
+	outputDir := t.TempDir()
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(ts.URL+"/b", filepath.Join(outputDir, "b"))
+	manifest = manifest.AddEntry(ts.URL+"/a", filepath.Join(outputDir, "a"))
+	_, _, err := getter.DownloadFiles(context.Background(), manifest)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("hello, world!"))
+	require.Len(t, records, 2)
+	for _, record := range records {
+		assert.Equal(t, int64(len("hello, world!")), record.Size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), record.SHA256)
+		assert.Equal(t, `"/`+filepath.Base(record.Dest)+`"`, record.ETag)
+	}
+
+	manifestPath := filepath.Join(t.TempDir(), "emitted")
+	require.NoError(t, pget.WriteDownloadRecords(manifestPath, records))
+	emitted, err := os.ReadFile(manifestPath)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s/a %s/a\n%s/b %s/b\n", ts.URL, outputDir, ts.URL, outputDir), string(emitted))
+
+	var decoded []map[string]any
+	data, err := os.ReadFile(manifestPath + ".json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Len(t, decoded, 2)
+	assert.Equal(t, ts.URL+"/a", decoded[0]["url"])
+	assert.Equal(t, hex.EncodeToString(sum[:]), decoded[0]["sha256"])
+	assert.Contains(t, decoded[0], "duration_seconds")
+}
+
+func TestDownloadProgress(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(testFS)))
+	defer ts.Close()
+
+	progress := &pget.Progress{}
+	getter := makeGetter(defaultOpts)
+	getter.Options.Progress = progress
+	getter.Options.ContinueOnError = true
+
+	outputDir := t.TempDir()
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(ts.URL+"/hello.txt", filepath.Join(outputDir, "hello.txt"))
+	manifest = manifest.AddEntry(ts.URL+"/missing.txt", filepath.Join(outputDir, "missing.txt"))
+	_, _, err := getter.DownloadFiles(context.Background(), manifest)
+	require.Error(t, err)
+
+	files := make(map[string]pget.FileProgress)
+	for _, file := range progress.Files() {
+		files[file.URL] = file
+	}
+	require.Len(t, files, 2)
+	hello := files[ts.URL+"/hello.txt"]
+	assert.Equal(t, pget.FileDone, hello.Status)
+	assert.Equal(t, int64(len("hello, world!")), hello.Size)
+	assert.Equal(t, hello.Size, hello.Downloaded)
+	assert.Equal(t, filepath.Join(outputDir, "hello.txt"), hello.Dest)
+	missing := files[ts.URL+"/missing.txt"]
+	assert.Equal(t, pget.FileFailed, missing.Status)
+	assert.Equal(t, int64(-1), missing.Size)
+}
+
+func TestDownloadToMemory(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(testFS)))
+	defer ts.Close()
+
+	// small chunks so that the object is assembled from several range requests
+	getter := makeGetter(download.Options{ChunkSize: 4})
+	data, err := getter.DownloadToMemory(context.Background(), ts.URL+"/hello.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(data))
+
+	getter.Options.MaxMemorySize = 5
+	_, err = getter.DownloadToMemory(context.Background(), ts.URL+"/hello.txt")
+	assert.ErrorIs(t, err, pget.ErrTooLarge)
+
+	_, err = getter.DownloadToMemory(context.Background(), ts.URL+"/missing.txt")
+	assert.Error(t, err)
+
+	// a server without range support is downloaded from over a single connection
+	noRanges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello, world!"))
+	}))
+	defer noRanges.Close()
+	getter.Options.MaxMemorySize = 0
+	data, err = getter.DownloadToMemory(context.Background(), noRanges.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(data))
+	getter.Options.MaxMemorySize = 5
+	_, err = getter.DownloadToMemory(context.Background(), noRanges.URL)
+	assert.ErrorIs(t, err, pget.ErrTooLarge)
+
+	empty := httptest.NewServer(http.FileServer(http.FS(fstest.MapFS{"empty.txt": {}})))
+	defer empty.Close()
+	data, err = getter.DownloadToMemory(context.Background(), empty.URL+"/empty.txt")
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestDownloadToWriter(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(testFS)))
+	defer ts.Close()
+
+	// small chunks so that the object is assembled from several range requests
+	getter := makeGetter(download.Options{ChunkSize: 4})
+	var buf strings.Builder
+	written, err := getter.DownloadToWriter(context.Background(), ts.URL+"/hello.txt", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("hello, world!")), written)
+	assert.Equal(t, "hello, world!", buf.String())
+
+	_, err = getter.DownloadToWriter(context.Background(), ts.URL+"/missing.txt", io.Discard)
+	assert.ErrorIs(t, err, download.ErrFileNotFound)
+
+	errSink := errors.New("sink failed")
+	_, err = getter.DownloadToWriter(context.Background(), ts.URL+"/hello.txt", failingWriter{errSink})
+	assert.ErrorIs(t, err, errSink)
+}
+
+func TestDownloadToWriterAt(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(testFS)))
+	defer ts.Close()
+
+	getter := makeGetter(download.Options{ChunkSize: 4})
+	f, err := os.Create(filepath.Join(t.TempDir(), "file"))
+	require.NoError(t, err)
+	defer f.Close()
+	size, err := getter.DownloadToWriterAt(context.Background(), ts.URL+"/hello.txt", f)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("hello, world!")), size)
+	content, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(content))
+	assert.Nil(t, getter.Consumer)
+
+	region := make([]byte, 64)
+	getter.Consumer = &consumer.MemoryWriter{Region: region}
+	size, _, err = getter.DownloadFile(context.Background(), ts.URL+"/hello.txt", "")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(region[:size]))
+}
+
+// randomAccessSpy records whether a download was consumed with random access or as a stream
+type randomAccessSpy struct {
+	consumer.MemoryWriter
+	randomAccess bool
+}
+
+func (s *randomAccessSpy) Consume(reader io.Reader, destPath string, expectedBytes int64) error {
+	s.randomAccess = false
+	return s.MemoryWriter.Consume(reader, destPath, expectedBytes)
+}
+
+func (s *randomAccessSpy) ConsumeAt(r io.ReaderAt, destPath string, size int64) error {
+	s.randomAccess = true
+	return s.MemoryWriter.ConsumeAt(r, destPath, size)
+}
+
+func TestDownloadRandomAccess(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(testFS)))
+	defer ts.Close()
+	noRanges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(testFS["hello.txt"].Data)
+	}))
+	defer noRanges.Close()
+
+	spy := &randomAccessSpy{MemoryWriter: consumer.MemoryWriter{Region: make([]byte, 64)}}
+	getter := makeGetter(download.Options{ChunkSize: 4})
+	getter.Consumer = spy
+	size, _, err := getter.DownloadFile(context.Background(), ts.URL+"/hello.txt", "")
+	require.NoError(t, err)
+	assert.True(t, spy.randomAccess)
+	assert.Equal(t, "hello, world!", string(spy.Region[:size]))
+
+	// the content is streamed if it has to be read in order
+	sum := sha256.Sum256(testFS["hello.txt"].Data)
+	expected, err := integrity.Parse(hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	_, _, err = getter.DownloadVerifiedFile(context.Background(), ts.URL+"/hello.txt", "", expected)
+	require.NoError(t, err)
+	assert.False(t, spy.randomAccess)
+
+	// or if the server doesn't serve ranges
+	size, _, err = getter.DownloadFile(context.Background(), noRanges.URL, "")
+	require.NoError(t, err)
+	assert.False(t, spy.randomAccess)
+	assert.Equal(t, "hello, world!", string(spy.Region[:size]))
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestDownloadFilesNotFound(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("hello, world!"))
+	}))
+	defer ts.Close()
+
+	outputDir := t.TempDir()
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(ts.URL+"/missing", filepath.Join(outputDir, "missing-1"))
+	manifest = manifest.AddEntry(ts.URL+"/present", filepath.Join(outputDir, "present"))
+	manifest = manifest.AddEntry(ts.URL+"/missing", filepath.Join(outputDir, "missing-2"))
+
+	getter := makeGetter(download.Options{Client: client.Options{MaxRetries: 3}})
+	getter.Options.MaxConcurrentFiles = 1
+	totalSize, _, err := getter.DownloadFiles(context.Background(), manifest)
+	assert.ErrorIs(t, err, download.ErrFileNotFound)
+	var missingErr *pget.MissingEntriesError
+	require.ErrorAs(t, err, &missingErr)
+	require.Len(t, missingErr.Entries, 2)
+	assert.Equal(t, filepath.Join(outputDir, "missing-1"), missingErr.Entries[0].Dest)
+	assert.Equal(t, filepath.Join(outputDir, "missing-2"), missingErr.Entries[1].Dest)
+
+	// the other entry is still downloaded
+	assert.Equal(t, int64(len("hello, world!")), totalSize)
+	data, err := os.ReadFile(filepath.Join(outputDir, "present"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(data))
+
+	// the missing object is neither retried nor asked for again
+	assert.Equal(t, 1, requests["/missing"])
+}
+
+func TestDownloadFilesContinueOnError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/broken":
+			http.Error(w, "broken", http.StatusForbidden)
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("hello, world!"))
+		}
+	}))
+	defer ts.Close()
+
+	outputDir := t.TempDir()
+	manifest := make(pget.Manifest, 0)
+	manifest = manifest.AddEntry(ts.URL+"/broken", filepath.Join(outputDir, "broken"))
+	manifest = manifest.AddEntry(ts.URL+"/missing", filepath.Join(outputDir, "missing"))
+	manifest = manifest.AddEntry(ts.URL+"/present", filepath.Join(outputDir, "present"))
+
+	getter := makeGetter(download.Options{})
+	getter.Options.MaxConcurrentFiles = 1
+	getter.Options.ContinueOnError = true
+	totalSize, _, err := getter.DownloadFiles(context.Background(), manifest)
+	var failedErr *pget.FailedEntriesError
+	require.ErrorAs(t, err, &failedErr)
+	require.Len(t, failedErr.Failures, 1)
+	assert.Equal(t, filepath.Join(outputDir, "broken"), failedErr.Failures[0].Entry.Dest)
+	assert.ErrorContains(t, failedErr.Failures[0].Err, "403")
+	var missingErr *pget.MissingEntriesError
+	require.ErrorAs(t, err, &missingErr)
+	require.Len(t, missingErr.Entries, 1)
+
+	// the entry after the failures is still downloaded
+	assert.Equal(t, int64(len("hello, world!")), totalSize)
+	data, err := os.ReadFile(filepath.Join(outputDir, "present"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(data))
+
+	// without ContinueOnError the first failure aborts the run
+	getter.Options.ContinueOnError = false
+	_, _, err = getter.DownloadFiles(context.Background(), manifest)
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &failedErr))
+}
+
+func TestDownloadFilesBudget(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.FS(testFS)))
+	defer ts.Close()
+
+	outputDir := t.TempDir()
+	manifest := make(pget.Manifest, 0)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		manifest = manifest.AddEntry(ts.URL+"/hello.txt", filepath.Join(outputDir, name))
+	}
+
+	// one file at a time, so that two complete before the budget is used up
+	getter := makeGetter(download.Options{})
+	getter.Options.MaxConcurrentFiles = 1
+	getter.Options.MaxTotalBytes = 20
+	totalSize, _, err := getter.DownloadFiles(context.Background(), manifest)
+	assert.ErrorIs(t, err, pget.ErrBudgetExceeded)
+	var budgetErr *pget.BudgetExceededError
+	require.ErrorAs(t, err, &budgetErr)
+	require.Len(t, budgetErr.Skipped, 2)
+	assert.Equal(t, filepath.Join(outputDir, "c"), budgetErr.Skipped[0].Dest)
+	assert.Equal(t, filepath.Join(outputDir, "d"), budgetErr.Skipped[1].Dest)
+	assert.Equal(t, int64(26), totalSize)
+	assert.FileExists(t, filepath.Join(outputDir, "b"))
+	assert.NoFileExists(t, filepath.Join(outputDir, "c"))
+
+	getter.Options.MaxTotalBytes = 0
+	getter.Options.MaxTotalTime = time.Nanosecond
+	_, _, err = getter.DownloadFiles(context.Background(), manifest)
+	require.ErrorAs(t, err, &budgetErr)
+	assert.Len(t, budgetErr.Skipped, 4)
+
+	getter.Options.MaxTotalTime = time.Hour
+	_, _, err = getter.DownloadFiles(context.Background(), pget.Manifest{{URL: ts.URL + "/hello.txt", Dest: filepath.Join(outputDir, "e")}})
+	assert.NoError(t, err)
+}
+
+func TestDownloadFilesDedupe(t *testing.T) {
+	var requests atomic.Int32
+	fileServer := http.FileServer(http.FS(testFS))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		name     string
+		strategy pget.DedupeStrategy
+		wrapped  bool
+		requests int32
+		linked   bool
+	}{
+		{"hardlink", pget.DedupeHardlink, false, 1, true},
+		{"copy", pget.DedupeCopy, false, 1, false},
+		{"off", pget.DedupeOff, false, 3, false},
+		{"wrapped", pget.DedupeHardlink, true, 1, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests.Store(0)
+			outputDir := t.TempDir()
+			manifest := make(pget.Manifest, 0)
+			for _, name := range []string{"a", "sub/b", "c"} {
+				manifest = manifest.AddEntry(ts.URL+"/hello.txt", filepath.Join(outputDir, name))
+			}
+
+			var mu sync.Mutex
+			var completed []string
+			getter := makeGetter(download.Options{})
+			getter.Consumer = &consumer.FileWriter{}
+			if tc.wrapped {
+				getter.Consumer = wrappingConsumer{getter.Consumer}
+			}
+			getter.Options.DedupeStrategy = tc.strategy
+			getter.Options.OnFileComplete = func(record pget.DownloadRecord) {
+				mu.Lock()
+				defer mu.Unlock()
+				completed = append(completed, record.Dest)
+			}
+			_, _, err := getter.DownloadFiles(context.Background(), manifest)
+			require.NoError(t, err)
+			assert.Equal(t, tc.requests, requests.Load())
+			assert.Len(t, completed, 3)
+
+			first, err := os.Stat(filepath.Join(outputDir, "a"))
+			require.NoError(t, err)
+			for _, name := range []string{"sub/b", "c"} {
+				content, err := os.ReadFile(filepath.Join(outputDir, name))
+				require.NoError(t, err)
+				assert.Equal(t, "hello, world!", string(content))
+				info, err := os.Stat(filepath.Join(outputDir, name))
+				require.NoError(t, err)
+				assert.Equal(t, tc.linked, os.SameFile(first, info), name)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package pget
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/replicate/pget/v2/pkg/download"
+)
+
+// FileStatus is the state of a file download tracked by a Progress.
+type FileStatus string
+
+const (
+	FileActive FileStatus = "active"
+	FileDone   FileStatus = "done"
+	FileFailed FileStatus = "failed"
+)
+
+// Progress tracks the file downloads of the Getters it is set on (see Options.Progress), for a live display of each:
+// its size, how much of it has been consumed, and its retries and fallbacks so far. As with Options.HeartbeatInterval,
+// progress is counted as the content is handed to the consumer. The zero value is ready to use, and it is safe for
+// concurrent use.
+type Progress struct {
+	mu    sync.Mutex
+	files []*trackedFile
+}
+
+// FileProgress is a snapshot of the progress of one file download.
+type FileProgress struct {
+	URL  string
+	Dest string
+	// Size is -1 until the first response of the download gives it.
+	Size       int64
+	Downloaded int64
+	Retries    int64
+	Fallbacks  int64
+	Status     FileStatus
+	// Elapsed is how long the download has been running, or ran for once it is over.
+	Elapsed time.Duration
+}
+
+// Files returns the progress of every file download started so far, in the order they were started.
+func (p *Progress) Files() []FileProgress {
+	p.mu.Lock()
+	files := p.files[:len(p.files):len(p.files)]
+	p.mu.Unlock()
+	now := time.Now()
+	snapshots := make([]FileProgress, len(files))
+	for i, f := range files {
+		snapshots[i] = f.snapshot(now)
+	}
+	return snapshots
+}
+
+// track starts tracking the download of url to dest, whose retries and fallbacks are counted in metadata. It
+// returns nil if p is nil, on which the methods of trackedFile do nothing.
+func (p *Progress) track(url, dest string, metadata *download.Metadata) *trackedFile {
+	if p == nil {
+		return nil
+	}
+	f := &trackedFile{url: url, dest: dest, metadata: metadata, started: time.Now(), status: FileActive}
+	f.size.Store(-1)
+	p.mu.Lock()
+	p.files = append(p.files, f)
+	p.mu.Unlock()
+	return f
+}
+
+type trackedFile struct {
+	url      string
+	dest     string
+	metadata *download.Metadata
+	started  time.Time
+	size     atomic.Int64
+	read     atomic.Int64
+
+	mu      sync.Mutex
+	status  FileStatus
+	elapsed time.Duration
+}
+
+// reader counts the content read from r, of size bytes, as downloaded.
+func (f *trackedFile) reader(r io.Reader, size int64) io.Reader {
+	if f == nil {
+		return r
+	}
+	f.size.Store(size)
+	return &progressReader{r: r, f: f}
+}
+
+// finish marks the download as over, failed if err is not nil.
+func (f *trackedFile) finish(err error) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status, f.elapsed = FileDone, time.Since(f.started)
+	if err != nil {
+		f.status = FileFailed
+	}
+}
+
+func (f *trackedFile) snapshot(now time.Time) FileProgress {
+	f.mu.Lock()
+	status, elapsed := f.status, f.elapsed
+	f.mu.Unlock()
+	if status == FileActive {
+		elapsed = now.Sub(f.started)
+	}
+	return FileProgress{
+		URL:        f.url,
+		Dest:       f.dest,
+		Size:       f.size.Load(),
+		Downloaded: f.read.Load(),
+		Retries:    f.metadata.Retries(),
+		Fallbacks:  f.metadata.Fallbacks(),
+		Status:     status,
+		Elapsed:    elapsed,
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	f *trackedFile
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.f.read.Add(int64(n))
+	return n, err
+}
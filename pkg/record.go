@@ -0,0 +1,78 @@
+package pget
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A DownloadRecord describes a file that was successfully downloaded, see Options.OnFileComplete.
+type DownloadRecord struct {
+	URL  string `json:"url"`
+	Dest string `json:"dest"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag,omitempty"`
+	// SHA256 is the hex-encoded SHA-256 of the downloaded content, before any extraction
+	SHA256   string        `json:"sha256"`
+	Duration time.Duration `json:"-"`
+	// Retries is the number of times requests for the file were retried
+	Retries int64 `json:"retries"`
+	// Fallbacks is the number of requests for the file which fell back from a cache host to the origin
+	Fallbacks int64 `json:"fallbacks"`
+	// CacheHits and CacheMisses are the numbers of responses for the file which cache hosts reported serving from
+	// their cache and from the origin (see download.CacheStatusHeader)
+	CacheHits   int64 `json:"cache_hits"`
+	CacheMisses int64 `json:"cache_misses"`
+}
+
+// cacheHitRatio returns the fraction of hits among the responses of cache hosts, and false if there were none.
+func cacheHitRatio(hits, misses int64) (float64, bool) {
+	if hits+misses == 0 {
+		return 0, false
+	}
+	return float64(hits) / float64(hits+misses), true
+}
+
+func (r DownloadRecord) MarshalJSON() ([]byte, error) {
+	type record DownloadRecord
+	return json.Marshal(struct {
+		record
+		DurationSeconds float64 `json:"duration_seconds"`
+	}{record(r), r.Duration.Seconds()})
+}
+
+// WriteDownloadRecords writes records to path as a manifest which `pget multifile` can read to repeat the
+// downloads, and to path + ".json" as a JSON array including sizes, ETags, checksums and durations. Records are
+// sorted by destination and destinations are made absolute so that the manifest can be used from any directory.
+func WriteDownloadRecords(path string, records []DownloadRecord) error {
+	records = append(make([]DownloadRecord, 0, len(records)), records...)
+	for i := range records {
+		dest, err := filepath.Abs(records[i].Dest)
+		if err != nil {
+			return fmt.Errorf("error resolving %s: %w", records[i].Dest, err)
+		}
+		records[i].Dest = dest
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Dest < records[j].Dest })
+
+	var manifest strings.Builder
+	for _, r := range records {
+		fmt.Fprintf(&manifest, "%s %s\n", r.URL, r.Dest)
+	}
+	if err := os.WriteFile(path, []byte(manifest.String()), 0644); err != nil {
+		return fmt.Errorf("error writing manifest %s: %w", path, err)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".json", append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing manifest %s: %w", path+".json", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Package validators stores HTTP cache validators (ETag and Last-Modified) for downloaded files in a sidecar file
+// next to the destination, so that later runs can issue conditional requests and skip unchanged content.
+package validators
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const sidecarSuffix = ".pget-validators"
+
+type Validators struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// SidecarPath returns the path of the (hidden) sidecar file for dest.
+func SidecarPath(dest string) string {
+	return filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+sidecarSuffix)
+}
+
+// FromResponse extracts the validators from resp. The returned Validators may be empty if the server did not send
+// any.
+func FromResponse(url string, resp *http.Response) Validators {
+	return Validators{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// Empty returns true if there are no validators to make a conditional request with.
+func (v Validators) Empty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// Apply sets the conditional request headers on req.
+func (v Validators) Apply(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// Load reads the validators stored for dest. It returns nil and no error if there is no sidecar or if dest
+// itself is missing, as the validators are meaningless without the content they describe.
+func Load(dest string) (*Validators, error) {
+	if _, err := os.Stat(dest); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(SidecarPath(dest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading validators for %s: %w", dest, err)
+	}
+	var v Validators
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("error parsing validators for %s: %w", dest, err)
+	}
+	return &v, nil
+}
+
+// Save writes the validators for dest to its sidecar file.
+func Save(dest string, v Validators) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(SidecarPath(dest), data, 0644); err != nil {
+		return fmt.Errorf("error writing validators for %s: %w", dest, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package validators_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/validators"
+)
+
+func TestSidecarPath(t *testing.T) {
+	assert.Equal(t, "/tmp/dir/.file.bin.pget-validators", validators.SidecarPath("/tmp/dir/file.bin"))
+	assert.Equal(t, ".file.bin.pget-validators", validators.SidecarPath("file.bin"))
+}
+
+func TestSaveLoad(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "file.bin")
+
+	// no destination, no validators
+	v, err := validators.Load(dest)
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	require.NoError(t, os.WriteFile(dest, []byte("content"), 0644))
+	v, err = validators.Load(dest)
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	stored := validators.Validators{URL: "https://example.com/file.bin", ETag: `"abc"`, LastModified: "Wed, 21 Oct 2015 07:28:00 GMT"}
+	require.NoError(t, validators.Save(dest, stored))
+	v, err = validators.Load(dest)
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	assert.Equal(t, stored, *v)
+
+	// validators without the content they describe are ignored
+	require.NoError(t, os.Remove(dest))
+	v, err = validators.Load(dest)
+	require.NoError(t, err)
+	assert.Nil(t, v)
+}
+
+func TestApply(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/file.bin", nil)
+	require.NoError(t, err)
+
+	validators.Validators{}.Apply(req)
+	assert.Empty(t, req.Header.Get("If-None-Match"))
+	assert.Empty(t, req.Header.Get("If-Modified-Since"))
+
+	validators.Validators{ETag: `"abc"`, LastModified: "Wed, 21 Oct 2015 07:28:00 GMT"}.Apply(req)
+	assert.Equal(t, `"abc"`, req.Header.Get("If-None-Match"))
+	assert.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", req.Header.Get("If-Modified-Since"))
+}
@@ -0,0 +1,341 @@
+// Package verify compares a local file against a remote object without downloading the object in full.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/integrity"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+const (
+	defaultSamples    = 16
+	defaultSampleSize = 1024 * 1024
+
+	MethodSample    = "sample"
+	MethodSliceSums = "slice-sums"
+)
+
+var (
+	ErrMismatch = errors.New("local file does not match remote")
+	// ErrRangeNotSupported is download.ErrRangeNotSupported, so that callers can check for either.
+	ErrRangeNotSupported = download.ErrRangeNotSupported
+
+	contentRangeRegexp = regexp.MustCompile(`^bytes [0-9]+-[0-9]+/([0-9]+)$`)
+)
+
+type Options struct {
+	// Samples is the number of byte ranges to compare when the server does not expose a
+	// whole-file hash. If set to zero, 16 will be used.
+	Samples int
+
+	// SampleSize is the number of bytes per sample. If set to zero, 1 MiB will be used.
+	SampleSize int64
+}
+
+type Verifier struct {
+	Client client.HTTPClient
+	Options
+}
+
+// Result describes how a successful verification was performed.
+type Result struct {
+	Size int64
+	// Method is MethodSample, MethodSliceSums, or the name of the digest algorithm that was compared
+	Method string
+}
+
+type digest struct {
+	algorithm string
+	newHash   func() hash.Hash
+	expected  []byte
+}
+
+func NewVerifier(opts Options, clientOpts client.Options) *Verifier {
+	return &Verifier{
+		Client:  client.NewHTTPClient(clientOpts),
+		Options: opts,
+	}
+}
+
+func (v *Verifier) samples() int {
+	if v.Samples <= 0 {
+		return defaultSamples
+	}
+	return v.Samples
+}
+
+func (v *Verifier) sampleSize() int64 {
+	if v.SampleSize <= 0 {
+		return defaultSampleSize
+	}
+	return v.SampleSize
+}
+
+// Verify compares the file at path with the object at url. The sizes are always compared; if the server publishes
+// slice sums for the object (see integrity.SliceSums) each slice of the local file is hashed and compared against
+// them, and the error names the slices which differ. Failing that, if the server exposes a whole-object digest the
+// local file is hashed and compared against it, otherwise a number of byte ranges spread
+// across the object are requested and compared against the same ranges of the local file. ErrMismatch is returned
+// if the file differs.
+func (v *Verifier) Verify(ctx context.Context, url, path string) (Result, error) {
+	logger := logging.GetLogger()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return Result{}, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return Result{}, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	remoteSize, header, err := v.stat(ctx, url)
+	if err != nil {
+		return Result{}, err
+	}
+	if remoteSize != stat.Size() {
+		return Result{}, fmt.Errorf("%w: remote size %d, local size %d", ErrMismatch, remoteSize, stat.Size())
+	}
+
+	if sums := v.fetchSliceSums(ctx, url, remoteSize); sums != nil {
+		logger.Debug().Str("url", url).Msg("Verify: using slice sums")
+		mismatches, err := sums.Check(file)
+		if err != nil {
+			return Result{}, fmt.Errorf("error hashing %s: %w", path, err)
+		}
+		if len(mismatches) > 0 {
+			return Result{}, fmt.Errorf("%w: %w: %d of %d slices differ, the first is %w", ErrMismatch,
+				download.ErrChecksumMismatch, len(mismatches), len(sums.Sums), mismatches[0])
+		}
+		return Result{Size: remoteSize, Method: MethodSliceSums}, nil
+	}
+
+	if d := findDigest(header); d != nil {
+		logger.Debug().Str("url", url).Str("algorithm", d.algorithm).Msg("Verify: using server digest")
+		h := d.newHash()
+		if _, err := io.Copy(h, file); err != nil {
+			return Result{}, fmt.Errorf("error hashing %s: %w", path, err)
+		}
+		if !bytes.Equal(h.Sum(nil), d.expected) {
+			return Result{}, fmt.Errorf("%w: %w: %s digest differs", ErrMismatch, download.ErrChecksumMismatch, d.algorithm)
+		}
+		return Result{Size: remoteSize, Method: d.algorithm}, nil
+	}
+
+	for _, window := range sampleWindows(remoteSize, v.samples(), v.sampleSize()) {
+		if err := v.compareWindow(ctx, url, file, window[0], window[1]); err != nil {
+			return Result{}, err
+		}
+	}
+	return Result{Size: remoteSize, Method: MethodSample}, nil
+}
+
+func (v *Verifier) compareWindow(ctx context.Context, url string, file *os.File, start, end int64) error {
+	logger := logging.GetLogger()
+	logger.Debug().Str("url", url).Int64("start", start).Int64("end", end).Msg("Verify: comparing sample")
+
+	resp, err := v.doRequest(ctx, url, start, end)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("%w: %s returned %s", ErrRangeNotSupported, url, resp.Status)
+	}
+
+	remote := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, remote); err != nil {
+		return fmt.Errorf("error reading bytes %d-%d of %s: %w", start, end, url, err)
+	}
+	local := make([]byte, len(remote))
+	if _, err := file.ReadAt(local, start); err != nil {
+		return fmt.Errorf("error reading bytes %d-%d of %s: %w", start, end, file.Name(), err)
+	}
+	if !bytes.Equal(remote, local) {
+		return fmt.Errorf("%w: bytes %d-%d differ", ErrMismatch, start, end)
+	}
+	return nil
+}
+
+// fetchSliceSums returns the slice sums published for the object at url, of size bytes, or nil if there are none or
+// they can't be used.
+func (v *Verifier) fetchSliceSums(ctx context.Context, url string, size int64) *integrity.SliceSums {
+	logger := logging.GetLogger()
+	sumsURL := url + integrity.SliceSumsSuffix
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sumsURL, nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		logger.Debug().Str("url", sumsURL).Err(err).Msg("Verify: no slice sums")
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Debug().Str("url", sumsURL).Str("status", resp.Status).Msg("Verify: no slice sums")
+		return nil
+	}
+	sums, err := integrity.ParseSliceSums(resp.Body)
+	if err == nil && sums.Size != size {
+		err = fmt.Errorf("slice sums are for %d bytes, the object is %d", sums.Size, size)
+	}
+	if err != nil {
+		logger.Warn().Str("url", sumsURL).Err(err).Msg("Verify: ignoring slice sums")
+		return nil
+	}
+	return sums
+}
+
+// stat requests the first byte of the object at url, returning the size of the object and the headers of the
+// response. An empty object has no first byte, and is answered with 416 Range Not Satisfiable and a Content-Range of
+// "bytes */0".
+func (v *Verifier) stat(ctx context.Context, url string) (int64, http.Header, error) {
+	resp, err := v.get(ctx, url, 0, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && resp.Header.Get("Content-Range") == "bytes */0" {
+		return 0, resp.Header, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, nil, fmt.Errorf("unexpected http status %s: %s", url, resp.Status)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, nil, fmt.Errorf("%w: %s returned %s", ErrRangeNotSupported, url, resp.Status)
+	}
+	groups := contentRangeRegexp.FindStringSubmatch(resp.Header.Get("Content-Range"))
+	if groups == nil {
+		return 0, nil, fmt.Errorf("couldn't parse Content-Range: %s", resp.Header.Get("Content-Range"))
+	}
+	size, err := strconv.ParseInt(groups[1], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("couldn't parse Content-Range: %w", err)
+	}
+	return size, resp.Header, nil
+}
+
+func (v *Verifier) doRequest(ctx context.Context, url string, start, end int64) (*http.Response, error) {
+	resp, err := v.get(ctx, url, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected http status %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// get requests bytes start to end of the object at url, whatever the status of the response.
+func (v *Verifier) get(ctx context.Context, url string, start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify %s: %w", url, err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error executing request for %s: %w", url, err)
+	}
+	return resp, nil
+}
+
+// sampleWindows returns inclusive [start, end] byte windows evenly spread across an object of the given size,
+// always including the first and last bytes.
+func sampleWindows(size int64, samples int, sampleSize int64) [][2]int64 {
+	if size == 0 {
+		return nil
+	}
+	if sampleSize > size {
+		sampleSize = size
+	}
+	maxStart := size - sampleSize
+	if int64(samples) > maxStart+1 {
+		samples = int(maxStart + 1)
+	}
+	windows := make([][2]int64, 0, samples)
+	for i := 0; i < samples; i++ {
+		var start int64
+		if samples > 1 {
+			start = maxStart * int64(i) / int64(samples-1)
+		}
+		windows = append(windows, [2]int64{start, start + sampleSize - 1})
+	}
+	return windows
+}
+
+// findDigest looks for a whole-object digest in the response headers. Digests of the representation (rather than
+// of the partial content) are used so that the headers of a ranged response can be trusted.
+func findDigest(header http.Header) *digest {
+	// RFC 9530 Repr-Digest: sha-256=:<base64>:
+	for _, value := range header.Values("Repr-Digest") {
+		for _, item := range strings.Split(value, ",") {
+			algorithm, encoded, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				continue
+			}
+			if d := newDigest(strings.ToLower(algorithm), strings.Trim(encoded, ":")); d != nil {
+				return d
+			}
+		}
+	}
+	// Google Cloud Storage: x-goog-hash: crc32c=<base64>,md5=<base64>
+	for _, value := range header.Values("X-Goog-Hash") {
+		for _, item := range strings.Split(value, ",") {
+			algorithm, encoded, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				continue
+			}
+			if d := newDigest(strings.ToLower(algorithm), encoded); d != nil {
+				return d
+			}
+		}
+	}
+	// Amazon S3 with x-amz-checksum-mode enabled
+	if encoded := header.Get("X-Amz-Checksum-Sha256"); encoded != "" {
+		return newDigest("sha-256", encoded)
+	}
+	return nil
+}
+
+func newDigest(algorithm, encoded string) *digest {
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	var newHash func() hash.Hash
+	switch algorithm {
+	case "sha-256":
+		newHash = sha256.New
+	case "sha-512":
+		newHash = sha512.New
+	case "md5":
+		newHash = md5.New
+	default:
+		return nil
+	}
+	if len(expected) != newHash().Size() {
+		return nil
+	}
+	return &digest{algorithm: algorithm, newHash: newHash, expected: expected}
+}
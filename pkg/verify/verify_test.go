@@ -0,0 +1,186 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicate/pget/v2/pkg/client"
+	"github.com/replicate/pget/v2/pkg/integrity"
+)
+
+func writeTempFile(t *testing.T, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "local")
+	require.NoError(t, os.WriteFile(path, content, 0644))
+	return path
+}
+
+func newTestServer(content []byte, reprDigest string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reprDigest != "" {
+			w.Header().Set("Repr-Digest", reprDigest)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestVerify(t *testing.T) {
+	content := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	sum := sha256.Sum256(content)
+	goodDigest := fmt.Sprintf("sha-256=:%s:", base64.StdEncoding.EncodeToString(sum[:]))
+	badSum := sha256.Sum256([]byte("something else"))
+	badDigest := fmt.Sprintf("sha-256=:%s:", base64.StdEncoding.EncodeToString(badSum[:]))
+
+	corrupted := bytes.Clone(content)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	testCases := []struct {
+		name           string
+		local          []byte
+		reprDigest     string
+		expectedMethod string
+		expectedErr    error
+	}{
+		{"identical, sampled", content, "", MethodSample, nil},
+		{"identical, digest", content, goodDigest, "sha-256", nil},
+		{"last byte differs, sampled", corrupted, "", "", ErrMismatch},
+		{"different size", content[:len(content)-1], "", "", ErrMismatch},
+		{"digest differs", content, badDigest, "", ErrMismatch},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestServer(content, tc.reprDigest)
+			defer server.Close()
+			path := writeTempFile(t, tc.local)
+
+			verifier := NewVerifier(Options{Samples: 4, SampleSize: 1024}, client.Options{})
+			result, err := verifier.Verify(context.Background(), server.URL, path)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedMethod, result.Method)
+			assert.Equal(t, int64(len(content)), result.Size)
+		})
+	}
+}
+
+func TestVerifyEmpty(t *testing.T) {
+	// as object stores answer a range request for an empty object
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes */0")
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	}))
+	defer server.Close()
+
+	verifier := NewVerifier(Options{}, client.Options{})
+	result, err := verifier.Verify(context.Background(), server.URL, writeTempFile(t, nil))
+	require.NoError(t, err)
+	assert.Equal(t, MethodSample, result.Method)
+	assert.Equal(t, int64(0), result.Size)
+
+	_, err = verifier.Verify(context.Background(), server.URL, writeTempFile(t, []byte("not empty")))
+	assert.ErrorIs(t, err, ErrMismatch)
+}
+
+func TestVerifySliceSums(t *testing.T) {
+	content := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	hasher := integrity.NewSliceHasher(4096)
+	_, _ = hasher.Write(content)
+	var sums bytes.Buffer
+	_, err := hasher.Sums(int64(len(content))).WriteTo(&sums)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, integrity.SliceSumsSuffix) {
+			_, _ = w.Write(sums.Bytes())
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	verifier := NewVerifier(Options{Samples: 4, SampleSize: 1024}, client.Options{})
+
+	result, err := verifier.Verify(context.Background(), server.URL+"/file", writeTempFile(t, content))
+	require.NoError(t, err)
+	assert.Equal(t, MethodSliceSums, result.Method)
+
+	// a byte between the samples differs
+	corrupted := bytes.Clone(content)
+	corrupted[5*4096+1] ^= 0xff
+	_, err = verifier.Verify(context.Background(), server.URL+"/file", writeTempFile(t, corrupted))
+	assert.ErrorIs(t, err, ErrMismatch)
+	var mismatch *integrity.SliceMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, 5, mismatch.Slice)
+}
+
+func TestSampleWindows(t *testing.T) {
+	testCases := []struct {
+		name       string
+		size       int64
+		samples    int
+		sampleSize int64
+		expected   [][2]int64
+	}{
+		{"empty", 0, 4, 10, nil},
+		{"single sample", 100, 1, 10, [][2]int64{{0, 9}}},
+		{"covers first and last bytes", 100, 3, 10, [][2]int64{{0, 9}, {45, 54}, {90, 99}}},
+		{"sample larger than file", 5, 4, 10, [][2]int64{{0, 4}}},
+		{"more samples than offsets", 12, 8, 10, [][2]int64{{0, 9}, {1, 10}, {2, 11}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, sampleWindows(tc.size, tc.samples, tc.sampleSize))
+		})
+	}
+}
+
+func TestFindDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+
+	testCases := []struct {
+		name              string
+		header            http.Header
+		expectedAlgorithm string
+	}{
+		{"none", http.Header{}, ""},
+		{"repr-digest", http.Header{"Repr-Digest": {"sha-256=:" + encoded + ":"}}, "sha-256"},
+		{"repr-digest unknown algorithm", http.Header{"Repr-Digest": {"unixsum=:AAAA:"}}, ""},
+		{"x-goog-hash", http.Header{"X-Goog-Hash": {"crc32c=n03x6A==,md5=XUFAKrxLKna5cZ2REBfFkg=="}}, "md5"},
+		{"x-amz-checksum-sha256", http.Header{"X-Amz-Checksum-Sha256": {encoded}}, "sha-256"},
+		{"x-amz-checksum-sha256 multipart", http.Header{"X-Amz-Checksum-Sha256": {encoded + "-3"}}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := findDigest(tc.header)
+			if tc.expectedAlgorithm == "" {
+				assert.Nil(t, d)
+				return
+			}
+			require.NotNil(t, d)
+			assert.Equal(t, tc.expectedAlgorithm, d.algorithm)
+		})
+	}
+}
@@ -0,0 +1,437 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+const (
+	// maxSinglePutSize is the largest object S3 accepts in a single PUT; larger files are uploaded in parts.
+	maxSinglePutSize = 5 * 1024 * 1024 * 1024
+	minPartSize      = 64 * 1024 * 1024
+	maxParts         = 10000
+	// partConcurrency is the number of parts of a multipart upload sent at a time, each buffered in memory
+	partConcurrency = 4
+
+	gcsEndpoint = "https://storage.googleapis.com"
+	// abortTimeout bounds the request abandoning a failed multipart upload, which is sent after its context is done
+	abortTimeout = 30 * time.Second
+)
+
+// ErrUpload is returned when an object store refuses an upload.
+var ErrUpload = errors.New("upload failed")
+
+// ObjectStore is a prefix of a bucket in S3, an S3-compatible object store, or Google Cloud Storage (through its
+// S3-compatible XML API). Each file is uploaded as an object, streamed from the archive in a single PUT, or in parts
+// if it is larger than S3 allows in one. Uploads are not retried, as the content can't be read again.
+type ObjectStore struct {
+	dest      string
+	bucket    string
+	prefix    string
+	endpoint  *url.URL
+	pathStyle bool
+	// sign authenticates a request whose body has the given SHA-256 (or unsignedPayload)
+	sign   func(req *http.Request, payloadHash string)
+	client *http.Client
+
+	// ifNotExists is the header making the request completing an upload fail if the object already exists
+	ifNotExists http.Header
+	// createOnly is set to make uploads fail rather than replace an existing object (see UploadNewObject)
+	createOnly bool
+
+	// multipartThreshold and partSize are overridden by tests; zero selects maxSinglePutSize and a part size
+	// fitting the file in maxParts parts
+	multipartThreshold int64
+	partSize           int64
+}
+
+var _ FS = &ObjectStore{}
+
+// NewObjectStore returns the ObjectStore for dest, s3://bucket/prefix/ or gs://bucket/prefix/, configured from the
+// environment:
+//
+//   - s3:// is signed with AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, for AWS_REGION (or
+//     AWS_DEFAULT_REGION, or us-east-1). AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) selects an S3-compatible store,
+//     which is addressed with path-style URLs.
+//   - gs:// is authenticated with the OAuth2 access token in GOOGLE_OAUTH_ACCESS_TOKEN (e.g. from
+//     gcloud auth print-access-token).
+func NewObjectStore(dest string) (*ObjectStore, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing destination %s: %w", dest, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("destination %s has no bucket", dest)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	s := &ObjectStore{dest: dest, bucket: u.Host, prefix: prefix, client: &http.Client{}}
+
+	switch u.Scheme {
+	case "s3":
+		creds := credentials{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+			return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to write to %s", dest)
+		}
+		region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+		s.pathStyle = endpoint != ""
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+		if s.endpoint, err = url.Parse(endpoint); err != nil {
+			return nil, fmt.Errorf("error parsing S3 endpoint %s: %w", endpoint, err)
+		}
+		s.sign = func(req *http.Request, payloadHash string) {
+			signV4(req, creds, region, "s3", payloadHash, time.Now())
+		}
+		s.ifNotExists = http.Header{"If-None-Match": {"*"}}
+	case "gs":
+		token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("GOOGLE_OAUTH_ACCESS_TOKEN must be set to write to %s", dest)
+		}
+		s.endpoint, _ = url.Parse(gcsEndpoint)
+		s.pathStyle = true
+		s.sign = func(req *http.Request, _ string) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.ifNotExists = http.Header{"X-Goog-If-Generation-Match": {"0"}}
+	default:
+		return nil, fmt.Errorf("unsupported destination %s, expected s3:// or gs://", dest)
+	}
+	return s, nil
+}
+
+// UploadObject uploads the size bytes read from r to dest, the URL of an object (s3://bucket/key or gs://bucket/key),
+// with the store configured from the environment as by NewObjectStore.
+func UploadObject(ctx context.Context, dest string, r io.Reader, size int64) error {
+	s, name, err := openObject(dest)
+	if err != nil {
+		return err
+	}
+	return s.WriteFile(ctx, name, r, size, 0)
+}
+
+// UploadNewObject is UploadObject for an object which must not exist yet. It fails with an error wrapping
+// fs.ErrExist if the object exists, checked before anything is read from r, and again by the object store when the
+// upload completes, so that an object created in the meantime is not replaced either.
+func UploadNewObject(ctx context.Context, dest string, r io.Reader, size int64) error {
+	s, name, err := openObject(dest)
+	if err != nil {
+		return err
+	}
+	exists, err := s.exists(ctx, s.prefix+name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return &fs.PathError{Op: "upload", Path: dest, Err: fs.ErrExist}
+	}
+	s.createOnly = true
+	return s.WriteFile(ctx, name, r, size, 0)
+}
+
+// ObjectExists returns true if there is an object at dest, the URL of an object (s3://bucket/key or
+// gs://bucket/key).
+func ObjectExists(ctx context.Context, dest string) (bool, error) {
+	s, name, err := openObject(dest)
+	if err != nil {
+		return false, err
+	}
+	return s.exists(ctx, s.prefix+name)
+}
+
+// openObject returns the ObjectStore for the prefix the object dest is in, and the object's name in it. Unlike
+// extracted files, which are many, an object larger than a part is uploaded in parts, sent concurrently so that
+// copying a download to object storage isn't limited to the throughput of a single request.
+func openObject(dest string) (*ObjectStore, string, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing destination %s: %w", dest, err)
+	}
+	prefix, name := path.Split(u.Path)
+	if name == "" {
+		return nil, "", fmt.Errorf("destination %s has no object name", dest)
+	}
+	u.Path, u.RawPath = prefix, ""
+	s, err := NewObjectStore(u.String())
+	if err != nil {
+		return nil, "", err
+	}
+	s.multipartThreshold = minPartSize
+	return s, name, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (s *ObjectStore) MkdirAll(string, fs.FileMode) error {
+	return nil
+}
+
+func (s *ObjectStore) String() string {
+	return s.dest
+}
+
+func (s *ObjectStore) WriteFile(ctx context.Context, name string, r io.Reader, size int64, _ fs.FileMode) error {
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid path %q", name)
+	}
+	key := s.prefix + name
+	threshold := s.multipartThreshold
+	if threshold == 0 {
+		threshold = maxSinglePutSize
+	}
+	if size > threshold {
+		return s.putMultipart(ctx, key, r, size)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, s.completeHeader(), io.LimitReader(r, size), size, unsignedPayload)
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %w", s.objectName(key), err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// completeHeader returns the header of the request completing an upload.
+func (s *ObjectStore) completeHeader() http.Header {
+	if !s.createOnly {
+		return nil
+	}
+	return s.ifNotExists
+}
+
+// exists returns true if there is an object key.
+func (s *ObjectStore) exists(ctx context.Context, key string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.objectURL(key, nil).String(), nil)
+	if err != nil {
+		return false, err
+	}
+	s.sign(req, emptyPayloadHash)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error checking for %s: %w", s.objectName(key), err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return false, fmt.Errorf("error checking for %s: %s", s.objectName(key), resp.Status)
+	}
+	return true, nil
+}
+
+func (s *ObjectStore) objectName(key string) string {
+	scheme, _, _ := strings.Cut(s.dest, "://")
+	return scheme + "://" + s.bucket + "/" + key
+}
+
+// objectURL returns the URL of the object key, with query.
+func (s *ObjectStore) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	objectPath := "/" + key
+	if s.pathStyle {
+		objectPath = "/" + s.bucket + objectPath
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	// send the path escaped as it is signed
+	u.RawPath = canonicalURI(&u)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request for the object key, with header added, returning the response if its status is 2xx.
+// body, of size bytes, may be nil. A precondition of header which fails is returned as an error wrapping fs.ErrExist.
+func (s *ObjectStore) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil && size > 0 {
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+	}
+	s.sign(req, payloadHash)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed && header != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s %w", ErrUpload, s.objectName(key), fs.ErrExist)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s %s", ErrUpload, resp.Status, responseError(resp.Body))
+	}
+	return resp, nil
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// responseError returns the code and message of the XML error document read from r, if it is one.
+func responseError(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 64*1024))
+	var e errorResponse
+	if err := xml.Unmarshal(data, &e); err != nil || e.Code == "" {
+		return ""
+	}
+	return fmt.Sprintf("(%s: %s)", e.Code, e.Message)
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads the size bytes read from r to key in parts, abandoning the upload if one fails.
+func (s *ObjectStore) putMultipart(ctx context.Context, key string, r io.Reader, size int64) error {
+	logger := logging.GetLogger()
+	partSize := s.partSize
+	if partSize == 0 {
+		partSize = max(minPartSize, (size+maxParts-1)/maxParts)
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return fmt.Errorf("error starting upload of %s: %w", s.objectName(key), err)
+	}
+	var initiated initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("error starting upload of %s: no upload ID in response", s.objectName(key))
+	}
+	logger.Debug().
+		Str("object", s.objectName(key)).
+		Int64("size", size).
+		Int64("part_size", partSize).
+		Msg("Multipart Upload")
+
+	if err := s.uploadParts(ctx, key, initiated.UploadID, r, size, partSize); err != nil {
+		abortCtx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+		defer cancel()
+		if resp, abortErr := s.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil, 0, emptyPayloadHash); abortErr == nil {
+			resp.Body.Close()
+		} else {
+			logger.Warn().Str("object", s.objectName(key)).Err(abortErr).Msg("Error abandoning upload")
+		}
+		return fmt.Errorf("error uploading %s: %w", s.objectName(key), err)
+	}
+	return nil
+}
+
+// uploadParts uploads the size bytes read from r as the parts of the upload uploadID, up to partConcurrency of them
+// at a time: each part is read into a buffer while the parts before it are being sent, so that the upload isn't
+// limited to the throughput of a single request.
+func (s *ObjectStore) uploadParts(ctx context.Context, key, uploadID string, r io.Reader, size, partSize int64) error {
+	numParts := (size + partSize - 1) / partSize
+	complete := completeMultipartUpload{Parts: make([]completedPart, numParts)}
+	buffers := make(chan []byte, partConcurrency)
+	for range partConcurrency {
+		buffers <- nil
+	}
+	errGroup, partCtx := errgroup.WithContext(ctx)
+	var readErr error
+parts:
+	for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-partCtx.Done():
+			// a part failed
+			break parts
+		}
+		if buf == nil {
+			buf = make([]byte, partSize)
+		}
+		buf = buf[:min(partSize, size-offset)]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			readErr = fmt.Errorf("part %d: %w", number, err)
+			break
+		}
+		errGroup.Go(func() error {
+			defer func() { buffers <- buf }()
+			query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+			resp, err := s.do(partCtx, http.MethodPut, key, query, nil, bytes.NewReader(buf), int64(len(buf)), unsignedPayload)
+			if err != nil {
+				return fmt.Errorf("part %d: %w", number, err)
+			}
+			resp.Body.Close()
+			complete.Parts[number-1] = completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")}
+			return nil
+		})
+	}
+	if err := errGroup.Wait(); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, s.completeHeader(), bytes.NewReader(body), int64(len(body)), sha256Hex(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// the upload may still fail after a 200 OK response, with an error document for a body
+	if e := responseError(resp.Body); e != "" {
+		return fmt.Errorf("%w: %s", ErrUpload, e)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package vfs abstracts the destinations archives are extracted to: a directory on local disk, or a prefix of an
+// object storage bucket (s3:// or gs://), which extracted entries are uploaded to as they are read from the archive,
+// without staging them on local disk. Downloads are uploaded to object storage the same way (see UploadObject).
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicate/pget/v2/pkg/overwrite"
+)
+
+// FS is a destination for extracted files. Names are slash-separated paths relative to its root, which must be
+// local (see fs.ValidPath).
+type FS interface {
+	// MkdirAll creates the directory name and any parents. Object stores have no directories, so it does nothing
+	// for them.
+	MkdirAll(name string, perm fs.FileMode) error
+	// WriteFile writes the size bytes read from r to the file name. The file is only created, or replaced, if all
+	// of them could be written.
+	WriteFile(ctx context.Context, name string, r io.Reader, size int64, perm fs.FileMode) error
+	// String returns the root of the destination, e.g. s3://bucket/prefix/.
+	String() string
+}
+
+// IsRemote reports whether dest is the URL of an object store destination, which Open supports.
+func IsRemote(dest string) bool {
+	scheme, _, ok := strings.Cut(dest, "://")
+	return ok && (scheme == "s3" || scheme == "gs")
+}
+
+// Open returns the FS for dest: an ObjectStore for s3:// and gs:// URLs, configured from the environment (see
+// NewObjectStore), and otherwise a Local directory written with overwrite.Always.
+func Open(dest string) (FS, error) {
+	if IsRemote(dest) {
+		return NewObjectStore(dest)
+	}
+	return &Local{Root: dest, Overwrite: overwrite.Always}, nil
+}
+
+// Local is a directory on local disk.
+type Local struct {
+	Root string
+	// Overwrite is the policy for files which already exist. If empty, overwrite.Never is used.
+	Overwrite overwrite.Policy
+}
+
+var _ FS = &Local{}
+
+func (l *Local) path(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(name)), nil
+}
+
+func (l *Local) MkdirAll(name string, perm fs.FileMode) error {
+	target, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(target, perm)
+}
+
+func (l *Local) WriteFile(_ context.Context, name string, r io.Reader, size int64, perm fs.FileMode) error {
+	target, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := overwrite.Create(target, size, perm, l.Overwrite)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %w", target, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing file %s: %w", target, err)
+	}
+	return nil
+}
+
+func (l *Local) String() string {
+	return l.Root
+}
+
+// CleanName returns the name of an archive entry as a name for an FS, relative to its root like the entry is to the
+// archive's, or an error if it would be outside the root.
+func CleanName(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty entry name")
+	}
+	cleaned := path.Clean(strings.TrimLeft(name, "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("entry %q is outside of the destination", name)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,178 @@
+package pget
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/replicate/pget/v2/pkg/download"
+	"github.com/replicate/pget/v2/pkg/logging"
+)
+
+// FileOrder is the order in which DownloadFiles schedules the entries of a manifest.
+type FileOrder string
+
+const (
+	// FileOrderManifest schedules the entries in manifest order. This is the default.
+	FileOrderManifest FileOrder = "manifest"
+	// FileOrderSmallestFirst schedules the smallest entries first (shortest job first), so that as many files as
+	// possible are complete early, which minimizes the average completion time.
+	FileOrderSmallestFirst FileOrder = "smallest-first"
+	// FileOrderLargestFirst schedules the largest entries first, so that the longest downloads don't start last and
+	// hold up the end of the run.
+	FileOrderLargestFirst FileOrder = "largest-first"
+)
+
+// fileOrderShortestFirst is accepted for FileOrderSmallestFirst, its name when it was introduced.
+const fileOrderShortestFirst = "shortest-first"
+
+// ParseFileOrder parses the value of --file-order. An empty value selects FileOrderManifest.
+func ParseFileOrder(value string) (FileOrder, error) {
+	switch order := FileOrder(value); order {
+	case "":
+		return FileOrderManifest, nil
+	case fileOrderShortestFirst:
+		return FileOrderSmallestFirst, nil
+	case FileOrderManifest, FileOrderSmallestFirst, FileOrderLargestFirst:
+		return order, nil
+	}
+	return "", fmt.Errorf("unknown --file-order value %q, expected manifest, smallest-first or largest-first", value)
+}
+
+// schedule returns the entries in the order they are to be downloaded. The shards of a group are kept together (see
+// scheduleGroupsTogether). With Options.Warmup or a FileOrder other than manifest order, the size of every entry is
+// discovered first (see warmup); a group is ordered by the size of all its shards.
+func (g *Getter) schedule(ctx context.Context, entries []ManifestEntry) []ManifestEntry {
+	entries = scheduleGroupsTogether(entries)
+	order := g.Options.FileOrder
+	if order == "" {
+		order = FileOrderManifest
+	}
+	if !g.Options.Warmup && order == FileOrderManifest {
+		return entries
+	}
+	sizes := g.warmup(ctx, entries)
+	if order == FileOrderManifest {
+		return entries
+	}
+
+	// the units of scheduling are single entries and whole groups
+	type unit struct {
+		entries []ManifestEntry
+		// size is -1 if the size of an entry is unknown
+		size int64
+	}
+	var units []*unit
+	groups := make(map[*ManifestGroup]*unit)
+	for i, entry := range entries {
+		u, ok := groups[entry.Group]
+		if !ok {
+			u = &unit{}
+			units = append(units, u)
+			if entry.Group != nil {
+				groups[entry.Group] = u
+			}
+		}
+		u.entries = append(u.entries, entry)
+		if u.size >= 0 {
+			u.size += sizes[i]
+		}
+		if sizes[i] < 0 {
+			u.size = -1
+		}
+	}
+	// entries of unknown size go last, in manifest order
+	slices.SortStableFunc(units, func(a, b *unit) int {
+		switch {
+		case a.size < 0 && b.size < 0:
+			return 0
+		case a.size < 0:
+			return 1
+		case b.size < 0:
+			return -1
+		case order == FileOrderLargestFirst:
+			return cmp.Compare(b.size, a.size)
+		}
+		return cmp.Compare(a.size, b.size)
+	})
+	scheduled := make([]ManifestEntry, 0, len(entries))
+	for _, u := range units {
+		scheduled = append(scheduled, u.entries...)
+	}
+	return scheduled
+}
+
+// schedulePriorities moves the entries with a priority to the front of scheduled, lowest value first. The relative
+// order of entries with the same priority, and of those without one, is preserved.
+func schedulePriorities(scheduled []ManifestEntry) []ManifestEntry {
+	if !slices.ContainsFunc(scheduled, func(entry ManifestEntry) bool { return entry.Priority > 0 }) {
+		return scheduled
+	}
+	scheduled = slices.Clone(scheduled)
+	slices.SortStableFunc(scheduled, func(a, b ManifestEntry) int {
+		switch {
+		case a.Priority > 0 && b.Priority > 0:
+			return cmp.Compare(a.Priority, b.Priority)
+		case a.Priority > 0:
+			return -1
+		case b.Priority > 0:
+			return 1
+		}
+		return 0
+	})
+	return scheduled
+}
+
+// warmup discovers the size of every entry with a single-byte request, in a wave of requests made before any
+// download starts, so that the total size of the manifest is known (and logged) early. It returns the sizes in the
+// order of entries, -1 for entries whose size could not be discovered; their downloads report the error.
+func (g *Getter) warmup(ctx context.Context, entries []ManifestEntry) []int64 {
+	logger := logging.GetLogger()
+	start := time.Now()
+	sizes := make([]int64, len(entries))
+	var errGroup errgroup.Group
+	if g.Options.MaxConcurrentFiles != 0 {
+		errGroup.SetLimit(g.Options.MaxConcurrentFiles)
+	}
+	for i, entry := range entries {
+		errGroup.Go(func() error {
+			resp, err := g.Downloader.DoRequest(ctx, 0, 0, entry.URL)
+			if err == nil {
+				sizes[i], err = download.ObjectSize(resp)
+				resp.Body.Close()
+			}
+			if err != nil {
+				sizes[i] = -1
+				logger.Debug().
+					Str("url", entry.URL).
+					Err(err).
+					Msg("Warmup: size unknown")
+			}
+			return nil
+		})
+	}
+	_ = errGroup.Wait()
+
+	var total int64
+	unknown := 0
+	for _, size := range sizes {
+		if size < 0 {
+			unknown++
+			continue
+		}
+		total += size
+	}
+	logger.Info().
+		Int("files", len(entries)).
+		Int("unknown_sizes", unknown).
+		Str("total_size", humanize.Bytes(uint64(total))).
+		Int64("total_bytes", total).
+		Str("elapsed", fmt.Sprintf("%.3fs", time.Since(start).Seconds())).
+		Msg("Warmup Complete")
+	return sizes
+}